@@ -1,6 +1,7 @@
 package backlog
 
 import (
+	"context"
 	"errors"
 	"sync"
 
@@ -49,6 +50,30 @@ func (b *Backlog) Get() command.Command {
 	return <-b.data
 }
 
+// GetContext возвращает команду из backlog или ошибку если контекст
+// завершился раньше чем появилась команда
+func (b *Backlog) GetContext(ctx context.Context) (command.Command, error) {
+	select {
+	case cmd := <-b.data:
+		return cmd, nil
+	case <-ctx.Done():
+		return command.Command{}, ctx.Err()
+	}
+}
+
+// Reset удаляет из backlog все команды
+func (b *Backlog) Reset() {
+	b.Lock()
+	defer b.Unlock()
+	for {
+		select {
+		case <-b.data:
+		default:
+			return
+		}
+	}
+}
+
 // Count возвращает количество команд в backlog
 func (b *Backlog) Count() int {
 	return len(b.data)
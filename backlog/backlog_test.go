@@ -1,6 +1,7 @@
 package backlog
 
 import (
+	"context"
 	"fmt"
 	"reflect"
 	"testing"
@@ -79,3 +80,46 @@ func testBacklog(
 		t.Fatalf("expected count %d but actual %d", expectedCount, count)
 	}
 }
+
+// TestBacklogGetContext проверяет ожидание команды с учётом контекста
+func TestBacklogGetContext(t *testing.T) {
+	t.Run("Normal", func(t *testing.T) {
+		backlog := New(1)
+		expected := command.New([]string{"command"})
+		err := backlog.Add(expected)
+		if err != nil {
+			t.Fatalf("backlog error: %v", err)
+		}
+		result, err := backlog.GetContext(context.Background())
+		if err != nil {
+			t.Fatalf("get error: %v", err)
+		}
+		if !reflect.DeepEqual(expected, result) {
+			t.Fatalf("expected command %q but actual %q", expected, result)
+		}
+	})
+	t.Run("Cancel", func(t *testing.T) {
+		backlog := New(1)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := backlog.GetContext(ctx)
+		if err != context.Canceled {
+			t.Fatalf("expected error %v but actual %v", context.Canceled, err)
+		}
+	})
+}
+
+// TestBacklogReset проверяет очистку backlog
+func TestBacklogReset(t *testing.T) {
+	backlog := New(10)
+	for i := 0; i < 5; i++ {
+		err := backlog.Add(command.New([]string{fmt.Sprintf("command_%d", i)}))
+		if err != nil {
+			t.Fatalf("backlog error: %v", err)
+		}
+	}
+	backlog.Reset()
+	if backlog.Count() != 0 {
+		t.Fatalf("expected count 0 but actual %d", backlog.Count())
+	}
+}
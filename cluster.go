@@ -0,0 +1,377 @@
+package replica
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/avito-tech/smart-redis-replication/command"
+	"github.com/avito-tech/smart-redis-replication/data"
+	"github.com/avito-tech/smart-redis-replication/rdb"
+	"github.com/avito-tech/smart-redis-replication/replica"
+	"github.com/avito-tech/smart-redis-replication/status"
+)
+
+const (
+	// DefaultClusterRefresh это интервал перечитывания топологии кластера
+	DefaultClusterRefresh = 10 * time.Second
+)
+
+// Origin это шард и слот из которых получены данные
+type Origin struct {
+	// Shard это идентификатор мастера
+	Shard string
+
+	// Slot это слот ключа или -1 если у команды нет ключа
+	Slot int
+}
+
+// ClusterConsumer это получатель информации из репликации всех шардов
+// кластера, методы вызываются последовательно
+type ClusterConsumer interface {
+	// Key принимает ключи с данными
+	Key(origin Origin, key data.Key) error
+
+	// Command принимает команды управления
+	Command(origin Origin, command command.Command) error
+
+	// CheckCommand возвращает true если команда интересна получателю
+	CheckCommand(command command.Command) bool
+
+	// ReplicaStatus принимает статус репликации шарда,
+	// статусы всего кластера приходят с пустым shard
+	ReplicaStatus(shard string, status status.Status) error
+
+	// Cancel останавливает обработку данных
+	Cancel(err *error)
+}
+
+// ClusterFunctionConsumer это получатель библиотек функций шардов,
+// ClusterConsumer может реализовать его дополнительно
+type ClusterFunctionConsumer interface {
+	SetFunction(shard string, function rdb.Function) error
+}
+
+// ClusterConfig это настройки репликации redis cluster
+type ClusterConfig struct {
+	// Seeds это адреса узлов кластера в формате host:port у которых
+	// запрашивается топология, опрашиваются по очереди до первого ответа
+	Seeds []string
+
+	// Connect это настройки подключения к узлам, Host и Port берутся из
+	// топологии, SELECT не отправляется
+	Connect Config
+
+	// Replica это настройки репликации шарда, к CacheRDBFile добавляется
+	// идентификатор мастера
+	Replica replica.Config
+
+	// MinBackoff, MaxBackoff и MaxRetries это настройки переподключения
+	// шарда, смотри SupervisorConfig
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	MaxRetries int
+
+	// Refresh это интервал перечитывания топологии,
+	// по умолчанию DefaultClusterRefresh
+	Refresh time.Duration
+}
+
+// Cluster реплицирует все мастера redis cluster и передаёт данные одному
+// получателю, при изменении топологии запускает репликацию новых мастеров
+// и останавливает репликацию исчезнувших
+type Cluster struct {
+	config ClusterConfig
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu     sync.Mutex
+	shards map[string]*clusterShard
+	wg     sync.WaitGroup
+	errs   chan error
+}
+
+// clusterShard это репликация одного мастера
+type clusterShard struct {
+	shard      ClusterShard
+	supervisor *Supervisor
+}
+
+// NewCluster возвращает новый Cluster
+func NewCluster(config ClusterConfig) (*Cluster, error) {
+	if len(config.Seeds) == 0 {
+		return nil, errors.New("expected cluster seeds")
+	}
+	if config.Refresh <= 0 {
+		config.Refresh = DefaultClusterRefresh
+	}
+	config.Connect.DB = -1
+	c := &Cluster{
+		config: config,
+		shards: map[string]*clusterShard{},
+		errs:   make(chan error, 1),
+	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	return c, nil
+}
+
+// Topology возвращает мастера кластера,
+// опрашивает узлы из Seeds по очереди до первого ответа
+func (c *Cluster) Topology() (shards []ClusterShard, err error) {
+	for _, seed := range c.config.Seeds {
+		shards, err = c.topology(seed)
+		if err == nil {
+			return shards, nil
+		}
+	}
+	return nil, fmt.Errorf("cluster topology error: %v", err)
+}
+
+// topology запрашивает топологию у одного узла
+func (c *Cluster) topology(seed string) ([]ClusterShard, error) {
+	host, port, err := net.SplitHostPort(seed)
+	if err != nil {
+		return nil, err
+	}
+	config := c.config.Connect
+	config.Host = host
+	config.Port, err = strconv.Atoi(port)
+	if err != nil {
+		return nil, fmt.Errorf("seed port error: %v", err)
+	}
+	conn, err := NewConnectConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close() // nolint:errcheck
+	return conn.clusterTopology()
+}
+
+// Shards возвращает мастера которые реплицируются сейчас
+func (c *Cluster) Shards() []ClusterShard {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	shards := make([]ClusterShard, 0, len(c.shards))
+	for _, shard := range c.shards {
+		shards = append(shards, shard.shard)
+	}
+	sortShards(shards)
+	return shards
+}
+
+// Run запускает репликацию всех мастеров и перечитывает топологию,
+// возвращает первую ошибку репликации шарда после которой останавливает
+// остальные шарды, после Stop возвращает nil,
+// метод синхронный
+func (c *Cluster) Run(consumer ClusterConsumer) (err error) {
+	if consumer == nil {
+		return errors.New("expected consumer but actual nil")
+	}
+	merged := &clusterConsumer{consumer: consumer}
+	defer func() {
+		c.cancel()
+		c.wg.Wait()
+		consumer.Cancel(&err)
+	}()
+
+	shards, err := c.Topology()
+	if err != nil {
+		return err
+	}
+	err = c.apply(merged, shards)
+	if err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(c.config.Refresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.ctx.Done():
+			return nil
+		case err = <-c.errs:
+			return err
+		case <-ticker.C:
+		}
+		shards, err = c.Topology()
+		if err != nil {
+			// топология будет перечитана на следующем шаге, шарды
+			// переподключаются самостоятельно
+			continue
+		}
+		err = c.apply(merged, shards)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// apply запускает репликацию новых мастеров и останавливает репликацию
+// мастеров которых нет в топологии
+func (c *Cluster) apply(merged *clusterConsumer, shards []ClusterShard) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	changed := false
+	actual := map[string]bool{}
+	for _, shard := range shards {
+		actual[shard.ID] = true
+		current, ok := c.shards[shard.ID]
+		if ok && current.shard.equal(shard) {
+			continue
+		}
+		changed = true
+		if ok && current.shard.Host == shard.Host &&
+			current.shard.Port == shard.Port {
+			// изменились только слоты, репликация мастера продолжается
+			current.shard = shard
+			continue
+		}
+		if ok {
+			current.supervisor.Stop()
+		}
+		err := c.start(merged, shard)
+		if err != nil {
+			return err
+		}
+	}
+	for id, current := range c.shards {
+		if !actual[id] {
+			changed = true
+			current.supervisor.Stop()
+			delete(c.shards, id)
+		}
+	}
+	if changed {
+		merged.ReplicaStatus("", status.TopologyChanged) // nolint:errcheck
+	}
+	return nil
+}
+
+// start запускает репликацию мастера
+func (c *Cluster) start(merged *clusterConsumer, shard ClusterShard) error {
+	connect := c.config.Connect
+	connect.Host = shard.Host
+	connect.Port = shard.Port
+	config := c.config.Replica
+	if config.CacheRDBFile != "" {
+		config.CacheRDBFile += "." + shard.ID
+	}
+	supervisor, err := NewSupervisor(SupervisorConfig{
+		Connect:    connect,
+		Replica:    config,
+		MinBackoff: c.config.MinBackoff,
+		MaxBackoff: c.config.MaxBackoff,
+		MaxRetries: c.config.MaxRetries,
+	})
+	if err != nil {
+		return err
+	}
+	c.shards[shard.ID] = &clusterShard{
+		shard:      shard,
+		supervisor: supervisor,
+	}
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		err := supervisor.Run(&shardConsumer{
+			merged: merged,
+			shard:  shard.ID,
+		})
+		if err != nil {
+			select {
+			case c.errs <- fmt.Errorf("shard %s error: %v", shard.ID, err):
+			default:
+			}
+		}
+	}()
+	go func() {
+		select {
+		case <-c.ctx.Done():
+			supervisor.Stop()
+		case <-supervisor.Done():
+		}
+	}()
+	return nil
+}
+
+// Done возвращает канал для ожидания завершения Run
+func (c *Cluster) Done() <-chan struct{} {
+	return c.ctx.Done()
+}
+
+// Stop прекращает репликацию всех мастеров
+func (c *Cluster) Stop() {
+	c.cancel()
+}
+
+// clusterConsumer передаёт данные всех шардов получателю последовательно
+type clusterConsumer struct {
+	sync.Mutex
+	consumer ClusterConsumer
+}
+
+// ReplicaStatus передаёт статус получателю
+func (c *clusterConsumer) ReplicaStatus(shard string, s status.Status) error {
+	c.Lock()
+	defer c.Unlock()
+	return c.consumer.ReplicaStatus(shard, s)
+}
+
+// shardConsumer это получатель репликации одного шарда,
+// добавляет к данным шард и слот
+type shardConsumer struct {
+	merged *clusterConsumer
+	shard  string
+}
+
+// Key передаёт ключ получателю
+func (c *shardConsumer) Key(key data.Key) error {
+	c.merged.Lock()
+	defer c.merged.Unlock()
+	return c.merged.consumer.Key(
+		Origin{Shard: c.shard, Slot: KeySlot(key.Name())},
+		key,
+	)
+}
+
+// Command передаёт команду получателю
+func (c *shardConsumer) Command(cmd command.Command) error {
+	c.merged.Lock()
+	defer c.merged.Unlock()
+	return c.merged.consumer.Command(
+		Origin{Shard: c.shard, Slot: CommandSlot(cmd)},
+		cmd,
+	)
+}
+
+// SetFunction передаёт библиотеку функций шарда получателю если он
+// реализует ClusterFunctionConsumer
+func (c *shardConsumer) SetFunction(function rdb.Function) error {
+	c.merged.Lock()
+	defer c.merged.Unlock()
+	functionConsumer, ok := c.merged.consumer.(ClusterFunctionConsumer)
+	if !ok {
+		return nil
+	}
+	return functionConsumer.SetFunction(c.shard, function)
+}
+
+// CheckCommand возвращает true если команда интересна получателю
+func (c *shardConsumer) CheckCommand(cmd command.Command) bool {
+	c.merged.Lock()
+	defer c.merged.Unlock()
+	return c.merged.consumer.CheckCommand(cmd)
+}
+
+// ReplicaStatus передаёт статус шарда получателю
+func (c *shardConsumer) ReplicaStatus(s status.Status) error {
+	return c.merged.ReplicaStatus(c.shard, s)
+}
+
+// Cancel не останавливает получателя, его останавливает Cluster
+func (c *shardConsumer) Cancel(err *error) {}
@@ -0,0 +1,261 @@
+package replica
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/avito-tech/smart-redis-replication/command"
+	"github.com/avito-tech/smart-redis-replication/data"
+	"github.com/avito-tech/smart-redis-replication/replica"
+	"github.com/avito-tech/smart-redis-replication/resp"
+	"github.com/avito-tech/smart-redis-replication/status"
+)
+
+// TestCluster проверяет репликацию всех мастеров кластера в одного
+// получателя и перестроение после изменения топологии
+func TestCluster(t *testing.T) {
+	master1 := newFakeMasterListener(t, testFullResync("foo", "a"))
+	defer master1.Close() // nolint:errcheck
+	master2 := newFakeMasterListener(t, testFullResync("bar", "b"))
+	defer master2.Close() // nolint:errcheck
+	master3 := newFakeMasterListener(t, testFullResync("zap", "c"))
+	defer master3.Close() // nolint:errcheck
+
+	node := newFakeClusterNode(t)
+	defer node.Close() // nolint:errcheck
+	node.SetSlots(
+		testSlots(0, 8191, master1, "m1"),
+		testSlots(8192, 16383, master2, "m2"),
+	)
+
+	cluster, err := NewCluster(ClusterConfig{
+		Seeds: []string{closedAddress(t), node.Addr().String()},
+		Replica: replica.Config{
+			ReadRDB:     true,
+			StreamRDB:   true,
+			BacklogSize: 100,
+		},
+		MinBackoff: time.Millisecond,
+		MaxBackoff: time.Millisecond,
+		Refresh:    10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("new cluster error: %v", err)
+	}
+	consumer := newTestClusterConsumer()
+	result := make(chan error, 1)
+	go func() {
+		result <- cluster.Run(consumer)
+	}()
+
+	waitEvents := func(expected ...string) {
+		actual := map[string]bool{}
+		for len(actual) < len(expected) {
+			select {
+			case event := <-consumer.events:
+				actual[event] = true
+			case err := <-result:
+				t.Fatalf("unexpected stop: %v", err)
+			case <-time.After(5 * time.Second):
+				t.Fatalf("timeout waiting %q, received %v", expected, actual)
+			}
+		}
+		for _, event := range expected {
+			if !actual[event] {
+				t.Fatalf("expected %q, received %v", expected, actual)
+			}
+		}
+	}
+	waitEvents(
+		fmt.Sprintf("m1 %d key foo", KeySlot("foo")),
+		fmt.Sprintf("m1 %d command a", KeySlot("a")),
+		fmt.Sprintf("m2 %d key bar", KeySlot("bar")),
+		fmt.Sprintf("m2 %d command b", KeySlot("b")),
+	)
+
+	// мастер m1 заменён на m3, слоты m2 изменились
+	node.SetSlots(
+		testSlots(0, 100, master3, "m3"),
+		testSlots(101, 16383, master2, "m2"),
+	)
+	waitEvents(
+		fmt.Sprintf("m3 %d key zap", KeySlot("zap")),
+		fmt.Sprintf("m3 %d command c", KeySlot("c")),
+	)
+	shards := cluster.Shards()
+	if len(shards) != 2 || shards[0].ID != "m3" || shards[1].ID != "m2" ||
+		shards[1].Slots[0].Start != 101 {
+		t.Fatalf("unexpected shards %#v", shards)
+	}
+
+	cluster.Stop()
+	if err := <-result; err != nil {
+		t.Fatalf("run error: %v", err)
+	}
+	if consumer.count("", status.TopologyChanged) != 2 {
+		t.Fatalf(
+			"expected 2 topology changes but actual %d",
+			consumer.count("", status.TopologyChanged),
+		)
+	}
+	if consumer.count("m2", status.FullResync) != 1 {
+		t.Fatalf("shard m2 must not be resynced")
+	}
+	if consumer.canceled != 1 {
+		t.Fatalf("expected one consumer cancel but actual %d", consumer.canceled)
+	}
+}
+
+// testFullResync возвращает ответ мастера с RDB из одного ключа key и
+// командой SET для ключа command
+func testFullResync(key, command string) string {
+	rdbData := fmt.Sprintf(
+		"REDIS0009\xfe\x00\x00%c%s\x01v\xff\x00\x00\x00\x00\x00\x00\x00\x00",
+		len(key),
+		key,
+	)
+	return fmt.Sprintf(
+		"+FULLRESYNC %s 0\r\n$%d\r\n%s%s",
+		strings.Repeat("a", 40),
+		len(rdbData),
+		rdbData,
+		respArray("SET", command, "1"),
+	)
+}
+
+// testSlots возвращает элемент ответа CLUSTER SLOTS
+func testSlots(start, end int, master net.Listener, id string) string {
+	host, port, _ := net.SplitHostPort(master.Addr().String())
+	return fmt.Sprintf(
+		"*3\r\n:%d\r\n:%d\r\n*3\r\n$%d\r\n%s\r\n:%s\r\n$%d\r\n%s\r\n",
+		start,
+		end,
+		len(host),
+		host,
+		port,
+		len(id),
+		id,
+	)
+}
+
+// fakeClusterNode это узел кластера который не поддерживает CLUSTER SHARDS
+// и отвечает на CLUSTER SLOTS
+type fakeClusterNode struct {
+	net.Listener
+	sync.Mutex
+	slots string
+}
+
+// newFakeClusterNode возвращает новый fakeClusterNode
+func newFakeClusterNode(t *testing.T) *fakeClusterNode {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen error: %v", err)
+	}
+	node := &fakeClusterNode{Listener: listener}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go node.serve(conn)
+		}
+	}()
+	return node
+}
+
+// SetSlots задаёт ответ CLUSTER SLOTS
+func (n *fakeClusterNode) SetSlots(items ...string) {
+	n.Lock()
+	defer n.Unlock()
+	n.slots = "*" + strconv.Itoa(len(items)) + "\r\n" + strings.Join(items, "")
+}
+
+// serve отвечает на команды одного соединения
+func (n *fakeClusterNode) serve(conn net.Conn) {
+	defer conn.Close() // nolint:errcheck
+	br := bufio.NewReader(conn)
+	r := resp.NewReader(br)
+	for {
+		args, err := readMasterCommand(br, r)
+		if err != nil || len(args) == 0 {
+			return
+		}
+		reply := "-ERR unknown command\r\n"
+		switch {
+		case strings.EqualFold(args[0], "PING"):
+			reply = "+PONG\r\n"
+		case len(args) == 2 && strings.EqualFold(args[1], "SLOTS"):
+			n.Lock()
+			reply = n.slots
+			n.Unlock()
+		}
+		_, err = conn.Write([]byte(reply))
+		if err != nil {
+			return
+		}
+	}
+}
+
+// testClusterConsumer записывает полученные данные
+type testClusterConsumer struct {
+	sync.Mutex
+	events   chan string
+	statuses []string
+	canceled int
+}
+
+func newTestClusterConsumer() *testClusterConsumer {
+	return &testClusterConsumer{events: make(chan string, 100)}
+}
+
+func (c *testClusterConsumer) Key(origin Origin, key data.Key) error {
+	c.events <- fmt.Sprintf("%s %d key %s", origin.Shard, origin.Slot, key.Name())
+	return nil
+}
+
+func (c *testClusterConsumer) Command(origin Origin, cmd command.Command) error {
+	c.events <- fmt.Sprintf(
+		"%s %d command %s",
+		origin.Shard,
+		origin.Slot,
+		cmd.Args()[1],
+	)
+	return nil
+}
+
+func (c *testClusterConsumer) CheckCommand(cmd command.Command) bool {
+	return true
+}
+
+func (c *testClusterConsumer) ReplicaStatus(shard string, s status.Status) error {
+	c.Lock()
+	defer c.Unlock()
+	c.statuses = append(c.statuses, shard+" "+string(s))
+	return nil
+}
+
+func (c *testClusterConsumer) Cancel(err *error) {
+	c.Lock()
+	defer c.Unlock()
+	c.canceled++
+}
+
+func (c *testClusterConsumer) count(shard string, s status.Status) int {
+	c.Lock()
+	defer c.Unlock()
+	n := 0
+	for _, item := range c.statuses {
+		if item == shard+" "+string(s) {
+			n++
+		}
+	}
+	return n
+}
@@ -3,8 +3,10 @@ package command
 import (
 	"errors"
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/avito-tech/smart-redis-replication/data"
 )
@@ -31,20 +33,34 @@ func (c Command) Type() Type {
 		return Empty
 	}
 	switch command {
-	case Ping, Select, Zadd, Sadd, Zrem, Delete, RDB:
+	case Ping, Select, Zadd, Sadd, Zrem, Delete, Replconf, RDB,
+		Xadd, Xdel, Xtrim, Xgroup, Xack,
+		Expire, ExpireAt, Pexpire, PexpireAt, Persist, Set, SetEX, PsetX:
 		return command
 	}
 	return Undefined
 }
 
+// Args возвращает команду вместе с аргументами в исходном виде
+func (c Command) Args() []string {
+	return c.data
+}
+
 // KeyName возвращает название ключа если оно предусмотрено командой
 func (c Command) KeyName() (string, error) {
 	if len(c.data) < 2 {
 		return "", fmt.Errorf("expected count args >= 2 but actual %d", len(c.data))
 	}
 	switch c.Type() {
-	case Delete, Zrem, Zadd, Sadd:
+	case Delete, Zrem, Zadd, Sadd, Xadd, Xdel, Xtrim, Xack,
+		Expire, ExpireAt, Pexpire, PexpireAt, Persist, Set, SetEX, PsetX:
 		return c.data[1], nil
+	case Xgroup:
+		// XGROUP <subcommand> <key> ...
+		if len(c.data) < 3 {
+			return "", fmt.Errorf("expected count args >= 3 but actual %d", len(c.data))
+		}
+		return c.data[2], nil
 	}
 	return "", fmt.Errorf("unexpected type %q", c.Type())
 }
@@ -64,6 +80,15 @@ func (c Command) Values() ([]string, error) {
 	return []string{}, fmt.Errorf("unexpected type %q", c.Type())
 }
 
+// IsGetAck возвращает true если это запрос сервера на подтверждение
+// смещения репликации (REPLCONF GETACK *)
+func (c Command) IsGetAck() bool {
+	if len(c.data) < 2 || c.Type() != Replconf {
+		return false
+	}
+	return strings.EqualFold(strings.TrimSpace(c.data[1]), "getack")
+}
+
 // ConvertToSelectDB конвертирует команду в номер базы данных
 func (c Command) ConvertToSelectDB() (db int, err error) {
 	if len(c.data) < 2 {
@@ -88,6 +113,193 @@ func (c Command) ConvertToRDB() (size int64, err error) {
 	return strconv.ParseInt(strings.TrimSpace(c.data[1]), 10, 64)
 }
 
+// RDBEOFMark возвращает метку окончания RDB и true если RDB передаётся
+// без указания размера (repl-diskless-sync), иначе false
+func (c Command) RDBEOFMark() (string, bool) {
+	if len(c.data) < 2 || c.Type() != RDB {
+		return "", false
+	}
+	if !strings.HasPrefix(c.data[1], RDBEOFMarkPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(c.data[1], RDBEOFMarkPrefix), true
+}
+
+// ConvertToExpiry конвертирует команду изменения времени жизни ключа
+// (EXPIRE, PEXPIRE, EXPIREAT, PEXPIREAT, PERSIST, SET, SETEX, PSETEX)
+// в абсолютное время окончания жизни.
+// Относительное время отсчитывается от now, обычно это время получения
+// команды, поэтому при отложенной обработке команду нужно заранее
+// привести к абсолютному времени через AbsoluteExpiry.
+// Возвращает false если команда не меняет время жизни (SET с KEEPTTL)
+// nolint:gocyclo
+func (c Command) ConvertToExpiry(now time.Time) (data.Expiry, bool, error) {
+	commandType := c.Type()
+	switch commandType {
+	case Persist:
+		if len(c.data) < 2 {
+			return data.NoExpiry(), false, fmt.Errorf(
+				"expected count args >=2 but actual %d",
+				len(c.data),
+			)
+		}
+		return data.NoExpiry(), true, nil
+	case Expire, Pexpire, ExpireAt, PexpireAt, SetEX, PsetX:
+		// EXPIRE key seconds [NX|XX|GT|LT], SETEX key seconds value
+		if len(c.data) < 3 {
+			return data.NoExpiry(), false, fmt.Errorf(
+				"expected count args >=3 but actual %d",
+				len(c.data),
+			)
+		}
+		return c.convertToExpiry(commandType, c.data[2], now)
+	case Set:
+		return c.convertSetToExpiry(now)
+	}
+	return data.NoExpiry(), false, fmt.Errorf(
+		"expected expiry command but actual %s",
+		commandType,
+	)
+}
+
+// setExpiryOptions это команды с теми же единицами времени жизни
+// что и у параметров команды SET
+var setExpiryOptions = map[Type]Type{
+	"ex":   SetEX,
+	"px":   PsetX,
+	"exat": ExpireAt,
+	"pxat": PexpireAt,
+}
+
+// convertSetToExpiry возвращает время жизни из аргументов команды
+// SET key value [NX|XX] [GET] [EX s|PX ms|EXAT s|PXAT ms|KEEPTTL],
+// SET без указания времени жизни удаляет его
+func (c Command) convertSetToExpiry(now time.Time) (data.Expiry, bool, error) {
+	if len(c.data) < 3 {
+		return data.NoExpiry(), false, fmt.Errorf(
+			"expected count args >=3 but actual %d",
+			len(c.data),
+		)
+	}
+	for i := 3; i < len(c.data); i++ {
+		option := Type(strings.ToLower(strings.TrimSpace(c.data[i])))
+		switch option {
+		case "keepttl":
+			return data.NoExpiry(), false, nil
+		case "ex", "px", "exat", "pxat":
+			if i+1 >= len(c.data) {
+				return data.NoExpiry(), false, fmt.Errorf(
+					"expected value of SET option %s",
+					option,
+				)
+			}
+			return c.convertToExpiry(setExpiryOptions[option], c.data[i+1], now)
+		}
+	}
+	return data.NoExpiry(), true, nil
+}
+
+// convertToExpiry конвертирует значение времени жизни в единицах команды
+// commandType в абсолютное время окончания жизни
+func (c Command) convertToExpiry(
+	commandType Type,
+	value string,
+	now time.Time,
+) (data.Expiry, bool, error) {
+	ms, err := expiryMilliseconds(commandType, value, now)
+	if err != nil {
+		return data.NoExpiry(), false, err
+	}
+	at := time.Unix(ms/1000, ms%1000*int64(time.Millisecond))
+	return data.NewExpiryAt(at), true, nil
+}
+
+// expiryMilliseconds возвращает абсолютное время окончания жизни как время
+// Unix в миллисекундах. Как и redis время считается в миллисекундах
+// и значение которое в них не умещается возвращает ошибку
+func expiryMilliseconds(
+	commandType Type,
+	value string,
+	now time.Time,
+) (int64, error) {
+	n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	switch commandType {
+	case Expire, SetEX, ExpireAt:
+		if n > math.MaxInt64/1000 || n < math.MinInt64/1000 {
+			return 0, fmt.Errorf("invalid expire time %d in %s", n, commandType)
+		}
+		n *= 1000
+	}
+	switch commandType {
+	case Expire, SetEX, Pexpire, PsetX:
+		base := now.Unix()*1000 + int64(now.Nanosecond())/int64(time.Millisecond)
+		if n > 0 && base > math.MaxInt64-n || n < 0 && base < math.MinInt64-n {
+			return 0, fmt.Errorf("invalid expire time %d in %s", n, commandType)
+		}
+		n += base
+	}
+	return n, nil
+}
+
+// AbsoluteExpiry возвращает команду в которой относительное время жизни
+// заменено абсолютным, так redis 7 передаёт команды репликам:
+// EXPIRE и PEXPIRE становятся PEXPIREAT, SETEX и PSETEX - SET с PXAT,
+// параметры EX и PX команды SET заменяются на PXAT.
+// Относительное время отсчитывается от now, обычно это время получения
+// команды. Остальные команды возвращаются без изменений
+func (c Command) AbsoluteExpiry(now time.Time) (Command, error) {
+	commandType := c.Type()
+	switch commandType {
+	case Expire, Pexpire:
+		// EXPIRE key seconds [NX|XX|GT|LT]
+		if len(c.data) < 3 {
+			return Command{}, fmt.Errorf(
+				"expected count args >=3 but actual %d",
+				len(c.data),
+			)
+		}
+		ms, err := expiryMilliseconds(commandType, c.data[2], now)
+		if err != nil {
+			return Command{}, err
+		}
+		args := []string{"PEXPIREAT", c.data[1], strconv.FormatInt(ms, 10)}
+		return New(append(args, c.data[3:]...)), nil
+	case SetEX, PsetX:
+		// SETEX key seconds value
+		if len(c.data) != 4 {
+			return Command{}, fmt.Errorf(
+				"expected count args 4 but actual %d",
+				len(c.data),
+			)
+		}
+		ms, err := expiryMilliseconds(commandType, c.data[2], now)
+		if err != nil {
+			return Command{}, err
+		}
+		return New([]string{
+			"SET", c.data[1], c.data[3], "PXAT", strconv.FormatInt(ms, 10),
+		}), nil
+	case Set:
+		for i := 3; i < len(c.data)-1; i++ {
+			option := Type(strings.ToLower(strings.TrimSpace(c.data[i])))
+			if option != "ex" && option != "px" {
+				continue
+			}
+			ms, err := expiryMilliseconds(setExpiryOptions[option], c.data[i+1], now)
+			if err != nil {
+				return Command{}, err
+			}
+			args := append([]string{}, c.data...)
+			args[i], args[i+1] = "PXAT", strconv.FormatInt(ms, 10)
+			return New(args), nil
+		}
+	}
+	return c, nil
+}
+
 // ConvertToSortedSetKey конвертирует команду Zadd в ключ SortedSetKey
 func (c Command) ConvertToSortedSetKey(db int) (data.SortedSetKey, error) {
 	if len(c.data) < 4 {
@@ -1,8 +1,10 @@
 package command
 
 import (
+	"fmt"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/avito-tech/smart-redis-replication/data"
 )
@@ -39,6 +41,26 @@ func TestCommandType(t *testing.T) {
 		testCommandType(t, []string{"DELETE"}, Delete)
 		testCommandType(t, []string{"Delete"}, Delete)
 	})
+	t.Run("Replconf", func(t *testing.T) {
+		testCommandType(t, []string{"replconf"}, Replconf)
+		testCommandType(t, []string{"REPLCONF"}, Replconf)
+		testCommandType(t, []string{"Replconf"}, Replconf)
+	})
+	t.Run("Stream", func(t *testing.T) {
+		testCommandType(t, []string{"XADD"}, Xadd)
+		testCommandType(t, []string{"xdel"}, Xdel)
+		testCommandType(t, []string{"Xtrim"}, Xtrim)
+		testCommandType(t, []string{"XGROUP"}, Xgroup)
+		testCommandType(t, []string{"xack"}, Xack)
+	})
+	t.Run("Expiry", func(t *testing.T) {
+		testCommandType(t, []string{"EXPIRE"}, Expire)
+		testCommandType(t, []string{"pexpireat"}, PexpireAt)
+		testCommandType(t, []string{"Persist"}, Persist)
+		testCommandType(t, []string{"SET"}, Set)
+		testCommandType(t, []string{"setex"}, SetEX)
+		testCommandType(t, []string{"PSETEX"}, PsetX)
+	})
 	t.Run("Empty", func(t *testing.T) {
 		testCommandType(t, []string{""}, Empty)
 		testCommandType(t, []string{"   "}, Empty)
@@ -64,6 +86,106 @@ func testCommandType(
 	}
 }
 
+// TestCommandKeyName проверяет получение названия ключа команды
+func TestCommandKeyName(t *testing.T) {
+	t.Run("Key", func(t *testing.T) {
+		testCommandKeyName(t, []string{"ZADD", "key", "1", "a"}, "key", true)
+		testCommandKeyName(t, []string{"XADD", "key", "*", "f", "v"}, "key", true)
+		testCommandKeyName(t, []string{"XDEL", "key", "1-0"}, "key", true)
+		testCommandKeyName(t, []string{"XTRIM", "key", "MAXLEN", "1"}, "key", true)
+		testCommandKeyName(t, []string{"XACK", "key", "group", "1-0"}, "key", true)
+		testCommandKeyName(
+			t,
+			[]string{"XGROUP", "CREATE", "key", "group", "$"},
+			"key",
+			true,
+		)
+	})
+	t.Run("NoKey", func(t *testing.T) {
+		testCommandKeyName(t, []string{"XGROUP", "HELP"}, "", false)
+		testCommandKeyName(t, []string{"XADD"}, "", false)
+		testCommandKeyName(t, []string{"PING", "key"}, "", false)
+	})
+}
+
+func testCommandKeyName(
+	t *testing.T,
+	args []string,
+	expected string,
+	success bool,
+) {
+	c := New(args)
+	result, err := c.KeyName()
+	if !success {
+		if err == nil {
+			t.Fatalf("expected error, args: %q", args)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("key name error: %v", err)
+	}
+	if result != expected {
+		t.Fatalf("expected key %q but actual %q", expected, result)
+	}
+}
+
+// TestCommandIsGetAck проверяет определение запроса подтверждения смещения
+func TestCommandIsGetAck(t *testing.T) {
+	t.Run("GetAck", func(t *testing.T) {
+		testCommandIsGetAck(t, []string{"REPLCONF", "GETACK", "*"}, true)
+		testCommandIsGetAck(t, []string{"replconf", "getack", "*"}, true)
+	})
+	t.Run("Other", func(t *testing.T) {
+		testCommandIsGetAck(t, []string{"REPLCONF", "ACK", "100"}, false)
+		testCommandIsGetAck(t, []string{"REPLCONF"}, false)
+		testCommandIsGetAck(t, []string{"PING", "GETACK"}, false)
+		testCommandIsGetAck(t, []string{}, false)
+	})
+}
+
+func testCommandIsGetAck(
+	t *testing.T,
+	args []string,
+	expected bool,
+) {
+	c := New(args)
+	result := c.IsGetAck()
+	if result != expected {
+		t.Errorf("expected %t but actual %t, args: %q", expected, result, args)
+	}
+}
+
+// TestCommandRDBEOFMark проверяет получение метки окончания RDB
+func TestCommandRDBEOFMark(t *testing.T) {
+	mark := "ac9ab1a1f5cb0f8b3e7c53fd0c2a7e1a9f5fd8d0"
+	t.Run("Diskless", func(t *testing.T) {
+		testCommandRDBEOFMark(t, []string{"rdb", "EOF:" + mark}, mark, true)
+	})
+	t.Run("Size", func(t *testing.T) {
+		testCommandRDBEOFMark(t, []string{"rdb", "1024"}, "", false)
+	})
+	t.Run("NoRDBType", func(t *testing.T) {
+		testCommandRDBEOFMark(t, []string{"ping", "EOF:" + mark}, "", false)
+	})
+}
+
+func testCommandRDBEOFMark(
+	t *testing.T,
+	args []string,
+	expected string,
+	expectedOK bool,
+) {
+	c := New(args)
+	result, ok := c.RDBEOFMark()
+	if ok != expectedOK {
+		t.Fatalf("expected %t but actual %t, args: %q", expectedOK, ok, args)
+	}
+	if result != expected {
+		t.Fatalf("expected mark %q but actual %q", expected, result)
+	}
+}
+
 // TestCommandConvertToSelectDB проверяет правильное конвертирование команды
 func TestCommandConvertToSelectDB(t *testing.T) {
 	t.Run("Normal", func(t *testing.T) {
@@ -228,3 +350,147 @@ func testCommandConvertToSetKey(
 		}
 	}
 }
+
+// TestCommandConvertToExpiry проверяет приведение времени жизни из команд
+// к абсолютному времени
+func TestCommandConvertToExpiry(t *testing.T) {
+	now := time.Unix(1492780026, 0)
+	at := data.NewExpiryAt(now.Add(10 * time.Second))
+	t.Run("Relative", func(t *testing.T) {
+		testCommandConvertToExpiry(t, []string{"EXPIRE", "key", "10"}, now, at, true)
+		testCommandConvertToExpiry(t, []string{"pexpire", "key", "10000", "NX"}, now, at, true)
+		testCommandConvertToExpiry(t, []string{"SETEX", "key", "10", "v"}, now, at, true)
+		testCommandConvertToExpiry(t, []string{"PSETEX", "key", "10000", "v"}, now, at, true)
+		testCommandConvertToExpiry(t, []string{"SET", "key", "v", "EX", "10"}, now, at, true)
+		testCommandConvertToExpiry(t, []string{"SET", "key", "v", "NX", "px", "10000"}, now, at, true)
+	})
+	t.Run("Absolute", func(t *testing.T) {
+		testCommandConvertToExpiry(t, []string{"EXPIREAT", "key", "1492780036"}, now, at, true)
+		testCommandConvertToExpiry(t, []string{"PEXPIREAT", "key", "1492780036000"}, now, at, true)
+		testCommandConvertToExpiry(t, []string{"SET", "key", "v", "EXAT", "1492780036"}, now, at, true)
+		testCommandConvertToExpiry(t, []string{"SET", "key", "v", "PXAT", "1492780036000"}, now, at, true)
+		// время до 1970 года остаётся заданным и истёкшим
+		testCommandConvertToExpiry(t, []string{"PEXPIREAT", "key", "-5000"}, now, data.NewExpiry(1), true)
+	})
+	t.Run("NoExpiry", func(t *testing.T) {
+		testCommandConvertToExpiry(t, []string{"PERSIST", "key"}, now, data.NoExpiry(), true)
+		testCommandConvertToExpiry(t, []string{"SET", "key", "v"}, now, data.NoExpiry(), true)
+		testCommandConvertToExpiry(t, []string{"SET", "key", "v", "KEEPTTL"}, now, data.NoExpiry(), false)
+	})
+	t.Run("Distant", func(t *testing.T) {
+		// больше 292 лет не умещается в time.Duration
+		years := int64(1000 * 365 * 24 * 3600)
+		distant := data.NewExpiry(uint64((now.Unix() + years) * 1000))
+		testCommandConvertToExpiry(t, []string{"EXPIRE", "key", fmt.Sprint(years)}, now, distant, true)
+		testCommandConvertToExpiry(t, []string{"SETEX", "key", fmt.Sprint(years), "v"}, now, distant, true)
+		testCommandConvertToExpiry(t, []string{"PEXPIRE", "key", fmt.Sprint(years * 1000)}, now, distant, true)
+	})
+	t.Run("Error", func(t *testing.T) {
+		for _, args := range [][]string{
+			{"EXPIRE", "key"},
+			{"EXPIRE", "key", "ten"},
+			{"EXPIRE", "key", "9223372036854775"},
+			{"PEXPIRE", "key", "9223372036854775807"},
+			{"EXPIREAT", "key", "9223372036854775807"},
+			{"SET", "key", "v", "EX"},
+			{"ZADD", "key", "1", "a"},
+		} {
+			_, _, err := New(args).ConvertToExpiry(now)
+			if err == nil {
+				t.Fatalf("expected error, args: %q", args)
+			}
+		}
+	})
+}
+
+// TestCommandAbsoluteExpiry проверяет замену относительного времени
+// жизни абсолютным
+func TestCommandAbsoluteExpiry(t *testing.T) {
+	now := time.Unix(1492780026, 0)
+	tests := map[string]struct {
+		args     []string
+		expected []string
+	}{
+		"Expire": {
+			args:     []string{"EXPIRE", "key", "10", "NX"},
+			expected: []string{"PEXPIREAT", "key", "1492780036000", "NX"},
+		},
+		"Pexpire": {
+			args:     []string{"pexpire", "key", "10000"},
+			expected: []string{"PEXPIREAT", "key", "1492780036000"},
+		},
+		"SetEX": {
+			args:     []string{"SETEX", "key", "10", "v"},
+			expected: []string{"SET", "key", "v", "PXAT", "1492780036000"},
+		},
+		"PsetX": {
+			args:     []string{"PSETEX", "key", "10000", "v"},
+			expected: []string{"SET", "key", "v", "PXAT", "1492780036000"},
+		},
+		"SetEx": {
+			args:     []string{"SET", "key", "v", "NX", "ex", "10", "GET"},
+			expected: []string{"SET", "key", "v", "NX", "PXAT", "1492780036000", "GET"},
+		},
+		"SetPXAT": {
+			args:     []string{"SET", "key", "v", "PXAT", "1492780036000"},
+			expected: []string{"SET", "key", "v", "PXAT", "1492780036000"},
+		},
+		"ExpireAt": {
+			args:     []string{"EXPIREAT", "key", "1492780036"},
+			expected: []string{"EXPIREAT", "key", "1492780036"},
+		},
+		"Sadd": {
+			args:     []string{"SADD", "key", "a"},
+			expected: []string{"SADD", "key", "a"},
+		},
+	}
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			cmd, err := New(test.args).AbsoluteExpiry(now)
+			if err != nil {
+				t.Fatalf("absolute expiry error: %v", err)
+			}
+			if !reflect.DeepEqual(cmd.Args(), test.expected) {
+				t.Fatalf("expected %q but actual %q", test.expected, cmd.Args())
+			}
+		})
+	}
+	t.Run("Error", func(t *testing.T) {
+		for _, args := range [][]string{
+			{"EXPIRE", "key"},
+			{"SETEX", "key", "10"},
+			{"SET", "key", "v", "EX", "ten"},
+			{"EXPIRE", "key", "9223372036854775"},
+		} {
+			_, err := New(args).AbsoluteExpiry(now)
+			if err == nil {
+				t.Fatalf("expected error, args: %q", args)
+			}
+		}
+	})
+}
+
+func testCommandConvertToExpiry(
+	t *testing.T,
+	args []string,
+	now time.Time,
+	expected data.Expiry,
+	expectedOk bool,
+) {
+	result, ok, err := New(args).ConvertToExpiry(now)
+	if err != nil {
+		t.Fatalf("convert to expiry error: %v, args: %q", err, args)
+	}
+	if ok != expectedOk {
+		t.Fatalf("expected %t but actual %t, args: %q", expectedOk, ok, args)
+	}
+	if result.Milliseconds() != expected.Milliseconds() {
+		t.Fatalf(
+			"expected expiry %d but actual %d, args: %q",
+			expected.Milliseconds(),
+			result.Milliseconds(),
+			args,
+		)
+	}
+}
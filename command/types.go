@@ -10,6 +10,7 @@ const (
 	Sadd      Type = "sadd"
 	Zrem      Type = "zrem"
 	Delete    Type = "delete"
+	Replconf  Type = "replconf"
 	Empty     Type = "empty"
 	Undefined Type = "undefined"
 
@@ -71,8 +72,18 @@ const (
 	HincrByFloat Type = "hincrby"
 	PfAdd        Type = "pfadd"
 	PfMerge      Type = "pfmerge"
-	PsetX        Type = "psetx"
+	PsetX        Type = "psetex"
+
+	Xadd   Type = "xadd"
+	Xdel   Type = "xdel"
+	Xtrim  Type = "xtrim"
+	Xgroup Type = "xgroup"
+	Xack   Type = "xack"
 )
 
+// RDBEOFMarkPrefix это префикс метки окончания RDB переданного без указания
+// размера ("$EOF:<mark>")
+const RDBEOFMarkPrefix = "EOF:"
+
 // Type это тип команды
 type Type string
@@ -0,0 +1,70 @@
+package replica
+
+import (
+	"errors"
+)
+
+var (
+	// DefaultCapabilities это возможности реплики которые сообщаются серверу
+	// через REPLCONF capa по умолчанию
+	DefaultCapabilities = []string{"eof", "psync2"}
+)
+
+// Config это параметры подключения к redis серверу
+type Config struct {
+	// Host это адрес сервера
+	Host string
+
+	// Port это порт сервера
+	Port int
+
+	// DB это номер базы данных, при значении -1 команда SELECT не отправляется
+	DB int
+
+	// User это имя пользователя ACL (redis >= 6),
+	// если не задано то AUTH отправляется только с паролем
+	User string
+
+	// Password это пароль, если не задан то AUTH не отправляется
+	Password string
+
+	// ListeningPort это порт который сообщается серверу через
+	// REPLCONF listening-port, при значении 0 команда не отправляется
+	ListeningPort int
+
+	// IPAddress это адрес который сообщается серверу через
+	// REPLCONF ip-address, если не задан то команда не отправляется
+	IPAddress string
+
+	// Capabilities это возможности реплики которые сообщаются серверу через
+	// REPLCONF capa, если не заданы то используются DefaultCapabilities
+	Capabilities []string
+
+	// TLS это параметры TLS соединения, если не заданы то используется TCP
+	TLS *TLSConfig
+}
+
+// Check проверяет что в конфиге все необходимые данные
+func (c Config) Check() error {
+	switch {
+	case c.Host == "":
+		return errors.New("expected host")
+	case c.Port <= 0:
+		return errors.New("expected port > 0")
+	case c.DB < -1:
+		return errors.New("expected db > -2")
+	case c.ListeningPort < 0:
+		return errors.New("expected listening port >= 0")
+	case c.User != "" && c.Password == "":
+		return errors.New("expected password for user")
+	}
+	return nil
+}
+
+// capabilities возвращает возможности реплики
+func (c Config) capabilities() []string {
+	if len(c.Capabilities) == 0 {
+		return DefaultCapabilities
+	}
+	return c.Capabilities
+}
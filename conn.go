@@ -1,7 +1,10 @@
 package replica
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"crypto/tls"
 	"fmt"
 	"io"
 	"net"
@@ -9,29 +12,53 @@ import (
 	"sync"
 
 	"github.com/avito-tech/smart-redis-replication/replica"
+	"github.com/avito-tech/smart-redis-replication/resp"
 )
 
 // Conn это постоянное соединение с redis сервером
 type Conn struct {
 	sync.Mutex
 	replication bool
+	config      Config
 
 	conn io.ReadWriteCloser
+
+	// buffer это буфер чтения соединения, он же передаётся реплике
+	// чтобы не потерять уже прочитанные в него данные
+	buffer *bufio.Reader
+
+	// reader читает ответы сервера во время подготовки соединения,
+	// все ответы вычитываются полностью до перехода в режим репликации
+	reader resp.Reader
+
+	// pending это количество ответов на команды Send которые ещё не
+	// прочитаны, они пропускаются перед чтением следующего ответа
+	pending int
+}
+
+// bufferedConn это соединение которое читает через буфер Conn
+type bufferedConn struct {
+	io.Reader
+	io.WriteCloser
 }
 
 // NewConnect возвращает новый Conn
 func NewConnect(host string, port int, db int) (*Conn, error) {
-	if host == "" {
-		return nil, fmt.Errorf("expected host")
-	}
-	if port <= 0 {
-		return nil, fmt.Errorf("expected port > 0")
-	}
-	if db < -1 {
-		return nil, fmt.Errorf("expected db > -2")
+	return NewConnectConfig(Config{
+		Host: host,
+		Port: port,
+		DB:   db,
+	})
+}
+
+// NewConnectConfig возвращает новый Conn, выполняя PING, AUTH и SELECT
+func NewConnectConfig(config Config) (*Conn, error) {
+	err := config.Check()
+	if err != nil {
+		return nil, err
 	}
 
-	conn, err := net.Dial("tcp", fmt.Sprintf("%s:%d", host, port))
+	conn, err := dial(config)
 	if err != nil {
 		return nil, err
 	}
@@ -39,39 +66,95 @@ func NewConnect(host string, port int, db int) (*Conn, error) {
 	if err != nil {
 		return nil, err
 	}
-	if db > -1 {
-		err = redisConn.Send("SELECT", db)
-		if err != nil {
-			err = redisConn.Close()
-			return nil, err
-		}
+	redisConn.config = config
+	err = redisConn.handshake()
+	if err != nil {
+		redisConn.Close() // nolint:errcheck
+		return nil, err
 	}
 	return redisConn, nil
 }
 
+// dial открывает TCP или TLS соединение с сервером
+func dial(config Config) (net.Conn, error) {
+	address := net.JoinHostPort(config.Host, strconv.Itoa(config.Port))
+	if config.TLS == nil {
+		return net.Dial("tcp", address)
+	}
+	tlsConfig, err := config.TLS.Build(config.Host)
+	if err != nil {
+		return nil, err
+	}
+	return tls.Dial("tcp", address, tlsConfig)
+}
+
 // NewConn возвращает новый Conn
 func NewConn(conn io.ReadWriteCloser) (*Conn, error) {
 	if conn == nil {
 		return nil, fmt.Errorf("expected conn io.ReadWriteCloser")
 	}
+	buffer := bufio.NewReader(conn)
 	return &Conn{
-		conn: conn,
+		config: Config{DB: -1},
+		conn:   conn,
+		buffer: buffer,
+		reader: resp.NewReader(buffer),
 	}, nil
 }
 
+// replicaConn возвращает соединение для реплики которое читает через
+// буфер Conn
+func (c *Conn) replicaConn() io.ReadWriteCloser {
+	return &bufferedConn{
+		Reader:      c.buffer,
+		WriteCloser: c.conn,
+	}
+}
+
 func (c *Conn) replicationLock() {
 	c.Lock()
 	defer c.Unlock()
 	c.replication = true
 }
 
-// NewReplica возвращает новый Replica, не переводит коннект в режим репликации
+// NewReplica возвращает новый Replica, сообщает серверу параметры реплики
+// через REPLCONF, не переводит коннект в режим репликации
 func (c *Conn) NewReplica(config replica.Config) (replica.Replica, error) {
+	err := c.replconfLock()
+	if err != nil {
+		return nil, err
+	}
 	c.replicationLock()
-	return replica.NewReplica(c.conn, config), nil
+	return replica.NewReplica(c.replicaConn(), config), nil
 }
 
-// Send отправляет комманду и не читает ответ
+// NewResumeReplica возвращает новый Replica который продолжает репликацию
+// предыдущей реплики prev после переподключения, декодер работает пока не
+// завершится ctx
+func (c *Conn) NewResumeReplica(
+	ctx context.Context,
+	config replica.Config,
+	prev replica.Replica,
+) (replica.Replica, error) {
+	err := c.replconfLock()
+	if err != nil {
+		return nil, err
+	}
+	c.replicationLock()
+	return replica.NewResumeReplica(ctx, c.replicaConn(), config, prev), nil
+}
+
+func (c *Conn) replconfLock() error {
+	c.Lock()
+	defer c.Unlock()
+	if c.replication {
+		return fmt.Errorf("error replconf: replication mode is enabled")
+	}
+	return c.replconf()
+}
+
+// Send отправляет комманду и не ждёт ответ, ответ пропускается перед
+// чтением ответа следующей команды подготовки соединения
 func (c *Conn) Send(commandName string, args ...interface{}) error {
 	c.Lock()
 	defer c.Unlock()
@@ -79,7 +162,12 @@ func (c *Conn) Send(commandName string, args ...interface{}) error {
 		return fmt.Errorf("error send: replication mode is enabled")
 	}
 
-	return c.send(commandName, args...)
+	err := c.send(commandName, args...)
+	if err != nil {
+		return err
+	}
+	c.pending++
+	return nil
 }
 
 // Close закрывает соединение
@@ -105,7 +193,7 @@ func (c *Conn) writeCommand(
 	if err != nil {
 		return err
 	}
-	_, err = c.conn.Write([]byte(commandName))
+	err = c.writeString(commandName)
 	if err != nil {
 		return err
 	}
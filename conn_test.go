@@ -0,0 +1,39 @@
+package replica
+
+import (
+	"io"
+	"net"
+	"testing"
+)
+
+// TestWriteCommand проверяет что название команды и аргументы
+// записываются как bulk string массива RESP
+func TestWriteCommand(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close() // nolint:errcheck
+
+	expected := "*4\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\nvalue\r\n$2\r\n10\r\n"
+	result := make(chan string, 1)
+	go func() {
+		body := make([]byte, len(expected))
+		_, err := io.ReadFull(server, body)
+		if err != nil {
+			result <- err.Error()
+			return
+		}
+		result <- string(body)
+	}()
+
+	conn, err := NewConn(client)
+	if err != nil {
+		t.Fatalf("new conn error: %v", err)
+	}
+	defer conn.Close() // nolint:errcheck
+	err = conn.Send("SET", "key", []byte("value"), 10)
+	if err != nil {
+		t.Fatalf("send error: %v", err)
+	}
+	if actual := <-result; actual != expected {
+		t.Fatalf("expected %q but actual %q", expected, actual)
+	}
+}
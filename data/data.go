@@ -13,7 +13,7 @@ type SortedSet struct {
 // IntegerSet это отсортированный набор целых чисел
 type IntegerSet struct {
 	key
-	data map[uint64]struct{}
+	data map[int64]struct{}
 }
 
 // Set это не упорядоченный набор данных
@@ -78,18 +78,18 @@ func (s *SortedSet) Values() map[string]float64 {
 func NewIntegerSet(name string) *IntegerSet {
 	s := new(IntegerSet)
 	s.name = name
-	s.data = make(map[uint64]struct{})
+	s.data = make(map[int64]struct{})
 	return s
 }
 
 // Set устанавливает значение в набор
-func (s *IntegerSet) Set(value uint64) error {
+func (s *IntegerSet) Set(value int64) error {
 	s.data[value] = struct{}{}
 	return nil
 }
 
 // SetData полностью меняет набор данных
-func (s *IntegerSet) SetData(data map[uint64]struct{}) error {
+func (s *IntegerSet) SetData(data map[int64]struct{}) error {
 	if data == nil {
 		return errors.New("expected data")
 	}
@@ -98,12 +98,12 @@ func (s *IntegerSet) SetData(data map[uint64]struct{}) error {
 }
 
 // Values возвращает набор данных
-func (s *IntegerSet) Values() map[uint64]struct{} {
+func (s *IntegerSet) Values() map[int64]struct{} {
 	return s.data
 }
 
 // Is возвращает true если значение есть, false если значения нет
-func (s *IntegerSet) Is(value uint64) (ok bool) {
+func (s *IntegerSet) Is(value int64) (ok bool) {
 	_, ok = s.data[value]
 	return ok
 }
@@ -1,6 +1,7 @@
 package data
 
 import (
+	"math"
 	"reflect"
 	"testing"
 )
@@ -104,31 +105,33 @@ func testSortedSetWeightUndefined(t *testing.T, value string) {
 func TestIntegerSet(t *testing.T) {
 	t.Run("Data", func(t *testing.T) {
 		t.Run("Normal", func(t *testing.T) {
-			data := make(map[uint64]struct{})
-			data[uint64(1)] = struct{}{}
-			data[uint64(2)] = struct{}{}
+			data := make(map[int64]struct{})
+			data[int64(1)] = struct{}{}
+			data[int64(2)] = struct{}{}
+			data[int64(-3)] = struct{}{}
 			testIntegerSetData(t, data)
 		})
 		t.Run("Error", testIntegerSetDataError)
 	})
 	t.Run("Set", func(t *testing.T) {
-		value := uint64(2938098)
-		testIntegerSetSet(t, value)
+		testIntegerSetSet(t, int64(2938098))
+		testIntegerSetSet(t, int64(-2938098))
+		testIntegerSetSet(t, math.MinInt64)
 	})
 	t.Run("Is", func(t *testing.T) {
 		t.Run("Normal", func(t *testing.T) {
-			value := uint64(8983984)
+			value := int64(8983984)
 			testIntegerSetIs(t, value)
 		})
 		t.Run("Undefined", func(t *testing.T) {
-			value := uint64(23989823)
+			value := int64(23989823)
 			testIntegerSetIsUndefined(t, value)
 		})
 	})
 }
 
 // testInsertSetData проверяет полную замену данных в InsertSet
-func testIntegerSetData(t *testing.T, data map[uint64]struct{}) {
+func testIntegerSetData(t *testing.T, data map[int64]struct{}) {
 	s := NewIntegerSet("")
 	AssertData(t, s.SetData(data), s.Values(), data)
 }
@@ -143,14 +146,14 @@ func testIntegerSetDataError(t *testing.T) {
 }
 
 // testIntegerSetSet проверяет установку значения
-func testIntegerSetSet(t *testing.T, value uint64) {
+func testIntegerSetSet(t *testing.T, value int64) {
 	s := NewIntegerSet("")
 	AssertSetIs(t, s.Set(value), s.Is(value))
 }
 
 // testIntegerSetIs проверяет наличие значения
-func testIntegerSetIs(t *testing.T, value uint64) {
-	data := make(map[uint64]struct{})
+func testIntegerSetIs(t *testing.T, value int64) {
+	data := make(map[int64]struct{})
 	data[value] = struct{}{}
 
 	s := NewIntegerSet("")
@@ -158,7 +161,7 @@ func testIntegerSetIs(t *testing.T, value uint64) {
 }
 
 // testIntegerSetIsUndefined проверяет что значения может и не быть
-func testIntegerSetIsUndefined(t *testing.T, value uint64) {
+func testIntegerSetIsUndefined(t *testing.T, value int64) {
 	s := NewIntegerSet("")
 	ok := s.Is(value)
 	if ok {
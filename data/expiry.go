@@ -4,24 +4,91 @@ import (
 	"time"
 )
 
-// Expiry это время жизни объекта, например ключа
+// NoTTL возвращается TTL для объекта без времени жизни,
+// аналогично ответу -1 команды TTL в redis
+const NoTTL time.Duration = -1
+
+// Expiry это абсолютное время окончания жизни объекта, например ключа.
+// Нулевое значение означает что время жизни не задано
 type Expiry struct {
-	t time.Duration
+	at time.Time
 }
 
-// NewExpiry возвращает новый Expiry
+// NewExpiry возвращает новый Expiry по времени Unix в миллисекундах,
+// 0 означает что время жизни не задано
 func NewExpiry(milliseconds uint64) Expiry {
+	if milliseconds == 0 {
+		return Expiry{}
+	}
 	return Expiry{
-		t: time.Duration(milliseconds*1000) * time.Microsecond,
+		at: time.Unix(
+			int64(milliseconds/1000),
+			int64(milliseconds%1000)*int64(time.Millisecond),
+		),
+	}
+}
+
+// NewExpiryAt возвращает новый Expiry по абсолютному времени,
+// нулевое время означает что время жизни не задано
+func NewExpiryAt(at time.Time) Expiry {
+	if at.IsZero() {
+		return Expiry{}
 	}
+	return Expiry{
+		at: time.Unix(at.Unix(), int64(at.Nanosecond())),
+	}
+}
+
+// NoExpiry возвращает Expiry без времени жизни
+func NoExpiry() Expiry {
+	return Expiry{}
+}
+
+// IsSet возвращает true если время жизни задано
+func (e Expiry) IsSet() bool {
+	return !e.at.IsZero()
 }
 
-// Milliseconds возвращает время жизни в миллисекундах
+// Time возвращает абсолютное время окончания жизни,
+// нулевое время если время жизни не задано
+func (e Expiry) Time() time.Time {
+	return e.at
+}
+
+// Milliseconds возвращает время окончания жизни как время Unix
+// в миллисекундах, 0 если время жизни не задано.
+// Время до 1970 года не умещается в uint64 и возвращается как 1:
+// значение остаётся заданным и уже истёкшим
 func (e Expiry) Milliseconds() uint64 {
-	return uint64(e.t / (1000 * time.Microsecond))
+	if !e.IsSet() {
+		return 0
+	}
+	seconds := e.at.Unix()
+	if seconds < 0 || seconds == 0 && e.at.Nanosecond() < int(time.Millisecond) {
+		return 1
+	}
+	return uint64(seconds)*1000 + uint64(e.at.Nanosecond())/uint64(time.Millisecond)
 }
 
-// Seconds возвращает время жизни в секундах
+// Seconds возвращает время окончания жизни как время Unix в секундах,
+// 0 если время жизни не задано
 func (e Expiry) Seconds() float64 {
-	return e.t.Seconds()
+	return float64(e.Milliseconds()) / 1000
+}
+
+// TTL возвращает оставшееся на момент now время жизни,
+// 0 если объект уже истёк и NoTTL если время жизни не задано
+func (e Expiry) TTL(now time.Time) time.Duration {
+	if !e.IsSet() {
+		return NoTTL
+	}
+	if !now.Before(e.at) {
+		return 0
+	}
+	return e.at.Sub(now)
+}
+
+// IsExpired возвращает true если на момент now объект уже истёк
+func (e Expiry) IsExpired(now time.Time) bool {
+	return e.IsSet() && !now.Before(e.at)
 }
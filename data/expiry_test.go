@@ -2,6 +2,7 @@ package data
 
 import (
 	"testing"
+	"time"
 )
 
 func TestExpiry(t *testing.T) {
@@ -10,6 +11,22 @@ func TestExpiry(t *testing.T) {
 			testExpiryMilliseconds(t, 1492780026)
 		})
 	})
+	t.Run("Before1970", func(t *testing.T) {
+		// отрицательный PEXPIREAT даёт время до 1970 года
+		for _, at := range []time.Time{
+			time.Unix(-1, 0),
+			time.Unix(-1000000, 0),
+			time.Unix(0, 0),
+		} {
+			e := NewExpiryAt(at)
+			if result := e.Milliseconds(); result != 1 {
+				t.Fatalf("%s: expected expiry 1 but actual %d", at, result)
+			}
+			if !e.IsExpired(time.Unix(0, 0)) {
+				t.Fatalf("%s: expected expired", at)
+			}
+		}
+	})
 	t.Run("Seconds", func(t *testing.T) {
 		t.Run("1492780026", func(t *testing.T) {
 			testExpirySeconds(t, 1492780026, 1492780.026)
@@ -32,7 +49,7 @@ func testExpiryMilliseconds(t *testing.T, milliseconds uint64) {
 	}
 }
 
-// testExpiryMilliseconds проверяет значение времени жизни в секундах
+// testExpirySeconds проверяет значение времени жизни в секундах
 func testExpirySeconds(t *testing.T, milliseconds uint64, expected float64) {
 	e := NewExpiry(milliseconds)
 	result := e.Seconds()
@@ -40,3 +57,40 @@ func testExpirySeconds(t *testing.T, milliseconds uint64, expected float64) {
 		t.Fatalf("expected expiry %0.4f but actual %0.4f", expected, result)
 	}
 }
+
+// TestExpiryTTL проверяет оставшееся время жизни и признак истечения
+func TestExpiryTTL(t *testing.T) {
+	now := time.Unix(1492780026, 0)
+	t.Run("NoExpiry", func(t *testing.T) {
+		for _, e := range []Expiry{NoExpiry(), NewExpiry(0), NewExpiryAt(time.Time{})} {
+			if e.IsSet() || e.IsExpired(now) || e.TTL(now) != NoTTL {
+				t.Fatalf("unexpected expiry %#v", e)
+			}
+			if e.Milliseconds() != 0 {
+				t.Fatalf("expected 0 milliseconds but actual %d", e.Milliseconds())
+			}
+		}
+	})
+	t.Run("Future", func(t *testing.T) {
+		e := NewExpiryAt(now.Add(1500 * time.Millisecond))
+		if e.IsExpired(now) {
+			t.Fatalf("unexpected expired %#v", e)
+		}
+		if e.TTL(now) != 1500*time.Millisecond {
+			t.Fatalf("expected ttl 1.5s but actual %s", e.TTL(now))
+		}
+		if e.Milliseconds() != 1492780027500 {
+			t.Fatalf("expected 1492780027500 but actual %d", e.Milliseconds())
+		}
+	})
+	t.Run("Past", func(t *testing.T) {
+		for _, e := range []Expiry{NewExpiryAt(now), NewExpiry(1492780025000)} {
+			if !e.IsExpired(now) {
+				t.Fatalf("expected expired %#v", e)
+			}
+			if e.TTL(now) != 0 {
+				t.Fatalf("expected ttl 0 but actual %s", e.TTL(now))
+			}
+		}
+	})
+}
@@ -12,6 +12,8 @@ type Key interface {
 	Expiry() Expiry
 	SetName(string) error
 	SetExpiry(Expiry) error
+	Metadata() Metadata
+	SetMetadata(Metadata) error
 	ReplaceName(*regexp.Regexp, string) error
 }
 
@@ -28,10 +30,10 @@ type SortedSetKey interface {
 // В RDB обозначается как IntSet представляет собой дерево бинарного поиска
 type IntegerSetKey interface {
 	Key
-	Set(value uint64) error
-	SetData(data map[uint64]struct{}) error
-	Values() map[uint64]struct{}
-	Is(value uint64) bool
+	Set(value int64) error
+	SetData(data map[int64]struct{}) error
+	Values() map[int64]struct{}
+	Is(value int64) bool
 }
 
 // SetKey это интерфейс не упорядоченного набора данных
@@ -68,3 +70,25 @@ type StringKey interface {
 	Set(value string) error
 	Value() string
 }
+
+// StreamKey это интерфейс потока записей (redis streams)
+type StreamKey interface {
+	Key
+	Add(entry StreamEntry) error
+	SetData(entries []StreamEntry) error
+	Values() []StreamEntry
+	SetInfo(info StreamInfo) error
+	Info() StreamInfo
+	AddGroup(group StreamGroup) error
+	Groups() []StreamGroup
+}
+
+// ModuleKey это интерфейс значения модуля в исходном виде
+type ModuleKey interface {
+	Key
+	Module() string
+	EncVersion() uint64
+	Add(value ModuleValue) error
+	SetData(values []ModuleValue) error
+	Values() []ModuleValue
+}
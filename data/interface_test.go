@@ -25,6 +25,12 @@ func TestInterface(t *testing.T) {
 		t.Run("String", func(t *testing.T) {
 			testInterfaceKey(t, new(String))
 		})
+		t.Run("Stream", func(t *testing.T) {
+			testInterfaceKey(t, new(Stream))
+		})
+		t.Run("Module", func(t *testing.T) {
+			testInterfaceKey(t, new(Module))
+		})
 	})
 	t.Run("SortedSetKey", func(t *testing.T) {
 		testInterfaceSortedSetKey(t, new(SortedSet))
@@ -44,6 +50,12 @@ func TestInterface(t *testing.T) {
 	t.Run("StringKey", func(t *testing.T) {
 		testInterfaceStringKey(t, new(String))
 	})
+	t.Run("StreamKey", func(t *testing.T) {
+		testInterfaceStreamKey(t, new(Stream))
+	})
+	t.Run("ModuleKey", func(t *testing.T) {
+		testInterfaceModuleKey(t, new(Module))
+	})
 }
 
 // testInterfaceKey проверяет принадлежность к интерфейсу Key
@@ -95,3 +107,17 @@ func testInterfaceStringKey(t *testing.T, key interface{}) {
 		t.Fatalf("does not implement the interface")
 	}
 }
+
+// testInterfaceStreamKey проверяет принадлежность к интерфейсу StreamKey
+func testInterfaceStreamKey(t *testing.T, key interface{}) {
+	if _, ok := key.(StreamKey); !ok {
+		t.Fatalf("does not implement the interface")
+	}
+}
+
+// testInterfaceModuleKey проверяет принадлежность к интерфейсу ModuleKey
+func testInterfaceModuleKey(t *testing.T, key interface{}) {
+	if _, ok := key.(ModuleKey); !ok {
+		t.Fatalf("does not implement the interface")
+	}
+}
@@ -2,13 +2,32 @@ package data
 
 import (
 	"regexp"
+	"time"
 )
 
+// Metadata это сведения о ключе из RDB: способ кодирования значения
+// и данные для политики вытеснения, сервер записывает либо Idle (LRU)
+// либо Freq (LFU)
+type Metadata struct {
+	// Encoding это способ кодирования значения в RDB, например listpack,
+	// пустой если ключ получен не из RDB
+	Encoding string
+
+	// Idle это время простоя ключа, задано если HasIdle
+	Idle    time.Duration
+	HasIdle bool
+
+	// Freq это логарифмический счётчик обращений, задан если HasFreq
+	Freq    uint8
+	HasFreq bool
+}
+
 // key это общая часть для работы с ключами
 type key struct {
-	db     int
-	name   string
-	expiry Expiry
+	db       int
+	name     string
+	expiry   Expiry
+	metadata Metadata
 }
 
 // ReplaceName заменяет название ключа по регулярному выражению
@@ -49,3 +68,14 @@ func (k *key) SetExpiry(expiry Expiry) error {
 func (k *key) Expiry() Expiry {
 	return k.expiry
 }
+
+// SetMetadata устанавливает сведения для политики вытеснения
+func (k *key) SetMetadata(metadata Metadata) error {
+	k.metadata = metadata
+	return nil
+}
+
+// Metadata возвращает сведения для политики вытеснения
+func (k *key) Metadata() Metadata {
+	return k.metadata
+}
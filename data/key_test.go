@@ -4,6 +4,7 @@ import (
 	"reflect"
 	"regexp"
 	"testing"
+	"time"
 )
 
 // newKey возвращает новый key
@@ -50,6 +51,20 @@ func TestKey(t *testing.T) {
 
 		})
 	})
+	t.Run("Metadata", func(t *testing.T) {
+		k := newKey()
+		if k.Metadata() != (Metadata{}) {
+			t.Fatalf("expected empty metadata but actual %#v", k.Metadata())
+		}
+		metadata := Metadata{Idle: 5 * time.Second, HasIdle: true}
+		err := k.SetMetadata(metadata)
+		if err != nil {
+			t.Fatalf("set metadata error: %q", err)
+		}
+		if k.Metadata() != metadata {
+			t.Fatalf("expected metadata %#v but actual %#v", metadata, k.Metadata())
+		}
+	})
 }
 
 // testKeyDB проверяет установку и получение номера базы данных
@@ -0,0 +1,76 @@
+package data
+
+import (
+	"errors"
+)
+
+// ModuleValueType это тип значения сохранённого модулем
+type ModuleValueType uint8
+
+// Это типы значений модуля, совпадают с кодами значений в RDB
+const (
+	ModuleSigned ModuleValueType = iota + 1
+	ModuleUnsigned
+	ModuleFloat
+	ModuleDouble
+	ModuleString
+)
+
+// ModuleValue это одно значение сохранённое модулем,
+// заполнено поле соответствующее типу
+type ModuleValue struct {
+	Type     ModuleValueType
+	Signed   int64
+	Unsigned uint64
+	Float    float64
+	String   string
+}
+
+// Module это значение ключа модуля (RedisJSON, RedisBloom и прочие)
+// в исходном виде: последовательность сохранённых модулем значений
+type Module struct {
+	key
+	module     string
+	encVersion uint64
+	values     []ModuleValue
+}
+
+// NewModule возвращает новый Module,
+// module это название типа модуля из 9 символов
+func NewModule(name, module string, encVersion uint64) *Module {
+	m := new(Module)
+	m.name = name
+	m.module = module
+	m.encVersion = encVersion
+	return m
+}
+
+// Module возвращает название типа модуля
+func (m *Module) Module() string {
+	return m.module
+}
+
+// EncVersion возвращает версию кодирования значения модулем
+func (m *Module) EncVersion() uint64 {
+	return m.encVersion
+}
+
+// Add добавляет значение в конец
+func (m *Module) Add(value ModuleValue) error {
+	m.values = append(m.values, value)
+	return nil
+}
+
+// SetData полностью меняет набор значений
+func (m *Module) SetData(values []ModuleValue) error {
+	if values == nil {
+		return errors.New("expected data")
+	}
+	m.values = values
+	return nil
+}
+
+// Values возвращает набор значений
+func (m *Module) Values() []ModuleValue {
+	return m.values
+}
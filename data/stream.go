@@ -0,0 +1,179 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StreamID это идентификатор записи потока <ms>-<seq>
+type StreamID struct {
+	Ms  uint64
+	Seq uint64
+}
+
+// ParseStreamID разбирает идентификатор записи потока
+func ParseStreamID(id string) (StreamID, error) {
+	parts := strings.SplitN(id, "-", 2)
+	if len(parts) != 2 {
+		return StreamID{}, fmt.Errorf("unexpected stream id %q", id)
+	}
+	ms, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return StreamID{}, err
+	}
+	seq, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return StreamID{}, err
+	}
+	return StreamID{Ms: ms, Seq: seq}, nil
+}
+
+// String возвращает идентификатор в виде <ms>-<seq>
+func (id StreamID) String() string {
+	return strconv.FormatUint(id.Ms, 10) + "-" + strconv.FormatUint(id.Seq, 10)
+}
+
+// Less возвращает true если идентификатор меньше other
+func (id StreamID) Less(other StreamID) bool {
+	if id.Ms != other.Ms {
+		return id.Ms < other.Ms
+	}
+	return id.Seq < other.Seq
+}
+
+// StreamField это поле записи потока
+type StreamField struct {
+	Name  string
+	Value string
+}
+
+// StreamEntry это запись потока
+type StreamEntry struct {
+	ID     StreamID
+	Fields []StreamField
+}
+
+// StreamInfo это метаданные потока
+type StreamInfo struct {
+	// Length это количество записей в потоке
+	Length uint64
+
+	// LastID это последний сгенерированный идентификатор
+	LastID StreamID
+
+	// FirstID это идентификатор первой записи (rdb version 10)
+	FirstID StreamID
+
+	// MaxDeletedID это максимальный удалённый идентификатор (rdb version 10)
+	MaxDeletedID StreamID
+
+	// EntriesAdded это количество записей добавленных за всё время
+	// (rdb version 10)
+	EntriesAdded uint64
+}
+
+// StreamPendingEntry это доставленная но не подтверждённая запись
+type StreamPendingEntry struct {
+	ID            StreamID
+	Consumer      string
+	DeliveryTime  time.Time
+	DeliveryCount uint64
+}
+
+// StreamConsumer это получатель группы
+type StreamConsumer struct {
+	Name     string
+	SeenTime time.Time
+
+	// ActiveTime это время последней успешной операции (rdb version 11),
+	// для более старых версий совпадает с SeenTime
+	ActiveTime time.Time
+
+	// Pending это идентификаторы записей ожидающих подтверждения
+	Pending []StreamID
+}
+
+// StreamGroup это группа получателей потока
+type StreamGroup struct {
+	Name   string
+	LastID StreamID
+
+	// EntriesRead это количество прочитанных группой записей (rdb version 10),
+	// -1 означает что значение неизвестно
+	EntriesRead int64
+
+	Pending   []StreamPendingEntry
+	Consumers []StreamConsumer
+}
+
+// Stream это поток записей
+type Stream struct {
+	key
+	info    StreamInfo
+	entries []StreamEntry
+	groups  []StreamGroup
+}
+
+// NewStream возвращает новый Stream
+func NewStream(name string) *Stream {
+	s := new(Stream)
+	s.name = name
+	return s
+}
+
+// Add добавляет запись в конец потока
+func (s *Stream) Add(entry StreamEntry) error {
+	if len(s.entries) > 0 && !s.entries[len(s.entries)-1].ID.Less(entry.ID) {
+		return fmt.Errorf(
+			"stream id %s must be greater than %s",
+			entry.ID,
+			s.entries[len(s.entries)-1].ID,
+		)
+	}
+	s.entries = append(s.entries, entry)
+	return nil
+}
+
+// SetData полностью меняет записи потока
+func (s *Stream) SetData(entries []StreamEntry) error {
+	if entries == nil {
+		return errors.New("expected data")
+	}
+	s.entries = entries
+	return nil
+}
+
+// Values возвращает записи потока
+func (s *Stream) Values() []StreamEntry {
+	return s.entries
+}
+
+// SetInfo устанавливает метаданные потока
+func (s *Stream) SetInfo(info StreamInfo) error {
+	s.info = info
+	return nil
+}
+
+// Info возвращает метаданные потока
+func (s *Stream) Info() StreamInfo {
+	return s.info
+}
+
+// AddGroup добавляет группу получателей
+func (s *Stream) AddGroup(group StreamGroup) error {
+	for _, g := range s.groups {
+		if g.Name == group.Name {
+			return fmt.Errorf("duplicate stream group %q", group.Name)
+		}
+	}
+	s.groups = append(s.groups, group)
+	return nil
+}
+
+// Groups возвращает группы получателей
+func (s *Stream) Groups() []StreamGroup {
+	return s.groups
+}
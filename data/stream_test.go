@@ -0,0 +1,84 @@
+package data
+
+import (
+	"testing"
+)
+
+// TestStreamID проверяет разбор и сравнение идентификаторов потока
+func TestStreamID(t *testing.T) {
+	t.Run("Parse", func(t *testing.T) {
+		id, err := ParseStreamID("1526919030474-55")
+		if err != nil {
+			t.Fatalf("parse error: %q", err)
+		}
+		expected := StreamID{Ms: 1526919030474, Seq: 55}
+		if id != expected {
+			t.Fatalf("expected id %#v but actual %#v", expected, id)
+		}
+		if id.String() != "1526919030474-55" {
+			t.Fatalf("unexpected string %q", id.String())
+		}
+	})
+	t.Run("ParseError", func(t *testing.T) {
+		for _, id := range []string{"", "1", "a-1", "1-a", "-1"} {
+			if _, err := ParseStreamID(id); err == nil {
+				t.Fatalf("expected error for %q", id)
+			}
+		}
+	})
+	t.Run("Less", func(t *testing.T) {
+		if !(StreamID{Ms: 1, Seq: 5}).Less(StreamID{Ms: 2, Seq: 0}) {
+			t.Fatalf("expected 1-5 < 2-0")
+		}
+		if !(StreamID{Ms: 1, Seq: 1}).Less(StreamID{Ms: 1, Seq: 2}) {
+			t.Fatalf("expected 1-1 < 1-2")
+		}
+		if (StreamID{Ms: 1, Seq: 1}).Less(StreamID{Ms: 1, Seq: 1}) {
+			t.Fatalf("expected 1-1 >= 1-1")
+		}
+	})
+}
+
+// TestStream проверяет функциональность Stream
+func TestStream(t *testing.T) {
+	t.Run("Add", func(t *testing.T) {
+		s := NewStream("name")
+		entry := StreamEntry{
+			ID:     StreamID{Ms: 1, Seq: 0},
+			Fields: []StreamField{{Name: "f", Value: "v"}},
+		}
+		err := s.Add(entry)
+		if err != nil {
+			t.Fatalf("add error: %q", err)
+		}
+		err = s.Add(StreamEntry{ID: StreamID{Ms: 1, Seq: 0}})
+		if err == nil {
+			t.Fatalf("expected error for duplicate id")
+		}
+		AssertData(t, nil, s.Values(), []StreamEntry{entry})
+	})
+	t.Run("SetData", func(t *testing.T) {
+		s := NewStream("name")
+		entries := []StreamEntry{{ID: StreamID{Ms: 1}}}
+		err := s.SetData(entries)
+		AssertData(t, err, s.Values(), entries)
+		if s.SetData(nil) == nil {
+			t.Fatalf("expected error for nil data")
+		}
+	})
+	t.Run("Info", func(t *testing.T) {
+		s := NewStream("name")
+		info := StreamInfo{Length: 1, LastID: StreamID{Ms: 5}}
+		err := s.SetInfo(info)
+		AssertData(t, err, s.Info(), info)
+	})
+	t.Run("Groups", func(t *testing.T) {
+		s := NewStream("name")
+		group := StreamGroup{Name: "group", EntriesRead: -1}
+		err := s.AddGroup(group)
+		AssertData(t, err, s.Groups(), []StreamGroup{group})
+		if s.AddGroup(group) == nil {
+			t.Fatalf("expected error for duplicate group")
+		}
+	})
+}
@@ -11,8 +11,15 @@ type Config struct {
 	useStderr  *bool
 	useMetrics *bool
 	redis      struct {
-		host *string
-		port *int
+		host     *string
+		port     *int
+		user     *string
+		password *string
+		tls      *bool
+		tlsCA    *string
+
+		sentinel       *string
+		sentinelMaster *string
 	}
 	service struct {
 		address *string
@@ -23,9 +30,11 @@ type Config struct {
 // Check проверяет что в конфиге все необходимые данные
 func (c Config) Check() error {
 	switch {
-	case *c.redis.host == "":
+	case *c.redis.sentinel != "" && *c.redis.sentinelMaster == "":
+		return errors.New("empty redis sentinel master name")
+	case *c.redis.sentinel == "" && *c.redis.host == "":
 		return errors.New("empty redis host")
-	case *c.redis.port < 1:
+	case *c.redis.sentinel == "" && *c.redis.port < 1:
 		return errors.New("expected redis port > 0")
 	case *c.service.address == "":
 		return errors.New("empty service address")
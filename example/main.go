@@ -12,13 +12,21 @@ func main() {
 	conf := Config{}
 	conf.redis.host = flag.String("redis-host", "", "redis master hostname")
 	conf.redis.port = flag.Int("redis-port", 0, "redis master port")
+	conf.redis.user = flag.String("redis-user", "", "redis master ACL user")
+	conf.redis.password = flag.String("redis-password", "", "redis master password")
+	conf.redis.tls = flag.Bool("redis-tls", false, "connect to redis master over TLS")
+	conf.redis.tlsCA = flag.String("redis-tls-ca", "", "redis master CA bundle file")
+	conf.redis.sentinel = flag.String("redis-sentinel", "", "comma separated redis sentinel addresses host:port")
+	conf.redis.sentinelMaster = flag.String("redis-sentinel-master", "", "redis master name in sentinel")
 	conf.service.address = flag.String("service-address", "", "service address")
 	conf.useStderr = flag.Bool("use-stderr", false, "logger useStderr")
 	conf.useMetrics = flag.Bool("use-metrics", true, "enable metrics")
 	conf.replica.CacheRDB = true
 	conf.replica.CacheRDBFile = "/tmp/rdb.cache"
+	conf.replica.ReuseCacheRDB = true
 	conf.replica.BacklogSize = backlog.DefaultBacklogSize
 	conf.replica.ReadRDB = true
+	flag.BoolVar(&conf.replica.StreamRDB, "stream-rdb", false, "decode rdb without cache file")
 	flag.Parse()
 
 	err := conf.Check()
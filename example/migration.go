@@ -4,10 +4,11 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"strings"
 	"time"
 
 	srr "github.com/avito-tech/smart-redis-replication"
-	"github.com/avito-tech/smart-redis-replication/status"
+	"github.com/avito-tech/smart-redis-replication/rdb"
 )
 
 // Migration это обёртка для запуска репликации
@@ -34,60 +35,63 @@ func NewMigration(
 	return m, nil
 }
 
-// ReloadConfig могла бы перечитать конфиг,
-// но по факту нам нужно включить чтение RDB
-func (m *Migration) ReloadConfig() {
-	m.Config.replica.ReadRDB = true
-}
-
-// Start запускает миграцию, перезапускает в случае потери соединения
+// Start запускает миграцию, Supervisor переподключается в случае потери
+// соединения и продолжает репликацию с сохранённого смещения
 func (m *Migration) Start() error {
-	for {
-		m.Connect() // nolint:errcheck
-		m.ReloadConfig()
+	connConfig := srr.Config{
+		Host:     *m.Config.redis.host,
+		Port:     *m.Config.redis.port,
+		DB:       -1,
+		User:     *m.Config.redis.user,
+		Password: *m.Config.redis.password,
 	}
-}
-
-// SendStatus отправляет статус миграции в лог
-func (m *Migration) SendStatus(status status.Status, err error) {
-	_ = err
-	err = m.Consumer.ReplicaStatus(status)
-	_ = err
-}
-
-// Connect подключается к редису и запускает миграцию
-func (m *Migration) Connect() error {
-	conn, err := srr.NewConnect(*m.Config.redis.host, *m.Config.redis.port, -1)
-	if err != nil {
-		return fmt.Errorf("connect error: %v", err)
+	if *m.Config.redis.tls {
+		connConfig.TLS = &srr.TLSConfig{CAFile: *m.Config.redis.tlsCA}
 	}
-	repl, err := conn.NewReplica(m.Config.replica)
+	// значения ключей без нужного префикса пропускаются без разбора
+	m.Config.replica.RDBOptions = append(
+		m.Config.replica.RDBOptions,
+		rdb.WithKeyFilter(func(_ int, name string, _ rdb.KeyType) bool {
+			return m.Consumer.CheckKeyName(name)
+		}),
+	)
+	supervisorConfig := srr.SupervisorConfig{
+		Connect: connConfig,
+		Replica: m.Config.replica,
+	}
+	if *m.Config.redis.sentinel != "" {
+		supervisorConfig.Sentinel = &srr.SentinelConfig{
+			Addresses:  strings.Split(*m.Config.redis.sentinel, ","),
+			MasterName: *m.Config.redis.sentinelMaster,
+		}
+	}
+	supervisor, err := srr.NewSupervisor(supervisorConfig)
 	if err != nil {
-		return fmt.Errorf("create replica error: %v", err)
+		return fmt.Errorf("create supervisor error: %v", err)
 	}
 
-	go m.Statistics(repl)
+	go m.Statistics(supervisor)
 
-	m.SendStatus(status.Connect, nil)
-	err = repl.Do(m.Consumer)
-	m.SendStatus(status.Reconnect, err)
-	return err
+	err = supervisor.Run(m.Consumer)
+	if err != nil {
+		return fmt.Errorf("replication error: %v", err)
+	}
+	return nil
 }
 
 // Statistics отправляет размер журнала отставания репликации в мониторинг
-func (m *Migration) Statistics(repl srr.Replica) {
-	backlog := repl.Backlog()
-	if backlog == nil {
-		log.Println("statistics off")
-		return
-	}
+func (m *Migration) Statistics(supervisor *srr.Supervisor) {
 	log.Println("statistics on")
 	for {
 		select {
-		case <-repl.Done():
+		case <-supervisor.Done():
 			return
 		case <-time.After(1 * time.Minute):
 		}
+		backlog := supervisor.Backlog()
+		if backlog == nil {
+			continue
+		}
 		go m.backlogSize(*m.Config.redis.port, backlog.Count())
 	}
 }
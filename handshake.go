@@ -0,0 +1,192 @@
+package replica
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/avito-tech/smart-redis-replication/resp"
+)
+
+// HandshakeError это ошибка одного из шагов подготовки соединения
+type HandshakeError struct {
+	// Step это команда на которой произошла ошибка
+	Step string
+
+	// Err это причина ошибки
+	Err error
+}
+
+// Error возвращает текст ошибки
+func (e *HandshakeError) Error() string {
+	return fmt.Sprintf("handshake %s error: %v", e.Step, e.Err)
+}
+
+// handshake проверяет соединение, авторизуется и выбирает базу данных
+func (c *Conn) handshake() error {
+	err := c.ping()
+	if err != nil {
+		return err
+	}
+	if c.config.Password != "" {
+		err = c.auth()
+		if err != nil {
+			return err
+		}
+	}
+	if c.config.DB > -1 {
+		err = c.expectOK("SELECT", "SELECT", c.config.DB)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replconf сообщает серверу параметры реплики
+func (c *Conn) replconf() error {
+	if c.config.ListeningPort > 0 {
+		err := c.expectOK(
+			"REPLCONF listening-port",
+			"REPLCONF",
+			"listening-port",
+			strconv.Itoa(c.config.ListeningPort),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	if c.config.IPAddress != "" {
+		err := c.expectOK(
+			"REPLCONF ip-address",
+			"REPLCONF",
+			"ip-address",
+			c.config.IPAddress,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	args := []interface{}{}
+	for _, capa := range c.config.capabilities() {
+		args = append(args, "capa", capa)
+	}
+	return c.expectOK("REPLCONF capa", "REPLCONF", args...)
+}
+
+// ping отправляет PING, ошибка авторизации допустима если задан пароль,
+// так как авторизация выполняется следующим шагом
+func (c *Conn) ping() error {
+	reply, err := c.request("PING")
+	if serverErr, ok := err.(resp.ServerError); ok {
+		if isAuthError(serverErr) && c.config.Password != "" {
+			return nil
+		}
+	}
+	if err != nil {
+		return &HandshakeError{Step: "PING", Err: err}
+	}
+	if reply != "PONG" {
+		return &HandshakeError{
+			Step: "PING",
+			Err:  fmt.Errorf("expected PONG but actual %q", reply),
+		}
+	}
+	return nil
+}
+
+// auth отправляет AUTH с паролем или с именем пользователя и паролем
+func (c *Conn) auth() error {
+	if c.config.User != "" {
+		return c.expectOK("AUTH", "AUTH", c.config.User, c.config.Password)
+	}
+	return c.expectOK("AUTH", "AUTH", c.config.Password)
+}
+
+// expectOK отправляет команду и проверяет что сервер ответил OK
+func (c *Conn) expectOK(
+	step string,
+	commandName string,
+	args ...interface{},
+) error {
+	reply, err := c.request(commandName, args...)
+	if err != nil {
+		return &HandshakeError{Step: step, Err: err}
+	}
+	if reply != "OK" {
+		return &HandshakeError{
+			Step: step,
+			Err:  fmt.Errorf("expected OK but actual %q", reply),
+		}
+	}
+	return nil
+}
+
+// request отправляет команду и читает однострочный ответ сервера
+func (c *Conn) request(commandName string, args ...interface{}) (string, error) {
+	err := c.send(commandName, args...)
+	if err != nil {
+		return "", err
+	}
+	err = c.skipPending()
+	if err != nil {
+		return "", err
+	}
+	return c.reader.ReadReply()
+}
+
+// requestArray отправляет команду и читает ответ сервера в виде массива
+func (c *Conn) requestArray(
+	commandName string,
+	args ...interface{},
+) ([]string, error) {
+	err := c.send(commandName, args...)
+	if err != nil {
+		return nil, err
+	}
+	err = c.skipPending()
+	if err != nil {
+		return nil, err
+	}
+	return c.reader.ReadArrayReply()
+}
+
+// requestValue отправляет команду и читает ответ сервера с сохранением
+// вложенности массивов
+func (c *Conn) requestValue(
+	commandName string,
+	args ...interface{},
+) (interface{}, error) {
+	err := c.send(commandName, args...)
+	if err != nil {
+		return nil, err
+	}
+	err = c.skipPending()
+	if err != nil {
+		return nil, err
+	}
+	return c.reader.ReadValueReply()
+}
+
+// skipPending пропускает ответы на команды отправленные через Send,
+// ошибки сервера в этих ответах не проверяются
+func (c *Conn) skipPending() error {
+	for ; c.pending > 0; c.pending-- {
+		_, err := c.reader.ReadValueReply()
+		if _, ok := err.(resp.ServerError); ok {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isAuthError возвращает true если сервер требует авторизацию
+func isAuthError(err resp.ServerError) bool {
+	message := err.Error()
+	return strings.HasPrefix(message, "NOAUTH") ||
+		strings.HasPrefix(message, "NOPERM") ||
+		strings.HasPrefix(message, "ERR operation not permitted")
+}
@@ -0,0 +1,223 @@
+package replica
+
+import (
+	"bufio"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/avito-tech/smart-redis-replication/replica"
+	"github.com/avito-tech/smart-redis-replication/resp"
+)
+
+// TestHandshake проверяет подготовку соединения к репликации
+func TestHandshake(t *testing.T) {
+	t.Run("Normal", func(t *testing.T) {
+		t.Run("NoAuth", func(t *testing.T) {
+			testHandshake(
+				t,
+				Config{DB: -1},
+				[]string{"+PONG", "+OK"},
+				[]string{"PING", "REPLCONF capa eof capa psync2"},
+				true,
+			)
+		})
+		t.Run("Password", func(t *testing.T) {
+			testHandshake(
+				t,
+				Config{DB: -1, Password: "secret"},
+				[]string{"-NOAUTH Authentication required.", "+OK", "+OK"},
+				[]string{"PING", "AUTH secret", "REPLCONF capa eof capa psync2"},
+				true,
+			)
+		})
+		t.Run("UserPassword", func(t *testing.T) {
+			testHandshake(
+				t,
+				Config{
+					DB:            0,
+					User:          "replica",
+					Password:      "secret",
+					ListeningPort: 6380,
+					IPAddress:     "10.0.0.1",
+					Capabilities:  []string{"eof", "psync2"},
+				},
+				[]string{"+PONG", "+OK", "+OK", "+OK", "+OK", "+OK"},
+				[]string{
+					"PING",
+					"AUTH replica secret",
+					"SELECT 0",
+					"REPLCONF listening-port 6380",
+					"REPLCONF ip-address 10.0.0.1",
+					"REPLCONF capa eof capa psync2",
+				},
+				true,
+			)
+		})
+	})
+	t.Run("Error", func(t *testing.T) {
+		t.Run("NoPassword", func(t *testing.T) {
+			testHandshake(
+				t,
+				Config{DB: -1},
+				[]string{"-NOAUTH Authentication required."},
+				[]string{"PING"},
+				false,
+			)
+		})
+		t.Run("WrongPassword", func(t *testing.T) {
+			testHandshake(
+				t,
+				Config{DB: -1, Password: "wrong"},
+				[]string{"+PONG", "-WRONGPASS invalid username-password pair"},
+				[]string{"PING", "AUTH wrong"},
+				false,
+			)
+		})
+		t.Run("Replconf", func(t *testing.T) {
+			testHandshake(
+				t,
+				Config{DB: -1},
+				[]string{"+PONG", "-ERR unknown command"},
+				[]string{"PING", "REPLCONF capa eof capa psync2"},
+				false,
+			)
+		})
+	})
+}
+
+// TestHandshakeSend проверяет что ответ на команду Send не принимается
+// за ответ следующей команды подготовки соединения
+func TestHandshakeSend(t *testing.T) {
+	// net.Pipe не буферизует запись, поэтому сервер на TCP соединении
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen error: %v", err)
+	}
+	defer listener.Close() // nolint:errcheck
+
+	commands := make(chan []string, 1)
+	go func() {
+		server, err := listener.Accept()
+		if err != nil {
+			commands <- nil
+			return
+		}
+		defer server.Close() // nolint:errcheck
+		fakeServer(server, []string{"-ERR wrong type", "+OK"}, commands)
+	}()
+
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("dial error: %v", err)
+	}
+	conn, err := NewConn(client)
+	if err != nil {
+		t.Fatalf("new conn error: %v", err)
+	}
+	defer conn.Close() // nolint:errcheck
+	err = conn.Send("SET", "key", "value")
+	if err != nil {
+		t.Fatalf("send error: %v", err)
+	}
+	_, err = conn.NewReplica(replica.Config{})
+	if err != nil {
+		t.Fatalf("replconf error: %v", err)
+	}
+	expected := []string{"SET key value", "REPLCONF capa eof capa psync2"}
+	if result := <-commands; !reflect.DeepEqual(result, expected) {
+		t.Fatalf("expected commands %q but actual %q", expected, result)
+	}
+}
+
+// TestReplicaConn проверяет что данные прочитанные в буфер во время
+// подготовки соединения достаются реплике
+func TestReplicaConn(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close() // nolint:errcheck
+
+	commands := make(chan []string, 1)
+	go fakeServer(server, []string{"+PONG\r\n+FULLRESYNC 8de1 0"}, commands)
+
+	conn, err := NewConn(client)
+	if err != nil {
+		t.Fatalf("new conn error: %v", err)
+	}
+	defer conn.Close() // nolint:errcheck
+	err = conn.handshake()
+	if err != nil {
+		t.Fatalf("handshake error: %v", err)
+	}
+	<-commands
+
+	line, err := bufio.NewReader(conn.replicaConn()).ReadString('\n')
+	if err != nil {
+		t.Fatalf("read error: %v", err)
+	}
+	if line != "+FULLRESYNC 8de1 0\r\n" {
+		t.Fatalf("expected buffered reply but actual %q", line)
+	}
+}
+
+// testHandshake проверяет команды отправленные серверу и обработку ответов
+func testHandshake(
+	t *testing.T,
+	config Config,
+	replies []string,
+	expectedCommands []string,
+	success bool,
+) {
+	client, server := net.Pipe()
+	defer server.Close() // nolint:errcheck
+
+	commands := make(chan []string, 1)
+	go fakeServer(server, replies, commands)
+
+	conn, err := NewConn(client)
+	if err != nil {
+		t.Fatalf("new conn error: %v", err)
+	}
+	conn.config = config
+	err = conn.handshake()
+	if err == nil {
+		_, err = conn.NewReplica(replica.Config{})
+	}
+	conn.Close() // nolint:errcheck
+
+	if success && err != nil {
+		t.Fatalf("handshake error: %v", err)
+	}
+	if !success {
+		if err == nil {
+			t.Fatalf("expected error")
+		}
+		if _, ok := err.(*HandshakeError); !ok {
+			t.Fatalf("expected HandshakeError but actual %#v", err)
+		}
+	}
+	result := <-commands
+	if !reflect.DeepEqual(result, expectedCommands) {
+		t.Fatalf("expected commands %q but actual %q", expectedCommands, result)
+	}
+}
+
+// fakeServer читает команды и отвечает на них заранее заданными ответами
+func fakeServer(conn net.Conn, replies []string, commands chan<- []string) {
+	r := resp.NewReader(bufio.NewReader(conn))
+	result := []string{}
+	defer func() {
+		commands <- result
+	}()
+	for _, reply := range replies {
+		cmd, err := r.Command()
+		if err != nil {
+			return
+		}
+		result = append(result, strings.Join(cmd.Args(), " "))
+		_, err = conn.Write([]byte(reply + "\r\n"))
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package rdb
+
+import (
+	"bufio"
+	"fmt"
+	"hash/crc64"
+	"io"
+)
+
+// crc64Jones это полином CRC64 Jones в отражённом виде,
+// redis использует его без инверсии начального и конечного значения
+const crc64Jones = 0x95AC9329AC4BC9B5
+
+// crc64Table это таблица для вычисления CRC64 Jones
+var crc64Table = crc64.MakeTable(crc64Jones)
+
+// crc64Update продолжает вычисление контрольной суммы RDB
+func crc64Update(crc uint64, p []byte) uint64 {
+	return ^crc64.Update(^crc, crc64Table, p)
+}
+
+// ChecksumError это ошибка несовпадения контрольной суммы RDB
+type ChecksumError struct {
+	// Expected это контрольная сумма записанная в конце RDB
+	Expected uint64
+
+	// Actual это контрольная сумма прочитанных данных
+	Actual uint64
+}
+
+// Error возвращает текст ошибки
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf(
+		"rdb checksum mismatch: expected %#016x but actual %#016x",
+		e.Expected,
+		e.Actual,
+	)
+}
+
+// checksumReader считает контрольную сумму всех прочитанных байт,
+// считать её нужно после буфера чтобы не учитывать прочитанное заранее
+type checksumReader struct {
+	*bufio.Reader
+	crc uint64
+}
+
+// newChecksumReader возвращает новый checksumReader
+func newChecksumReader(r *bufio.Reader) *checksumReader {
+	return &checksumReader{Reader: r}
+}
+
+// Read читает данные и обновляет контрольную сумму
+func (r *checksumReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.crc = crc64Update(r.crc, p[:n])
+	return n, err
+}
+
+// ReadByte читает один байт и обновляет контрольную сумму
+func (r *checksumReader) ReadByte() (byte, error) {
+	b, err := r.Reader.ReadByte()
+	if err != nil {
+		return b, err
+	}
+	r.crc = crc64Table[byte(r.crc)^b] ^ (r.crc >> 8)
+	return b, nil
+}
+
+// Discard пропускает n байт и обновляет контрольную сумму
+func (r *checksumReader) Discard(n int) (int, error) {
+	discarded := 0
+	for discarded < n {
+		size := n - discarded
+		if size > r.Reader.Size() {
+			size = r.Reader.Size()
+		}
+		p, err := r.Reader.Peek(size)
+		r.crc = crc64Update(r.crc, p)
+		m, _ := r.Reader.Discard(len(p))
+		discarded += m
+		if err != nil {
+			return discarded, err
+		}
+	}
+	return discarded, nil
+}
+
+// Checksum возвращает контрольную сумму прочитанных данных
+func (r *checksumReader) Checksum() uint64 {
+	return r.crc
+}
+
+// checksumWriter считает контрольную сумму всех записанных байт
+type checksumWriter struct {
+	w   io.Writer
+	crc uint64
+}
+
+// newChecksumWriter возвращает новый checksumWriter
+func newChecksumWriter(w io.Writer) *checksumWriter {
+	return &checksumWriter{w: w}
+}
+
+// Write записывает данные и обновляет контрольную сумму
+func (w *checksumWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.crc = crc64Update(w.crc, p[:n])
+	return n, err
+}
+
+// Checksum возвращает контрольную сумму записанных данных
+func (w *checksumWriter) Checksum() uint64 {
+	return w.crc
+}
@@ -0,0 +1,83 @@
+package rdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// TestCRC64 проверяет вычисление CRC64 Jones как в redis
+func TestCRC64(t *testing.T) {
+	crc := crc64Update(0, []byte("123456789"))
+	if crc != 0xe9c6d914c4b8d9ca {
+		t.Fatalf("expected crc %#x but actual %#x", uint64(0xe9c6d914c4b8d9ca), crc)
+	}
+	// вычисление по частям совпадает с вычислением целиком
+	crc = crc64Update(crc64Update(0, []byte("1234")), []byte("56789"))
+	if crc != 0xe9c6d914c4b8d9ca {
+		t.Fatalf("expected incremental crc %#x but actual %#x",
+			uint64(0xe9c6d914c4b8d9ca),
+			crc,
+		)
+	}
+}
+
+// TestDecodeChecksum проверяет проверку контрольной суммы в конце RDB
+func TestDecodeChecksum(t *testing.T) {
+	t.Run("Valid", func(t *testing.T) {
+		err := NewDecoder(testChecksumRDB(false, nil)).DecodeKeys(&testKeyConsumer{})
+		if err != nil {
+			t.Fatalf("decode error: %v", err)
+		}
+	})
+	t.Run("Corrupted", func(t *testing.T) {
+		err := NewDecoder(testChecksumRDB(true, nil)).DecodeKeys(&testKeyConsumer{})
+		checksumErr, ok := err.(*ChecksumError)
+		if !ok {
+			t.Fatalf("expected ChecksumError but actual %#v", err)
+		}
+		if checksumErr.Expected == checksumErr.Actual {
+			t.Fatalf("unexpected checksum error %v", checksumErr)
+		}
+	})
+	t.Run("Disabled", func(t *testing.T) {
+		decoder := NewDecoder(testChecksumRDB(true, nil), WithChecksum(false))
+		err := decoder.DecodeKeys(&testKeyConsumer{})
+		if err != nil {
+			t.Fatalf("decode error: %v", err)
+		}
+	})
+	t.Run("Zero", func(t *testing.T) {
+		zero := uint64(0)
+		err := NewDecoder(testChecksumRDB(true, &zero)).DecodeKeys(&testKeyConsumer{})
+		if err != nil {
+			t.Fatalf("decode error: %v", err)
+		}
+	})
+}
+
+// testChecksumRDB возвращает RDB с одним ключом и контрольной суммой,
+// corrupt меняет значение ключа после вычисления контрольной суммы,
+// checksum заменяет вычисленную контрольную сумму
+func testChecksumRDB(corrupt bool, checksum *uint64) *bytes.Buffer {
+	var rdb bytes.Buffer
+	rdb.Write(NewMagic(9).Bytes())
+	rdb.Write(NewAuxField("redis-ver", "7.2.0").Bytes())
+	rdb.Write(NewDBSelector(0).Bytes())
+	rdb.WriteByte(StringValueOpcode)
+	rdb.Write(EncodeString("key"))
+	rdb.Write(EncodeString("value"))
+	rdb.WriteByte(EOFOpcode)
+
+	crc := crc64Update(0, rdb.Bytes())
+	if checksum != nil {
+		crc = *checksum
+	}
+	body := rdb.Bytes()
+	if corrupt {
+		body[len(body)-2] = 'X'
+	}
+	crcBytes := make([]byte, 8)
+	binary.LittleEndian.PutUint64(crcBytes, crc)
+	return bytes.NewBuffer(append(body, crcBytes...))
+}
@@ -0,0 +1,268 @@
+package rdb
+
+import (
+	"fmt"
+
+	"github.com/avito-tech/smart-redis-replication/data"
+)
+
+// DefaultChunkSize это количество значений в одной части ключа
+// для ChunkConsumer если размер не задан через WithChunkSize
+const DefaultChunkSize = 1024
+
+// ChunkConsumer это потребитель больших ключей по частям,
+// если KeyConsumer или Consumer реализует этот интерфейс то коллекции
+// больше размера части передаются последовательно в KeyStart, KeyChunk
+// и KeyEnd вместо Key, ключи меньшего размера по-прежнему передаются в Key
+type ChunkConsumer interface {
+	// KeyStart принимает ключ без значений перед его частями
+	KeyStart(data.Key) error
+
+	// KeyChunk принимает ключ того же типа содержащий только
+	// значения очередной части
+	KeyChunk(data.Key) error
+
+	// KeyEnd принимает ключ без значений после всех его частей
+	KeyEnd(data.Key) error
+}
+
+// KeyStart это начало ключа передаваемого частями
+type KeyStart struct {
+	key data.Key
+}
+
+// GetKey возвращает ключ без значений
+func (k KeyStart) GetKey() data.Key {
+	return k.key
+}
+
+// KeyChunk это часть значений ключа
+type KeyChunk struct {
+	key data.Key
+}
+
+// GetKey возвращает ключ содержащий только значения части
+func (k KeyChunk) GetKey() data.Key {
+	return k.key
+}
+
+// KeyEnd это окончание ключа передаваемого частями
+type KeyEnd struct {
+	key data.Key
+}
+
+// GetKey возвращает ключ без значений
+func (k KeyEnd) GetKey() data.Key {
+	return k.key
+}
+
+// ChunkReader это интерфейс чтения значений большого ключа частями
+type ChunkReader interface {
+	// Key возвращает ключ без значений
+	Key() data.Key
+
+	// Len возвращает количество непрочитанных элементов,
+	// для QuickList это количество узлов включая прочитанные Fits
+	Len() uint32
+
+	// Fits возвращает true если все значения умещаются в одну часть,
+	// для QuickList читает узлы пока значений не станет больше size
+	Fits(size int) (bool, error)
+
+	// ReadChunk читает следующую часть из size значений или всех
+	// оставшихся, узел QuickList читается целиком поэтому часть может
+	// оказаться больше
+	ReadChunk(size int) (data.Key, error)
+}
+
+// IsChunkOpcode возвращает true если ключ с этим типом можно читать
+// частями, это типы значения которых записаны по одному, а не
+// упакованы в одну строку
+func IsChunkOpcode(opcode byte) bool {
+	switch opcode {
+	case ListOpcode, SetOpcode, SortedSetOpcode, SortedSet2Opcode,
+		ListHashMapOpcode, QuickListOpcode, QuickList2Opcode:
+		return true
+	}
+	return false
+}
+
+// chunkReader реализует интерфейс ChunkReader
+type chunkReader struct {
+	key   data.Key
+	count uint32
+	nodes bool
+
+	// pending содержит значения узлов QuickList прочитанных Fits,
+	// они передаются в начале следующей части
+	pending      data.ListKey
+	pendingNodes uint32
+
+	// newKey возвращает новый пустой ключ того же типа
+	newKey func(name string) data.Key
+
+	// readValue читает один элемент или узел в key и возвращает
+	// количество прочитанных значений
+	readValue func(key data.Key) (int, error)
+}
+
+// Key возвращает ключ без значений
+func (c *chunkReader) Key() data.Key {
+	return c.key
+}
+
+// Len возвращает количество непрочитанных элементов
+func (c *chunkReader) Len() uint32 {
+	return c.count + c.pendingNodes
+}
+
+// Fits возвращает true если все значения умещаются в одну часть,
+// количество значений узлов QuickList известно только после их чтения
+// поэтому узлы читаются заранее пока не наберётся больше size значений
+func (c *chunkReader) Fits(size int) (bool, error) {
+	if !c.nodes {
+		return c.count <= uint32(size), nil
+	}
+	if c.pending == nil {
+		c.pending = data.NewList(c.key.Name())
+	}
+	for c.count > 0 && len(c.pending.Values()) <= size {
+		c.count--
+		_, err := c.readValue(c.pending)
+		if err != nil {
+			return false, err
+		}
+		c.pendingNodes++
+	}
+	return len(c.pending.Values()) <= size, nil
+}
+
+// ReadChunk читает следующую часть значений
+func (c *chunkReader) ReadChunk(size int) (data.Key, error) {
+	key := c.newKey(c.key.Name())
+	err := key.SetDB(c.key.DB())
+	if err != nil {
+		return nil, err
+	}
+	err = key.SetExpiry(c.key.Expiry())
+	if err != nil {
+		return nil, err
+	}
+	err = key.SetMetadata(c.key.Metadata())
+	if err != nil {
+		return nil, err
+	}
+	read := 0
+	if c.pending != nil {
+		values := c.pending.Values()
+		err = key.(data.ListKey).Rpush(values...)
+		if err != nil {
+			return nil, err
+		}
+		read = len(values)
+		c.pending, c.pendingNodes = nil, 0
+	}
+	for c.count > 0 && read < size {
+		c.count--
+		n, err := c.readValue(key)
+		if err != nil {
+			return nil, err
+		}
+		read += n
+	}
+	return key, nil
+}
+
+// readChunks читает количество элементов ключа keyName и возвращает
+// ChunkReader для чтения его значений, opcode должен удовлетворять
+// IsChunkOpcode
+// nolint:gocyclo
+func (r *reader) readChunks(
+	opcode byte,
+	keyName string,
+	expiry data.Expiry,
+) (
+	ChunkReader,
+	error,
+) {
+	if !IsChunkOpcode(opcode) {
+		return nil, fmt.Errorf("unexpected chunk opcode %#v", opcode)
+	}
+	count, _, err := r.ReadLength()
+	if err != nil {
+		return nil, err
+	}
+
+	c := &chunkReader{count: count}
+	switch opcode {
+	case ListOpcode:
+		c.newKey = func(name string) data.Key { return data.NewList(name) }
+		c.readValue = func(key data.Key) (int, error) {
+			value, err := r.ReadString()
+			if err != nil {
+				return 0, err
+			}
+			return 1, key.(data.ListKey).Rpush(value)
+		}
+	case SetOpcode:
+		c.newKey = func(name string) data.Key { return data.NewSet(name) }
+		c.readValue = func(key data.Key) (int, error) {
+			value, err := r.ReadString()
+			if err != nil {
+				return 0, err
+			}
+			return 1, key.(data.SetKey).Set(value)
+		}
+	case SortedSetOpcode, SortedSet2Opcode:
+		readScore := r.ReadFloat64
+		if opcode == SortedSet2Opcode {
+			readScore = r.ReadBinaryFloat64
+		}
+		c.newKey = func(name string) data.Key { return data.NewSortedSet(name) }
+		c.readValue = func(key data.Key) (int, error) {
+			value, err := r.ReadString()
+			if err != nil {
+				return 0, err
+			}
+			score, err := readScore()
+			if err != nil {
+				return 0, err
+			}
+			return 1, key.(data.SortedSetKey).Set(score, value)
+		}
+	case ListHashMapOpcode:
+		c.newKey = func(name string) data.Key { return data.NewMap(name) }
+		c.readValue = func(key data.Key) (int, error) {
+			field, err := r.ReadString()
+			if err != nil {
+				return 0, err
+			}
+			value, err := r.ReadString()
+			if err != nil {
+				return 0, err
+			}
+			return 1, key.(data.MapKey).Set(field, value)
+		}
+	case QuickListOpcode, QuickList2Opcode:
+		c.nodes = true
+		c.newKey = func(name string) data.Key { return data.NewList(name) }
+		c.readValue = func(key data.Key) (int, error) {
+			list := key.(data.ListKey)
+			before := len(list.Values())
+			var err error
+			if opcode == QuickListOpcode {
+				err = r.readQuickListNode(list)
+			} else {
+				err = r.readQuickList2Node(list)
+			}
+			return len(list.Values()) - before, err
+		}
+	}
+
+	c.key = c.newKey(keyName)
+	err = c.key.SetExpiry(expiry)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
@@ -0,0 +1,206 @@
+package rdb
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/avito-tech/smart-redis-replication/data"
+)
+
+// TestDecodeChunks проверяет передачу больших ключей частями
+func TestDecodeChunks(t *testing.T) {
+	body := testChunkRDB()
+
+	t.Run("Chunks", func(t *testing.T) {
+		consumer := &testChunkConsumer{}
+		err := NewDecoder(bytes.NewReader(body), WithChunkSize(2)).DecodeKeys(consumer)
+		if err != nil {
+			t.Fatalf("decode error: %v", err)
+		}
+		expected := []string{
+			"start zset",
+			"chunk zset [a b]", "chunk zset [c d]", "chunk zset [e]",
+			"end zset",
+			"key set [x y]",
+			"start list",
+			"chunk list [1 2 3]", "chunk list [4 5]",
+			"end list",
+			"key hash [f]",
+		}
+		if !reflect.DeepEqual(consumer.events, expected) {
+			t.Fatalf("expected %q but actual %q", expected, consumer.events)
+		}
+		encodings := []string{"skiplist", "hashtable", "quicklist", "hashtable"}
+		if !reflect.DeepEqual(consumer.encodings, encodings) {
+			t.Fatalf("expected %q but actual %q", encodings, consumer.encodings)
+		}
+	})
+	t.Run("KeyConsumer", func(t *testing.T) {
+		consumer := &testKeyConsumer{}
+		err := NewDecoder(bytes.NewReader(body), WithChunkSize(2)).DecodeKeys(consumer)
+		if err != nil {
+			t.Fatalf("decode error: %v", err)
+		}
+		names := make([]string, 0, len(consumer.keys))
+		for _, key := range consumer.keys {
+			names = append(names, testChunkEvent("key", key))
+		}
+		expected := []string{
+			"key zset [a b c d e]",
+			"key set [x y]",
+			"key list [1 2 3 4 5]",
+			"key hash [f]",
+		}
+		if !reflect.DeepEqual(names, expected) {
+			t.Fatalf("expected %q but actual %q", expected, names)
+		}
+	})
+	t.Run("Default", func(t *testing.T) {
+		consumer := &testChunkConsumer{}
+		err := NewDecoder(bytes.NewReader(body)).DecodeKeys(consumer)
+		if err != nil {
+			t.Fatalf("decode error: %v", err)
+		}
+		expected := []string{
+			"key zset [a b c d e]",
+			"key set [x y]",
+			"key list [1 2 3 4 5]",
+			"key hash [f]",
+		}
+		if !reflect.DeepEqual(consumer.events, expected) {
+			t.Fatalf("expected %q but actual %q", expected, consumer.events)
+		}
+	})
+	t.Run("QuickListNodes", func(t *testing.T) {
+		// значения нескольких узлов умещаются в одну часть
+		consumer := &testChunkConsumer{}
+		err := NewDecoder(bytes.NewReader(body), WithChunkSize(5)).DecodeKeys(consumer)
+		if err != nil {
+			t.Fatalf("decode error: %v", err)
+		}
+		if consumer.events[2] != "key list [1 2 3 4 5]" {
+			t.Fatalf("expected whole list but actual %q", consumer.events)
+		}
+
+		// значений узлов больше размера части
+		consumer = &testChunkConsumer{}
+		err = NewDecoder(bytes.NewReader(body), WithChunkSize(3)).DecodeKeys(consumer)
+		if err != nil {
+			t.Fatalf("decode error: %v", err)
+		}
+		expected := []string{
+			"start list",
+			"chunk list [1 2 3 4]", "chunk list [5]",
+			"end list",
+		}
+		if !reflect.DeepEqual(consumer.events[5:9], expected) {
+			t.Fatalf("expected %q but actual %q", expected, consumer.events)
+		}
+	})
+}
+
+// testChunkRDB возвращает RDB с ключами разных типов которые можно читать
+// частями
+func testChunkRDB() []byte {
+	var rdb bytes.Buffer
+	rdb.Write(NewMagic(11).Bytes())
+	rdb.Write(NewDBSelector(0).Bytes())
+
+	rdb.WriteByte(SortedSet2Opcode)
+	rdb.Write(EncodeString("zset"))
+	rdb.Write(EncodeLength(5))
+	for i, member := range []string{"a", "b", "c", "d", "e"} {
+		rdb.Write(EncodeString(member))
+		rdb.Write(testBinaryFloat64(float64(i)))
+	}
+
+	rdb.WriteByte(SetOpcode)
+	rdb.Write(EncodeString("set"))
+	rdb.Write(EncodeLength(2))
+	rdb.Write(EncodeString("x"))
+	rdb.Write(EncodeString("y"))
+
+	// узлы QuickList читаются целиком
+	rdb.WriteByte(QuickList2Opcode)
+	rdb.Write(EncodeString("list"))
+	rdb.Write(EncodeLength(3))
+	rdb.Write(EncodeLength(quickListNodePacked))
+	rdb.Write(EncodeString(testListpack(
+		testListpackInt(1),
+		testListpackInt(2),
+		testListpackInt(3),
+	)))
+	rdb.Write(EncodeLength(quickListNodePlain))
+	rdb.Write(EncodeString("4"))
+	rdb.Write(EncodeLength(quickListNodePlain))
+	rdb.Write(EncodeString("5"))
+
+	rdb.WriteByte(ListHashMapOpcode)
+	rdb.Write(EncodeString("hash"))
+	rdb.Write(EncodeLength(1))
+	rdb.Write(EncodeString("f"))
+	rdb.Write(EncodeString("v"))
+
+	rdb.WriteByte(EOFOpcode)
+	rdb.Write(make([]byte, 8))
+	return rdb.Bytes()
+}
+
+// testChunkConsumer записывает полученные ключи и части вместе
+// со способом кодирования ключей
+type testChunkConsumer struct {
+	events    []string
+	encodings []string
+}
+
+func (c *testChunkConsumer) Key(key data.Key) error {
+	c.events = append(c.events, testChunkEvent("key", key))
+	c.encodings = append(c.encodings, key.Metadata().Encoding)
+	return nil
+}
+
+func (c *testChunkConsumer) KeyStart(key data.Key) error {
+	c.events = append(c.events, "start "+key.Name())
+	c.encodings = append(c.encodings, key.Metadata().Encoding)
+	return nil
+}
+
+func (c *testChunkConsumer) KeyChunk(key data.Key) error {
+	c.events = append(c.events, testChunkEvent("chunk", key))
+	return nil
+}
+
+func (c *testChunkConsumer) KeyEnd(key data.Key) error {
+	c.events = append(c.events, "end "+key.Name())
+	return nil
+}
+
+// testChunkEvent возвращает описание ключа со списком значений
+func testChunkEvent(event string, key data.Key) string {
+	var values []string
+	switch key := key.(type) {
+	case data.SortedSetKey:
+		for value := range key.Values() {
+			values = append(values, value)
+		}
+	case data.SetKey:
+		for value := range key.Values() {
+			values = append(values, value)
+		}
+	case data.IntegerSetKey:
+		for value := range key.Values() {
+			values = append(values, fmt.Sprint(value))
+		}
+	case data.MapKey:
+		for field := range key.Values() {
+			values = append(values, field)
+		}
+	case data.ListKey:
+		values = append(values, key.Values()...)
+	}
+	sort.Strings(values)
+	return fmt.Sprintf("%s %s %v", event, key.Name(), values)
+}
@@ -4,6 +4,9 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/avito-tech/smart-redis-replication/data"
 )
@@ -16,49 +19,164 @@ const (
 
 // decoder реализует интерфейс Decoder
 type decoder struct {
-	r               Reader
+	r               *reader
 	tokenLevelState int
 	file            *os.File
+	verifyChecksum  bool
+	dropExpired     bool
+	snapshotTime    time.Time
+	chunkSize       int
+	chunks          ChunkReader
+	dropChunks      bool
+	keyFilter       KeyFilter
+	modules         *ModuleRegistry
+	db              uint32
+	version         uint32
 }
 
-// NewDecoder возвращает новый Decoder
-func NewDecoder(r io.Reader) Decoder {
-	return &decoder{
-		r: NewReader(r),
+// SnapshotTimeAuxField это название служебного поля с временем создания RDB
+// в секундах Unix
+const SnapshotTimeAuxField = "ctime"
+
+// DecoderOption это настройка Decoder
+type DecoderOption func(*decoder)
+
+// WithChecksum включает или отключает проверку контрольной суммы CRC64
+// в конце RDB, по умолчанию проверка включена.
+// Нулевая контрольная сумма означает что сервер её не вычислял
+// (rdbchecksum no) и не проверяется
+func WithChecksum(verify bool) DecoderOption {
+	return func(d *decoder) {
+		d.verifyChecksum = verify
 	}
 }
 
-// NewStringDecoder возвращает новый Decoder на основании строки
-func NewStringDecoder(data string) Decoder {
-	return &decoder{
-		r: NewStringReader(data),
+// WithDropExpired включает пропуск ключей время жизни которых истекло
+// до создания RDB, по умолчанию такие ключи возвращаются.
+// Время создания берётся из служебного поля SnapshotTimeAuxField,
+// если его нет то используется время создания Decoder
+func WithDropExpired(drop bool) DecoderOption {
+	return func(d *decoder) {
+		d.dropExpired = drop
 	}
 }
 
-// NewLimitDecoder возвращает новый Decoder ограниченный по размеру
-func NewLimitDecoder(r io.Reader, size int64) Decoder {
-	return &decoder{
-		r: NewReader(io.LimitReader(r, size)),
+// WithChunkSize задаёт количество значений в одной части ключа.
+// Next возвращает KeyStart, KeyChunk и KeyEnd для коллекций больше size
+// только если размер задан, DecodeKeys и Decode передают части
+// если потребитель реализует ChunkConsumer, по умолчанию по
+// DefaultChunkSize значений. Отрицательный size отключает чтение частями
+func WithChunkSize(size int) DecoderOption {
+	return func(d *decoder) {
+		d.chunkSize = size
+	}
+}
+
+// WithKeyFilter задаёт условие отбора ключей по базе данных, названию
+// и типу, значения отклонённых ключей пропускаются без разбора и
+// распаковки
+func WithKeyFilter(filter KeyFilter) DecoderOption {
+	return func(d *decoder) {
+		d.keyFilter = filter
 	}
 }
 
+// WithModuleRegistry задаёт декодеры модулей, по умолчанию используется
+// DefaultModuleRegistry
+func WithModuleRegistry(registry *ModuleRegistry) DecoderOption {
+	return func(d *decoder) {
+		d.modules = registry
+	}
+}
+
+// newDecoder возвращает новый decoder с настройками
+func newDecoder(r *reader, file *os.File, options []DecoderOption) *decoder {
+	d := &decoder{
+		r:              r,
+		file:           file,
+		verifyChecksum: true,
+		snapshotTime:   time.Now(),
+	}
+	for _, option := range options {
+		option(d)
+	}
+	r.modules = d.modules
+	return d
+}
+
+// NewDecoder возвращает новый Decoder
+func NewDecoder(r io.Reader, options ...DecoderOption) Decoder {
+	return newDecoder(newReader(r), nil, options)
+}
+
+// NewStringDecoder возвращает новый Decoder на основании строки
+func NewStringDecoder(data string, options ...DecoderOption) Decoder {
+	return newDecoder(newReader(strings.NewReader(data)), nil, options)
+}
+
+// NewLimitDecoder возвращает новый Decoder ограниченный по размеру
+func NewLimitDecoder(
+	r io.Reader,
+	size int64,
+	options ...DecoderOption,
+) Decoder {
+	return newDecoder(newReader(io.LimitReader(r, size)), nil, options)
+}
+
 // NewFileDecoder возвращает новый Decoder на основании файла,
 // файл закрывается в конце Decode
 // Для возможности преждевременного закрытия файла воспользуйтесь NewDecoder
 // передав в него файл
-func NewFileDecoder(filename string) (Decoder, error) {
+func NewFileDecoder(filename string, options ...DecoderOption) (Decoder, error) {
 	file, err := os.Open(filename)
 	if err != nil {
 		return nil, err
 	}
-	return &decoder{
-		r:    NewReader(file),
-		file: file,
-	}, nil
+	return newDecoder(newReader(file), file, options), nil
+}
+
+// setChunkConsumer включает чтение частями если consumer реализует
+// ChunkConsumer и отключает в противном случае
+func (d *decoder) setChunkConsumer(consumer interface{}) ChunkConsumer {
+	chunkConsumer, ok := consumer.(ChunkConsumer)
+	if !ok || d.chunkSize < 0 {
+		d.chunkSize = 0
+		return nil
+	}
+	if d.chunkSize == 0 {
+		d.chunkSize = DefaultChunkSize
+	}
+	return chunkConsumer
+}
+
+// decodeChunk передаёт ChunkConsumer начало, часть или окончание ключа
+func decodeChunk(
+	consumer ChunkConsumer,
+	token interface{},
+	db uint32,
+) error {
+	var key data.Key
+	var send func(data.Key) error
+	switch op := token.(type) {
+	case KeyStart:
+		key, send = op.GetKey(), consumer.KeyStart
+	case KeyChunk:
+		key, send = op.GetKey(), consumer.KeyChunk
+	case KeyEnd:
+		key, send = op.GetKey(), consumer.KeyEnd
+	default:
+		return fmt.Errorf("unexpected chunk token %#v", token)
+	}
+	err := key.SetDB(int(db))
+	if err != nil {
+		return err
+	}
+	return send(key)
 }
 
 // nolint:gocyclo
 func (d *decoder) DecodeKeys(consumer KeyConsumer) error {
+	chunkConsumer := d.setChunkConsumer(consumer)
 	var db uint32
 	for {
 		token, err := d.Next()
@@ -71,13 +189,22 @@ func (d *decoder) DecodeKeys(consumer KeyConsumer) error {
 			}
 			return err
 		}
+		if _, ok := err.(*ChecksumError); ok {
+			return err
+		}
 		if err != nil {
 			return fmt.Errorf("error get next token: %q", err)
 		}
 		switch op := token.(type) {
-		case Magic, AuxField, ResizeDB:
+		case Magic, AuxField, ResizeDB, ModuleAux, SlotInfo:
+		case Function:
+			if functionConsumer, ok := consumer.(FunctionConsumer); ok {
+				err = functionConsumer.SetFunction(op)
+			}
 		case DBSelector:
 			db = op.GetDBNumber()
+		case KeyStart, KeyChunk, KeyEnd:
+			err = decodeChunk(chunkConsumer, op, db)
 		case data.Key:
 			err = op.SetDB(int(db))
 			if err == nil {
@@ -99,6 +226,7 @@ func (d *decoder) Decode(consumer Consumer) error {
 			_ = d.file.Close()
 		}()
 	}
+	chunkConsumer := d.setChunkConsumer(consumer)
 	var db uint32
 	for {
 		token, err := d.Next()
@@ -111,6 +239,9 @@ func (d *decoder) Decode(consumer Consumer) error {
 			}
 			return err
 		}
+		if _, ok := err.(*ChecksumError); ok {
+			return err
+		}
 		if err != nil {
 			return fmt.Errorf("error get next token: %q", err)
 		}
@@ -123,6 +254,20 @@ func (d *decoder) Decode(consumer Consumer) error {
 			db = op.GetDBNumber()
 		case ResizeDB:
 			err = consumer.SetResizeDB(db, op)
+		case ModuleAux:
+			if moduleAuxConsumer, ok := consumer.(ModuleAuxConsumer); ok {
+				err = moduleAuxConsumer.SetModuleAux(op)
+			}
+		case SlotInfo:
+			if slotInfoConsumer, ok := consumer.(SlotInfoConsumer); ok {
+				err = slotInfoConsumer.SetSlotInfo(op)
+			}
+		case Function:
+			if functionConsumer, ok := consumer.(FunctionConsumer); ok {
+				err = functionConsumer.SetFunction(op)
+			}
+		case KeyStart, KeyChunk, KeyEnd:
+			err = decodeChunk(chunkConsumer, op, db)
 		case data.Key:
 			err = op.SetDB(int(db))
 			if err == nil {
@@ -152,11 +297,73 @@ func (d *decoder) checkTokenLevelState(tokenLevels ...int) error {
 	)
 }
 
+// Next возвращает следующий токен, пропускает ключи отклонённые
+// WithKeyFilter и при WithDropExpired истёкшие ключи
 // nolint:gocyclo
 func (d *decoder) Next() (interface{}, error) {
+	for {
+		token, err := d.next()
+		if err != nil {
+			return token, err
+		}
+		switch op := token.(type) {
+		case nil:
+			continue
+		case AuxField:
+			d.setSnapshotTime(op)
+		case DBSelector:
+			d.db = op.GetDBNumber()
+		case data.Key:
+			if d.isExpired(op) {
+				continue
+			}
+		case KeyStart:
+			if d.isExpired(op.GetKey()) {
+				d.dropChunks = true
+				continue
+			}
+		case KeyChunk:
+			if d.dropChunks {
+				continue
+			}
+		case KeyEnd:
+			if d.dropChunks {
+				d.dropChunks = false
+				continue
+			}
+		}
+		return token, nil
+	}
+}
+
+// isExpired возвращает true если ключ нужно пропустить так как
+// его время жизни истекло до создания RDB
+func (d *decoder) isExpired(key data.Key) bool {
+	return d.dropExpired && key.Expiry().IsExpired(d.snapshotTime)
+}
+
+// setSnapshotTime запоминает время создания RDB из служебного поля
+func (d *decoder) setSnapshotTime(field AuxField) {
+	if field.GetKey() != SnapshotTimeAuxField {
+		return
+	}
+	seconds, err := strconv.ParseInt(field.GetValue(), 10, 64)
+	if err != nil {
+		return
+	}
+	d.snapshotTime = time.Unix(seconds, 0)
+}
+
+// nolint:gocyclo
+func (d *decoder) next() (interface{}, error) {
+	if d.chunks != nil {
+		return d.nextChunk()
+	}
 	if d.tokenLevelState == tokenLevelStart {
 		d.tokenLevelState = tokenLevelInit
-		return d.r.ReadMagic()
+		magic, err := d.r.ReadMagic()
+		d.version = magic.GetRDBVersion()
+		return magic, err
 	}
 
 	opcode, err := d.r.ReadOpcode()
@@ -183,6 +390,12 @@ func (d *decoder) Next() (interface{}, error) {
 			return nil, err
 		}
 		return d.r.ReadResizeDB()
+	case ModuleAuxOpcode:
+		err = d.checkTokenLevelState(tokenLevelInit, tokenLevelDB)
+		if err != nil {
+			return nil, err
+		}
+		return d.r.ReadModuleAux()
 	case ExpirySecondsOpcode, ExpiryMillisecondsOpcode:
 		err = d.checkTokenLevelState(tokenLevelDB)
 		if err != nil {
@@ -199,52 +412,154 @@ func (d *decoder) Next() (interface{}, error) {
 		if err != nil {
 			return nil, err
 		}
-		return d.readKey(opcodeNext, expiry)
+		return d.readKeyWithMetadata(opcodeNext, expiry)
+	case IdleOpcode, FreqOpcode:
+		err = d.checkTokenLevelState(tokenLevelDB)
+		if err != nil {
+			return nil, err
+		}
+		return d.readKeyWithMetadata(opcode, data.NoExpiry())
+	case SlotInfoOpcode:
+		err = d.checkTokenLevelState(tokenLevelDB)
+		if err != nil {
+			return nil, err
+		}
+		return d.r.ReadSlotInfo()
+	case FunctionOpcode, FunctionPreGAOpcode:
+		err = d.checkTokenLevelState(tokenLevelInit, tokenLevelDB)
+		if err != nil {
+			return nil, err
+		}
+		return d.r.ReadFunction(opcode)
 	case EOFOpcode:
 		err = d.checkTokenLevelState(tokenLevelInit, tokenLevelDB)
 		if err != nil {
 			return nil, err
 		}
-		return d.r.ReadEOF()
+		return d.readEOF()
 	}
 	err = d.checkTokenLevelState(tokenLevelDB)
 	if err != nil {
 		return nil, err
 	}
-	return d.readKey(opcode, data.NewExpiry(0))
+	return d.readKeyToken(opcode, data.NoExpiry(), data.Metadata{})
 }
 
-// nolint:gocyclo
-func (d *decoder) readKey(opcode byte, expiry data.Expiry) (data.Key, error) {
-	switch opcode {
-	// SortedSet
-	case ZipListSortedSetOpcode:
-		return d.r.ReadZipListSortedSet(expiry)
-	case SortedSetOpcode:
-		return d.r.ReadSortedSet(expiry)
-
-	// HashMap
-	case ListHashMapOpcode:
-		return d.r.ReadListHashMap(expiry)
-	case ZipListHashMapOpcode:
-		return d.r.ReadZipListHashMap(expiry)
-	case ZipMapHashMapOpcode:
-		return d.r.ReadZipMapHashMap(expiry)
-
-	// List
-	case ListOpcode:
-		return d.r.ReadList(expiry)
-	case ZipListOpcode:
-		return d.r.ReadZipList(expiry)
-	case QuickListOpcode:
-		return d.r.ReadQuickList(expiry)
-
-	case SetOpcode:
-		return d.r.ReadSet(expiry)
-	case IntSetOpcode:
-		return d.r.ReadIntSet(expiry)
-	case StringValueOpcode:
-		return d.r.ReadStringValue(expiry)
-	}
-	return nil, fmt.Errorf("unsupported key opcode: %#v", opcode)
+// nextChunk возвращает следующую часть ключа или его окончание
+func (d *decoder) nextChunk() (interface{}, error) {
+	if d.chunks.Len() == 0 {
+		key := d.chunks.Key()
+		d.chunks = nil
+		return KeyEnd{key: key}, nil
+	}
+	key, err := d.chunks.ReadChunk(d.chunkSize)
+	if err != nil {
+		return nil, err
+	}
+	return KeyChunk{key: key}, nil
+}
+
+// readEOF читает EOF и проверяет контрольную сумму всех данных до неё
+func (d *decoder) readEOF() (EOF, error) {
+	if d.version < 5 {
+		// до RDB версии 5 контрольная сумма не записывается
+		return EOF{}, io.EOF
+	}
+	checksum := d.r.Checksum()
+	eof, err := d.r.ReadEOF()
+	if err != io.EOF || !d.verifyChecksum || eof.GetChecksum() == 0 {
+		return eof, err
+	}
+	if eof.GetChecksum() != checksum {
+		return eof, &ChecksumError{
+			Expected: eof.GetChecksum(),
+			Actual:   checksum,
+		}
+	}
+	return eof, err
+}
+
+// readKeyWithMetadata читает сведения для политики вытеснения которые
+// предшествуют типу ключа и сам ключ
+func (d *decoder) readKeyWithMetadata(
+	opcode byte,
+	expiry data.Expiry,
+) (interface{}, error) {
+	var metadata data.Metadata
+	var err error
+	for {
+		switch opcode {
+		case IdleOpcode:
+			metadata.Idle, err = d.r.ReadIdle()
+			metadata.HasIdle = true
+		case FreqOpcode:
+			metadata.Freq, err = d.r.ReadFreq()
+			metadata.HasFreq = true
+		default:
+			return d.readKeyToken(opcode, expiry, metadata)
+		}
+		if err != nil {
+			return nil, err
+		}
+		opcode, err = d.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// readKeyToken читает ключ целиком или, если задан размер части и ключ
+// в неё не умещается, начало ключа передаваемого частями.
+// Если ключ отклонён KeyFilter то его значение пропускается
+// и возвращается nil
+func (d *decoder) readKeyToken(
+	opcode byte,
+	expiry data.Expiry,
+	metadata data.Metadata,
+) (interface{}, error) {
+	keyType := OpcodeKeyType(opcode)
+	if keyType == "" {
+		return nil, fmt.Errorf("unsupported key opcode: %#v", opcode)
+	}
+	metadata.Encoding = string(OpcodeKeyEncoding(opcode))
+	keyName, err := d.r.ReadString()
+	if err != nil {
+		return nil, err
+	}
+	if d.keyFilter != nil && !d.keyFilter(int(d.db), keyName, keyType) {
+		return nil, d.r.skipValue(opcode)
+	}
+
+	var key data.Key
+	if d.chunkSize > 0 && IsChunkOpcode(opcode) {
+		var chunks ChunkReader
+		chunks, err = d.r.readChunks(opcode, keyName, expiry)
+		if err != nil {
+			return nil, err
+		}
+		var fits bool
+		fits, err = chunks.Fits(d.chunkSize)
+		if err != nil {
+			return nil, err
+		}
+		if !fits {
+			err = chunks.Key().SetMetadata(metadata)
+			if err != nil {
+				return nil, err
+			}
+			d.chunks = chunks
+			return KeyStart{key: chunks.Key()}, nil
+		}
+		key, err = chunks.ReadChunk(d.chunkSize)
+	} else {
+		key, err = d.r.readValue(opcode, keyName, expiry)
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = key.SetMetadata(metadata)
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
 }
@@ -10,6 +10,11 @@ import (
 const (
 	encInt16 = 1
 	encInt32 = 2
+	encLZF   = 3
+
+	// lzfMinStringLength это длина строки начиная с которой redis пробует
+	// сжимать её LZF, короче сжимать бессмысленно
+	lzfMinStringLength = 21
 )
 
 var (
@@ -61,6 +66,23 @@ func EncodeString(st string) []byte {
 	return append(length, []byte(st)...)
 }
 
+// EncodeCompressedString кодирует строку в байты сжимая её LZF как redis
+// с rdbcompression yes: строки от 21 байта сжимаются если это сокращает
+// их хотя бы на 4 байта, иначе строка кодируется как в EncodeString
+func EncodeCompressedString(st string) []byte {
+	if len(st) < lzfMinStringLength {
+		return EncodeString(st)
+	}
+	compressed := lzfCompress([]byte(st), len(st)-4)
+	if compressed == nil {
+		return EncodeString(st)
+	}
+	b := []byte{lenEnc<<6 | encLZF}
+	b = append(b, EncodeLength(uint32(len(compressed)))...)
+	b = append(b, EncodeLength(uint32(len(st)))...)
+	return append(b, compressed...)
+}
+
 // EncodeLength кодирует длину в байты
 func EncodeLength(l uint32) []byte {
 	switch {
@@ -76,6 +98,25 @@ func EncodeLength(l uint32) []byte {
 	return b
 }
 
+// EncodeLength64 кодирует 64 битную длину в байты,
+// длины до 32 бит кодируются как в EncodeLength
+func EncodeLength64(l uint64) []byte {
+	if l <= math.MaxUint32 {
+		return EncodeLength(uint32(l))
+	}
+	b := make([]byte, 9)
+	b[0] = len64BitPrefix
+	binary.BigEndian.PutUint64(b[1:], l)
+	return b
+}
+
+// EncodeBinaryFloat64 кодирует float64 в 8 байт little endian IEEE 754
+func EncodeBinaryFloat64(f float64) []byte {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, math.Float64bits(f))
+	return b
+}
+
 // EncodeFloat кодирует float64 в байты
 func EncodeFloat(f float64) []byte {
 	switch {
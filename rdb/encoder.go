@@ -0,0 +1,734 @@
+package rdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/avito-tech/smart-redis-replication/data"
+)
+
+const (
+	// DefaultRDBVersion это версия RDB которую Encoder записывает если
+	// Magic не передан, её загружают redis начиная с 5.0
+	DefaultRDBVersion = 9
+
+	// DefaultWriterSize это размер буфера записи по умолчанию
+	DefaultWriterSize = 16384
+
+	// streamNodeMaxEntries это количество записей в одном узле Stream,
+	// совпадает с stream-node-max-entries по умолчанию
+	streamNodeMaxEntries = 100
+)
+
+var (
+	// ErrEncoderClosed это ошибка записи после EOF
+	ErrEncoderClosed = errors.New("rdb encoder: write after EOF")
+)
+
+// encoder реализует интерфейс Encoder
+type encoder struct {
+	buffer *bufio.Writer
+	w      *checksumWriter
+	err    error
+
+	// version это версия RDB, 0 пока Magic не записан
+	version uint32
+
+	// db это номер текущей базы данных, -1 пока DBSelector не записан
+	db int
+
+	closed        bool
+	compression   bool
+	targetVersion uint32
+	limits        EncodingLimits
+}
+
+// EncodingLimits это пороги выбора компактного кодирования коллекций
+// как в настройках redis: коллекция записывается в Listpack (ZipList
+// до RDB версии 10) если количество элементов и длина каждого значения
+// не больше порогов, нулевое количество отключает компактное кодирование
+type EncodingLimits struct {
+	// HashMaxEntries и HashMaxValue это hash-max-listpack-entries
+	// и hash-max-listpack-value
+	HashMaxEntries int
+	HashMaxValue   int
+
+	// SetMaxIntsetEntries это set-max-intset-entries,
+	// набор целых чисел записывается в IntSet
+	SetMaxIntsetEntries int
+
+	// SetMaxEntries и SetMaxValue это set-max-listpack-entries
+	// и set-max-listpack-value (rdb version 11)
+	SetMaxEntries int
+	SetMaxValue   int
+
+	// ZSetMaxEntries и ZSetMaxValue это zset-max-listpack-entries
+	// и zset-max-listpack-value
+	ZSetMaxEntries int
+	ZSetMaxValue   int
+}
+
+// DefaultEncodingLimits возвращает пороги по умолчанию redis 7.2
+func DefaultEncodingLimits() EncodingLimits {
+	return EncodingLimits{
+		HashMaxEntries:      128,
+		HashMaxValue:        64,
+		SetMaxIntsetEntries: 512,
+		SetMaxEntries:       128,
+		SetMaxValue:         64,
+		ZSetMaxEntries:      128,
+		ZSetMaxValue:        64,
+	}
+}
+
+// EncoderOption это настройка Encoder
+type EncoderOption func(*encoder)
+
+// WithRDBVersion задаёт версию записываемого RDB независимо от
+// переданного в SetMagic, от неё зависят форматы значений
+func WithRDBVersion(version uint32) EncoderOption {
+	return func(e *encoder) {
+		e.targetVersion = version
+	}
+}
+
+// WithEncodingLimits задаёт пороги компактного кодирования коллекций,
+// по умолчанию DefaultEncodingLimits
+func WithEncodingLimits(limits EncodingLimits) EncoderOption {
+	return func(e *encoder) {
+		e.limits = limits
+	}
+}
+
+// WithCompression включает или отключает сжатие строк LZF,
+// по умолчанию строки сжимаются как в redis с rdbcompression yes
+func WithCompression(compress bool) EncoderOption {
+	return func(e *encoder) {
+		e.compression = compress
+	}
+}
+
+// NewEncoder возвращает новый Encoder записывающий RDB в w
+func NewEncoder(w io.Writer, options ...EncoderOption) Encoder {
+	buffer := bufio.NewWriterSize(w, DefaultWriterSize)
+	e := &encoder{
+		buffer:      buffer,
+		w:           newChecksumWriter(buffer),
+		db:          -1,
+		compression: true,
+		limits:      DefaultEncodingLimits(),
+	}
+	for _, option := range options {
+		option(e)
+	}
+	return e
+}
+
+// write записывает данные, после первой ошибки запись прекращается
+func (e *encoder) write(body ...[]byte) {
+	for _, p := range body {
+		if e.err != nil {
+			return
+		}
+		_, e.err = e.w.Write(p)
+	}
+}
+
+// writeByte записывает один байт
+func (e *encoder) writeByte(b byte) {
+	e.write([]byte{b})
+}
+
+// begin проверяет что запись возможна и записывает Magic
+// с DefaultRDBVersion если он ещё не записан
+func (e *encoder) begin() error {
+	if e.err != nil {
+		return e.err
+	}
+	if e.closed {
+		return ErrEncoderClosed
+	}
+	if e.version == 0 {
+		e.version = DefaultRDBVersion
+		if e.targetVersion != 0 {
+			e.version = e.targetVersion
+		}
+		e.write(NewMagic(e.version).Bytes())
+	}
+	return e.err
+}
+
+// selectDB записывает DBSelector если номер базы данных изменился
+func (e *encoder) selectDB(db int) {
+	if db == e.db || e.err != nil {
+		return
+	}
+	if db < 0 || db > math.MaxUint32 {
+		e.err = fmt.Errorf("unexpected db number %d", db)
+		return
+	}
+	e.db = db
+	e.write(NewDBSelector(uint32(db)).Bytes())
+}
+
+// Checksum возвращает контрольную сумму записанных данных
+func (e *encoder) Checksum() uint64 {
+	return e.w.Checksum()
+}
+
+// SetMagic записывает Magic, он должен быть первым.
+// При WithRDBVersion записывается заданная версия
+func (e *encoder) SetMagic(magic Magic) error {
+	if e.version != 0 {
+		return fmt.Errorf("rdb encoder: magic already written")
+	}
+	if e.targetVersion != 0 {
+		magic = NewMagic(e.targetVersion)
+	}
+	if magic.GetRDBVersion() == 0 {
+		return fmt.Errorf("rdb encoder: unexpected rdb version 0")
+	}
+	e.version = magic.GetRDBVersion()
+	e.write(magic.Bytes())
+	return e.err
+}
+
+// SetAuxField записывает дополнительное поле, поля должны
+// предшествовать базам данных
+func (e *encoder) SetAuxField(field AuxField) error {
+	if err := e.begin(); err != nil {
+		return err
+	}
+	if e.db >= 0 {
+		return fmt.Errorf("rdb encoder: aux field %q after db selector", field.GetKey())
+	}
+	e.write(field.Bytes())
+	return e.err
+}
+
+// SetResizeDB записывает размеры базы данных db
+func (e *encoder) SetResizeDB(db uint32, resizeDB ResizeDB) error {
+	if err := e.begin(); err != nil {
+		return err
+	}
+	e.selectDB(int(db))
+	e.write(resizeDB.Bytes())
+	return e.err
+}
+
+// SetModuleAux записывает дополнительные данные модуля
+func (e *encoder) SetModuleAux(aux ModuleAux) error {
+	if err := e.begin(); err != nil {
+		return err
+	}
+	id, err := moduleTypeID(aux.Module, aux.EncVersion)
+	if err != nil {
+		return err
+	}
+	e.writeByte(ModuleAuxOpcode)
+	e.write(
+		EncodeLength64(id),
+		EncodeLength(uint32(data.ModuleUnsigned)),
+		EncodeLength64(aux.When),
+	)
+	e.writeModuleValues(aux.Values)
+	return e.err
+}
+
+// SetSlotInfo записывает данные о размере слота текущей базы данных
+func (e *encoder) SetSlotInfo(slotInfo SlotInfo) error {
+	if err := e.begin(); err != nil {
+		return err
+	}
+	if e.db < 0 {
+		e.selectDB(0)
+	}
+	e.write(slotInfo.Bytes())
+	return e.err
+}
+
+// SetFunction записывает библиотеку функций
+func (e *encoder) SetFunction(function Function) error {
+	if err := e.begin(); err != nil {
+		return err
+	}
+	e.write(function.Bytes())
+	return e.err
+}
+
+// SetEOF записывает EOF с контрольной суммой всех записанных данных
+// и сбрасывает буфер, контрольная сумма из eof не используется.
+// До RDB версии 5 контрольной суммы нет и файл заканчивается EOFOpcode
+func (e *encoder) SetEOF(EOF) error {
+	if err := e.begin(); err != nil {
+		return err
+	}
+	e.writeByte(EOFOpcode)
+	if e.version >= 5 {
+		checksum := make([]byte, 8)
+		binary.LittleEndian.PutUint64(checksum, e.Checksum())
+		e.write(checksum)
+	}
+	if e.err == nil {
+		e.err = e.buffer.Flush()
+	}
+	e.closed = true
+	return e.err
+}
+
+// Key записывает ключ в его базу данных вместе со временем жизни
+// и сведениями для политики вытеснения
+func (e *encoder) Key(key data.Key) error {
+	if err := e.begin(); err != nil {
+		return err
+	}
+	e.selectDB(key.DB())
+
+	expiry := key.Expiry()
+	if expiry.IsSet() {
+		body := make([]byte, 8)
+		binary.LittleEndian.PutUint64(body, expiry.Milliseconds())
+		e.writeByte(ExpiryMillisecondsOpcode)
+		e.write(body)
+	}
+
+	// сведения для политики вытеснения появились в RDB версии 9
+	metadata := key.Metadata()
+	if e.version >= 9 && metadata.HasIdle {
+		e.writeByte(IdleOpcode)
+		e.write(EncodeLength64(uint64(metadata.Idle / time.Second)))
+	}
+	if e.version >= 9 && metadata.HasFreq {
+		e.write([]byte{FreqOpcode, metadata.Freq})
+	}
+	if e.err != nil {
+		return e.err
+	}
+	return e.writeKey(key)
+}
+
+// writeKey записывает тип, название и значение ключа
+// nolint:gocyclo
+func (e *encoder) writeKey(key data.Key) error {
+	switch key := key.(type) {
+	case data.StringKey:
+		e.writeByte(StringValueOpcode)
+		e.writeString(key.Name())
+		e.writeString(key.Value())
+	case data.ListKey:
+		e.writeByte(ListOpcode)
+		e.writeString(key.Name())
+		values := key.Values()
+		e.write(EncodeLength(uint32(len(values))))
+		for _, value := range values {
+			e.writeString(value)
+		}
+	case data.SetKey:
+		values := make([]string, 0, len(key.Values()))
+		for value := range key.Values() {
+			values = append(values, value)
+		}
+		e.writeSet(key.Name(), values)
+	case data.IntegerSetKey:
+		values := make([]string, 0, len(key.Values()))
+		for value := range key.Values() {
+			values = append(values, strconv.FormatInt(value, 10))
+		}
+		e.writeSet(key.Name(), values)
+	case data.SortedSetKey:
+		e.writeSortedSet(key)
+	case data.MapKey:
+		e.writeHash(key)
+	case data.StreamKey:
+		return e.writeStream(key)
+	case data.ModuleKey:
+		return e.writeModule(key)
+	default:
+		return fmt.Errorf("rdb encoder: unsupported key type %T", key)
+	}
+	return e.err
+}
+
+// writeString записывает строку, при WithCompression сжатую LZF
+func (e *encoder) writeString(value string) {
+	if e.compression {
+		e.write(EncodeCompressedString(value))
+		return
+	}
+	e.write(EncodeString(value))
+}
+
+// entriesWriter это общий интерфейс listpackWriter и zipListWriter
+type entriesWriter interface {
+	Append(entry string)
+	Bytes() []byte
+}
+
+// newEntriesWriter возвращает writer компактного кодирования коллекции
+// для версии RDB: Listpack начиная с 10, до неё ZipList
+func (e *encoder) newEntriesWriter() entriesWriter {
+	if e.version >= 10 {
+		return newListpackWriter()
+	}
+	return newZipListWriter()
+}
+
+// writeSet записывает набор: IntSet если все значения целые числа,
+// Listpack начиная с RDB версии 11 или значения по одному
+func (e *encoder) writeSet(name string, values []string) {
+	if e.limits.SetMaxIntsetEntries > 0 &&
+		len(values) <= e.limits.SetMaxIntsetEntries {
+		integers := make([]int64, 0, len(values))
+		for _, value := range values {
+			i, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || strconv.FormatInt(i, 10) != value {
+				break
+			}
+			integers = append(integers, i)
+		}
+		if len(integers) == len(values) {
+			e.writeByte(IntSetOpcode)
+			e.writeString(name)
+			e.writeString(string(encodeIntSet(integers)))
+			return
+		}
+	}
+
+	if e.version >= 11 && fitsEncodingLimits(
+		len(values),
+		e.limits.SetMaxEntries,
+		e.limits.SetMaxValue,
+		values...,
+	) {
+		lp := newListpackWriter()
+		for _, value := range values {
+			lp.Append(value)
+		}
+		e.writeByte(ListpackSetOpcode)
+		e.writeString(name)
+		e.writeString(string(lp.Bytes()))
+		return
+	}
+
+	e.writeByte(SetOpcode)
+	e.writeString(name)
+	e.write(EncodeLength(uint32(len(values))))
+	for _, value := range values {
+		e.writeString(value)
+	}
+}
+
+// writeHash записывает hash map в Listpack (ZipList до RDB версии 10)
+// или значения по одному
+func (e *encoder) writeHash(key data.MapKey) {
+	values := key.Values()
+	compact := fitsEncodingLimits(len(values), e.limits.HashMaxEntries, 0)
+	for field, value := range values {
+		if !compact {
+			break
+		}
+		compact = len(field) <= e.limits.HashMaxValue &&
+			len(value) <= e.limits.HashMaxValue
+	}
+	if compact {
+		entries := e.newEntriesWriter()
+		for field, value := range values {
+			entries.Append(field)
+			entries.Append(value)
+		}
+		if e.version >= 10 {
+			e.writeByte(ListpackHashMapOpcode)
+		} else {
+			e.writeByte(ZipListHashMapOpcode)
+		}
+		e.writeString(key.Name())
+		e.writeString(string(entries.Bytes()))
+		return
+	}
+
+	e.writeByte(ListHashMapOpcode)
+	e.writeString(key.Name())
+	e.write(EncodeLength(uint32(len(values))))
+	for field, value := range values {
+		e.writeString(field)
+		e.writeString(value)
+	}
+}
+
+// writeSortedSet записывает упорядоченный набор в Listpack (ZipList до
+// RDB версии 10) отсортированным по весу или значения по одному,
+// начиная с RDB версии 8 веса записываются в бинарном виде
+func (e *encoder) writeSortedSet(key data.SortedSetKey) {
+	values := key.Values()
+	members := make([]string, 0, len(values))
+	for member := range values {
+		members = append(members, member)
+	}
+	if fitsEncodingLimits(
+		len(members),
+		e.limits.ZSetMaxEntries,
+		e.limits.ZSetMaxValue,
+		members...,
+	) {
+		sort.Slice(members, func(i, j int) bool {
+			si, sj := values[members[i]], values[members[j]]
+			if si != sj {
+				return si < sj
+			}
+			return members[i] < members[j]
+		})
+		entries := e.newEntriesWriter()
+		for _, member := range members {
+			entries.Append(member)
+			entries.Append(formatScore(values[member]))
+		}
+		if e.version >= 10 {
+			e.writeByte(ListpackSortedSetOpcode)
+		} else {
+			e.writeByte(ZipListSortedSetOpcode)
+		}
+		e.writeString(key.Name())
+		e.writeString(string(entries.Bytes()))
+		return
+	}
+
+	binaryScore := e.version >= 8
+	if binaryScore {
+		e.writeByte(SortedSet2Opcode)
+	} else {
+		e.writeByte(SortedSetOpcode)
+	}
+	e.writeString(key.Name())
+	e.write(EncodeLength(uint32(len(members))))
+	for _, member := range members {
+		e.writeString(member)
+		if binaryScore {
+			e.write(EncodeBinaryFloat64(values[member]))
+		} else {
+			e.write(EncodeFloat(values[member]))
+		}
+	}
+}
+
+// fitsEncodingLimits возвращает true если count не больше maxEntries,
+// а длина каждого значения не больше maxValue. Нулевой maxEntries
+// отключает компактное кодирование даже для пустой коллекции
+func fitsEncodingLimits(count, maxEntries, maxValue int, values ...string) bool {
+	if maxEntries <= 0 || count > maxEntries {
+		return false
+	}
+	for _, value := range values {
+		if len(value) > maxValue {
+			return false
+		}
+	}
+	return true
+}
+
+// formatScore возвращает вес в виде строки как redis в Listpack:
+// целые веса записываются числом, бесконечности как inf и -inf
+func formatScore(score float64) string {
+	switch {
+	case math.IsInf(score, 1):
+		return "inf"
+	case math.IsInf(score, -1):
+		return "-inf"
+	case score == math.Trunc(score) && math.Abs(score) < 1<<53:
+		return strconv.FormatInt(int64(score), 10)
+	}
+	return strconv.FormatFloat(score, 'g', -1, 64)
+}
+
+// writeModule записывает значение модуля в формате Module2Opcode
+func (e *encoder) writeModule(key data.ModuleKey) error {
+	if e.version < 8 {
+		return fmt.Errorf("rdb encoder: module key requires rdb version 8")
+	}
+	id, err := moduleTypeID(key.Module(), key.EncVersion())
+	if err != nil {
+		return err
+	}
+	e.writeByte(Module2Opcode)
+	e.writeString(key.Name())
+	e.write(EncodeLength64(id))
+	e.writeModuleValues(key.Values())
+	return e.err
+}
+
+// writeModuleValues записывает значения с кодами типов и код окончания
+func (e *encoder) writeModuleValues(values []data.ModuleValue) {
+	for _, value := range values {
+		e.write(EncodeLength(uint32(value.Type)))
+		switch value.Type {
+		case data.ModuleSigned:
+			e.write(EncodeLength64(uint64(value.Signed)))
+		case data.ModuleUnsigned:
+			e.write(EncodeLength64(value.Unsigned))
+		case data.ModuleFloat:
+			body := make([]byte, 4)
+			binary.LittleEndian.PutUint32(body, math.Float32bits(float32(value.Float)))
+			e.write(body)
+		case data.ModuleDouble:
+			e.write(EncodeBinaryFloat64(value.Float))
+		case data.ModuleString:
+			e.writeString(value.String)
+		default:
+			if e.err == nil {
+				e.err = fmt.Errorf("unknown module value type %d", value.Type)
+			}
+			return
+		}
+	}
+	e.write(EncodeLength(moduleOpcodeEOF))
+}
+
+// writeStream записывает Stream, формат зависит от версии RDB:
+// StreamListpacksOpcode до 10, StreamListpacks2Opcode в 10 и
+// StreamListpacks3Opcode начиная с 11
+// nolint:gocyclo
+func (e *encoder) writeStream(key data.StreamKey) error {
+	var opcode byte
+	switch {
+	case e.version < 9:
+		return fmt.Errorf("rdb encoder: stream key requires rdb version 9")
+	case e.version < 10:
+		opcode = StreamListpacksOpcode
+	case e.version < 11:
+		opcode = StreamListpacks2Opcode
+	default:
+		opcode = StreamListpacks3Opcode
+	}
+	e.writeByte(opcode)
+	e.writeString(key.Name())
+
+	entries := key.Values()
+	nodes := (len(entries) + streamNodeMaxEntries - 1) / streamNodeMaxEntries
+	e.write(EncodeLength(uint32(nodes)))
+	for i := 0; i < len(entries); i += streamNodeMaxEntries {
+		end := i + streamNodeMaxEntries
+		if end > len(entries) {
+			end = len(entries)
+		}
+		node := entries[i:end]
+		e.writeString(string(encodeStreamID(node[0].ID)))
+		e.writeString(string(encodeStreamListpack(node)))
+	}
+
+	// метаданные согласуются с записанными записями иначе redis
+	// не загрузит поток
+	info := key.Info()
+	var firstID data.StreamID
+	if len(entries) > 0 {
+		firstID = entries[0].ID
+		if info.LastID.Less(entries[len(entries)-1].ID) {
+			info.LastID = entries[len(entries)-1].ID
+		}
+	}
+	if info.EntriesAdded < uint64(len(entries)) {
+		info.EntriesAdded = uint64(len(entries))
+	}
+	e.write(EncodeLength(uint32(len(entries))))
+	e.writeStreamID(info.LastID)
+	if opcode != StreamListpacksOpcode {
+		e.writeStreamID(firstID)
+		e.writeStreamID(info.MaxDeletedID)
+		e.write(EncodeLength64(info.EntriesAdded))
+	}
+
+	groups := key.Groups()
+	e.write(EncodeLength(uint32(len(groups))))
+	for _, group := range groups {
+		e.writeString(group.Name)
+		e.writeStreamID(group.LastID)
+		if opcode != StreamListpacksOpcode {
+			e.write(EncodeLength64(uint64(group.EntriesRead)))
+		}
+		e.write(EncodeLength(uint32(len(group.Pending))))
+		for _, pending := range group.Pending {
+			e.write(
+				encodeStreamID(pending.ID),
+				encodeMillisecondTime(pending.DeliveryTime),
+				EncodeLength64(pending.DeliveryCount),
+			)
+		}
+		e.write(EncodeLength(uint32(len(group.Consumers))))
+		for _, consumer := range group.Consumers {
+			e.writeString(consumer.Name)
+			e.write(encodeMillisecondTime(consumer.SeenTime))
+			if opcode == StreamListpacks3Opcode {
+				e.write(encodeMillisecondTime(consumer.ActiveTime))
+			}
+			e.write(EncodeLength(uint32(len(consumer.Pending))))
+			for _, id := range consumer.Pending {
+				e.write(encodeStreamID(id))
+			}
+		}
+	}
+	return e.err
+}
+
+// writeStreamID записывает идентификатор как две длины ms и seq
+func (e *encoder) writeStreamID(id data.StreamID) {
+	e.write(EncodeLength64(id.Ms), EncodeLength64(id.Seq))
+}
+
+// encodeStreamListpack кодирует записи одного узла Stream в Listpack,
+// структура описана в DecodeStreamListpack. Поля главной записи это
+// поля первой записи узла
+func encodeStreamListpack(entries []data.StreamEntry) []byte {
+	master := entries[0]
+	lp := newListpackWriter()
+	lp.AppendInt(int64(len(entries)))
+	lp.AppendInt(0)
+	lp.AppendInt(int64(len(master.Fields)))
+	for _, field := range master.Fields {
+		lp.Append(field.Name)
+	}
+	lp.AppendInt(0)
+
+	for _, entry := range entries {
+		sameFields := len(entry.Fields) == len(master.Fields)
+		for i := 0; sameFields && i < len(entry.Fields); i++ {
+			sameFields = entry.Fields[i].Name == master.Fields[i].Name
+		}
+		if sameFields {
+			lp.AppendInt(streamItemSameFields)
+		} else {
+			lp.AppendInt(0)
+		}
+		lp.AppendInt(int64(entry.ID.Ms - master.ID.Ms))
+		lp.AppendInt(int64(entry.ID.Seq - master.ID.Seq))
+		if sameFields {
+			for _, field := range entry.Fields {
+				lp.Append(field.Value)
+			}
+			lp.AppendInt(int64(len(entry.Fields) + 3))
+			continue
+		}
+		lp.AppendInt(int64(len(entry.Fields)))
+		for _, field := range entry.Fields {
+			lp.Append(field.Name)
+			lp.Append(field.Value)
+		}
+		lp.AppendInt(int64(len(entry.Fields)*2 + 4))
+	}
+	return lp.Bytes()
+}
+
+// encodeMillisecondTime кодирует время в миллисекундах little endian,
+// нулевое время кодируется как 0
+func encodeMillisecondTime(t time.Time) []byte {
+	body := make([]byte, 8)
+	if !t.IsZero() {
+		ms := t.UnixNano() / int64(time.Millisecond)
+		binary.LittleEndian.PutUint64(body, uint64(ms))
+	}
+	return body
+}
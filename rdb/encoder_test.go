@@ -0,0 +1,490 @@
+package rdb
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/avito-tech/smart-redis-replication/data"
+)
+
+// TestEncoder проверяет что записанный Encoder файл читается Decoder
+// без потерь для всех версий с разными форматами значений
+func TestEncoder(t *testing.T) {
+	limits := map[string]EncodingLimits{
+		"Compact": DefaultEncodingLimits(),
+		"Plain":   {},
+	}
+	for _, version := range []uint32{7, 9, 10, 11} {
+		for name, limits := range limits {
+			version, limits := version, limits
+			t.Run(fmt.Sprintf("%sVersion%d", name, version), func(t *testing.T) {
+				testEncoder(t, version, limits)
+			})
+		}
+	}
+}
+
+func testEncoder(t *testing.T, version uint32, limits EncodingLimits) {
+	keys := testEncoderKeys(t, version)
+	var rdb bytes.Buffer
+	encoder := NewEncoder(&rdb, WithEncodingLimits(limits))
+	steps := []func() error{
+		func() error { return encoder.SetMagic(NewMagic(version)) },
+		func() error { return encoder.SetAuxField(NewAuxField("redis-ver", "7.2.4")) },
+		func() error { return encoder.SetFunction(NewFunction("#!lua name=lib")) },
+		func() error { return encoder.SetResizeDB(0, NewResizeDB(2, 1)) },
+	}
+	for _, key := range keys {
+		key := key
+		steps = append(steps, func() error { return encoder.Key(key) })
+	}
+	steps = append(steps, func() error { return encoder.SetEOF(NewEOF()) })
+	for _, step := range steps {
+		if err := step(); err != nil {
+			t.Fatalf("encode error: %v", err)
+		}
+	}
+
+	decoder := NewDecoder(bytes.NewReader(rdb.Bytes()))
+	var tokens []string
+	var decoded []data.Key
+	for {
+		token, err := decoder.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("decode error: %v", err)
+		}
+		switch op := token.(type) {
+		case Magic:
+			tokens = append(tokens, fmt.Sprintf("magic %d", op.GetRDBVersion()))
+		case AuxField:
+			tokens = append(tokens, "aux "+op.GetKey()+"="+op.GetValue())
+		case Function:
+			tokens = append(tokens, "function "+op.GetCode())
+		case DBSelector:
+			tokens = append(tokens, fmt.Sprintf("db %d", op.GetDBNumber()))
+		case ResizeDB:
+			tokens = append(tokens, fmt.Sprintf("resize %d", op.GetHashTableSize()))
+		case data.Key:
+			tokens = append(tokens, "key "+op.Name())
+			decoded = append(decoded, op)
+		default:
+			t.Fatalf("unexpected token %#v", token)
+		}
+	}
+
+	expectedTokens := []string{
+		fmt.Sprintf("magic %d", version),
+		"aux redis-ver=7.2.4", "function #!lua name=lib", "db 0", "resize 2",
+	}
+	for i, key := range keys {
+		if i > 0 && keys[i-1].DB() != key.DB() {
+			expectedTokens = append(expectedTokens, fmt.Sprintf("db %d", key.DB()))
+		}
+		expectedTokens = append(expectedTokens, "key "+key.Name())
+	}
+	if !reflect.DeepEqual(tokens, expectedTokens) {
+		t.Fatalf("expected %q but actual %q", expectedTokens, tokens)
+	}
+
+	for i, key := range testEncoderExpectedKeys(keys, limits) {
+		// номер базы данных Next не устанавливает, а способ кодирования
+		// выбирает Encoder
+		_ = decoded[i].SetDB(key.DB())
+		metadata := decoded[i].Metadata()
+		metadata.Encoding = ""
+		_ = decoded[i].SetMetadata(metadata)
+		if !reflect.DeepEqual(decoded[i], key) {
+			t.Fatalf("expected %#v but actual %#v", key, decoded[i])
+		}
+	}
+}
+
+// TestEncoderErrors проверяет ошибки записи
+func TestEncoderErrors(t *testing.T) {
+	t.Run("AuxAfterDB", func(t *testing.T) {
+		encoder := NewEncoder(ioutil.Discard)
+		if err := encoder.Key(data.NewString("key", "value")); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := encoder.SetAuxField(NewAuxField("a", "b")); err == nil {
+			t.Fatalf("expected aux field error")
+		}
+	})
+	t.Run("MagicTwice", func(t *testing.T) {
+		encoder := NewEncoder(ioutil.Discard)
+		if err := encoder.SetEOF(NewEOF()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := encoder.SetMagic(NewMagic(9)); err == nil {
+			t.Fatalf("expected magic error")
+		}
+		if err := encoder.Key(data.NewString("key", "value")); err != ErrEncoderClosed {
+			t.Fatalf("expected %v but actual %v", ErrEncoderClosed, err)
+		}
+	})
+	t.Run("StreamVersion", func(t *testing.T) {
+		encoder := NewEncoder(ioutil.Discard)
+		if err := encoder.SetMagic(NewMagic(8)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := encoder.Key(data.NewStream("stream")); err == nil {
+			t.Fatalf("expected stream version error")
+		}
+	})
+}
+
+// TestEncoderDefaultMagic проверяет что без SetMagic записывается
+// DefaultRDBVersion и контрольная сумма совпадает с вычисленной
+func TestEncoderDefaultMagic(t *testing.T) {
+	var rdb bytes.Buffer
+	encoder := NewEncoder(&rdb)
+	if err := encoder.Key(data.NewString("key", "value")); err != nil {
+		t.Fatalf("encode error: %v", err)
+	}
+	if err := encoder.SetEOF(NewEOF()); err != nil {
+		t.Fatalf("encode error: %v", err)
+	}
+	expected := string(NewMagic(DefaultRDBVersion).Bytes()) +
+		"\xfe\x00\x00\x03key\x05value\xff"
+	body := rdb.Bytes()
+	if string(body[:len(body)-8]) != expected {
+		t.Fatalf("expected %q but actual %q", expected, body)
+	}
+	eof := NewEOF()
+	eof.checksum = crc64Update(0, []byte(expected))
+	if !bytes.Equal(body[len(body)-9:], eof.Bytes()) {
+		t.Fatalf("expected %x but actual %x", eof.Bytes(), body[len(body)-9:])
+	}
+}
+
+// TestEncoderVersion4 проверяет что до RDB версии 5 файл заканчивается
+// EOFOpcode без контрольной суммы и читается Decoder
+func TestEncoderVersion4(t *testing.T) {
+	var rdb bytes.Buffer
+	encoder := NewEncoder(&rdb)
+	if err := encoder.SetMagic(NewMagic(4)); err != nil {
+		t.Fatalf("encode error: %v", err)
+	}
+	if err := encoder.Key(data.NewString("key", "value")); err != nil {
+		t.Fatalf("encode error: %v", err)
+	}
+	if err := encoder.SetEOF(NewEOF()); err != nil {
+		t.Fatalf("encode error: %v", err)
+	}
+	expected := "REDIS0004\xfe\x00\x00\x03key\x05value\xff"
+	if body := rdb.String(); body != expected {
+		t.Fatalf("expected %q but actual %q", expected, body)
+	}
+
+	consumer := &testKeyConsumer{}
+	if err := NewDecoder(&rdb).DecodeKeys(consumer); err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	if len(consumer.keys) != 1 || consumer.keys[0].Name() != "key" {
+		t.Fatalf("unexpected keys %#v", consumer.keys)
+	}
+}
+
+// testEncoderKeys возвращает ключи всех типов поддерживаемых версией
+func testEncoderKeys(t *testing.T, version uint32) []data.Key {
+	str := data.NewString("string", "value")
+	_ = str.SetExpiry(data.NewExpiry(1893456000123))
+	integer := data.NewString("integer", "-12345")
+	long := data.NewString("long", strings.Repeat("x", 20000))
+
+	list := data.NewList("list")
+	_ = list.Rpush("1", "", "-100000", "9223372036854775807", "value")
+	set := data.NewSet("set")
+	_ = set.SetData(map[string]struct{}{"a": {}, "b": {}, "1": {}})
+	if version >= 9 {
+		_ = list.SetMetadata(data.Metadata{Idle: 10 * time.Second, HasIdle: true})
+		_ = set.SetMetadata(data.Metadata{Freq: 5, HasFreq: true})
+	}
+
+	intSet := data.NewIntegerSet("intset")
+	_ = intSet.SetData(map[int64]struct{}{-1: {}, 2: {}, math.MaxInt64: {}})
+
+	sortedSet := data.NewSortedSet("zset")
+	_ = sortedSet.SetData(map[string]float64{
+		"a": 1.5, "b": -math.MaxFloat64, "c": math.Inf(1), "d": 0.1,
+	})
+
+	hash := data.NewMap("hash")
+	_ = hash.SetData(map[string]string{"f1": "v1", "f2": "12"})
+	_ = hash.SetDB(2)
+
+	keys := []data.Key{str, integer, long, list, set, intSet, sortedSet, hash}
+	if version >= 9 {
+		module := data.NewModule("module", "testmodul", 3)
+		_ = module.SetData([]data.ModuleValue{
+			{Type: data.ModuleSigned, Signed: -5},
+			{Type: data.ModuleUnsigned, Unsigned: math.MaxUint64},
+			{Type: data.ModuleFloat, Float: 1.5},
+			{Type: data.ModuleDouble, Float: math.Pi},
+			{Type: data.ModuleString, String: "value"},
+		})
+		_ = module.SetDB(2)
+		keys = append(keys, module, testEncoderStream(t, version))
+	}
+	return keys
+}
+
+// testEncoderStream возвращает Stream из нескольких узлов с группой
+func testEncoderStream(t *testing.T, version uint32) data.StreamKey {
+	stream := data.NewStream("stream")
+	_ = stream.SetDB(2)
+	for i := uint64(0); i < 250; i++ {
+		// seq первой записи больше seq следующих записей узла
+		entry := data.StreamEntry{
+			ID:     data.StreamID{Ms: 1000 + i},
+			Fields: []data.StreamField{{Name: "f", Value: fmt.Sprint(i)}},
+		}
+		if i == 0 {
+			entry.ID = data.StreamID{Ms: 999, Seq: 5}
+		}
+		if i%3 == 0 {
+			entry.Fields = append(entry.Fields, data.StreamField{Name: "g", Value: "x"})
+		}
+		if err := stream.Add(entry); err != nil {
+			t.Fatalf("stream add error: %v", err)
+		}
+	}
+	last := stream.Values()[249].ID
+	info := data.StreamInfo{Length: 250, LastID: last}
+	if version >= 10 {
+		info.FirstID = stream.Values()[0].ID
+		info.MaxDeletedID = data.StreamID{Ms: 1, Seq: 1}
+		info.EntriesAdded = 300
+	}
+	_ = stream.SetInfo(info)
+
+	group := data.StreamGroup{Name: "group", LastID: last, EntriesRead: -1}
+	if version >= 10 {
+		group.EntriesRead = 100
+	}
+	pending := data.StreamPendingEntry{
+		ID:            stream.Values()[1].ID,
+		Consumer:      "consumer",
+		DeliveryTime:  time.Unix(1700000000, int64(123*time.Millisecond)),
+		DeliveryCount: 2,
+	}
+	group.Pending = []data.StreamPendingEntry{pending}
+	consumer := data.StreamConsumer{
+		Name:       "consumer",
+		SeenTime:   time.Unix(1700000001, 0),
+		ActiveTime: time.Unix(1700000001, 0),
+		Pending:    []data.StreamID{pending.ID},
+	}
+	if version >= 11 {
+		consumer.ActiveTime = time.Unix(1700000002, 0)
+	}
+	group.Consumers = []data.StreamConsumer{consumer}
+	_ = stream.AddGroup(group)
+	return stream
+}
+
+// testEncoderExpectedKeys возвращает ключи в том виде в котором их вернёт
+// Decoder: IntegerSet больше порога записывается как Set
+func testEncoderExpectedKeys(keys []data.Key, limits EncodingLimits) []data.Key {
+	expected := make([]data.Key, len(keys))
+	copy(expected, keys)
+	for i, key := range keys {
+		intSet, ok := key.(data.IntegerSetKey)
+		if !ok || limits.SetMaxIntsetEntries > 0 &&
+			len(intSet.Values()) <= limits.SetMaxIntsetEntries {
+			continue
+		}
+		set := data.NewSet(key.Name())
+		for value := range intSet.Values() {
+			_ = set.Set(fmt.Sprint(value))
+		}
+		_ = set.SetDB(key.DB())
+		expected[i] = set
+	}
+	return expected
+}
+
+// TestEncoderCompression проверяет сжатие строк и его отключение
+func TestEncoderCompression(t *testing.T) {
+	value := strings.Repeat("value:", 1000)
+	sizes := map[bool]int{}
+	for _, compress := range []bool{true, false} {
+		var rdb bytes.Buffer
+		encoder := NewEncoder(&rdb, WithCompression(compress))
+		if err := encoder.Key(data.NewString("key", value)); err != nil {
+			t.Fatalf("encode error: %v", err)
+		}
+		if err := encoder.SetEOF(NewEOF()); err != nil {
+			t.Fatalf("encode error: %v", err)
+		}
+		sizes[compress] = rdb.Len()
+
+		consumer := &testKeyConsumer{}
+		if err := NewDecoder(&rdb).DecodeKeys(consumer); err != nil {
+			t.Fatalf("decode error: %v", err)
+		}
+		if len(consumer.keys) != 1 || consumer.keys[0].(data.StringKey).Value() != value {
+			t.Fatalf("unexpected keys %#v", consumer.keys)
+		}
+	}
+	if sizes[true] >= sizes[false]/10 {
+		t.Fatalf("expected compressed size %d much less than %d", sizes[true], sizes[false])
+	}
+}
+
+// TestEncoderEncodings проверяет выбор кодирования коллекций по версии
+// RDB и порогам
+func TestEncoderEncodings(t *testing.T) {
+	set := data.NewSet("set")
+	_ = set.SetData(map[string]struct{}{"a": {}, "b": {}})
+	intSet := data.NewSet("intset")
+	_ = intSet.SetData(map[string]struct{}{"1": {}, "-2": {}})
+	hash := data.NewMap("hash")
+	_ = hash.SetData(map[string]string{"field": "value"})
+	zset := data.NewSortedSet("zset")
+	_ = zset.SetData(map[string]float64{"b": 1, "a": 1, "c": -0.5})
+	long := data.NewSortedSet("long")
+	_ = long.SetData(map[string]float64{strings.Repeat("m", 65): 1})
+
+	tests := []struct {
+		key     data.Key
+		version uint32
+		opcode  byte
+	}{
+		{set, 9, SetOpcode},
+		{set, 10, SetOpcode},
+		{set, 11, ListpackSetOpcode},
+		{intSet, 9, IntSetOpcode},
+		{intSet, 11, IntSetOpcode},
+		{hash, 9, ZipListHashMapOpcode},
+		{hash, 10, ListpackHashMapOpcode},
+		{zset, 9, ZipListSortedSetOpcode},
+		{zset, 11, ListpackSortedSetOpcode},
+		{long, 11, SortedSet2Opcode},
+		{long, 7, SortedSetOpcode},
+	}
+	for _, test := range tests {
+		var rdb bytes.Buffer
+		encoder := NewEncoder(&rdb, WithRDBVersion(test.version))
+		if err := encoder.SetMagic(NewMagic(12)); err != nil {
+			t.Fatalf("encode error: %v", err)
+		}
+		if err := encoder.Key(test.key); err != nil {
+			t.Fatalf("encode error: %v", err)
+		}
+		if err := encoder.SetEOF(NewEOF()); err != nil {
+			t.Fatalf("encode error: %v", err)
+		}
+
+		body := rdb.Bytes()
+		if version := string(body[5:9]); version != fmt.Sprintf("%04d", test.version) {
+			t.Fatalf("expected version %d but actual %s", test.version, version)
+		}
+		// Magic и DBSelector(0) занимают 11 байт
+		if body[11] != test.opcode {
+			t.Fatalf(
+				"%s version %d: expected opcode %d but actual %d",
+				test.key.Name(),
+				test.version,
+				test.opcode,
+				body[11],
+			)
+		}
+		consumer := &testKeyConsumer{}
+		if err := NewDecoder(&rdb).DecodeKeys(consumer); err != nil {
+			t.Fatalf("decode error: %v", err)
+		}
+		if len(consumer.keys) != 1 {
+			t.Fatalf("expected 1 key but actual %d", len(consumer.keys))
+		}
+		expected := testChunkEvent("key", test.key)
+		if actual := testChunkEvent("key", consumer.keys[0]); actual != expected {
+			t.Fatalf("expected %q but actual %q", expected, actual)
+		}
+	}
+}
+
+// TestEncoderZeroLimits проверяет что нулевые пороги отключают
+// компактное кодирование и для пустых коллекций
+func TestEncoderZeroLimits(t *testing.T) {
+	intSet := data.NewSet("intset")
+	_ = intSet.SetData(map[string]struct{}{"1": {}})
+	tests := []struct {
+		key    data.Key
+		opcode byte
+	}{
+		{data.NewSet("set"), SetOpcode},
+		{intSet, SetOpcode},
+		{data.NewMap("hash"), ListHashMapOpcode},
+		{data.NewSortedSet("zset"), SortedSet2Opcode},
+	}
+	for _, test := range tests {
+		var rdb bytes.Buffer
+		encoder := NewEncoder(&rdb, WithEncodingLimits(EncodingLimits{}))
+		if err := encoder.SetMagic(NewMagic(11)); err != nil {
+			t.Fatalf("encode error: %v", err)
+		}
+		if err := encoder.Key(test.key); err != nil {
+			t.Fatalf("encode error: %v", err)
+		}
+		if err := encoder.SetEOF(NewEOF()); err != nil {
+			t.Fatalf("encode error: %v", err)
+		}
+		// Magic и DBSelector(0) занимают 11 байт
+		if opcode := rdb.Bytes()[11]; opcode != test.opcode {
+			t.Fatalf(
+				"%s: expected opcode %d but actual %d",
+				test.key.Name(),
+				test.opcode,
+				opcode,
+			)
+		}
+	}
+}
+
+// TestEncodeSortedListpack проверяет порядок элементов упорядоченного
+// набора в Listpack: по весу, при равных весах по значению
+func TestEncodeSortedListpack(t *testing.T) {
+	zset := data.NewSortedSet("zset")
+	_ = zset.SetData(map[string]float64{
+		"b": 1, "a": 1, "c": -0.5, "d": math.Inf(1), "e": 2.25,
+	})
+	var rdb bytes.Buffer
+	encoder := NewEncoder(&rdb, WithRDBVersion(11))
+	if err := encoder.Key(zset); err != nil {
+		t.Fatalf("encode error: %v", err)
+	}
+	if err := encoder.SetEOF(NewEOF()); err != nil {
+		t.Fatalf("encode error: %v", err)
+	}
+	r := NewStringReader(string(rdb.Bytes()[12:]))
+	if _, err := r.ReadString(); err != nil {
+		t.Fatalf("read name error: %v", err)
+	}
+	body, err := r.ReadString()
+	if err != nil {
+		t.Fatalf("read listpack error: %v", err)
+	}
+	entries, err := NewListpackStringReader(body).ReadEntries()
+	if err != nil {
+		t.Fatalf("read listpack error: %v", err)
+	}
+	var actual []string
+	for _, entry := range entries {
+		actual = append(actual, string(entry))
+	}
+	expected := []string{"c", "-0.5", "a", "1", "b", "1", "e", "2.25", "d", "inf"}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("expected %q but actual %q", expected, actual)
+	}
+}
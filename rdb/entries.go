@@ -6,6 +6,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"math"
 	"strconv"
 )
 
@@ -124,7 +125,7 @@ func (r *entriesReader) ReadEntry() ([]byte, error) {
 		}
 		return []byte(
 			strconv.FormatInt(
-				int64(binary.LittleEndian.Uint16(intBytes)),
+				int64(int16(binary.LittleEndian.Uint16(intBytes))),
 				10,
 			),
 		), nil
@@ -135,7 +136,7 @@ func (r *entriesReader) ReadEntry() ([]byte, error) {
 		}
 		return []byte(
 			strconv.FormatInt(
-				int64(binary.LittleEndian.Uint32(intBytes)),
+				int64(int32(binary.LittleEndian.Uint32(intBytes))),
 				10,
 			),
 		), nil
@@ -155,16 +156,17 @@ func (r *entriesReader) ReadEntry() ([]byte, error) {
 		if err != nil {
 			return nil, err
 		}
+		// число сдвигается в старшие байты и обратно для расширения знака
 		intBytes = append([]byte{0x00}, intBytes...)
 		return []byte(
 			strconv.FormatInt(
-				int64(binary.LittleEndian.Uint32(intBytes)>>8),
+				int64(int32(binary.LittleEndian.Uint32(intBytes))>>8),
 				10,
 			),
 		), nil
 	case header == zipListInt8:
 		b, err := r.ReadByte()
-		return []byte(strconv.FormatInt(int64(b), 10)), err
+		return []byte(strconv.FormatInt(int64(int8(b)), 10)), err
 	case header>>4 == zipListInt4:
 		return []byte(strconv.FormatInt(int64(header&0x0f)-1, 10)), nil
 	}
@@ -183,3 +185,98 @@ func (r *entriesReader) ReadEnd() error {
 	}
 	return nil
 }
+
+// zipListWriter собирает ZipList из элементов
+type zipListWriter struct {
+	body       []byte
+	count      int
+	tail       int
+	prevLength int
+}
+
+// newZipListWriter возвращает новый zipListWriter
+func newZipListWriter() *zipListWriter {
+	return &zipListWriter{}
+}
+
+// Append добавляет элемент: <prevlen><encoding><data>, строки до 32 байт
+// в каноническом виде целого числа записываются как числа
+func (w *zipListWriter) Append(entry string) {
+	var prevLength []byte
+	if w.prevLength < 254 {
+		prevLength = []byte{byte(w.prevLength)}
+	} else {
+		prevLength = make([]byte, 5)
+		prevLength[0] = 254
+		binary.LittleEndian.PutUint32(prevLength[1:], uint32(w.prevLength))
+	}
+
+	var encoded []byte
+	i, err := strconv.ParseInt(entry, 10, 64)
+	if len(entry) <= 32 && err == nil && strconv.FormatInt(i, 10) == entry {
+		encoded = zipListInt(i)
+	} else {
+		encoded = zipListString(entry)
+	}
+
+	w.tail = len(w.body)
+	w.body = append(w.body, prevLength...)
+	w.body = append(w.body, encoded...)
+	w.prevLength = len(prevLength) + len(encoded)
+	w.count++
+}
+
+// Len возвращает количество элементов
+func (w *zipListWriter) Len() int {
+	return w.count
+}
+
+// Bytes возвращает ZipList с заголовком и закрывающим байтом
+func (w *zipListWriter) Bytes() []byte {
+	count := w.count
+	if count > math.MaxUint16 {
+		count = math.MaxUint16
+	}
+	result := make([]byte, 10, 10+len(w.body)+1)
+	binary.LittleEndian.PutUint32(result, uint32(10+len(w.body)+1))
+	binary.LittleEndian.PutUint32(result[4:], uint32(10+w.tail))
+	binary.LittleEndian.PutUint16(result[8:], uint16(count))
+	result = append(result, w.body...)
+	return append(result, 255)
+}
+
+// zipListInt кодирует целое число в самой короткой кодировке ZipList
+func zipListInt(i int64) []byte {
+	body := make([]byte, 8)
+	binary.LittleEndian.PutUint64(body, uint64(i))
+	switch {
+	case i >= 0 && i <= 12:
+		return []byte{zipListInt4<<4 | byte(i+1)}
+	case i >= math.MinInt8 && i <= math.MaxInt8:
+		return []byte{zipListInt8, body[0]}
+	case i >= math.MinInt16 && i <= math.MaxInt16:
+		return append([]byte{zipListInt16}, body[:2]...)
+	case i >= -1<<23 && i < 1<<23:
+		return append([]byte{zipListInt24}, body[:3]...)
+	case i >= math.MinInt32 && i <= math.MaxInt32:
+		return append([]byte{zipListInt32}, body[:4]...)
+	}
+	return append([]byte{zipListInt64}, body...)
+}
+
+// zipListString кодирует строку с длиной в 6, 14 или 32 битах
+func zipListString(entry string) []byte {
+	size := len(entry)
+	var header []byte
+	switch {
+	case size < 1<<6:
+		header = []byte{len6Bit<<6 | byte(size)}
+	case size < 1<<14:
+		header = []byte{len14Bit<<6 | byte(size>>8), byte(size)}
+	default:
+		header = make([]byte, 5)
+		header[0] = len32Bit << 6
+		binary.BigEndian.PutUint32(header[1:], uint32(size))
+	}
+	return append(header, entry...)
+}
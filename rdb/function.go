@@ -0,0 +1,142 @@
+package rdb
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Function это библиотека функций (FUNCTION LOAD)
+type Function struct {
+	name        string
+	engine      string
+	description string
+	code        string
+
+	// preGA означает что библиотека прочитана из FunctionPreGAOpcode
+	// и записывается в том же формате
+	preGA bool
+}
+
+// NewFunction возвращает новую библиотеку функций по её исходному коду,
+// название и движок берутся из первой строки "#!<engine> name=<name>"
+func NewFunction(code string) Function {
+	f := Function{code: code}
+	f.engine, f.name = parseFunctionShebang(code)
+	return f
+}
+
+// GetName возвращает название библиотеки
+func (f Function) GetName() string {
+	return f.name
+}
+
+// GetEngine возвращает название движка, например "lua"
+func (f Function) GetEngine() string {
+	return f.engine
+}
+
+// GetDescription возвращает описание,
+// задано только в формате FunctionPreGAOpcode
+func (f Function) GetDescription() string {
+	return f.description
+}
+
+// GetCode возвращает исходный код библиотеки
+func (f Function) GetCode() string {
+	return f.code
+}
+
+// IsPreGA возвращает true если библиотека записана в формате
+// FunctionPreGAOpcode
+func (f Function) IsPreGA() bool {
+	return f.preGA
+}
+
+// Bytes возвращает бинарное представление в том формате в котором
+// библиотека была прочитана: FunctionPreGAOpcode сохраняет название,
+// движок и описание
+func (f Function) Bytes() []byte {
+	buffer := bytes.NewBuffer(nil)
+	if !f.preGA {
+		buffer.WriteByte(FunctionOpcode)
+		buffer.Write(EncodeString(f.code))
+		return buffer.Bytes()
+	}
+	buffer.WriteByte(FunctionPreGAOpcode)
+	buffer.Write(EncodeString(f.name))
+	buffer.Write(EncodeString(f.engine))
+	if f.description != "" {
+		buffer.Write(EncodeLength(1))
+		buffer.Write(EncodeString(f.description))
+	} else {
+		buffer.Write(EncodeLength(0))
+	}
+	buffer.Write(EncodeString(f.code))
+	return buffer.Bytes()
+}
+
+// ReadFunction читает библиотеку функций, opcode это FunctionOpcode или
+// FunctionPreGAOpcode
+func (r *reader) ReadFunction(opcode byte) (Function, error) {
+	if opcode == FunctionOpcode {
+		code, err := r.ReadString()
+		if err != nil {
+			return Function{}, err
+		}
+		return NewFunction(code), nil
+	}
+	if opcode != FunctionPreGAOpcode {
+		return Function{}, fmt.Errorf("unexpected function opcode %#v", opcode)
+	}
+
+	// name, engine, признак описания, описание и код
+	f := Function{preGA: true}
+	var err error
+	f.name, err = r.ReadString()
+	if err != nil {
+		return Function{}, err
+	}
+	f.engine, err = r.ReadString()
+	if err != nil {
+		return Function{}, err
+	}
+	hasDescription, _, err := r.ReadLength()
+	if err != nil {
+		return Function{}, err
+	}
+	if hasDescription != 0 {
+		f.description, err = r.ReadString()
+		if err != nil {
+			return Function{}, err
+		}
+	}
+	f.code, err = r.ReadString()
+	if err != nil {
+		return Function{}, err
+	}
+	return f, nil
+}
+
+// parseFunctionShebang возвращает движок и название библиотеки
+// из строки "#!<engine> name=<name> [...]"
+func parseFunctionShebang(code string) (engine, name string) {
+	if !strings.HasPrefix(code, "#!") {
+		return "", ""
+	}
+	line := code[2:]
+	if i := strings.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return "", ""
+	}
+	engine = fields[0]
+	for _, field := range fields[1:] {
+		if strings.HasPrefix(field, "name=") {
+			name = strings.TrimPrefix(field, "name=")
+		}
+	}
+	return engine, name
+}
@@ -0,0 +1,237 @@
+package rdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/avito-tech/smart-redis-replication/data"
+)
+
+// testIntegers это граничные значения целых чисел всех размеров
+var testIntegers = []int64{
+	0, 1, -1, 12, 13,
+	math.MaxInt8, math.MinInt8, math.MaxInt8 + 1, math.MinInt8 - 1,
+	math.MaxInt16, math.MinInt16, math.MaxInt16 + 1, math.MinInt16 - 1,
+	1<<23 - 1, -1 << 23, 1 << 23, -1<<23 - 1,
+	math.MaxInt32, math.MinInt32, math.MaxInt32 + 1, math.MinInt32 - 1,
+	math.MaxInt64, math.MinInt64,
+}
+
+// TestStringIntegerRoundTrip проверяет что числа записанные как строки
+// читаются обратно без изменений
+func TestStringIntegerRoundTrip(t *testing.T) {
+	for _, value := range testIntegers {
+		expected := strconv.FormatInt(value, 10)
+		result, err := NewStringReader(string(EncodeString(expected))).ReadString()
+		if err != nil {
+			t.Fatalf("read %s error: %v", expected, err)
+		}
+		if result != expected {
+			t.Fatalf("expected %q but actual %q", expected, result)
+		}
+	}
+}
+
+// TestIntSetDecode проверяет чтение IntSet с отрицательными
+// и граничными значениями всех размеров, запись проверяет
+// TestEntriesWriterRoundTrip
+func TestIntSetDecode(t *testing.T) {
+	tests := map[uint32][]int64{
+		2: {math.MinInt16, -1, 0, 1, math.MaxInt16},
+		4: {math.MinInt32, math.MinInt16 - 1, -1, math.MaxInt16 + 1, math.MaxInt32},
+		8: {math.MinInt64, math.MinInt32 - 1, -1, math.MaxInt32 + 1, math.MaxInt64},
+	}
+	for size, values := range tests {
+		key := data.NewIntegerSet("intset")
+		err := (&reader{}).DecodeIntegerSet(key, testIntSet(size, values))
+		if err != nil {
+			t.Fatalf("decode intset error: %v", err)
+		}
+		expected := make(map[int64]struct{}, len(values))
+		for _, value := range values {
+			expected[value] = struct{}{}
+		}
+		if !reflect.DeepEqual(key.Values(), expected) {
+			t.Fatalf(
+				"size %d: expected %v but actual %v",
+				size,
+				expected,
+				key.Values(),
+			)
+		}
+	}
+}
+
+// TestZipListIntegerDecode проверяет чтение чисел ZipList всех кодировок
+func TestZipListIntegerDecode(t *testing.T) {
+	key := data.NewList("ziplist")
+	err := (&reader{}).DecodeZipList(key, testZipList(testIntegers))
+	if err != nil {
+		t.Fatalf("decode ziplist error: %v", err)
+	}
+	expected := make([]string, 0, len(testIntegers))
+	for _, value := range testIntegers {
+		expected = append(expected, strconv.FormatInt(value, 10))
+	}
+	if !reflect.DeepEqual(key.Values(), expected) {
+		t.Fatalf("expected %q but actual %q", expected, key.Values())
+	}
+}
+
+// TestListpackIntegerDecode проверяет чтение чисел Listpack всех
+// кодировок
+func TestListpackIntegerDecode(t *testing.T) {
+	entries := make([][]byte, 0, len(testIntegers))
+	for _, value := range testIntegers {
+		entries = append(entries, testListpackInt(value))
+	}
+	result, err := NewListpackStringReader(testListpack(entries...)).ReadEntries()
+	if err != nil {
+		t.Fatalf("read listpack error: %v", err)
+	}
+	for i, value := range testIntegers {
+		if string(result[i]) != strconv.FormatInt(value, 10) {
+			t.Fatalf("expected %d but actual %s", value, result[i])
+		}
+	}
+}
+
+// TestEntriesWriterRoundTrip проверяет что ZipList, Listpack и IntSet
+// собранные writer читаются без изменений
+func TestEntriesWriterRoundTrip(t *testing.T) {
+	values := []string{"", "007", "-0", "1.5", "+1"}
+	for _, size := range []int{63, 64, 300, 4095, 4096, 16383, 16384} {
+		values = append(values, strings.Repeat("x", size))
+	}
+	for _, value := range testIntegers {
+		values = append(values, strconv.FormatInt(value, 10))
+	}
+
+	t.Run("ZipList", func(t *testing.T) {
+		zipList := newZipListWriter()
+		for _, value := range values {
+			zipList.Append(value)
+		}
+		key := data.NewList("ziplist")
+		err := (&reader{}).DecodeZipList(key, string(zipList.Bytes()))
+		if err != nil {
+			t.Fatalf("decode ziplist error: %v", err)
+		}
+		if !reflect.DeepEqual(key.Values(), values) {
+			t.Fatalf("expected %q but actual %q", values, key.Values())
+		}
+	})
+	t.Run("Listpack", func(t *testing.T) {
+		lp := newListpackWriter()
+		for _, value := range values {
+			lp.Append(value)
+		}
+		entries, err := NewListpackStringReader(string(lp.Bytes())).ReadEntries()
+		if err != nil {
+			t.Fatalf("read listpack error: %v", err)
+		}
+		for i, value := range values {
+			if string(entries[i]) != value {
+				t.Fatalf("expected %q but actual %q", value, entries[i])
+			}
+		}
+	})
+	t.Run("IntSet", func(t *testing.T) {
+		tests := map[uint32][]int64{
+			2: {math.MaxInt16, math.MinInt16, 0},
+			4: {math.MinInt16 - 1, 1, math.MaxInt32},
+			8: testIntegers,
+		}
+		for size, values := range tests {
+			body := encodeIntSet(values)
+			if actual := binary.LittleEndian.Uint32(body); actual != size {
+				t.Fatalf("expected size %d but actual %d", size, actual)
+			}
+			key := data.NewIntegerSet("intset")
+			err := (&reader{}).DecodeIntegerSet(key, string(body))
+			if err != nil {
+				t.Fatalf("decode intset error: %v", err)
+			}
+			for _, value := range values {
+				if !key.Is(value) {
+					t.Fatalf("size %d: value %d not found", size, value)
+				}
+			}
+		}
+	})
+}
+
+// testIntSet кодирует IntSet с элементами размера size
+func testIntSet(size uint32, values []int64) string {
+	body := make([]byte, 8, 8+int(size)*len(values))
+	binary.LittleEndian.PutUint32(body, size)
+	binary.LittleEndian.PutUint32(body[4:], uint32(len(values)))
+	for _, value := range values {
+		entry := make([]byte, 8)
+		binary.LittleEndian.PutUint64(entry, uint64(value))
+		body = append(body, entry[:size]...)
+	}
+	return string(body)
+}
+
+// testZipList кодирует ZipList из чисел в минимальной кодировке
+func testZipList(values []int64) string {
+	var entries bytes.Buffer
+	prevLength := 0
+	tail := 10
+	for _, value := range values {
+		entry := testZipListInt(value)
+		tail = 10 + entries.Len()
+		entries.WriteByte(byte(prevLength))
+		entries.Write(entry)
+		prevLength = len(entry) + 1
+	}
+	header := make([]byte, 10)
+	binary.LittleEndian.PutUint32(header, uint32(10+entries.Len()+1))
+	binary.LittleEndian.PutUint32(header[4:], uint32(tail))
+	binary.LittleEndian.PutUint16(header[8:], uint16(len(values)))
+	return string(header) + entries.String() + "\xff"
+}
+
+// testZipListInt кодирует число как элемент ZipList без prevlen
+func testZipListInt(value int64) []byte {
+	body := make([]byte, 8)
+	binary.LittleEndian.PutUint64(body, uint64(value))
+	switch {
+	case value >= 0 && value <= 12:
+		return []byte{zipListInt4<<4 | byte(value+1)}
+	case value >= math.MinInt8 && value <= math.MaxInt8:
+		return []byte{zipListInt8, body[0]}
+	case value >= math.MinInt16 && value <= math.MaxInt16:
+		return append([]byte{zipListInt16}, body[:2]...)
+	case value >= -1<<23 && value < 1<<23:
+		return append([]byte{zipListInt24}, body[:3]...)
+	case value >= math.MinInt32 && value <= math.MaxInt32:
+		return append([]byte{zipListInt32}, body[:4]...)
+	}
+	return append([]byte{zipListInt64}, body...)
+}
+
+// testListpackInt кодирует число как элемент Listpack без backlen
+func testListpackInt(value int64) []byte {
+	body := make([]byte, 8)
+	binary.LittleEndian.PutUint64(body, uint64(value))
+	switch {
+	case value >= 0 && value <= 127:
+		return []byte{byte(value)}
+	case value >= -1<<12 && value < 1<<12:
+		return []byte{listpack13BitInt | byte(uint64(value)>>8)&0x1F, body[0]}
+	case value >= math.MinInt16 && value <= math.MaxInt16:
+		return append([]byte{listpack16BitInt}, body[:2]...)
+	case value >= -1<<23 && value < 1<<23:
+		return append([]byte{listpack24BitInt}, body[:3]...)
+	case value >= math.MinInt32 && value <= math.MaxInt32:
+		return append([]byte{listpack32BitInt}, body[:4]...)
+	}
+	return append([]byte{listpack64BitInt}, body...)
+}
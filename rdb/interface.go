@@ -2,6 +2,7 @@ package rdb
 
 import (
 	"io"
+	"time"
 
 	"github.com/avito-tech/smart-redis-replication/data"
 )
@@ -11,6 +12,27 @@ type KeyConsumer interface {
 	Key(data.Key) error
 }
 
+// FunctionConsumer это потребитель библиотек функций,
+// если KeyConsumer или Consumer реализует этот интерфейс то DecodeKeys
+// и Decode передают ему и библиотеки функций
+type FunctionConsumer interface {
+	SetFunction(Function) error
+}
+
+// ModuleAuxConsumer это потребитель дополнительных данных модулей,
+// если Consumer реализует этот интерфейс то Decode передаёт ему
+// и дополнительные данные модулей
+type ModuleAuxConsumer interface {
+	SetModuleAux(ModuleAux) error
+}
+
+// SlotInfoConsumer это потребитель данных о размере слотов,
+// если Consumer реализует этот интерфейс то Decode передаёт ему
+// и данные о размере слотов
+type SlotInfoConsumer interface {
+	SetSlotInfo(SlotInfo) error
+}
+
 // Consumer это интерфейс потребителя всех данных прочитанных из RDB файла
 type Consumer interface {
 	// SetMagic устанавливает Magic строку
@@ -36,6 +58,20 @@ type Decoder interface {
 	Next() (interface{}, error)
 }
 
+// Encoder это интерфейс для записи RDB файла. Encoder реализует Consumer
+// поэтому результат Decoder.Decode можно записать в новый файл.
+// DBSelector записывается при смене базы данных ключа, контрольная
+// сумма EOF вычисляется по записанным данным
+type Encoder interface {
+	Consumer
+	ModuleAuxConsumer
+	SlotInfoConsumer
+	FunctionConsumer
+
+	// Checksum возвращает контрольную сумму записанных данных
+	Checksum() uint64
+}
+
 // Reader это интерфейс для чтения специфичных для RDB форматов
 type Reader interface {
 	io.Reader
@@ -46,6 +82,9 @@ type Reader interface {
 	// ReadOpcode читает код команды
 	ReadOpcode() (byte, error)
 
+	// Checksum возвращает контрольную сумму прочитанных данных
+	Checksum() uint64
+
 	// ReadString читает закодированную строку
 	ReadString() (line string, err error)
 
@@ -55,35 +94,59 @@ type Reader interface {
 	// ReadLength читает длину или кодировку
 	ReadLength() (length uint32, encoding int8, err error)
 
+	// ReadLength64 читает 64 битную длину
+	ReadLength64() (uint64, error)
+
 	// ReadUint8 читает закодированный uint8
 	ReadUint8() (uint8, error)
 
 	// ReadFloat64 читает закодированный float64
 	ReadFloat64() (float64, error)
 
+	// ReadBinaryFloat64 читает float64 в бинарном виде
+	ReadBinaryFloat64() (float64, error)
+
 	ReadMagic() (Magic, error)
 	ReadAuxField() (AuxField, error)
 	ReadDBSelector() (DBSelector, error)
 	ReadResizeDB() (ResizeDB, error)
 	ReadEOF() (EOF, error)
 	ReadExpiry(opcode byte) (data.Expiry, error)
+	ReadIdle() (time.Duration, error)
+	ReadFreq() (uint8, error)
+	ReadSlotInfo() (SlotInfo, error)
+	ReadFunction(opcode byte) (Function, error)
 
 	ReadSet(expiry data.Expiry) (data.SetKey, error)
 
 	// SortedSet
 	ReadSortedSet(expiry data.Expiry) (data.SortedSetKey, error)
+	ReadSortedSet2(expiry data.Expiry) (data.SortedSetKey, error)
 	ReadZipListSortedSet(expiry data.Expiry) (data.SortedSetKey, error)
 
 	// HashMap
 	ReadZipListHashMap(expiry data.Expiry) (data.MapKey, error)
 	ReadZipMapHashMap(expiry data.Expiry) (data.MapKey, error)
 	ReadListHashMap(expiry data.Expiry) (data.MapKey, error)
+	ReadListpackHashMap(expiry data.Expiry) (data.MapKey, error)
 
 	// List
 	ReadZipList(expiry data.Expiry) (data.ListKey, error)
 	ReadQuickList(expiry data.Expiry) (data.ListKey, error)
+	ReadQuickList2(expiry data.Expiry) (data.ListKey, error)
 	ReadList(expiry data.Expiry) (data.ListKey, error)
 
+	// Listpack
+	ReadListpackSortedSet(expiry data.Expiry) (data.SortedSetKey, error)
+	ReadListpackSet(expiry data.Expiry) (data.SetKey, error)
+
+	// Stream
+	ReadStream(opcode byte, expiry data.Expiry) (data.StreamKey, error)
+
+	// Module
+	ReadModule(opcode byte, expiry data.Expiry) (data.Key, error)
+	ReadModuleAux() (ModuleAux, error)
+
 	ReadIntSet(expiry data.Expiry) (data.IntegerSetKey, error)
 	ReadStringValue(expiry data.Expiry) (data.StringKey, error)
 }
@@ -108,9 +171,25 @@ type EntriesReader interface {
 	ReadEntry() ([]byte, error)
 }
 
+// ListpackReader это интерфейс для чтения Listpack структур (redis >= 7),
+// в отличие от ZipList элементы хранят свой размер в конце
+type ListpackReader interface {
+	// ReadTotalBytes читает размер listpack в байтах
+	ReadTotalBytes() (uint32, error)
+
+	// ReadEntriesCount читает количество элементов
+	ReadEntriesCount() (uint16, error)
+
+	// ReadEntry читает элемент, после последнего элемента возвращает io.EOF
+	ReadEntry() ([]byte, error)
+
+	// ReadEntries читает заголовок и все элементы
+	ReadEntries() ([][]byte, error)
+}
+
 // IntSetReader это интерфейс для чтения бинарного дерева поиска целых чисел
 // Отличается от EntriesReader тем что все элементы это целые числа
-// одного размера: uint64, uint32, uint16
+// со знаком одного размера: int64, int32, int16
 type IntSetReader interface {
 	// SafeRead безопасно читает N-байт
 	SafeRead(n uint32) ([]byte, error)
@@ -121,14 +200,14 @@ type IntSetReader interface {
 	// ReadEntriesCount читает количество элементов
 	ReadEntriesCount() (uint32, error)
 
-	// ReadEntry64 читает entry в формате uint64
-	ReadEntry64() (uint64, error)
+	// ReadEntry64 читает entry в формате int64
+	ReadEntry64() (int64, error)
 
-	// ReadEntry32 читает entry в формате uint32
-	ReadEntry32() (uint32, error)
+	// ReadEntry32 читает entry в формате int32
+	ReadEntry32() (int32, error)
 
-	// ReadEntry16 читает entry в формате uint16
-	ReadEntry16() (uint16, error)
+	// ReadEntry16 читает entry в формате int16
+	ReadEntry16() (int16, error)
 }
 
 // ZipMapReader это интерфейс для чтения ZipMap структур
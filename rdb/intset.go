@@ -6,6 +6,8 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"math"
+	"sort"
 )
 
 type intSetReader struct {
@@ -56,29 +58,58 @@ func (r *intSetReader) ReadEntriesCount() (uint32, error) {
 	return binary.LittleEndian.Uint32(count), nil
 }
 
-// ReadEntry64 читает элемент длиной в 8 байт
-func (r *intSetReader) ReadEntry64() (uint64, error) {
+// ReadEntry64 читает элемент со знаком длиной в 8 байт
+func (r *intSetReader) ReadEntry64() (int64, error) {
 	entry, err := r.SafeRead(8)
 	if err != nil {
 		return 0, err
 	}
-	return binary.LittleEndian.Uint64(entry), nil
+	return int64(binary.LittleEndian.Uint64(entry)), nil
 }
 
-// ReadEntry32 читает элемент длиной в 4 байта
-func (r *intSetReader) ReadEntry32() (uint32, error) {
+// ReadEntry32 читает элемент со знаком длиной в 4 байта
+func (r *intSetReader) ReadEntry32() (int32, error) {
 	entry, err := r.SafeRead(4)
 	if err != nil {
 		return 0, err
 	}
-	return binary.LittleEndian.Uint32(entry), nil
+	return int32(binary.LittleEndian.Uint32(entry)), nil
 }
 
-// ReadEntry32 читает элемент длиной в 2 байта
-func (r *intSetReader) ReadEntry16() (uint16, error) {
+// ReadEntry16 читает элемент со знаком длиной в 2 байта
+func (r *intSetReader) ReadEntry16() (int16, error) {
 	entry, err := r.SafeRead(2)
 	if err != nil {
 		return 0, err
 	}
-	return binary.LittleEndian.Uint16(entry), nil
+	return int16(binary.LittleEndian.Uint16(entry)), nil
+}
+
+// encodeIntSet кодирует IntSet: размер элементов, их количество
+// и элементы по возрастанию, размер это 2, 4 или 8 байт в зависимости
+// от самого большого по модулю элемента
+func encodeIntSet(values []int64) []byte {
+	sorted := make([]int64, len(values))
+	copy(sorted, values)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	size := 2
+	for _, value := range sorted {
+		switch {
+		case value < math.MinInt32 || value > math.MaxInt32:
+			size = 8
+		case size < 4 && (value < math.MinInt16 || value > math.MaxInt16):
+			size = 4
+		}
+	}
+
+	body := make([]byte, 8, 8+size*len(sorted))
+	binary.LittleEndian.PutUint32(body, uint32(size))
+	binary.LittleEndian.PutUint32(body[4:], uint32(len(sorted)))
+	entry := make([]byte, 8)
+	for _, value := range sorted {
+		binary.LittleEndian.PutUint64(entry, uint64(value))
+		body = append(body, entry[:size]...)
+	}
+	return body
 }
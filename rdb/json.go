@@ -0,0 +1,413 @@
+package rdb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/avito-tech/smart-redis-replication/data"
+)
+
+// JSONExporter записывает ключи в JSON: по одному объекту на ключ
+// с полями db, name, type, expiry (Unix миллисекунды, если задано),
+// encoding (если известно) и value
+type JSONExporter interface {
+	KeyConsumer
+
+	// Close завершает документ и сбрасывает буфер
+	Close() error
+}
+
+// JSONOption это настройка JSONExporter
+type JSONOption func(*jsonExporter)
+
+// WithJSONDocument включает запись всех ключей одним JSON массивом,
+// по умолчанию ключи записываются в формате JSON Lines: по объекту
+// в строке
+func WithJSONDocument(document bool) JSONOption {
+	return func(e *jsonExporter) {
+		e.document = document
+	}
+}
+
+// WithBase64 включает запись названий и значений в base64.
+// JSON строки могут содержать только UTF-8, поэтому без base64
+// некорректные байты заменяются на U+FFFD
+func WithBase64(enabled bool) JSONOption {
+	return func(e *jsonExporter) {
+		e.base64 = enabled
+	}
+}
+
+// jsonExporter реализует интерфейс JSONExporter
+type jsonExporter struct {
+	w        *bufio.Writer
+	buffer   bytes.Buffer
+	json     *json.Encoder
+	document bool
+	base64   bool
+	count    int
+}
+
+// jsonKey это JSON представление ключа
+type jsonKey struct {
+	DB       int         `json:"db"`
+	Name     string      `json:"name"`
+	Type     KeyType     `json:"type"`
+	Expiry   uint64      `json:"expiry,omitempty"`
+	Encoding KeyEncoding `json:"encoding,omitempty"`
+	Value    interface{} `json:"value"`
+}
+
+// NewJSONExporter возвращает новый JSONExporter записывающий в w
+func NewJSONExporter(w io.Writer, options ...JSONOption) JSONExporter {
+	e := &jsonExporter{
+		w: bufio.NewWriterSize(w, DefaultWriterSize),
+	}
+	e.json = json.NewEncoder(&e.buffer)
+	e.json.SetEscapeHTML(false)
+	for _, option := range options {
+		option(e)
+	}
+	return e
+}
+
+// ExportJSON читает все ключи decoder и записывает их в w
+func ExportJSON(decoder Decoder, w io.Writer, options ...JSONOption) error {
+	exporter := NewJSONExporter(w, options...)
+	err := decoder.DecodeKeys(exporter)
+	if err != nil {
+		return err
+	}
+	return exporter.Close()
+}
+
+// Key записывает ключ
+func (e *jsonExporter) Key(key data.Key) error {
+	value, err := e.value(key)
+	if err != nil {
+		return err
+	}
+	object := jsonKey{
+		DB:       key.DB(),
+		Name:     e.string(key.Name()),
+		Type:     KeyTypeOf(key),
+		Expiry:   key.Expiry().Milliseconds(),
+		Encoding: KeyEncoding(key.Metadata().Encoding),
+		Value:    value,
+	}
+
+	e.buffer.Reset()
+	switch {
+	case !e.document:
+	case e.count == 0:
+		e.buffer.WriteString("[\n")
+	default:
+		e.buffer.WriteString(",\n")
+	}
+	err = e.json.Encode(object)
+	if err != nil {
+		return err
+	}
+	body := e.buffer.Bytes()
+	if e.document {
+		// перевод строки пишется перед следующим объектом или в Close
+		body = body[:len(body)-1]
+	}
+	e.count++
+	_, err = e.w.Write(body)
+	return err
+}
+
+// Close завершает массив в режиме документа и сбрасывает буфер
+func (e *jsonExporter) Close() error {
+	if e.document {
+		end := "\n]\n"
+		if e.count == 0 {
+			end = "[]\n"
+		}
+		_, err := e.w.WriteString(end)
+		if err != nil {
+			return err
+		}
+	}
+	return e.w.Flush()
+}
+
+// string возвращает строку как есть или в base64
+func (e *jsonExporter) string(s string) string {
+	if e.base64 {
+		return base64.StdEncoding.EncodeToString([]byte(s))
+	}
+	return s
+}
+
+// strings возвращает строки как есть или в base64
+func (e *jsonExporter) strings(values []string) []string {
+	result := make([]string, 0, len(values))
+	for _, value := range values {
+		result = append(result, e.string(value))
+	}
+	return result
+}
+
+// value возвращает JSON представление значения ключа:
+// строку, массив строк (множества отсортированы), объект hash,
+// массив member и score упорядоченного набора или объект Stream и Module
+// nolint:gocyclo
+func (e *jsonExporter) value(key data.Key) (interface{}, error) {
+	switch key := key.(type) {
+	case data.StringKey:
+		return e.string(key.Value()), nil
+	case data.ListKey:
+		return e.strings(key.Values()), nil
+	case data.SetKey:
+		values := make([]string, 0, len(key.Values()))
+		for value := range key.Values() {
+			values = append(values, value)
+		}
+		sort.Strings(values)
+		return e.strings(values), nil
+	case data.IntegerSetKey:
+		values := make([]int64, 0, len(key.Values()))
+		for value := range key.Values() {
+			values = append(values, value)
+		}
+		sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+		return values, nil
+	case data.SortedSetKey:
+		return e.sortedSet(key), nil
+	case data.MapKey:
+		values := make(map[string]string, len(key.Values()))
+		for field, value := range key.Values() {
+			values[e.string(field)] = e.string(value)
+		}
+		return values, nil
+	case data.StreamKey:
+		return e.stream(key), nil
+	case data.ModuleKey:
+		return e.module(key), nil
+	}
+	return nil, fmt.Errorf("json exporter: unsupported key type %T", key)
+}
+
+// jsonScore это вес упорядоченного набора, бесконечности записываются
+// строками inf и -inf так как в JSON их нет
+type jsonScore float64
+
+// MarshalJSON возвращает вес числом или строкой
+func (s jsonScore) MarshalJSON() ([]byte, error) {
+	f := float64(s)
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return []byte(strconv.Quote(formatScore(f))), nil
+	}
+	return []byte(strconv.FormatFloat(f, 'g', -1, 64)), nil
+}
+
+// jsonSortedSetMember это элемент упорядоченного набора
+type jsonSortedSetMember struct {
+	Member string    `json:"member"`
+	Score  jsonScore `json:"score"`
+}
+
+// sortedSet возвращает элементы упорядоченного набора по возрастанию
+// веса, при равных весах по значению
+func (e *jsonExporter) sortedSet(key data.SortedSetKey) []jsonSortedSetMember {
+	values := key.Values()
+	members := make([]string, 0, len(values))
+	for member := range values {
+		members = append(members, member)
+	}
+	sort.Slice(members, func(i, j int) bool {
+		si, sj := values[members[i]], values[members[j]]
+		if si != sj {
+			return si < sj
+		}
+		return members[i] < members[j]
+	})
+	result := make([]jsonSortedSetMember, 0, len(members))
+	for _, member := range members {
+		result = append(result, jsonSortedSetMember{
+			Member: e.string(member),
+			Score:  jsonScore(values[member]),
+		})
+	}
+	return result
+}
+
+// jsonStream это JSON представление Stream
+type jsonStream struct {
+	Entries      []jsonStreamEntry `json:"entries"`
+	Length       uint64            `json:"length"`
+	LastID       string            `json:"last_id"`
+	FirstID      string            `json:"first_id"`
+	MaxDeletedID string            `json:"max_deleted_id"`
+	EntriesAdded uint64            `json:"entries_added"`
+	Groups       []jsonStreamGroup `json:"groups"`
+}
+
+// jsonStreamEntry это запись потока, поля записаны парами
+// название-значение как в ответе XRANGE
+type jsonStreamEntry struct {
+	ID     string   `json:"id"`
+	Fields []string `json:"fields"`
+}
+
+// jsonStreamGroup это группа получателей потока
+type jsonStreamGroup struct {
+	Name        string                   `json:"name"`
+	LastID      string                   `json:"last_id"`
+	EntriesRead int64                    `json:"entries_read"`
+	Pending     []jsonStreamPendingEntry `json:"pending"`
+	Consumers   []jsonStreamConsumer     `json:"consumers"`
+}
+
+// jsonStreamPendingEntry это неподтверждённая запись,
+// время доставки в Unix миллисекундах
+type jsonStreamPendingEntry struct {
+	ID            string `json:"id"`
+	Consumer      string `json:"consumer"`
+	DeliveryTime  int64  `json:"delivery_time"`
+	DeliveryCount uint64 `json:"delivery_count"`
+}
+
+// jsonStreamConsumer это получатель группы, время в Unix миллисекундах
+type jsonStreamConsumer struct {
+	Name       string   `json:"name"`
+	SeenTime   int64    `json:"seen_time"`
+	ActiveTime int64    `json:"active_time"`
+	Pending    []string `json:"pending"`
+}
+
+// stream возвращает записи, метаданные и группы потока
+func (e *jsonExporter) stream(key data.StreamKey) jsonStream {
+	info := key.Info()
+	result := jsonStream{
+		Entries:      make([]jsonStreamEntry, 0, len(key.Values())),
+		Length:       info.Length,
+		LastID:       info.LastID.String(),
+		FirstID:      info.FirstID.String(),
+		MaxDeletedID: info.MaxDeletedID.String(),
+		EntriesAdded: info.EntriesAdded,
+		Groups:       make([]jsonStreamGroup, 0, len(key.Groups())),
+	}
+	for _, entry := range key.Values() {
+		fields := make([]string, 0, len(entry.Fields)*2)
+		for _, field := range entry.Fields {
+			fields = append(fields, e.string(field.Name), e.string(field.Value))
+		}
+		result.Entries = append(result.Entries, jsonStreamEntry{
+			ID:     entry.ID.String(),
+			Fields: fields,
+		})
+	}
+	for _, group := range key.Groups() {
+		g := jsonStreamGroup{
+			Name:        e.string(group.Name),
+			LastID:      group.LastID.String(),
+			EntriesRead: group.EntriesRead,
+			Pending:     make([]jsonStreamPendingEntry, 0, len(group.Pending)),
+			Consumers:   make([]jsonStreamConsumer, 0, len(group.Consumers)),
+		}
+		for _, pending := range group.Pending {
+			g.Pending = append(g.Pending, jsonStreamPendingEntry{
+				ID:            pending.ID.String(),
+				Consumer:      e.string(pending.Consumer),
+				DeliveryTime:  unixMilliseconds(pending.DeliveryTime),
+				DeliveryCount: pending.DeliveryCount,
+			})
+		}
+		for _, consumer := range group.Consumers {
+			c := jsonStreamConsumer{
+				Name:       e.string(consumer.Name),
+				SeenTime:   unixMilliseconds(consumer.SeenTime),
+				ActiveTime: unixMilliseconds(consumer.ActiveTime),
+				Pending:    make([]string, 0, len(consumer.Pending)),
+			}
+			for _, id := range consumer.Pending {
+				c.Pending = append(c.Pending, id.String())
+			}
+			g.Consumers = append(g.Consumers, c)
+		}
+		result.Groups = append(result.Groups, g)
+	}
+	return result
+}
+
+// jsonModule это JSON представление значения модуля
+type jsonModule struct {
+	Module     string            `json:"module"`
+	EncVersion uint64            `json:"enc_version"`
+	Values     []jsonModuleValue `json:"values"`
+}
+
+// jsonModuleValue это значение модуля с названием его типа
+type jsonModuleValue struct {
+	Type  string      `json:"type"`
+	Value interface{} `json:"value"`
+}
+
+// module возвращает значения модуля
+func (e *jsonExporter) module(key data.ModuleKey) jsonModule {
+	result := jsonModule{
+		Module:     key.Module(),
+		EncVersion: key.EncVersion(),
+		Values:     make([]jsonModuleValue, 0, len(key.Values())),
+	}
+	for _, value := range key.Values() {
+		var v jsonModuleValue
+		switch value.Type {
+		case data.ModuleSigned:
+			v = jsonModuleValue{Type: "signed", Value: value.Signed}
+		case data.ModuleUnsigned:
+			v = jsonModuleValue{Type: "unsigned", Value: value.Unsigned}
+		case data.ModuleFloat:
+			v = jsonModuleValue{Type: "float", Value: jsonScore(value.Float)}
+		case data.ModuleDouble:
+			v = jsonModuleValue{Type: "double", Value: jsonScore(value.Float)}
+		case data.ModuleString:
+			v = jsonModuleValue{Type: "string", Value: e.string(value.String)}
+		default:
+			v = jsonModuleValue{Type: strconv.Itoa(int(value.Type))}
+		}
+		result.Values = append(result.Values, v)
+	}
+	return result
+}
+
+// KeyTypeOf возвращает тип значения ключа
+func KeyTypeOf(key data.Key) KeyType {
+	switch key.(type) {
+	case data.StringKey:
+		return StringKeyType
+	case data.ListKey:
+		return ListKeyType
+	case data.SetKey, data.IntegerSetKey:
+		return SetKeyType
+	case data.SortedSetKey:
+		return SortedSetKeyType
+	case data.MapKey:
+		return HashKeyType
+	case data.StreamKey:
+		return StreamKeyType
+	case data.ModuleKey:
+		return ModuleKeyType
+	}
+	return ""
+}
+
+// unixMilliseconds возвращает время в Unix миллисекундах,
+// 0 для нулевого времени
+func unixMilliseconds(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano() / int64(time.Millisecond)
+}
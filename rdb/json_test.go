@@ -0,0 +1,154 @@
+package rdb
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/avito-tech/smart-redis-replication/data"
+)
+
+// TestExportJSON проверяет запись ключей в формате JSON Lines
+// со способом кодирования из Decoder
+func TestExportJSON(t *testing.T) {
+	var out bytes.Buffer
+	decoder := NewDecoder(bytes.NewReader(testJSONRDB(t)))
+	if err := ExportJSON(decoder, &out); err != nil {
+		t.Fatalf("export error: %v", err)
+	}
+	expected := strings.Join([]string{
+		`{"db":0,"name":"string","type":"string","expiry":1700000000000,"encoding":"string","value":"<value>"}`,
+		`{"db":0,"name":"list","type":"list","encoding":"linkedlist","value":["a","b"]}`,
+		`{"db":0,"name":"set","type":"set","encoding":"listpack","value":["a","b"]}`,
+		`{"db":0,"name":"intset","type":"set","encoding":"intset","value":[-1,3]}`,
+		`{"db":2,"name":"hash","type":"hash","encoding":"listpack","value":{"f":"v"}}`,
+		`{"db":2,"name":"zset","type":"zset","encoding":"listpack","value":[{"member":"c","score":"-inf"},{"member":"a","score":1.5},{"member":"b","score":1.5}]}`,
+	}, "\n") + "\n"
+	if out.String() != expected {
+		t.Fatalf("expected\n%s\nbut actual\n%s", expected, out.String())
+	}
+}
+
+// TestJSONDocument проверяет запись ключей одним JSON массивом
+func TestJSONDocument(t *testing.T) {
+	var empty bytes.Buffer
+	exporter := NewJSONExporter(&empty, WithJSONDocument(true))
+	if err := exporter.Close(); err != nil {
+		t.Fatalf("close error: %v", err)
+	}
+	if empty.String() != "[]\n" {
+		t.Fatalf("expected empty array but actual %q", empty.String())
+	}
+
+	var out bytes.Buffer
+	decoder := NewDecoder(bytes.NewReader(testJSONRDB(t)))
+	if err := ExportJSON(decoder, &out, WithJSONDocument(true)); err != nil {
+		t.Fatalf("export error: %v", err)
+	}
+	var keys []map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &keys); err != nil {
+		t.Fatalf("unmarshal error: %v\n%s", err, out.String())
+	}
+	if len(keys) != 6 || keys[5]["name"] != "zset" {
+		t.Fatalf("unexpected document %s", out.String())
+	}
+}
+
+// TestJSONBase64 проверяет запись двоичных названий и значений в base64
+func TestJSONBase64(t *testing.T) {
+	value := "\x00\xff\xfe"
+	key := data.NewMap("\xc3\x28")
+	_ = key.SetData(map[string]string{"f": value})
+
+	var out bytes.Buffer
+	exporter := NewJSONExporter(&out, WithBase64(true))
+	if err := exporter.Key(key); err != nil {
+		t.Fatalf("export error: %v", err)
+	}
+	if err := exporter.Close(); err != nil {
+		t.Fatalf("close error: %v", err)
+	}
+	var object struct {
+		Name  string
+		Value map[string]string
+	}
+	if err := json.Unmarshal(out.Bytes(), &object); err != nil {
+		t.Fatalf("unmarshal error: %v", err)
+	}
+	name, _ := base64.StdEncoding.DecodeString(object.Name)
+	field := base64.StdEncoding.EncodeToString([]byte("f"))
+	actual, _ := base64.StdEncoding.DecodeString(object.Value[field])
+	if string(name) != key.Name() || string(actual) != value {
+		t.Fatalf("unexpected object %s", out.String())
+	}
+}
+
+// TestJSONStream проверяет запись потока с группами получателей
+func TestJSONStream(t *testing.T) {
+	stream := data.NewStream("stream")
+	_ = stream.Add(data.StreamEntry{
+		ID:     data.StreamID{Ms: 1, Seq: 2},
+		Fields: []data.StreamField{{Name: "f", Value: "v"}},
+	})
+	_ = stream.SetInfo(data.StreamInfo{
+		Length:       1,
+		LastID:       data.StreamID{Ms: 1, Seq: 2},
+		FirstID:      data.StreamID{Ms: 1, Seq: 2},
+		EntriesAdded: 1,
+	})
+	_ = stream.AddGroup(data.StreamGroup{
+		Name:        "g",
+		LastID:      data.StreamID{Ms: 1, Seq: 2},
+		EntriesRead: 1,
+		Consumers:   []data.StreamConsumer{{Name: "c", Pending: []data.StreamID{{Ms: 1, Seq: 2}}}},
+	})
+
+	var out bytes.Buffer
+	exporter := NewJSONExporter(&out)
+	if err := exporter.Key(stream); err != nil {
+		t.Fatalf("export error: %v", err)
+	}
+	if err := exporter.Close(); err != nil {
+		t.Fatalf("close error: %v", err)
+	}
+	expected := `{"db":0,"name":"stream","type":"stream","value":{` +
+		`"entries":[{"id":"1-2","fields":["f","v"]}],` +
+		`"length":1,"last_id":"1-2","first_id":"1-2","max_deleted_id":"0-0","entries_added":1,` +
+		`"groups":[{"name":"g","last_id":"1-2","entries_read":1,"pending":[],` +
+		`"consumers":[{"name":"c","seen_time":0,"active_time":0,"pending":["1-2"]}]}]}}` + "\n"
+	if out.String() != expected {
+		t.Fatalf("expected\n%s\nbut actual\n%s", expected, out.String())
+	}
+}
+
+// testJSONRDB возвращает RDB с ключами разных типов
+func testJSONRDB(t *testing.T) []byte {
+	var rdb bytes.Buffer
+	encoder := NewEncoder(&rdb, WithRDBVersion(11))
+	str := data.NewString("string", "<value>")
+	_ = str.SetExpiry(data.NewExpiry(1700000000000))
+	list := data.NewList("list")
+	_ = list.Rpush("a", "b")
+	set := data.NewSet("set")
+	_ = set.SetData(map[string]struct{}{"b": {}, "a": {}})
+	intset := data.NewIntegerSet("intset")
+	_ = intset.SetData(map[int64]struct{}{3: {}, -1: {}})
+	hash := data.NewMap("hash")
+	_ = hash.SetData(map[string]string{"f": "v"})
+	_ = hash.SetDB(2)
+	zset := data.NewSortedSet("zset")
+	_ = zset.SetData(map[string]float64{"b": 1.5, "a": 1.5, "c": math.Inf(-1)})
+	_ = zset.SetDB(2)
+	for _, key := range []data.Key{str, list, set, intset, hash, zset} {
+		if err := encoder.Key(key); err != nil {
+			t.Fatalf("encode error: %v", err)
+		}
+	}
+	if err := encoder.SetEOF(NewEOF()); err != nil {
+		t.Fatalf("encode error: %v", err)
+	}
+	return rdb.Bytes()
+}
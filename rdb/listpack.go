@@ -0,0 +1,327 @@
+package rdb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+const (
+	listpack7BitUint     = 0x00
+	listpack7BitUintMask = 0x80
+	listpack6BitStr      = 0x80
+	listpack6BitStrMask  = 0xC0
+	listpack13BitInt     = 0xC0
+	listpack13BitIntMask = 0xE0
+	listpack12BitStr     = 0xE0
+	listpack12BitStrMask = 0xF0
+	listpack32BitStr     = 0xF0
+	listpack16BitInt     = 0xF1
+	listpack24BitInt     = 0xF2
+	listpack32BitInt     = 0xF3
+	listpack64BitInt     = 0xF4
+	listpackEnd          = 0xFF
+
+	// listpackUnknownCount означает что количество элементов больше 65534
+	// и его нужно считать чтением всего listpack
+	listpackUnknownCount = 0xFFFF
+)
+
+type listpackReader struct {
+	*bufio.Reader
+}
+
+// NewListpackReader возвращает новый ListpackReader
+func NewListpackReader(r io.Reader) ListpackReader {
+	return &listpackReader{
+		Reader: bufio.NewReaderSize(r, DefaultReaderSize),
+	}
+}
+
+// NewListpackStringReader возвращает новый ListpackReader
+func NewListpackStringReader(st string) ListpackReader {
+	r := bytes.NewBufferString(st)
+	return &listpackReader{Reader: bufio.NewReaderSize(r, DefaultReaderSize)}
+}
+
+// SafeRead безопасно читает N байт
+func (r *listpackReader) SafeRead(n uint32) ([]byte, error) {
+	result := make([]byte, n)
+	_, err := io.ReadFull(r.Reader, result)
+	return result, err
+}
+
+// ReadTotalBytes читает размер listpack в байтах
+func (r *listpackReader) ReadTotalBytes() (uint32, error) {
+	totalBytes, err := r.SafeRead(4)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(totalBytes), nil
+}
+
+// ReadEntriesCount читает количество элементов,
+// значение 65535 означает что количество неизвестно
+func (r *listpackReader) ReadEntriesCount() (uint16, error) {
+	count, err := r.SafeRead(2)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint16(count), nil
+}
+
+// ReadEntry читает элемент, после последнего элемента возвращает io.EOF.
+// Элемент состоит из <encoding-type><element-data><element-tot-len>,
+// где encoding-type это тип и размер данных (числа хранятся со знаком),
+// а element-tot-len это размер элемента для чтения с конца, он пропускается
+// nolint:gocyclo
+func (r *listpackReader) ReadEntry() ([]byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	var value []byte
+	var size uint32
+	switch {
+	case header == listpackEnd:
+		return nil, io.EOF
+	case header&listpack7BitUintMask == listpack7BitUint:
+		value = []byte(strconv.FormatInt(int64(header), 10))
+		size = 1
+	case header&listpack6BitStrMask == listpack6BitStr:
+		length := uint32(header & 0x3F)
+		value, err = r.SafeRead(length)
+		size = 1 + length
+	case header&listpack13BitIntMask == listpack13BitInt:
+		var b byte
+		b, err = r.ReadByte()
+		number := int64(header&0x1F)<<8 | int64(b)
+		if number >= 1<<12 {
+			number -= 1 << 13
+		}
+		value = []byte(strconv.FormatInt(number, 10))
+		size = 2
+	case header&listpack12BitStrMask == listpack12BitStr:
+		var b byte
+		b, err = r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		length := uint32(header&0x0F)<<8 | uint32(b)
+		value, err = r.SafeRead(length)
+		size = 2 + length
+	case header == listpack32BitStr:
+		var lengthBytes []byte
+		lengthBytes, err = r.SafeRead(4)
+		if err != nil {
+			return nil, err
+		}
+		length := binary.LittleEndian.Uint32(lengthBytes)
+		value, err = r.SafeRead(length)
+		size = 5 + length
+	case header == listpack16BitInt:
+		value, err = r.readInt(2)
+		size = 3
+	case header == listpack24BitInt:
+		value, err = r.readInt(3)
+		size = 4
+	case header == listpack32BitInt:
+		value, err = r.readInt(4)
+		size = 5
+	case header == listpack64BitInt:
+		value, err = r.readInt(8)
+		size = 9
+	default:
+		return nil, fmt.Errorf("rdb: unknown listpack encoding: %#v", header)
+	}
+	if err != nil {
+		return nil, err
+	}
+	_, err = r.SafeRead(listpackBackLenSize(size))
+	if err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// readInt читает целое число со знаком размером n байт в little endian
+func (r *listpackReader) readInt(n uint32) ([]byte, error) {
+	intBytes, err := r.SafeRead(n)
+	if err != nil {
+		return nil, err
+	}
+	var number uint64
+	for i := int(n) - 1; i >= 0; i-- {
+		number = number<<8 | uint64(intBytes[i])
+	}
+	// расширение знака до 64 бит
+	shift := 64 - 8*n
+	signed := int64(number<<shift) >> shift
+	return []byte(strconv.FormatInt(signed, 10)), nil
+}
+
+// listpackBackLenSize возвращает количество байт в которых записан
+// размер элемента size
+func listpackBackLenSize(size uint32) uint32 {
+	switch {
+	case size <= 127:
+		return 1
+	case size < 16383:
+		return 2
+	case size < 2097151:
+		return 3
+	case size < 268435455:
+		return 4
+	}
+	return 5
+}
+
+// ReadEntries читает заголовок и все элементы listpack
+func (r *listpackReader) ReadEntries() ([][]byte, error) {
+	_, err := r.ReadTotalBytes()
+	if err != nil {
+		return nil, err
+	}
+	count, err := r.ReadEntriesCount()
+	if err != nil {
+		return nil, err
+	}
+	var entries [][]byte
+	if count != listpackUnknownCount {
+		entries = make([][]byte, 0, count)
+	}
+	for {
+		entry, err := r.ReadEntry()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	if count != listpackUnknownCount && int(count) != len(entries) {
+		return nil, fmt.Errorf(
+			"expected listpack entries count %d but actual %d",
+			count,
+			len(entries),
+		)
+	}
+	return entries, nil
+}
+
+// listpackWriter собирает Listpack из элементов
+type listpackWriter struct {
+	body  []byte
+	count int
+}
+
+// newListpackWriter возвращает новый listpackWriter
+func newListpackWriter() *listpackWriter {
+	return &listpackWriter{}
+}
+
+// Append добавляет элемент, строки в каноническом виде целого числа
+// записываются как числа так же как это делает redis
+func (w *listpackWriter) Append(entry string) {
+	i, err := strconv.ParseInt(entry, 10, 64)
+	if err == nil && strconv.FormatInt(i, 10) == entry {
+		w.AppendInt(i)
+		return
+	}
+	size := len(entry)
+	var header []byte
+	switch {
+	case size < 1<<6:
+		header = []byte{listpack6BitStr | byte(size)}
+	case size < 1<<12:
+		header = []byte{listpack12BitStr | byte(size>>8), byte(size)}
+	default:
+		header = make([]byte, 5)
+		header[0] = listpack32BitStr
+		binary.LittleEndian.PutUint32(header[1:], uint32(size))
+	}
+	w.append(append(header, entry...))
+}
+
+// AppendInt добавляет целое число в самой короткой кодировке
+func (w *listpackWriter) AppendInt(i int64) {
+	var entry []byte
+	switch {
+	case i >= 0 && i <= 127:
+		entry = []byte{listpack7BitUint | byte(i)}
+	case i >= -(1<<12) && i < 1<<12:
+		u := uint64(i) & (1<<13 - 1)
+		entry = []byte{listpack13BitInt | byte(u>>8), byte(u)}
+	case i >= math.MinInt16 && i <= math.MaxInt16:
+		entry = listpackInt(listpack16BitInt, i, 2)
+	case i >= -(1<<23) && i < 1<<23:
+		entry = listpackInt(listpack24BitInt, i, 3)
+	case i >= math.MinInt32 && i <= math.MaxInt32:
+		entry = listpackInt(listpack32BitInt, i, 4)
+	default:
+		entry = listpackInt(listpack64BitInt, i, 8)
+	}
+	w.append(entry)
+}
+
+// append добавляет закодированный элемент и его размер для чтения с конца
+func (w *listpackWriter) append(entry []byte) {
+	w.body = append(w.body, entry...)
+	w.body = append(w.body, listpackBackLen(uint32(len(entry)))...)
+	w.count++
+}
+
+// Len возвращает количество элементов
+func (w *listpackWriter) Len() int {
+	return w.count
+}
+
+// Size возвращает размер Listpack в байтах
+func (w *listpackWriter) Size() int {
+	return 6 + len(w.body) + 1
+}
+
+// Bytes возвращает Listpack с заголовком и признаком конца
+func (w *listpackWriter) Bytes() []byte {
+	count := w.count
+	if count >= listpackUnknownCount {
+		count = listpackUnknownCount
+	}
+	result := make([]byte, 6, w.Size())
+	binary.LittleEndian.PutUint32(result, uint32(w.Size()))
+	binary.LittleEndian.PutUint16(result[4:], uint16(count))
+	result = append(result, w.body...)
+	return append(result, listpackEnd)
+}
+
+// listpackInt кодирует целое число со знаком размером n байт
+// в little endian
+func listpackInt(header byte, i int64, n int) []byte {
+	entry := make([]byte, 1+n)
+	entry[0] = header
+	for j := 1; j <= n; j++ {
+		entry[j] = byte(i)
+		i >>= 8
+	}
+	return entry
+}
+
+// listpackBackLen кодирует размер элемента size для чтения с конца:
+// по 7 бит в байте, старший бит всех байт кроме первого установлен
+func listpackBackLen(size uint32) []byte {
+	n := listpackBackLenSize(size)
+	backLen := make([]byte, n)
+	for i := int(n) - 1; i >= 0; i-- {
+		backLen[i] = byte(size & 127)
+		if i > 0 {
+			backLen[i] |= 128
+		}
+		size >>= 7
+	}
+	return backLen
+}
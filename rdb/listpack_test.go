@@ -0,0 +1,210 @@
+package rdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/avito-tech/smart-redis-replication/data"
+)
+
+// TestListpackReadEntry проверяет чтение элементов всех кодировок
+func TestListpackReadEntry(t *testing.T) {
+	long := strings.Repeat("x", 100)
+	body := testListpack(
+		[]byte{0x05},                         // 7 bit uint
+		append([]byte{0x83}, "abc"...),       // 6 bit str
+		[]byte{0xDF, 0xFF},                   // 13 bit int -1
+		[]byte{0xC1, 0x00},                   // 13 bit int 256
+		append([]byte{0xE0, 100}, long...),   // 12 bit str
+		[]byte{0xF1, 0xD4, 0xFE},             // 16 bit int -300
+		[]byte{0xF2, 0x90, 0xEE, 0xFE},       // 24 bit int -70000
+		[]byte{0xF3, 0x00, 0x00, 0x00, 0x80}, // 32 bit int min
+		[]byte{0xF4, 0x01, 0, 0, 0, 0, 0, 0, 0x80},
+	)
+	entries, err := NewListpackStringReader(body).ReadEntries()
+	if err != nil {
+		t.Fatalf("read entries error: %v", err)
+	}
+	expected := []string{
+		"5",
+		"abc",
+		"-1",
+		"256",
+		long,
+		"-300",
+		"-70000",
+		"-2147483648",
+		"-9223372036854775807",
+	}
+	actual := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		actual = append(actual, string(entry))
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("expected %q but actual %q", expected, actual)
+	}
+}
+
+// TestListpackReadEntriesError проверяет ошибки чтения listpack
+func TestListpackReadEntriesError(t *testing.T) {
+	t.Run("Count", func(t *testing.T) {
+		body := []byte(testListpack([]byte{0x01}))
+		body[4] = 2
+		_, err := NewListpackStringReader(string(body)).ReadEntries()
+		if err == nil {
+			t.Fatalf("expected error")
+		}
+	})
+	t.Run("Truncated", func(t *testing.T) {
+		body := testListpack(append([]byte{0x83}, "abc"...))
+		_, err := NewListpackStringReader(body[:8]).ReadEntries()
+		if err == nil {
+			t.Fatalf("expected error")
+		}
+	})
+	t.Run("UnknownCount", func(t *testing.T) {
+		body := []byte(testListpack([]byte{0x01}, []byte{0x02}))
+		binary.LittleEndian.PutUint16(body[4:], listpackUnknownCount)
+		entries, err := NewListpackStringReader(string(body)).ReadEntries()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(entries) != 2 {
+			t.Fatalf("expected 2 entries but actual %d", len(entries))
+		}
+	})
+}
+
+// TestDecodeListpackKeys проверяет чтение ключей в кодировках listpack
+func TestDecodeListpackKeys(t *testing.T) {
+	var rdb bytes.Buffer
+	rdb.Write(NewMagic(11).Bytes())
+	rdb.Write(NewDBSelector(0).Bytes())
+
+	rdb.WriteByte(ListpackHashMapOpcode)
+	rdb.Write(EncodeString("hash"))
+	rdb.Write(EncodeString(testListpack(
+		testListpackString("field"),
+		testListpackString("value"),
+		testListpackString("count"),
+		[]byte{0x07},
+	)))
+
+	rdb.WriteByte(ListpackSortedSetOpcode)
+	rdb.Write(EncodeString("zset"))
+	rdb.Write(EncodeString(testListpack(
+		testListpackString("a"),
+		testListpackString("1.5"),
+		testListpackString("b"),
+		[]byte{0xDF, 0xFE},
+	)))
+
+	rdb.WriteByte(QuickList2Opcode)
+	rdb.Write(EncodeString("list"))
+	rdb.Write(EncodeLength(2))
+	rdb.Write(EncodeLength(quickListNodePacked))
+	rdb.Write(EncodeString(testListpack(
+		testListpackString("a"),
+		testListpackString("b"),
+	)))
+	rdb.Write(EncodeLength(quickListNodePlain))
+	rdb.Write(EncodeString("plain"))
+
+	rdb.WriteByte(ListpackSetOpcode)
+	rdb.Write(EncodeString("set"))
+	rdb.Write(EncodeString(testListpack(
+		testListpackString("x"),
+		[]byte{0x05},
+	)))
+
+	rdb.WriteByte(EOFOpcode)
+	rdb.Write(make([]byte, 8))
+
+	consumer := &testKeyConsumer{}
+	err := NewDecoder(&rdb).DecodeKeys(consumer)
+	if err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	if len(consumer.keys) != 4 {
+		t.Fatalf("expected 4 keys but actual %d", len(consumer.keys))
+	}
+
+	hash, ok := consumer.keys[0].(data.MapKey)
+	if !ok {
+		t.Fatalf("expected MapKey but actual %#v", consumer.keys[0])
+	}
+	expectedHash := map[string]string{"field": "value", "count": "7"}
+	if !reflect.DeepEqual(hash.Values(), expectedHash) {
+		t.Fatalf("expected %v but actual %v", expectedHash, hash.Values())
+	}
+
+	zset, ok := consumer.keys[1].(data.SortedSetKey)
+	if !ok {
+		t.Fatalf("expected SortedSetKey but actual %#v", consumer.keys[1])
+	}
+	expectedZset := map[string]float64{"a": 1.5, "b": -2}
+	if !reflect.DeepEqual(zset.Values(), expectedZset) {
+		t.Fatalf("expected %v but actual %v", expectedZset, zset.Values())
+	}
+
+	list, ok := consumer.keys[2].(data.ListKey)
+	if !ok {
+		t.Fatalf("expected ListKey but actual %#v", consumer.keys[2])
+	}
+	expectedList := []string{"a", "b", "plain"}
+	if !reflect.DeepEqual(list.Values(), expectedList) {
+		t.Fatalf("expected %v but actual %v", expectedList, list.Values())
+	}
+
+	set, ok := consumer.keys[3].(data.SetKey)
+	if !ok {
+		t.Fatalf("expected SetKey but actual %#v", consumer.keys[3])
+	}
+	expectedSet := map[string]struct{}{"x": {}, "5": {}}
+	if !reflect.DeepEqual(set.Values(), expectedSet) {
+		t.Fatalf("expected %v but actual %v", expectedSet, set.Values())
+	}
+}
+
+// testListpack собирает listpack из закодированных элементов,
+// добавляя к каждому элементу его размер
+func testListpack(entries ...[]byte) string {
+	var body bytes.Buffer
+	for _, entry := range entries {
+		body.Write(entry)
+		size := len(entry)
+		switch {
+		case size <= 127:
+			body.WriteByte(byte(size))
+		case size < 16383:
+			body.WriteByte(byte(size >> 7))
+			body.WriteByte(byte(size&127) | 128)
+		default:
+			panic("unexpected entry size")
+		}
+	}
+	body.WriteByte(listpackEnd)
+
+	header := make([]byte, 6)
+	binary.LittleEndian.PutUint32(header, uint32(body.Len()+6))
+	binary.LittleEndian.PutUint16(header[4:], uint16(len(entries)))
+	return string(header) + body.String()
+}
+
+// testListpackString кодирует короткую строку как элемент listpack
+func testListpackString(s string) []byte {
+	return append([]byte{listpack6BitStr | byte(len(s))}, s...)
+}
+
+// testKeyConsumer собирает прочитанные ключи
+type testKeyConsumer struct {
+	keys []data.Key
+}
+
+func (c *testKeyConsumer) Key(key data.Key) error {
+	c.keys = append(c.keys, key)
+	return nil
+}
@@ -1,5 +1,108 @@
 package rdb
 
+const (
+	// lzfHashLog это максимальный размер таблицы последних позиций
+	// трёх байт, для коротких строк таблица меньше
+	lzfHashLog = 14
+
+	// lzfMaxLiteral это максимальное количество байт в одном литерале
+	lzfMaxLiteral = 1 << 5
+
+	// lzfMaxOffset это максимальное расстояние до повтора
+	lzfMaxOffset = 1 << 13
+
+	// lzfMaxRef это максимальная длина повтора
+	lzfMaxRef = 1<<8 + 1<<3
+)
+
+// lzfCompress сжимает input в формате LZF (liblzf), который читает
+// lzfDecompress. Если сжатые данные больше maxLength то возвращается nil.
+// Данные это литералы <длина-1><байты> длиной до 32 байт и повторы
+// <длина-2:3 бита><смещение:13 бит> (длина 7 и больше продолжается
+// следующим байтом), смещение отсчитывается от текущей позиции минус 1
+func lzfCompress(input []byte, maxLength int) []byte {
+	output := make([]byte, 0, maxLength)
+	hashLog := lzfTableLog(len(input))
+	table := make([]int, 1<<hashLog)
+	literal := 0
+	i := 0
+	for i+2 < len(input) {
+		h := lzfHash(input[i:], hashLog)
+		ref := table[h] - 1
+		table[h] = i + 1
+		if ref < 0 || i-ref > lzfMaxOffset ||
+			input[ref] != input[i] ||
+			input[ref+1] != input[i+1] ||
+			input[ref+2] != input[i+2] {
+			i++
+			continue
+		}
+
+		maxRef := len(input) - i
+		if maxRef > lzfMaxRef {
+			maxRef = lzfMaxRef
+		}
+		length := 3
+		for length < maxRef && input[ref+length] == input[i+length] {
+			length++
+		}
+
+		output = lzfAppendLiteral(output, input[literal:i])
+		offset := i - ref - 1
+		if length-2 < 7 {
+			output = append(output, byte((length-2)<<5|offset>>8))
+		} else {
+			output = append(output, byte(7<<5|offset>>8), byte(length-2-7))
+		}
+		output = append(output, byte(offset))
+		if len(output) > maxLength {
+			return nil
+		}
+
+		for j := i + 1; j < i+length && j+2 < len(input); j++ {
+			table[lzfHash(input[j:], hashLog)] = j + 1
+		}
+		i += length
+		literal = i
+	}
+	output = lzfAppendLiteral(output, input[literal:])
+	if len(output) > maxLength {
+		return nil
+	}
+	return output
+}
+
+// lzfTableLog возвращает размер таблицы для строки длиной length,
+// позиций в таблице не больше чем байт в строке
+func lzfTableLog(length int) uint {
+	hashLog := uint(1)
+	for hashLog < lzfHashLog && 1<<hashLog < length {
+		hashLog++
+	}
+	return hashLog
+}
+
+// lzfHash возвращает позицию в таблице из 1<<hashLog позиций
+// для первых трёх байт p
+func lzfHash(p []byte, hashLog uint) uint32 {
+	v := uint32(p[0])<<16 | uint32(p[1])<<8 | uint32(p[2])
+	return (v * 2654435761) >> (32 - hashLog)
+}
+
+// lzfAppendLiteral добавляет литералы по lzfMaxLiteral байт
+func lzfAppendLiteral(output, literal []byte) []byte {
+	for len(literal) > 0 {
+		n := len(literal)
+		if n > lzfMaxLiteral {
+			n = lzfMaxLiteral
+		}
+		output = append(output, byte(n-1))
+		output = append(output, literal[:n]...)
+		literal = literal[n:]
+	}
+	return output
+}
+
 // Taken from Golly: https://github.com/tav/golly/blob/master/lzf/lzf.go
 // Removed part that gets outputLength from data
 // nolint:gocyclo
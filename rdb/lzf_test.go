@@ -0,0 +1,114 @@
+package rdb
+
+import (
+	"bytes"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// TestLZFRoundTrip проверяет что сжатые lzfCompress данные
+// распаковываются lzfDecompress без изменений
+func TestLZFRoundTrip(t *testing.T) {
+	random := make([]byte, 50000)
+	rand.New(rand.NewSource(1)).Read(random)
+	// повторы на расстоянии больше максимального смещения
+	far := append(append([]byte{}, random[:10000]...), random[:10000]...)
+
+	tests := map[string][]byte{
+		"Empty":    {},
+		"Short":    []byte("ab"),
+		"Small":    []byte(strings.Repeat("abc", 7)),
+		"Repeat":   []byte(strings.Repeat("a", 100000)),
+		"Pattern":  []byte(strings.Repeat("abcdefgh", 1000)),
+		"Text":     []byte(strings.Repeat("the quick brown fox jumps over the lazy dog ", 50)),
+		"Random":   random,
+		"Far":      far,
+		"Literals": []byte(strings.Repeat("0123456789abcdefghijklmnopqrstuvwxyz", 2)),
+	}
+	for name, input := range tests {
+		input := input
+		t.Run(name, func(t *testing.T) {
+			compressed := lzfCompress(input, len(input)+len(input)/32+1)
+			if compressed == nil {
+				t.Fatalf("expected compressed data")
+			}
+			output := lzfDecompress(compressed, uint32(len(input)))
+			if !bytes.Equal(output, input) {
+				t.Fatalf("round trip mismatch: %d bytes compressed to %d",
+					len(input),
+					len(compressed),
+				)
+			}
+		})
+	}
+}
+
+// TestLZFTableLog проверяет что размер таблицы зависит от длины строки
+// и не превышает lzfHashLog
+func TestLZFTableLog(t *testing.T) {
+	tests := map[int]uint{
+		0:       1,
+		21:      5,
+		32:      5,
+		33:      6,
+		1 << 14: lzfHashLog,
+		100000:  lzfHashLog,
+	}
+	for length, expected := range tests {
+		if actual := lzfTableLog(length); actual != expected {
+			t.Fatalf("expected %d for length %d but actual %d", expected, length, actual)
+		}
+	}
+}
+
+// TestLZFCompressLimit проверяет отказ от сжатия больше maxLength
+func TestLZFCompressLimit(t *testing.T) {
+	random := make([]byte, 1000)
+	rand.New(rand.NewSource(2)).Read(random)
+	if compressed := lzfCompress(random, len(random)-4); compressed != nil {
+		t.Fatalf("expected nil but actual %d bytes", len(compressed))
+	}
+
+	input := []byte(strings.Repeat("a", 1000))
+	compressed := lzfCompress(input, len(input)-4)
+	if compressed == nil || len(compressed) > 20 {
+		t.Fatalf("expected short compressed data but actual %d bytes", len(compressed))
+	}
+}
+
+// TestEncodeCompressedString проверяет выбор между сжатой и обычной
+// строкой и чтение результата Reader
+func TestEncodeCompressedString(t *testing.T) {
+	random := make([]byte, 100)
+	rand.New(rand.NewSource(3)).Read(random)
+	tests := map[string]struct {
+		value      string
+		compressed bool
+	}{
+		"Short":   {value: strings.Repeat("a", 20)},
+		"Number":  {value: "12345"},
+		"Random":  {value: string(random)},
+		"Repeat":  {value: strings.Repeat("a", 21), compressed: true},
+		"Pattern": {value: strings.Repeat("value:", 1000), compressed: true},
+	}
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			body := EncodeCompressedString(test.value)
+			if compressed := body[0] == lenEnc<<6|encLZF; compressed != test.compressed {
+				t.Fatalf("expected compressed %v but actual %v", test.compressed, compressed)
+			}
+			if !test.compressed && !bytes.Equal(body, EncodeString(test.value)) {
+				t.Fatalf("expected %q but actual %q", EncodeString(test.value), body)
+			}
+			value, err := NewStringReader(string(body)).ReadString()
+			if err != nil {
+				t.Fatalf("read error: %v", err)
+			}
+			if value != test.value {
+				t.Fatalf("expected %q but actual %q", test.value, value)
+			}
+		})
+	}
+}
@@ -0,0 +1,379 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+
+	"github.com/avito-tech/smart-redis-replication/data"
+)
+
+const (
+	// moduleTypeNameCharSet это алфавит названий типов модулей,
+	// каждый символ кодируется 6 битами
+	moduleTypeNameCharSet = "ABCDEFGHIJKLMNOPQRSTUVWXYZ" +
+		"abcdefghijklmnopqrstuvwxyz0123456789-_"
+
+	// ModuleTypeNameLength это длина названия типа модуля
+	ModuleTypeNameLength = 9
+
+	// MaxModuleEncVersion это максимальная версия кодирования модуля
+	MaxModuleEncVersion = 1023
+
+	// moduleOpcodeEOF это конец значения модуля
+	moduleOpcodeEOF = 0
+)
+
+// ModuleDecoder читает значение ключа name сохранённое модулем
+// и возвращает ключ любого типа
+type ModuleDecoder func(r ModuleReader, name string) (data.Key, error)
+
+// moduleType это тип модуля и версия кодирования
+type moduleType struct {
+	name       string
+	encVersion uint64
+}
+
+// ModuleRegistry это набор декодеров значений модулей
+type ModuleRegistry struct {
+	mu       sync.RWMutex
+	decoders map[moduleType]ModuleDecoder
+}
+
+// DefaultModuleRegistry это набор декодеров модулей который используется
+// при чтении RDB
+var DefaultModuleRegistry = NewModuleRegistry()
+
+// NewModuleRegistry возвращает новый пустой ModuleRegistry
+func NewModuleRegistry() *ModuleRegistry {
+	return &ModuleRegistry{
+		decoders: make(map[moduleType]ModuleDecoder),
+	}
+}
+
+// RegisterModule регистрирует декодер в DefaultModuleRegistry
+func RegisterModule(name string, encVersion uint64, decoder ModuleDecoder) error {
+	return DefaultModuleRegistry.Register(name, encVersion, decoder)
+}
+
+// Register регистрирует декодер для типа модуля name (9 символов)
+// и версии кодирования encVersion, повторная регистрация заменяет декодер
+func (m *ModuleRegistry) Register(
+	name string,
+	encVersion uint64,
+	decoder ModuleDecoder,
+) error {
+	if _, err := moduleTypeID(name, encVersion); err != nil {
+		return err
+	}
+	if decoder == nil {
+		return fmt.Errorf("expected decoder for module %q", name)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.decoders[moduleType{name: name, encVersion: encVersion}] = decoder
+	return nil
+}
+
+// Decoder возвращает декодер для типа модуля и версии кодирования
+func (m *ModuleRegistry) Decoder(name string, encVersion uint64) (ModuleDecoder, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	decoder, ok := m.decoders[moduleType{name: name, encVersion: encVersion}]
+	return decoder, ok
+}
+
+// ModuleAux это дополнительные данные модуля не относящиеся к ключам
+type ModuleAux struct {
+	// Module это название типа модуля
+	Module string
+
+	// EncVersion это версия кодирования
+	EncVersion uint64
+
+	// When это момент сохранения: до или после ключей
+	When uint64
+
+	// Values это значения в исходном виде
+	Values []data.ModuleValue
+}
+
+// ModuleReader читает значения сохранённые модулем
+type ModuleReader interface {
+	ReadSigned() (int64, error)
+	ReadUnsigned() (uint64, error)
+	ReadFloat() (float32, error)
+	ReadDouble() (float64, error)
+	ReadString() (string, error)
+}
+
+// moduleReader реализует ModuleReader,
+// в RDB_TYPE_MODULE_2 каждому значению предшествует код его типа
+type moduleReader struct {
+	r      *reader
+	tagged bool
+}
+
+// readType проверяет код типа следующего значения
+func (m *moduleReader) readType(expected data.ModuleValueType) error {
+	if !m.tagged {
+		return nil
+	}
+	opcode, err := m.r.ReadLength64()
+	if err != nil {
+		return err
+	}
+	if opcode != uint64(expected) {
+		return fmt.Errorf(
+			"expected module value type %d but actual %d",
+			expected,
+			opcode,
+		)
+	}
+	return nil
+}
+
+// ReadSigned читает целое число со знаком
+func (m *moduleReader) ReadSigned() (int64, error) {
+	if err := m.readType(data.ModuleSigned); err != nil {
+		return 0, err
+	}
+	value, err := m.r.ReadLength64()
+	return int64(value), err
+}
+
+// ReadUnsigned читает целое число без знака
+func (m *moduleReader) ReadUnsigned() (uint64, error) {
+	if err := m.readType(data.ModuleUnsigned); err != nil {
+		return 0, err
+	}
+	return m.r.ReadLength64()
+}
+
+// ReadFloat читает число с плавающей точкой одинарной точности
+func (m *moduleReader) ReadFloat() (float32, error) {
+	if err := m.readType(data.ModuleFloat); err != nil {
+		return 0, err
+	}
+	body, err := m.r.SafeRead(4)
+	if err != nil {
+		return 0, err
+	}
+	return math.Float32frombits(binary.LittleEndian.Uint32(body)), nil
+}
+
+// ReadDouble читает число с плавающей точкой двойной точности
+func (m *moduleReader) ReadDouble() (float64, error) {
+	if err := m.readType(data.ModuleDouble); err != nil {
+		return 0, err
+	}
+	return m.r.ReadBinaryFloat64()
+}
+
+// ReadString читает строку
+func (m *moduleReader) ReadString() (string, error) {
+	if err := m.readType(data.ModuleString); err != nil {
+		return "", err
+	}
+	return m.r.ReadString()
+}
+
+// moduleRegistry возвращает декодеры модулей заданные WithModuleRegistry
+// или DefaultModuleRegistry
+func (r *reader) moduleRegistry() *ModuleRegistry {
+	if r.modules != nil {
+		return r.modules
+	}
+	return DefaultModuleRegistry
+}
+
+// ReadModule читает ключ модуля, opcode это ModuleOpcode или Module2Opcode.
+// Если для модуля зарегистрирован декодер то ключ читает он,
+// иначе значения в формате Module2Opcode возвращаются как data.ModuleKey
+// nolint:gocyclo
+func (r *reader) ReadModule(opcode byte, expiry data.Expiry) (data.Key, error) {
+	keyName, err := r.ReadString()
+	if err != nil {
+		return nil, err
+	}
+	return r.readModuleValue(keyName, opcode, expiry)
+}
+
+// readModuleValue читает значение ключа keyName
+func (r *reader) readModuleValue(
+	keyName string,
+	opcode byte,
+	expiry data.Expiry,
+) (data.Key, error) {
+	id, err := r.ReadLength64()
+	if err != nil {
+		return nil, err
+	}
+	name, encVersion := moduleTypeName(id)
+	tagged := opcode == Module2Opcode
+
+	var key data.Key
+	decoder, ok := r.moduleRegistry().Decoder(name, encVersion)
+	switch {
+	case ok:
+		key, err = decoder(&moduleReader{r: r, tagged: tagged}, keyName)
+		if err != nil {
+			return nil, err
+		}
+		if tagged {
+			err = r.readModuleEOF()
+		}
+	case tagged:
+		moduleKey := data.NewModule(keyName, name, encVersion)
+		err = r.DecodeModuleValues(moduleKey)
+		key = moduleKey
+	default:
+		err = fmt.Errorf(
+			"module %q version %d: decoder is not registered",
+			name,
+			encVersion,
+		)
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = key.SetExpiry(expiry)
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// DecodeModuleValues декодирует значения модуля до кода окончания
+func (r *reader) DecodeModuleValues(key data.ModuleKey) error {
+	values, err := r.readModuleValues()
+	if err != nil {
+		return err
+	}
+	for _, value := range values {
+		err = key.Add(value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadModuleAux читает дополнительные данные модуля
+func (r *reader) ReadModuleAux() (ModuleAux, error) {
+	id, err := r.ReadLength64()
+	if err != nil {
+		return ModuleAux{}, err
+	}
+	var aux ModuleAux
+	aux.Module, aux.EncVersion = moduleTypeName(id)
+	whenOpcode, err := r.ReadLength64()
+	if err != nil {
+		return ModuleAux{}, err
+	}
+	if whenOpcode != uint64(data.ModuleUnsigned) {
+		return ModuleAux{}, fmt.Errorf(
+			"module %q aux: unexpected when opcode %d",
+			aux.Module,
+			whenOpcode,
+		)
+	}
+	aux.When, err = r.ReadLength64()
+	if err != nil {
+		return ModuleAux{}, err
+	}
+	aux.Values, err = r.readModuleValues()
+	if err != nil {
+		return ModuleAux{}, err
+	}
+	return aux, nil
+}
+
+// readModuleValues читает значения с кодами типов до кода окончания
+func (r *reader) readModuleValues() ([]data.ModuleValue, error) {
+	m := &moduleReader{r: r}
+	values := []data.ModuleValue{}
+	for {
+		opcode, err := r.ReadLength64()
+		if err != nil {
+			return nil, err
+		}
+		value := data.ModuleValue{Type: data.ModuleValueType(opcode)}
+		switch value.Type {
+		case moduleOpcodeEOF:
+			return values, nil
+		case data.ModuleSigned:
+			value.Signed, err = m.ReadSigned()
+		case data.ModuleUnsigned:
+			value.Unsigned, err = m.ReadUnsigned()
+		case data.ModuleFloat:
+			var f float32
+			f, err = m.ReadFloat()
+			value.Float = float64(f)
+		case data.ModuleDouble:
+			value.Float, err = m.ReadDouble()
+		case data.ModuleString:
+			value.String, err = m.ReadString()
+		default:
+			err = fmt.Errorf("unknown module value type %d", opcode)
+		}
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+}
+
+// readModuleEOF читает код окончания значения модуля
+func (r *reader) readModuleEOF() error {
+	opcode, err := r.ReadLength64()
+	if err != nil {
+		return err
+	}
+	if opcode != moduleOpcodeEOF {
+		return fmt.Errorf("expected module EOF but actual opcode %d", opcode)
+	}
+	return nil
+}
+
+// moduleTypeName возвращает название типа модуля и версию кодирования:
+// старшие 54 бита это 9 символов по 6 бит, младшие 10 бит это версия
+func moduleTypeName(id uint64) (string, uint64) {
+	encVersion := id & MaxModuleEncVersion
+	name := make([]byte, ModuleTypeNameLength)
+	id >>= 10
+	for i := ModuleTypeNameLength - 1; i >= 0; i-- {
+		name[i] = moduleTypeNameCharSet[id&63]
+		id >>= 6
+	}
+	return string(name), encVersion
+}
+
+// moduleTypeID возвращает идентификатор типа модуля
+func moduleTypeID(name string, encVersion uint64) (uint64, error) {
+	if len(name) != ModuleTypeNameLength {
+		return 0, fmt.Errorf(
+			"module type name %q must be %d characters",
+			name,
+			ModuleTypeNameLength,
+		)
+	}
+	if encVersion > MaxModuleEncVersion {
+		return 0, fmt.Errorf("module %q: encoding version %d > %d",
+			name,
+			encVersion,
+			MaxModuleEncVersion,
+		)
+	}
+	var id uint64
+	for i := 0; i < len(name); i++ {
+		c := strings.IndexByte(moduleTypeNameCharSet, name[i])
+		if c < 0 {
+			return 0, fmt.Errorf("module type name %q: invalid character", name)
+		}
+		id = id<<6 | uint64(c)
+	}
+	return id<<10 | encVersion, nil
+}
@@ -0,0 +1,251 @@
+package rdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/avito-tech/smart-redis-replication/data"
+)
+
+// TestModuleTypeName проверяет кодирование названия типа модуля
+func TestModuleTypeName(t *testing.T) {
+	id, err := moduleTypeID("ReJSON-RL", 3)
+	if err != nil {
+		t.Fatalf("module type id error: %v", err)
+	}
+	name, encVersion := moduleTypeName(id)
+	if name != "ReJSON-RL" || encVersion != 3 {
+		t.Fatalf("expected ReJSON-RL version 3 but actual %q version %d",
+			name,
+			encVersion,
+		)
+	}
+	for _, name := range []string{"short", "too-long-name", "bad name!"} {
+		if _, err := moduleTypeID(name, 0); err == nil {
+			t.Fatalf("expected error for %q", name)
+		}
+	}
+	if _, err := moduleTypeID("ReJSON-RL", MaxModuleEncVersion+1); err == nil {
+		t.Fatalf("expected error for encoding version")
+	}
+}
+
+// TestDecodeModule проверяет чтение ключей модулей
+// nolint:gocyclo
+func TestDecodeModule(t *testing.T) {
+	registry := NewModuleRegistry()
+	err := registry.Register("testmodul", 1, func(
+		r ModuleReader,
+		name string,
+	) (data.Key, error) {
+		count, err := r.ReadUnsigned()
+		if err != nil {
+			return nil, err
+		}
+		value, err := r.ReadString()
+		if err != nil {
+			return nil, err
+		}
+		return data.NewString(name, strconv.FormatUint(count, 10)+value), nil
+	})
+	if err != nil {
+		t.Fatalf("register error: %v", err)
+	}
+
+	var rdb bytes.Buffer
+	rdb.Write(NewMagic(9).Bytes())
+
+	// дополнительные данные модуля до ключей
+	rdb.WriteByte(ModuleAuxOpcode)
+	rdb.Write(testModuleID(t, "auxmodule", 2))
+	rdb.Write(EncodeLength(uint32(data.ModuleUnsigned)))
+	rdb.Write(EncodeLength(1))
+	rdb.Write(EncodeLength(uint32(data.ModuleString)))
+	rdb.Write(EncodeString("aux"))
+	rdb.Write(EncodeLength(moduleOpcodeEOF))
+
+	rdb.Write(NewDBSelector(0).Bytes())
+
+	// модуль без декодера
+	rdb.WriteByte(Module2Opcode)
+	rdb.Write(EncodeString("raw"))
+	rdb.Write(testModuleID(t, "ReJSON-RL", 3))
+	rdb.Write(EncodeLength(uint32(data.ModuleSigned)))
+	rdb.Write(testLength64(uint64(math.MaxUint64 - 4)))
+	rdb.Write(EncodeLength(uint32(data.ModuleUnsigned)))
+	rdb.Write(EncodeLength(7))
+	rdb.Write(EncodeLength(uint32(data.ModuleFloat)))
+	floatBytes := make([]byte, 4)
+	binary.LittleEndian.PutUint32(floatBytes, math.Float32bits(1.5))
+	rdb.Write(floatBytes)
+	rdb.Write(EncodeLength(uint32(data.ModuleDouble)))
+	rdb.Write(testBinaryFloat64(2.5))
+	rdb.Write(EncodeLength(uint32(data.ModuleString)))
+	rdb.Write(EncodeString("json"))
+	rdb.Write(EncodeLength(moduleOpcodeEOF))
+
+	// модуль с зарегистрированным декодером
+	rdb.WriteByte(Module2Opcode)
+	rdb.Write(EncodeString("decoded"))
+	rdb.Write(testModuleID(t, "testmodul", 1))
+	rdb.Write(EncodeLength(uint32(data.ModuleUnsigned)))
+	rdb.Write(EncodeLength(5))
+	rdb.Write(EncodeLength(uint32(data.ModuleString)))
+	rdb.Write(EncodeString("x"))
+	rdb.Write(EncodeLength(moduleOpcodeEOF))
+
+	// модуль старого формата с зарегистрированным декодером
+	rdb.WriteByte(ModuleOpcode)
+	rdb.Write(EncodeString("old"))
+	rdb.Write(testModuleID(t, "testmodul", 1))
+	rdb.Write(EncodeLength(6))
+	rdb.Write(EncodeString("y"))
+
+	rdb.WriteByte(EOFOpcode)
+	rdb.Write(make([]byte, 8))
+
+	var tokens []interface{}
+	decoder := NewDecoder(&rdb, WithModuleRegistry(registry))
+	for {
+		token, err := decoder.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("decode error: %v", err)
+		}
+		tokens = append(tokens, token)
+	}
+	if len(tokens) != 6 {
+		t.Fatalf("expected 6 tokens but actual %#v", tokens)
+	}
+	if _, ok := DefaultModuleRegistry.Decoder("testmodul", 1); ok {
+		t.Fatalf("expected decoder only in registry")
+	}
+
+	expectedAux := ModuleAux{
+		Module:     "auxmodule",
+		EncVersion: 2,
+		When:       1,
+		Values: []data.ModuleValue{
+			{Type: data.ModuleString, String: "aux"},
+		},
+	}
+	if !reflect.DeepEqual(tokens[1], expectedAux) {
+		t.Fatalf("expected %#v but actual %#v", expectedAux, tokens[1])
+	}
+
+	raw, ok := tokens[3].(data.ModuleKey)
+	if !ok {
+		t.Fatalf("expected ModuleKey but actual %#v", tokens[3])
+	}
+	if raw.Name() != "raw" || raw.Module() != "ReJSON-RL" || raw.EncVersion() != 3 {
+		t.Fatalf("unexpected module key %#v", raw)
+	}
+	expectedValues := []data.ModuleValue{
+		{Type: data.ModuleSigned, Signed: -5},
+		{Type: data.ModuleUnsigned, Unsigned: 7},
+		{Type: data.ModuleFloat, Float: 1.5},
+		{Type: data.ModuleDouble, Float: 2.5},
+		{Type: data.ModuleString, String: "json"},
+	}
+	if !reflect.DeepEqual(raw.Values(), expectedValues) {
+		t.Fatalf("expected %#v but actual %#v", expectedValues, raw.Values())
+	}
+
+	for i, expected := range map[int]string{4: "5x", 5: "6y"} {
+		key, ok := tokens[i].(data.StringKey)
+		if !ok {
+			t.Fatalf("expected StringKey but actual %#v", tokens[i])
+		}
+		if key.Value() != expected {
+			t.Fatalf("expected %q but actual %q", expected, key.Value())
+		}
+	}
+}
+
+// TestDecodeModuleNotRegistered проверяет ошибку чтения модуля старого
+// формата без декодера
+func TestDecodeModuleNotRegistered(t *testing.T) {
+	var rdb bytes.Buffer
+	rdb.Write(NewMagic(9).Bytes())
+	rdb.Write(NewDBSelector(0).Bytes())
+	rdb.WriteByte(ModuleOpcode)
+	rdb.Write(EncodeString("old"))
+	rdb.Write(testModuleID(t, "unknownmo", 1))
+	rdb.WriteByte(EOFOpcode)
+	rdb.Write(make([]byte, 8))
+	err := NewDecoder(&rdb).DecodeKeys(&testKeyConsumer{})
+	if err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+// TestDecodeModuleAux проверяет что Decode передаёт дополнительные данные
+// модуля только потребителю реализующему ModuleAuxConsumer
+func TestDecodeModuleAux(t *testing.T) {
+	var rdb bytes.Buffer
+	rdb.Write(NewMagic(9).Bytes())
+	rdb.WriteByte(ModuleAuxOpcode)
+	rdb.Write(testModuleID(t, "auxmodule", 2))
+	rdb.Write(EncodeLength(uint32(data.ModuleUnsigned)))
+	rdb.Write(EncodeLength(1))
+	rdb.Write(EncodeLength(moduleOpcodeEOF))
+	rdb.Write(NewDBSelector(0).Bytes())
+	rdb.WriteByte(StringValueOpcode)
+	rdb.Write(EncodeString("key"))
+	rdb.Write(EncodeString("value"))
+	rdb.WriteByte(EOFOpcode)
+	rdb.Write(make([]byte, 8))
+
+	consumer := &testConsumer{}
+	err := NewDecoder(bytes.NewReader(rdb.Bytes())).Decode(consumer)
+	if err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	if len(consumer.keys) != 1 {
+		t.Fatalf("expected 1 key but actual %d", len(consumer.keys))
+	}
+
+	auxConsumer := &testModuleAuxConsumer{}
+	err = NewDecoder(bytes.NewReader(rdb.Bytes())).Decode(auxConsumer)
+	if err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	if len(auxConsumer.aux) != 1 || auxConsumer.aux[0].Module != "auxmodule" {
+		t.Fatalf("unexpected module aux %#v", auxConsumer.aux)
+	}
+}
+
+// testModuleAuxConsumer собирает дополнительные данные модулей
+type testModuleAuxConsumer struct {
+	testConsumer
+	aux []ModuleAux
+}
+
+func (c *testModuleAuxConsumer) SetModuleAux(aux ModuleAux) error {
+	c.aux = append(c.aux, aux)
+	return nil
+}
+
+// testModuleID кодирует идентификатор типа модуля
+func testModuleID(t *testing.T, name string, encVersion uint64) []byte {
+	id, err := moduleTypeID(name, encVersion)
+	if err != nil {
+		t.Fatalf("module type id error: %v", err)
+	}
+	return testLength64(id)
+}
+
+// testLength64 кодирует 64 битную длину
+func testLength64(length uint64) []byte {
+	body := make([]byte, 9)
+	body[0] = len64BitPrefix
+	binary.BigEndian.PutUint64(body[1:], length)
+	return body
+}
@@ -9,6 +9,7 @@ import (
 	"io"
 	"math"
 	"strconv"
+	"time"
 
 	"github.com/avito-tech/smart-redis-replication/data"
 )
@@ -20,6 +21,28 @@ const (
 	// ResizeDBOpcode это индикатор изменения размеров базы (rdb version 7)
 	ResizeDBOpcode = 0xFB
 
+	// SlotInfoOpcode это индикатор данных о размере слота redis cluster
+	// (rdb version 12)
+	SlotInfoOpcode = 0xF4
+
+	// FunctionOpcode это индикатор библиотеки функций (rdb version 10)
+	FunctionOpcode = 0xF5
+
+	// FunctionPreGAOpcode это индикатор функции в формате redis 7.0-rc
+	FunctionPreGAOpcode = 0xF6
+
+	// IdleOpcode это индикатор времени простоя следующего ключа
+	// (rdb version 9)
+	IdleOpcode = 0xF8
+
+	// FreqOpcode это индикатор счётчика обращений к следующему ключу
+	// (rdb version 9)
+	FreqOpcode = 0xF9
+
+	// ModuleAuxOpcode это индикатор дополнительных данных модуля
+	// (rdb version 9)
+	ModuleAuxOpcode = 0xF7
+
 	// AuxFieldOpcode это индикатор дополнительного поля (rdb version 7)
 	AuxFieldOpcode = 0xFA
 
@@ -48,9 +71,21 @@ const (
 	// SortedSetOpcode это индикатор SortedSet закодированного через List
 	SortedSetOpcode = 0x03
 
+	// SortedSet2Opcode это индикатор SortedSet закодированного через List,
+	// веса хранятся как 8 байт little endian IEEE 754 (rdb version 8)
+	SortedSet2Opcode = 0x05
+
 	// ListHashMapOpcode это индикатор HashMap закодированного через List
 	ListHashMapOpcode = 0x04
 
+	// ModuleOpcode это индикатор значения модуля без кодов типов значений,
+	// прочитать его можно только зарегистрированным декодером модуля
+	ModuleOpcode = 0x06
+
+	// Module2Opcode это индикатор значения модуля с кодами типов значений
+	// (rdb version 8)
+	Module2Opcode = 0x07
+
 	// ZipMapHashMapOpcode это индикатор HashMap (собственный формат)
 	ZipMapHashMapOpcode = 0x09
 
@@ -72,10 +107,46 @@ const (
 	// QuickListOpcode это индикатор List закодированного через QuickList
 	QuickListOpcode = 0x0e
 
+	// ListpackHashMapOpcode это индикатор HashMap закодированного через
+	// Listpack (rdb version 10)
+	ListpackHashMapOpcode = 0x10
+
+	// ListpackSortedSetOpcode это индикатор SortedSet закодированного через
+	// Listpack (rdb version 10)
+	ListpackSortedSetOpcode = 0x11
+
+	// QuickList2Opcode это индикатор List закодированного через QuickList
+	// из Listpack (rdb version 10)
+	QuickList2Opcode = 0x12
+
+	// ListpackSetOpcode это индикатор Set закодированного через Listpack
+	// (rdb version 11)
+	ListpackSetOpcode = 0x14
+
+	// StreamListpacksOpcode это индикатор Stream (rdb version 9)
+	StreamListpacksOpcode = 0x0f
+
+	// StreamListpacks2Opcode это индикатор Stream с дополнительными
+	// метаданными потока и групп (rdb version 10)
+	StreamListpacks2Opcode = 0x13
+
+	// StreamListpacks3Opcode это индикатор Stream со временем активности
+	// получателей (rdb version 11)
+	StreamListpacks3Opcode = 0x15
+
+	// quickListNodePlain это узел QuickList с одним большим элементом
+	quickListNodePlain = 1
+
+	// quickListNodePacked это узел QuickList с элементами в Listpack
+	quickListNodePacked = 2
+
 	len6Bit  = 0x0
 	len14Bit = 0x1
 	len32Bit = 0x2
 	lenEnc   = 0x3
+
+	// len64BitPrefix это префикс 64 битной длины
+	len64BitPrefix = 0x81
 )
 
 var (
@@ -91,32 +162,40 @@ var (
 
 // reader реализует интерфейс Reader
 type reader struct {
-	*bufio.Reader
+	*checksumReader
+
+	// modules это декодеры модулей, если не задан то используется
+	// DefaultModuleRegistry
+	modules *ModuleRegistry
 }
 
 // NewReader возвращает новый Reader
 func NewReader(r io.Reader) Reader {
-	return &reader{
-		Reader: bufio.NewReaderSize(r, DefaultReaderSize),
-	}
+	return newReader(r)
 }
 
 // NewStringReader возвращает новый Reader
 func NewStringReader(st string) Reader {
-	r := bytes.NewBufferString(st)
-	return &reader{Reader: bufio.NewReaderSize(r, DefaultReaderSize)}
+	return newReader(bytes.NewBufferString(st))
+}
+
+// newReader возвращает новый reader
+func newReader(r io.Reader) *reader {
+	return &reader{
+		checksumReader: newChecksumReader(bufio.NewReaderSize(r, DefaultReaderSize)),
+	}
 }
 
 // SafeRead безопасно читает N байт
 func (r *reader) SafeRead(n uint32) ([]byte, error) {
 	result := make([]byte, n)
-	_, err := io.ReadFull(r.Reader, result)
+	_, err := io.ReadFull(r.checksumReader, result)
 	return result, err
 }
 
 // ReadOpcode читает код команды
 func (r *reader) ReadOpcode() (byte, error) {
-	return r.Reader.ReadByte()
+	return r.checksumReader.ReadByte()
 }
 
 // ReadString читает строку RDB файла, поддерживается только несжатая версия
@@ -142,16 +221,16 @@ func (r *reader) ReadString() (string, error) {
 		if err != nil {
 			return "", err
 		}
-		var num uint32
+		var num int32
 
 		if encoding == 0 {
-			num = uint32(data[0])
+			num = int32(int8(data[0]))
 		} else if encoding == 1 {
-			num = uint32(data[0]) | (uint32(data[1]) << 8)
+			num = int32(int16(binary.LittleEndian.Uint16(data)))
 		} else if encoding == 2 {
-			num = uint32(data[0]) | (uint32(data[1]) << 8) | (uint32(data[2]) << 16) | (uint32(data[3]) << 24) //nolint:lll
+			num = int32(binary.LittleEndian.Uint32(data))
 		}
-		return fmt.Sprintf("%d", num), nil
+		return strconv.FormatInt(int64(num), 10), nil
 
 	// compressed string
 	case 3:
@@ -200,6 +279,17 @@ func (r *reader) ReadLength() (length uint32, encoding int8, err error) {
 		length = (uint32(prefix&0x3F) << 8) | uint32(data)
 		return length, -1, nil
 	case len32Bit:
+		if prefix == len64BitPrefix {
+			data, err := r.SafeRead(8)
+			if err != nil {
+				return 0, 0, err
+			}
+			length64 := binary.BigEndian.Uint64(data)
+			if length64 > math.MaxUint32 {
+				return 0, 0, fmt.Errorf("length %d overflows uint32", length64)
+			}
+			return uint32(length64), -1, nil
+		}
 		data, err := r.SafeRead(4)
 		if err != nil {
 			return 0, 0, err
@@ -213,6 +303,39 @@ func (r *reader) ReadLength() (length uint32, encoding int8, err error) {
 	return 0, 0, fmt.Errorf("Undefined length")
 }
 
+// ReadLength64 читает префикс длины в том числе 64 битной,
+// используется для чисел которые не помещаются в uint32
+func (r *reader) ReadLength64() (uint64, error) {
+	prefix, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	switch prefix >> 6 {
+	case len6Bit:
+		return uint64(prefix & 0x3F), nil
+	case len14Bit:
+		data, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		return uint64(prefix&0x3F)<<8 | uint64(data), nil
+	case len32Bit:
+		if prefix == len64BitPrefix {
+			data, err := r.SafeRead(8)
+			if err != nil {
+				return 0, err
+			}
+			return binary.BigEndian.Uint64(data), nil
+		}
+		data, err := r.SafeRead(4)
+		if err != nil {
+			return 0, err
+		}
+		return uint64(binary.BigEndian.Uint32(data)), nil
+	}
+	return 0, fmt.Errorf("unexpected length prefix %#v", prefix)
+}
+
 // ReadUint8 читает один байт
 func (r *reader) ReadUint8() (uint8, error) {
 	d, err := r.ReadByte()
@@ -249,6 +372,16 @@ func (r *reader) ReadFloat64() (float64, error) {
 	}
 }
 
+// ReadBinaryFloat64 читает значение с двойной точностью записанное как
+// 8 байт little endian IEEE 754
+func (r *reader) ReadBinaryFloat64() (float64, error) {
+	floatBytes, err := r.SafeRead(8)
+	if err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(floatBytes)), nil
+}
+
 // Magic это сигнатура RDB файла
 type Magic struct {
 	rdbVersion uint32
@@ -288,8 +421,12 @@ func (r *reader) ReadMagic() (Magic, error) {
 	if err != nil {
 		return Magic{}, fmt.Errorf("error rdb version: %q", err)
 	}
+	rdbVersion, err := strconv.ParseUint(string(version), 10, 32)
+	if err != nil {
+		return Magic{}, fmt.Errorf("error rdb version %q: %q", version, err)
+	}
 	return Magic{
-		rdbVersion: binary.LittleEndian.Uint32(version),
+		rdbVersion: uint32(rdbVersion),
 	}, nil
 }
 
@@ -443,6 +580,64 @@ func (r *reader) ReadResizeDB() (ResizeDB, error) {
 	}, nil
 }
 
+// SlotInfo это данные о размере слота redis cluster (rdb version 12)
+type SlotInfo struct {
+	slot        uint32
+	size        uint32
+	expiresSize uint32
+}
+
+// NewSlotInfo возвращает новый SlotInfo
+func NewSlotInfo(slot, size, expiresSize uint32) SlotInfo {
+	return SlotInfo{
+		slot:        slot,
+		size:        size,
+		expiresSize: expiresSize,
+	}
+}
+
+// GetSlot возвращает номер слота
+func (s SlotInfo) GetSlot() uint32 {
+	return s.slot
+}
+
+// GetSize возвращает количество ключей слота
+func (s SlotInfo) GetSize() uint32 {
+	return s.size
+}
+
+// GetExpiresSize возвращает количество ключей слота со временем жизни
+func (s SlotInfo) GetExpiresSize() uint32 {
+	return s.expiresSize
+}
+
+// Bytes возвращает бинарное представление
+func (s SlotInfo) Bytes() []byte {
+	buffer := bytes.NewBuffer(nil)
+	buffer.WriteByte(SlotInfoOpcode)
+	buffer.Write(EncodeLength(s.slot))
+	buffer.Write(EncodeLength(s.size))
+	buffer.Write(EncodeLength(s.expiresSize))
+	return buffer.Bytes()
+}
+
+// ReadSlotInfo читает данные о размере слота
+func (r *reader) ReadSlotInfo() (SlotInfo, error) {
+	slot, _, err := r.ReadLength()
+	if err != nil {
+		return SlotInfo{}, err
+	}
+	size, _, err := r.ReadLength()
+	if err != nil {
+		return SlotInfo{}, err
+	}
+	expiresSize, _, err := r.ReadLength()
+	if err != nil {
+		return SlotInfo{}, err
+	}
+	return NewSlotInfo(slot, size, expiresSize), nil
+}
+
 // EOF означает конец файла, доступен с RDB версии 5
 type EOF struct {
 	checksum uint64
@@ -461,6 +656,12 @@ func (e EOF) Bytes() []byte {
 	return data
 }
 
+// GetChecksum возвращает контрольную сумму,
+// 0 означает что при сохранении она не вычислялась
+func (e EOF) GetChecksum() uint64 {
+	return e.checksum
+}
+
 // ReadEOF читает EOF
 // EOF доступен с RDB версии 5
 func (r *reader) ReadEOF() (EOF, error) {
@@ -481,22 +682,36 @@ func (r *reader) ReadExpiry(opcode byte) (data.Expiry, error) {
 	case ExpirySecondsOpcode:
 		body, err := r.SafeRead(4)
 		if err != nil {
-			return data.NewExpiry(0), err
+			return data.NoExpiry(), err
 		}
 		expiry = uint64(binary.LittleEndian.Uint32(body)) * 1000
 	case ExpiryMillisecondsOpcode:
 		body, err := r.SafeRead(8)
 		if err != nil {
-			return data.NewExpiry(0), err
+			return data.NoExpiry(), err
 		}
 		expiry = binary.LittleEndian.Uint64(body)
 	default:
-		return data.NewExpiry(0), fmt.Errorf("unexpected expiry opcode %#v", opcode)
+		return data.NoExpiry(), fmt.Errorf("unexpected expiry opcode %#v", opcode)
 	}
 
 	return data.NewExpiry(expiry), nil
 }
 
+// ReadIdle читает время простоя ключа (политика вытеснения LRU)
+func (r *reader) ReadIdle() (time.Duration, error) {
+	seconds, err := r.ReadLength64()
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// ReadFreq читает счётчик обращений к ключу (политика вытеснения LFU)
+func (r *reader) ReadFreq() (uint8, error) {
+	return r.ReadUint8()
+}
+
 // ReadSet читает неупорядоченный набор значений
 // nolint:dupl
 func (r *reader) ReadSet(expiry data.Expiry) (data.SetKey, error) {
@@ -504,9 +719,16 @@ func (r *reader) ReadSet(expiry data.Expiry) (data.SetKey, error) {
 	if err != nil {
 		return nil, err
 	}
+	return r.readSetValue(keyName, expiry)
+}
 
+// readSetValue читает значение ключа keyName
+func (r *reader) readSetValue(
+	keyName string,
+	expiry data.Expiry,
+) (data.SetKey, error) {
 	key := data.NewSet(keyName)
-	err = key.SetExpiry(expiry)
+	err := key.SetExpiry(expiry)
 	if err != nil {
 		return nil, err
 	}
@@ -538,6 +760,53 @@ func (r *reader) DecodeSetList(key data.SetKey) error {
 	return nil
 }
 
+// ReadListpackSet читает Set закодированный с помощью Listpack
+// nolint:dupl
+func (r *reader) ReadListpackSet(expiry data.Expiry) (data.SetKey, error) {
+	keyName, err := r.ReadString()
+	if err != nil {
+		return nil, err
+	}
+	return r.readListpackSetValue(keyName, expiry)
+}
+
+// readListpackSetValue читает значение ключа keyName
+func (r *reader) readListpackSetValue(
+	keyName string,
+	expiry data.Expiry,
+) (data.SetKey, error) {
+	body, err := r.ReadString()
+	if err != nil {
+		return nil, err
+	}
+
+	key := data.NewSet(keyName)
+	err = key.SetExpiry(expiry)
+	if err != nil {
+		return nil, err
+	}
+	err = r.DecodeListpackSet(key, body)
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// DecodeListpackSet декодирует значения Set закодированные через Listpack
+func (r *reader) DecodeListpackSet(key data.SetKey, body string) error {
+	entries, err := NewListpackStringReader(body).ReadEntries()
+	if err != nil {
+		return err
+	}
+	for _, value := range entries {
+		err = key.Set(string(value))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // ReadZipListSortedSet читает SortedSet закодированный с помощью ZipList
 // nolint:dupl
 func (r *reader) ReadZipListSortedSet(
@@ -550,7 +819,17 @@ func (r *reader) ReadZipListSortedSet(
 	if err != nil {
 		return nil, err
 	}
+	return r.readZipListSortedSetValue(keyName, expiry)
+}
 
+// readZipListSortedSetValue читает значение ключа keyName
+func (r *reader) readZipListSortedSetValue(
+	keyName string,
+	expiry data.Expiry,
+) (
+	data.SortedSetKey,
+	error,
+) {
 	body, err := r.ReadString()
 	if err != nil {
 		return nil, err
@@ -628,9 +907,16 @@ func (r *reader) ReadSortedSet(expiry data.Expiry) (data.SortedSetKey, error) {
 	if err != nil {
 		return nil, err
 	}
+	return r.readSortedSetValue(keyName, expiry)
+}
 
+// readSortedSetValue читает значение ключа keyName
+func (r *reader) readSortedSetValue(
+	keyName string,
+	expiry data.Expiry,
+) (data.SortedSetKey, error) {
 	key := data.NewSortedSet(keyName)
-	err = key.SetExpiry(expiry)
+	err := key.SetExpiry(expiry)
 	if err != nil {
 		return nil, err
 	}
@@ -668,6 +954,131 @@ func (r *reader) DecodeSortedSetList(key data.SortedSetKey) error {
 	return nil
 }
 
+// ReadSortedSet2 читает SortedSet с весами в бинарном виде
+// nolint:dupl
+func (r *reader) ReadSortedSet2(expiry data.Expiry) (data.SortedSetKey, error) {
+	keyName, err := r.ReadString()
+	if err != nil {
+		return nil, err
+	}
+	return r.readSortedSet2Value(keyName, expiry)
+}
+
+// readSortedSet2Value читает значение ключа keyName
+func (r *reader) readSortedSet2Value(
+	keyName string,
+	expiry data.Expiry,
+) (data.SortedSetKey, error) {
+	key := data.NewSortedSet(keyName)
+	err := key.SetExpiry(expiry)
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.DecodeSortedSet2List(key)
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// DecodeSortedSet2List декодирует значения SortedSet закодированные через
+// List с весами в бинарном виде
+// nolint:dupl
+func (r *reader) DecodeSortedSet2List(key data.SortedSetKey) error {
+	count, _, err := r.ReadLength()
+	if err != nil {
+		return err
+	}
+	for count > 0 {
+		count--
+
+		value, err := r.ReadString()
+		if err != nil {
+			return err
+		}
+		score, err := r.ReadBinaryFloat64()
+		if err != nil {
+			return err
+		}
+		err = key.Set(score, value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadListpackSortedSet читает SortedSet закодированный с помощью Listpack
+// nolint:dupl
+func (r *reader) ReadListpackSortedSet(
+	expiry data.Expiry,
+) (
+	data.SortedSetKey,
+	error,
+) {
+	keyName, err := r.ReadString()
+	if err != nil {
+		return nil, err
+	}
+	return r.readListpackSortedSetValue(keyName, expiry)
+}
+
+// readListpackSortedSetValue читает значение ключа keyName
+func (r *reader) readListpackSortedSetValue(
+	keyName string,
+	expiry data.Expiry,
+) (
+	data.SortedSetKey,
+	error,
+) {
+	body, err := r.ReadString()
+	if err != nil {
+		return nil, err
+	}
+
+	key := data.NewSortedSet(keyName)
+	err = key.SetExpiry(expiry)
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.DecodeListpackSortedSet(key, body)
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// DecodeListpackSortedSet декодирует значения SortedSet закодированные через
+// Listpack, элементы идут парами: значение, вес
+func (r *reader) DecodeListpackSortedSet(
+	key data.SortedSetKey,
+	body string,
+) error {
+	entries, err := NewListpackStringReader(body).ReadEntries()
+	if err != nil {
+		return err
+	}
+	if len(entries)%2 == 1 {
+		return fmt.Errorf(
+			`expected "even" entries count bud actual "odd", count %d`,
+			len(entries),
+		)
+	}
+	for i := 0; i < len(entries); i += 2 {
+		score, err := strconv.ParseFloat(string(entries[i+1]), 64)
+		if err != nil {
+			return err
+		}
+		err = key.Set(score, string(entries[i]))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // ZipMapReader это Reader для чтения ZipMap формата
 type zipMapReader struct {
 	*bufio.Reader
@@ -764,7 +1175,14 @@ func (r *reader) ReadZipListHashMap(expiry data.Expiry) (data.MapKey, error) {
 	if err != nil {
 		return nil, err
 	}
+	return r.readZipListHashMapValue(keyName, expiry)
+}
 
+// readZipListHashMapValue читает значение ключа keyName
+func (r *reader) readZipListHashMapValue(
+	keyName string,
+	expiry data.Expiry,
+) (data.MapKey, error) {
 	body, err := r.ReadString()
 	if err != nil {
 		return nil, err
@@ -835,9 +1253,16 @@ func (r *reader) ReadListHashMap(expiry data.Expiry) (data.MapKey, error) {
 	if err != nil {
 		return nil, err
 	}
+	return r.readListHashMapValue(keyName, expiry)
+}
 
+// readListHashMapValue читает значение ключа keyName
+func (r *reader) readListHashMapValue(
+	keyName string,
+	expiry data.Expiry,
+) (data.MapKey, error) {
 	key := data.NewMap(keyName)
-	err = key.SetExpiry(expiry)
+	err := key.SetExpiry(expiry)
 	if err != nil {
 		return nil, err
 	}
@@ -874,6 +1299,60 @@ func (r *reader) DecodeHashMapList(key data.MapKey) error {
 	return nil
 }
 
+// ReadListpackHashMap читает HashMap закодированный с помощью Listpack
+// nolint:dupl
+func (r *reader) ReadListpackHashMap(expiry data.Expiry) (data.MapKey, error) {
+	keyName, err := r.ReadString()
+	if err != nil {
+		return nil, err
+	}
+	return r.readListpackHashMapValue(keyName, expiry)
+}
+
+// readListpackHashMapValue читает значение ключа keyName
+func (r *reader) readListpackHashMapValue(
+	keyName string,
+	expiry data.Expiry,
+) (data.MapKey, error) {
+	body, err := r.ReadString()
+	if err != nil {
+		return nil, err
+	}
+
+	key := data.NewMap(keyName)
+	err = key.SetExpiry(expiry)
+	if err != nil {
+		return nil, err
+	}
+	err = r.DecodeListpackHashMap(key, body)
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// DecodeListpackHashMap декодирует Listpack реализацию HashMap,
+// элементы идут парами: поле, значение
+func (r *reader) DecodeListpackHashMap(key data.MapKey, body string) error {
+	entries, err := NewListpackStringReader(body).ReadEntries()
+	if err != nil {
+		return err
+	}
+	if len(entries)%2 == 1 {
+		return fmt.Errorf(
+			`expected "even" entries count bud actual "odd", count %d`,
+			len(entries),
+		)
+	}
+	for i := 0; i < len(entries); i += 2 {
+		err = key.Set(string(entries[i]), string(entries[i+1]))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // ReadZipMapHashMap читает HashMap закодированный в строку
 // nolint:dupl
 func (r *reader) ReadZipMapHashMap(expiry data.Expiry) (data.MapKey, error) {
@@ -881,6 +1360,14 @@ func (r *reader) ReadZipMapHashMap(expiry data.Expiry) (data.MapKey, error) {
 	if err != nil {
 		return nil, err
 	}
+	return r.readZipMapHashMapValue(keyName, expiry)
+}
+
+// readZipMapHashMapValue читает значение ключа keyName
+func (r *reader) readZipMapHashMapValue(
+	keyName string,
+	expiry data.Expiry,
+) (data.MapKey, error) {
 	body, err := r.ReadString()
 	if err != nil {
 		return nil, err
@@ -960,7 +1447,14 @@ func (r *reader) ReadZipList(expiry data.Expiry) (data.ListKey, error) {
 	if err != nil {
 		return nil, err
 	}
+	return r.readZipListValue(keyName, expiry)
+}
 
+// readZipListValue читает значение ключа keyName
+func (r *reader) readZipListValue(
+	keyName string,
+	expiry data.Expiry,
+) (data.ListKey, error) {
 	body, err := r.ReadString()
 	if err != nil {
 		return nil, err
@@ -1020,8 +1514,16 @@ func (r *reader) ReadQuickList(expiry data.Expiry) (data.ListKey, error) {
 	if err != nil {
 		return nil, err
 	}
+	return r.readQuickListValue(keyName, expiry)
+}
+
+// readQuickListValue читает значение ключа keyName
+func (r *reader) readQuickListValue(
+	keyName string,
+	expiry data.Expiry,
+) (data.ListKey, error) {
 	key := data.NewList(keyName)
-	err = key.SetExpiry(expiry)
+	err := key.SetExpiry(expiry)
 	if err != nil {
 		return nil, err
 	}
@@ -1043,11 +1545,97 @@ func (r *reader) DecodeQuickList(key data.ListKey) error {
 
 	for count > 0 {
 		count--
-		body, err := r.ReadString()
+		err = r.readQuickListNode(key)
 		if err != nil {
 			return err
 		}
-		err = r.DecodeZipList(key, body)
+	}
+	return nil
+}
+
+// readQuickListNode читает один узел QuickList
+func (r *reader) readQuickListNode(key data.ListKey) error {
+	body, err := r.ReadString()
+	if err != nil {
+		return err
+	}
+	return r.DecodeZipList(key, body)
+}
+
+// ReadQuickList2 читает List закодированный как несколько Listpack,
+// объединяя их в один List
+// nolint:dupl
+func (r *reader) ReadQuickList2(expiry data.Expiry) (data.ListKey, error) {
+	keyName, err := r.ReadString()
+	if err != nil {
+		return nil, err
+	}
+	return r.readQuickList2Value(keyName, expiry)
+}
+
+// readQuickList2Value читает значение ключа keyName
+func (r *reader) readQuickList2Value(
+	keyName string,
+	expiry data.Expiry,
+) (data.ListKey, error) {
+	key := data.NewList(keyName)
+	err := key.SetExpiry(expiry)
+	if err != nil {
+		return nil, err
+	}
+	err = r.DecodeQuickList2(key)
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// DecodeQuickList2 декодирует QuickList версии 2,
+// каждый узел это тип контейнера и строка: Listpack с элементами или
+// один большой элемент без упаковки
+func (r *reader) DecodeQuickList2(key data.ListKey) error {
+	count, _, err := r.ReadLength()
+	if err != nil {
+		return err
+	}
+
+	for count > 0 {
+		count--
+		err = r.readQuickList2Node(key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readQuickList2Node читает один узел QuickList версии 2
+func (r *reader) readQuickList2Node(key data.ListKey) error {
+	container, _, err := r.ReadLength()
+	if err != nil {
+		return err
+	}
+	body, err := r.ReadString()
+	if err != nil {
+		return err
+	}
+	switch container {
+	case quickListNodePlain:
+		return key.Rpush(body)
+	case quickListNodePacked:
+		return r.DecodeListpackList(key, body)
+	}
+	return fmt.Errorf("unexpected quicklist container %d", container)
+}
+
+// DecodeListpackList декодирует элементы List закодированные через Listpack
+func (r *reader) DecodeListpackList(key data.ListKey, body string) error {
+	entries, err := NewListpackStringReader(body).ReadEntries()
+	if err != nil {
+		return err
+	}
+	for _, value := range entries {
+		err = key.Rpush(string(value))
 		if err != nil {
 			return err
 		}
@@ -1062,6 +1650,14 @@ func (r *reader) ReadList(expiry data.Expiry) (data.ListKey, error) {
 	if err != nil {
 		return nil, err
 	}
+	return r.readListValue(keyName, expiry)
+}
+
+// readListValue читает значение ключа keyName
+func (r *reader) readListValue(
+	keyName string,
+	expiry data.Expiry,
+) (data.ListKey, error) {
 	count, _, err := r.ReadLength()
 	if err != nil {
 		return nil, err
@@ -1093,7 +1689,14 @@ func (r *reader) ReadIntSet(expiry data.Expiry) (data.IntegerSetKey, error) {
 	if err != nil {
 		return nil, err
 	}
+	return r.readIntSetValue(keyName, expiry)
+}
 
+// readIntSetValue читает значение ключа keyName
+func (r *reader) readIntSetValue(
+	keyName string,
+	expiry data.Expiry,
+) (data.IntegerSetKey, error) {
 	body, err := r.ReadString()
 	if err != nil {
 		return nil, err
@@ -1113,7 +1716,7 @@ func (r *reader) ReadIntSet(expiry data.Expiry) (data.IntegerSetKey, error) {
 
 // Decode декодирует IntSet из строки
 //   Структура строки: <encoding><length-of-contents><contents>
-//     encoding - тип чисел, 2, 4, 8 байтовые (uint8, uint32, uint64)
+//     encoding - тип чисел, 2, 4, 8 байтовые (int16, int32, int64)
 //     length-of-contents - количество элементов
 //     contents - перечень элементов кратные encoding
 // nolint:gocyclo
@@ -1135,7 +1738,7 @@ func (r *reader) DecodeIntegerSet(key data.IntegerSetKey, body string) error {
 			if err != nil {
 				return err
 			}
-			err = key.Set(uint64(value))
+			err = key.Set(int64(value))
 			if err != nil {
 				return err
 			}
@@ -1147,7 +1750,7 @@ func (r *reader) DecodeIntegerSet(key data.IntegerSetKey, body string) error {
 			if err != nil {
 				return err
 			}
-			err = key.Set(uint64(value))
+			err = key.Set(int64(value))
 			if err != nil {
 				return err
 			}
@@ -1177,7 +1780,14 @@ func (r *reader) ReadStringValue(expiry data.Expiry) (data.StringKey, error) {
 	if err != nil {
 		return nil, err
 	}
+	return r.readStringValue(keyName, expiry)
+}
 
+// readStringValue читает значение ключа keyName
+func (r *reader) readStringValue(
+	keyName string,
+	expiry data.Expiry,
+) (data.StringKey, error) {
 	value, err := r.ReadString()
 	if err != nil {
 		return nil, err
@@ -0,0 +1,323 @@
+package rdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/avito-tech/smart-redis-replication/data"
+)
+
+// TestReadMagic проверяет разбор версии из заголовка RDB: версия
+// записана десятичными цифрами, а не числом little endian
+func TestReadMagic(t *testing.T) {
+	tests := map[string]uint32{
+		"REDIS0003": 3,
+		"REDIS0009": 9,
+		"REDIS0011": 11,
+		"REDIS0012": 12,
+	}
+	for header, expected := range tests {
+		magic, err := NewStringReader(header).ReadMagic()
+		if err != nil {
+			t.Fatalf("read magic %q error: %v", header, err)
+		}
+		if magic.GetRDBVersion() != expected {
+			t.Fatalf("%q: expected version %d but actual %d", header, expected, magic.GetRDBVersion())
+		}
+	}
+	for _, header := range []string{"REDIS00x1", "RADIS0011", "REDIS"} {
+		if _, err := NewStringReader(header).ReadMagic(); err == nil {
+			t.Fatalf("%q: expected error", header)
+		}
+	}
+}
+
+// TestFunctionBytes проверяет что библиотека записывается в формате
+// в котором была прочитана
+func TestFunctionBytes(t *testing.T) {
+	tests := map[string][]byte{
+		"GA": NewFunction("#!lua name=lib\nreturn 1").Bytes(),
+	}
+	for name, description := range map[string]string{
+		"PreGA":              "description",
+		"PreGANoDescription": "",
+	} {
+		var body bytes.Buffer
+		body.WriteByte(FunctionPreGAOpcode)
+		body.Write(EncodeString("old"))
+		body.Write(EncodeString("LUA"))
+		if description != "" {
+			body.Write(EncodeLength(1))
+			body.Write(EncodeString(description))
+		} else {
+			body.Write(EncodeLength(0))
+		}
+		body.Write(EncodeString("return 1"))
+		tests[name] = body.Bytes()
+	}
+	for name, body := range tests {
+		r := NewStringReader(string(body))
+		opcode, err := r.ReadOpcode()
+		if err != nil {
+			t.Fatalf("%s: read opcode error: %v", name, err)
+		}
+		function, err := r.ReadFunction(opcode)
+		if err != nil {
+			t.Fatalf("%s: read function error: %v", name, err)
+		}
+		if function.IsPreGA() != (opcode == FunctionPreGAOpcode) {
+			t.Fatalf("%s: unexpected pre-GA flag", name)
+		}
+		if !bytes.Equal(function.Bytes(), body) {
+			t.Fatalf("%s: expected %q but actual %q", name, body, function.Bytes())
+		}
+	}
+}
+
+// TestDecodeSortedSet2 проверяет чтение SortedSet с весами в бинарном виде
+func TestDecodeSortedSet2(t *testing.T) {
+	var rdb bytes.Buffer
+	rdb.Write(NewMagic(9).Bytes())
+	rdb.Write(NewDBSelector(0).Bytes())
+	rdb.WriteByte(SortedSet2Opcode)
+	rdb.Write(EncodeString("zset"))
+	rdb.Write(EncodeLength(3))
+	rdb.Write(EncodeString("a"))
+	rdb.Write(testBinaryFloat64(1.5))
+	rdb.Write(EncodeString("b"))
+	rdb.Write(testBinaryFloat64(-1e300))
+	rdb.Write(EncodeString("c"))
+	rdb.Write(testBinaryFloat64(math.Inf(1)))
+	rdb.WriteByte(EOFOpcode)
+	rdb.Write(make([]byte, 8))
+
+	consumer := &testKeyConsumer{}
+	err := NewDecoder(&rdb).DecodeKeys(consumer)
+	if err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	if len(consumer.keys) != 1 {
+		t.Fatalf("expected 1 key but actual %d", len(consumer.keys))
+	}
+	zset, ok := consumer.keys[0].(data.SortedSetKey)
+	if !ok {
+		t.Fatalf("expected SortedSetKey but actual %#v", consumer.keys[0])
+	}
+	expected := map[string]float64{"a": 1.5, "b": -1e300, "c": math.Inf(1)}
+	if !reflect.DeepEqual(zset.Values(), expected) {
+		t.Fatalf("expected %v but actual %v", expected, zset.Values())
+	}
+}
+
+// testBinaryFloat64 кодирует float64 как 8 байт little endian
+func testBinaryFloat64(f float64) []byte {
+	body := make([]byte, 8)
+	binary.LittleEndian.PutUint64(body, math.Float64bits(f))
+	return body
+}
+
+// TestDecodeMetadata проверяет чтение сведений для политики вытеснения,
+// размера слота и библиотек функций
+// nolint:gocyclo
+func TestDecodeMetadata(t *testing.T) {
+	code := "#!lua name=mylib\nredis.register_function('f', function() end)"
+	var rdb bytes.Buffer
+	rdb.Write(NewMagic(12).Bytes())
+	rdb.Write(NewFunction(code).Bytes())
+	rdb.WriteByte(FunctionPreGAOpcode)
+	rdb.Write(EncodeString("old"))
+	rdb.Write(EncodeString("LUA"))
+	rdb.Write(EncodeLength(1))
+	rdb.Write(EncodeString("description"))
+	rdb.Write(EncodeString("return 1"))
+	rdb.Write(NewDBSelector(0).Bytes())
+	rdb.Write(NewSlotInfo(12182, 2, 1).Bytes())
+
+	// ключ со временем жизни и временем простоя
+	rdb.WriteByte(ExpiryMillisecondsOpcode)
+	rdb.Write(make([]byte, 8))
+	rdb.WriteByte(IdleOpcode)
+	rdb.Write(EncodeLength(300))
+	rdb.WriteByte(StringValueOpcode)
+	rdb.Write(EncodeString("idle"))
+	rdb.Write(EncodeString("v"))
+
+	// ключ со счётчиком обращений
+	rdb.WriteByte(FreqOpcode)
+	rdb.WriteByte(5)
+	rdb.WriteByte(StringValueOpcode)
+	rdb.Write(EncodeString("freq"))
+	rdb.Write(EncodeString("v"))
+
+	rdb.WriteByte(EOFOpcode)
+	rdb.Write(make([]byte, 8))
+
+	consumer := &testFunctionConsumer{}
+	err := NewDecoder(bytes.NewReader(rdb.Bytes())).DecodeKeys(consumer)
+	if err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	if len(consumer.functions) != 2 {
+		t.Fatalf("expected 2 functions but actual %#v", consumer.functions)
+	}
+	function := consumer.functions[0]
+	if function.GetName() != "mylib" || function.GetEngine() != "lua" ||
+		function.GetCode() != code {
+		t.Fatalf("unexpected function %#v", function)
+	}
+	function = consumer.functions[1]
+	if function.GetName() != "old" || function.GetEngine() != "LUA" ||
+		function.GetDescription() != "description" ||
+		function.GetCode() != "return 1" {
+		t.Fatalf("unexpected pre-GA function %#v", function)
+	}
+
+	if len(consumer.keys) != 2 {
+		t.Fatalf("expected 2 keys but actual %d", len(consumer.keys))
+	}
+	expected := data.Metadata{
+		Encoding: string(StringKeyEncoding),
+		Idle:     300 * time.Second,
+		HasIdle:  true,
+	}
+	if consumer.keys[0].Metadata() != expected {
+		t.Fatalf("expected %#v but actual %#v", expected, consumer.keys[0].Metadata())
+	}
+	expected = data.Metadata{
+		Encoding: string(StringKeyEncoding),
+		Freq:     5,
+		HasFreq:  true,
+	}
+	if consumer.keys[1].Metadata() != expected {
+		t.Fatalf("expected %#v but actual %#v", expected, consumer.keys[1].Metadata())
+	}
+
+	// Decode пропускает данные слота и функции если потребитель
+	// не реализует SlotInfoConsumer и FunctionConsumer
+	base := &testConsumer{}
+	err = NewDecoder(bytes.NewReader(rdb.Bytes())).Decode(base)
+	if err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	if len(base.keys) != 2 {
+		t.Fatalf("expected 2 keys but actual %d", len(base.keys))
+	}
+
+	decoder := NewDecoder(bytes.NewReader(rdb.Bytes()))
+	for {
+		token, err := decoder.Next()
+		if err != nil {
+			t.Fatalf("next token error: %v", err)
+		}
+		if slotInfo, ok := token.(SlotInfo); ok {
+			if slotInfo != NewSlotInfo(12182, 2, 1) {
+				t.Fatalf("unexpected slot info %#v", slotInfo)
+			}
+			break
+		}
+	}
+}
+
+// testFunctionConsumer собирает прочитанные ключи и библиотеки функций
+type testFunctionConsumer struct {
+	testKeyConsumer
+	functions []Function
+}
+
+func (c *testFunctionConsumer) SetFunction(function Function) error {
+	c.functions = append(c.functions, function)
+	return nil
+}
+
+// testConsumer реализует только обязательные методы Consumer
+// и собирает прочитанные ключи
+type testConsumer struct {
+	testKeyConsumer
+}
+
+func (c *testConsumer) SetMagic(Magic) error {
+	return nil
+}
+
+func (c *testConsumer) SetAuxField(AuxField) error {
+	return nil
+}
+
+func (c *testConsumer) SetResizeDB(uint32, ResizeDB) error {
+	return nil
+}
+
+func (c *testConsumer) SetEOF(EOF) error {
+	return nil
+}
+
+// TestDecodeDropExpired проверяет пропуск ключей истёкших до создания RDB
+func TestDecodeDropExpired(t *testing.T) {
+	var rdb bytes.Buffer
+	rdb.Write(NewMagic(9).Bytes())
+	rdb.Write(NewAuxField(SnapshotTimeAuxField, "1492780026").Bytes())
+	rdb.Write(NewDBSelector(0).Bytes())
+	for _, expiry := range []struct {
+		name         string
+		milliseconds uint64
+	}{
+		{"expired", 1492780025000},
+		{"alive", 1492780027000},
+		{"persistent", 0},
+	} {
+		if expiry.milliseconds != 0 {
+			rdb.WriteByte(ExpiryMillisecondsOpcode)
+			body := make([]byte, 8)
+			binary.LittleEndian.PutUint64(body, expiry.milliseconds)
+			rdb.Write(body)
+		}
+		rdb.WriteByte(StringValueOpcode)
+		rdb.Write(EncodeString(expiry.name))
+		rdb.Write(EncodeString("v"))
+	}
+	// время жизни в секундах
+	rdb.WriteByte(ExpirySecondsOpcode)
+	rdb.Write([]byte{0x01, 0x00, 0x00, 0x00})
+	rdb.WriteByte(StringValueOpcode)
+	rdb.Write(EncodeString("seconds"))
+	rdb.Write(EncodeString("v"))
+	rdb.WriteByte(EOFOpcode)
+	rdb.Write(make([]byte, 8))
+
+	tests := map[bool][]string{
+		false: {"expired", "alive", "persistent", "seconds"},
+		true:  {"alive", "persistent"},
+	}
+	for drop, expected := range tests {
+		consumer := &testKeyConsumer{}
+		decoder := NewDecoder(bytes.NewReader(rdb.Bytes()), WithDropExpired(drop))
+		err := decoder.DecodeKeys(consumer)
+		if err != nil {
+			t.Fatalf("decode error: %v", err)
+		}
+		names := make([]string, 0, len(consumer.keys))
+		for _, key := range consumer.keys {
+			names = append(names, key.Name())
+		}
+		if !reflect.DeepEqual(names, expected) {
+			t.Fatalf("drop %t: expected %q but actual %q", drop, expected, names)
+		}
+		if drop {
+			continue
+		}
+		expiry := consumer.keys[1].Expiry()
+		if !expiry.Time().Equal(time.Unix(1492780027, 0)) {
+			t.Fatalf("unexpected expiry %v", expiry.Time())
+		}
+		if consumer.keys[2].Expiry().IsSet() {
+			t.Fatalf("unexpected expiry %v", consumer.keys[2].Expiry().Time())
+		}
+		if consumer.keys[3].Expiry().Milliseconds() != 1000 {
+			t.Fatalf("expected 1000 but actual %d", consumer.keys[3].Expiry().Milliseconds())
+		}
+	}
+}
@@ -0,0 +1,408 @@
+package rdb
+
+import (
+	"fmt"
+
+	"github.com/avito-tech/smart-redis-replication/data"
+)
+
+// KeyType это тип значения ключа независимо от способа кодирования,
+// совпадает с ответом команды TYPE
+type KeyType string
+
+// Это типы значений ключей
+const (
+	StringKeyType    KeyType = "string"
+	ListKeyType      KeyType = "list"
+	SetKeyType       KeyType = "set"
+	SortedSetKeyType KeyType = "zset"
+	HashKeyType      KeyType = "hash"
+	StreamKeyType    KeyType = "stream"
+	ModuleKeyType    KeyType = "module"
+)
+
+// KeyFilter это условие отбора ключей, проверяется сразу после чтения
+// названия ключа. Значения отклонённых ключей пропускаются без разбора
+type KeyFilter func(db int, name string, keyType KeyType) bool
+
+// OpcodeKeyType возвращает тип значения ключа по коду его кодирования,
+// пустую строку если код не является типом ключа
+// nolint:gocyclo
+func OpcodeKeyType(opcode byte) KeyType {
+	switch opcode {
+	case StringValueOpcode:
+		return StringKeyType
+	case ListOpcode, ZipListOpcode, QuickListOpcode, QuickList2Opcode:
+		return ListKeyType
+	case SetOpcode, IntSetOpcode, ListpackSetOpcode:
+		return SetKeyType
+	case SortedSetOpcode, SortedSet2Opcode, ZipListSortedSetOpcode,
+		ListpackSortedSetOpcode:
+		return SortedSetKeyType
+	case ListHashMapOpcode, ZipMapHashMapOpcode, ZipListHashMapOpcode,
+		ListpackHashMapOpcode:
+		return HashKeyType
+	case StreamListpacksOpcode, StreamListpacks2Opcode, StreamListpacks3Opcode:
+		return StreamKeyType
+	case ModuleOpcode, Module2Opcode:
+		return ModuleKeyType
+	}
+	return ""
+}
+
+// KeyEncoding это способ кодирования значения ключа в RDB,
+// названия совпадают с ответом команды OBJECT ENCODING.
+// Decoder передаёт его вместе с ключом в data.Metadata.Encoding
+type KeyEncoding string
+
+// Это способы кодирования значений ключей
+const (
+	StringKeyEncoding     KeyEncoding = "string"
+	LinkedListKeyEncoding KeyEncoding = "linkedlist"
+	HashTableKeyEncoding  KeyEncoding = "hashtable"
+	SkipListKeyEncoding   KeyEncoding = "skiplist"
+	ZipMapKeyEncoding     KeyEncoding = "zipmap"
+	ZipListKeyEncoding    KeyEncoding = "ziplist"
+	IntSetKeyEncoding     KeyEncoding = "intset"
+	QuickListKeyEncoding  KeyEncoding = "quicklist"
+	ListpackKeyEncoding   KeyEncoding = "listpack"
+	StreamKeyEncoding     KeyEncoding = "stream"
+	ModuleKeyEncoding     KeyEncoding = "module"
+)
+
+// OpcodeKeyEncoding возвращает способ кодирования значения ключа по коду
+// его типа, пустую строку если код не является типом ключа
+// nolint:gocyclo
+func OpcodeKeyEncoding(opcode byte) KeyEncoding {
+	switch opcode {
+	case StringValueOpcode:
+		return StringKeyEncoding
+	case ListOpcode:
+		return LinkedListKeyEncoding
+	case SetOpcode, ListHashMapOpcode:
+		return HashTableKeyEncoding
+	case SortedSetOpcode, SortedSet2Opcode:
+		return SkipListKeyEncoding
+	case ZipMapHashMapOpcode:
+		return ZipMapKeyEncoding
+	case ZipListOpcode, ZipListSortedSetOpcode, ZipListHashMapOpcode:
+		return ZipListKeyEncoding
+	case IntSetOpcode:
+		return IntSetKeyEncoding
+	case QuickListOpcode, QuickList2Opcode:
+		return QuickListKeyEncoding
+	case ListpackHashMapOpcode, ListpackSortedSetOpcode, ListpackSetOpcode:
+		return ListpackKeyEncoding
+	case StreamListpacksOpcode, StreamListpacks2Opcode, StreamListpacks3Opcode:
+		return StreamKeyEncoding
+	case ModuleOpcode, Module2Opcode:
+		return ModuleKeyEncoding
+	}
+	return ""
+}
+
+// readValue читает значение ключа keyName закодированное согласно opcode
+// nolint:gocyclo
+func (r *reader) readValue(
+	opcode byte,
+	keyName string,
+	expiry data.Expiry,
+) (data.Key, error) {
+	switch opcode {
+	// SortedSet
+	case ZipListSortedSetOpcode:
+		return r.readZipListSortedSetValue(keyName, expiry)
+	case SortedSetOpcode:
+		return r.readSortedSetValue(keyName, expiry)
+	case SortedSet2Opcode:
+		return r.readSortedSet2Value(keyName, expiry)
+	case ListpackSortedSetOpcode:
+		return r.readListpackSortedSetValue(keyName, expiry)
+
+	// HashMap
+	case ListHashMapOpcode:
+		return r.readListHashMapValue(keyName, expiry)
+	case ZipListHashMapOpcode:
+		return r.readZipListHashMapValue(keyName, expiry)
+	case ZipMapHashMapOpcode:
+		return r.readZipMapHashMapValue(keyName, expiry)
+	case ListpackHashMapOpcode:
+		return r.readListpackHashMapValue(keyName, expiry)
+
+	// List
+	case ListOpcode:
+		return r.readListValue(keyName, expiry)
+	case ZipListOpcode:
+		return r.readZipListValue(keyName, expiry)
+	case QuickListOpcode:
+		return r.readQuickListValue(keyName, expiry)
+	case QuickList2Opcode:
+		return r.readQuickList2Value(keyName, expiry)
+
+	case SetOpcode:
+		return r.readSetValue(keyName, expiry)
+	case ListpackSetOpcode:
+		return r.readListpackSetValue(keyName, expiry)
+	case IntSetOpcode:
+		return r.readIntSetValue(keyName, expiry)
+	case StringValueOpcode:
+		return r.readStringValue(keyName, expiry)
+
+	// Module
+	case ModuleOpcode, Module2Opcode:
+		return r.readModuleValue(keyName, opcode, expiry)
+
+	// Stream
+	case StreamListpacksOpcode, StreamListpacks2Opcode, StreamListpacks3Opcode:
+		return r.readStreamValue(keyName, opcode, expiry)
+	}
+	return nil, fmt.Errorf("unsupported key opcode: %#v", opcode)
+}
+
+// skipValue пропускает значение ключа закодированное согласно opcode
+// не разбирая его. Значение ModuleOpcode не содержит типов и размеров
+// поэтому читается зарегистрированным декодером модуля
+// nolint:gocyclo
+func (r *reader) skipValue(opcode byte) error {
+	switch opcode {
+	case StringValueOpcode, ZipMapHashMapOpcode, ZipListOpcode, IntSetOpcode,
+		ZipListSortedSetOpcode, ZipListHashMapOpcode, ListpackHashMapOpcode,
+		ListpackSortedSetOpcode, ListpackSetOpcode:
+		return r.skipString()
+	case ListOpcode, SetOpcode, QuickListOpcode:
+		return r.skipList(1, r.skipString)
+	case ListHashMapOpcode:
+		return r.skipList(2, r.skipString)
+	case SortedSetOpcode:
+		return r.skipList(1, func() error {
+			err := r.skipString()
+			if err != nil {
+				return err
+			}
+			return r.skipFloat64()
+		})
+	case SortedSet2Opcode:
+		return r.skipList(1, func() error {
+			err := r.skipString()
+			if err != nil {
+				return err
+			}
+			return r.skip(8)
+		})
+	case QuickList2Opcode:
+		return r.skipList(1, func() error {
+			_, _, err := r.ReadLength()
+			if err != nil {
+				return err
+			}
+			return r.skipString()
+		})
+	case ModuleOpcode:
+		_, err := r.readModuleValue("", opcode, data.NoExpiry())
+		return err
+	case Module2Opcode:
+		_, err := r.ReadLength64()
+		if err != nil {
+			return err
+		}
+		return r.skipModuleValues()
+	case StreamListpacksOpcode, StreamListpacks2Opcode, StreamListpacks3Opcode:
+		return r.skipStream(opcode)
+	}
+	return fmt.Errorf("unsupported key opcode: %#v", opcode)
+}
+
+// skipString пропускает строку не распаковывая её
+func (r *reader) skipString() error {
+	length, encoding, err := r.ReadLength()
+	if err != nil {
+		return err
+	}
+	switch encoding {
+	case -1:
+		return r.skip(length)
+	case 0, 1, 2:
+		return r.skip(1 << uint8(encoding))
+	case 3:
+		clength, _, err := r.ReadLength()
+		if err != nil {
+			return err
+		}
+		_, _, err = r.ReadLength()
+		if err != nil {
+			return err
+		}
+		return r.skip(clength)
+	}
+	return fmt.Errorf("unsupported string encoding")
+}
+
+// skip пропускает n байт
+func (r *reader) skip(n uint32) error {
+	_, err := r.checksumReader.Discard(int(n))
+	return err
+}
+
+// skipList читает количество элементов и пропускает их,
+// каждый элемент это size вызовов skipEntry
+func (r *reader) skipList(size int, skipEntry func() error) error {
+	count, _, err := r.ReadLength()
+	if err != nil {
+		return err
+	}
+	for ; count > 0; count-- {
+		for i := 0; i < size; i++ {
+			err = skipEntry()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// skipFloat64 пропускает число записанное строкой
+func (r *reader) skipFloat64() error {
+	length, err := r.ReadUint8()
+	if err != nil {
+		return err
+	}
+	if length >= 253 {
+		return nil
+	}
+	return r.skip(uint32(length))
+}
+
+// skipLengths пропускает n длин
+func (r *reader) skipLengths(n int) error {
+	for ; n > 0; n-- {
+		_, err := r.ReadLength64()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// skipModuleValues пропускает значения модуля до кода окончания
+func (r *reader) skipModuleValues() error {
+	for {
+		opcode, err := r.ReadLength64()
+		if err != nil {
+			return err
+		}
+		switch data.ModuleValueType(opcode) {
+		case moduleOpcodeEOF:
+			return nil
+		case data.ModuleSigned, data.ModuleUnsigned:
+			_, err = r.ReadLength64()
+		case data.ModuleFloat:
+			err = r.skip(4)
+		case data.ModuleDouble:
+			err = r.skip(8)
+		case data.ModuleString:
+			err = r.skipString()
+		default:
+			err = fmt.Errorf("unknown module value type %d", opcode)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// skipStream пропускает Stream, структура описана в DecodeStream
+// nolint:gocyclo
+func (r *reader) skipStream(opcode byte) error {
+	nodes, err := r.ReadLength64()
+	if err != nil {
+		return err
+	}
+	for ; nodes > 0; nodes-- {
+		// идентификатор узла и Listpack с записями
+		for i := 0; i < 2; i++ {
+			err = r.skipString()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// длина и последний идентификатор, с версии 2 ещё первый
+	// идентификатор, максимальный удалённый и количество добавленных
+	infoLengths := 3
+	if opcode != StreamListpacksOpcode {
+		infoLengths += 5
+	}
+	err = r.skipLengths(infoLengths)
+	if err != nil {
+		return err
+	}
+
+	groups, err := r.ReadLength64()
+	if err != nil {
+		return err
+	}
+	for ; groups > 0; groups-- {
+		err = r.skipString()
+		if err != nil {
+			return err
+		}
+		// последний идентификатор, с версии 2 ещё количество прочитанных
+		groupLengths := 2
+		if opcode != StreamListpacksOpcode {
+			groupLengths++
+		}
+		err = r.skipLengths(groupLengths)
+		if err != nil {
+			return err
+		}
+
+		pending, err := r.ReadLength64()
+		if err != nil {
+			return err
+		}
+		for ; pending > 0; pending-- {
+			// идентификатор, время доставки и количество доставок
+			err = r.skip(streamIDSize + 8)
+			if err != nil {
+				return err
+			}
+			_, err = r.ReadLength64()
+			if err != nil {
+				return err
+			}
+		}
+
+		consumers, err := r.ReadLength64()
+		if err != nil {
+			return err
+		}
+		for ; consumers > 0; consumers-- {
+			err = r.skipString()
+			if err != nil {
+				return err
+			}
+			// время последнего обращения, с версии 3 ещё время активности
+			times := uint32(8)
+			if opcode == StreamListpacks3Opcode {
+				times += 8
+			}
+			err = r.skip(times)
+			if err != nil {
+				return err
+			}
+			count, err := r.ReadLength64()
+			if err != nil {
+				return err
+			}
+			for ; count > 0; count-- {
+				err = r.skip(streamIDSize)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
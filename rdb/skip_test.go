@@ -0,0 +1,162 @@
+package rdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/avito-tech/smart-redis-replication/data"
+)
+
+// TestDecodeKeyFilter проверяет пропуск значений ключей отклонённых
+// KeyFilter: все остальные ключи и контрольная сумма читаются верно
+func TestDecodeKeyFilter(t *testing.T) {
+	body := testSkipRDB(t)
+
+	t.Run("All", func(t *testing.T) {
+		consumer := &testKeyConsumer{}
+		err := NewDecoder(bytes.NewReader(body)).DecodeKeys(consumer)
+		if err != nil {
+			t.Fatalf("decode error: %v", err)
+		}
+		if len(consumer.keys) != 10 {
+			t.Fatalf("expected 10 keys but actual %d", len(consumer.keys))
+		}
+		lzf, ok := consumer.keys[0].(data.StringKey)
+		if !ok || lzf.Value() != "abcabc" {
+			t.Fatalf("unexpected lzf string %#v", consumer.keys[0])
+		}
+	})
+	t.Run("Filter", func(t *testing.T) {
+		var filtered []string
+		filter := func(db int, name string, keyType KeyType) bool {
+			filtered = append(filtered, string(keyType)+":"+name)
+			return db == 1 && !strings.HasPrefix(name, "skip:")
+		}
+		consumer := &testKeyConsumer{}
+		err := NewDecoder(bytes.NewReader(body), WithKeyFilter(filter)).
+			DecodeKeys(consumer)
+		if err != nil {
+			t.Fatalf("decode error: %v", err)
+		}
+		expectedFiltered := []string{
+			"string:skip:lzf", "zset:skip:zset", "zset:skip:zset2",
+			"hash:skip:hash", "list:skip:quicklist", "set:skip:intset",
+			"module:skip:module", "stream:skip:stream",
+			"string:keep", "list:skip:list",
+		}
+		if !reflect.DeepEqual(filtered, expectedFiltered) {
+			t.Fatalf("expected %q but actual %q", expectedFiltered, filtered)
+		}
+		if len(consumer.keys) != 1 || consumer.keys[0].Name() != "keep" {
+			t.Fatalf("expected only keep key but actual %#v", consumer.keys)
+		}
+		if consumer.keys[0].DB() != 1 {
+			t.Fatalf("expected db 1 but actual %d", consumer.keys[0].DB())
+		}
+	})
+}
+
+// testSkipRDB возвращает RDB с ключами всех способов пропуска значений
+// и контрольной суммой
+func testSkipRDB(t *testing.T) []byte {
+	var rdb bytes.Buffer
+	rdb.Write(NewMagic(11).Bytes())
+	rdb.Write(NewDBSelector(0).Bytes())
+
+	// строка сжатая LZF: литерал "abc" и повтор 3 байт со смещением 3
+	rdb.WriteByte(StringValueOpcode)
+	rdb.Write(EncodeString("skip:lzf"))
+	rdb.WriteByte(0xC3)
+	rdb.Write(EncodeLength(6))
+	rdb.Write(EncodeLength(6))
+	rdb.Write([]byte{0x02, 'a', 'b', 'c', 0x20, 0x02})
+
+	rdb.WriteByte(SortedSetOpcode)
+	rdb.Write(EncodeString("skip:zset"))
+	rdb.Write(EncodeLength(2))
+	rdb.Write(EncodeString("a"))
+	rdb.Write([]byte{3, '1', '.', '5'})
+	rdb.Write(EncodeString("b"))
+	rdb.WriteByte(254)
+
+	rdb.WriteByte(SortedSet2Opcode)
+	rdb.Write(EncodeString("skip:zset2"))
+	rdb.Write(EncodeLength(1))
+	rdb.Write(EncodeString("a"))
+	rdb.Write(testBinaryFloat64(math.Pi))
+
+	rdb.WriteByte(ListHashMapOpcode)
+	rdb.Write(EncodeString("skip:hash"))
+	rdb.Write(EncodeLength(1))
+	rdb.Write(EncodeString("field"))
+	rdb.Write(EncodeString("12345"))
+
+	rdb.WriteByte(QuickList2Opcode)
+	rdb.Write(EncodeString("skip:quicklist"))
+	rdb.Write(EncodeLength(2))
+	rdb.Write(EncodeLength(quickListNodePacked))
+	rdb.Write(EncodeString(testListpack(testListpackInt(1))))
+	rdb.Write(EncodeLength(quickListNodePlain))
+	rdb.Write(EncodeString("plain"))
+
+	rdb.WriteByte(IntSetOpcode)
+	rdb.Write(EncodeString("skip:intset"))
+	rdb.Write(EncodeString(testIntSet(2, []int64{1, 2})))
+
+	rdb.WriteByte(Module2Opcode)
+	rdb.Write(EncodeString("skip:module"))
+	rdb.Write(testModuleID(t, "skipmodul", 1))
+	rdb.Write(EncodeLength(uint32(data.ModuleSigned)))
+	rdb.Write(testLength64(math.MaxUint64))
+	rdb.Write(EncodeLength(uint32(data.ModuleFloat)))
+	rdb.Write(make([]byte, 4))
+	rdb.Write(EncodeLength(uint32(data.ModuleDouble)))
+	rdb.Write(testBinaryFloat64(1))
+	rdb.Write(EncodeLength(uint32(data.ModuleString)))
+	rdb.Write(EncodeString("value"))
+	rdb.Write(EncodeLength(moduleOpcodeEOF))
+
+	// поток без записей с группой, ожидающей записью и получателем
+	rdb.WriteByte(StreamListpacks3Opcode)
+	rdb.Write(EncodeString("skip:stream"))
+	rdb.Write(EncodeLength(0))
+	for _, length := range []uint32{0, 1, 0, 0, 0, 1, 0, 1} {
+		rdb.Write(EncodeLength(length))
+	}
+	rdb.Write(EncodeLength(1))
+	rdb.Write(EncodeString("g"))
+	rdb.Write(EncodeLength(1))
+	rdb.Write(EncodeLength(0))
+	rdb.Write(EncodeLength(1))
+	rdb.Write(EncodeLength(1))
+	rdb.Write(testRawStreamID(1, 0))
+	rdb.Write(testMillisecondTime(1000))
+	rdb.Write(EncodeLength(1))
+	rdb.Write(EncodeLength(1))
+	rdb.Write(EncodeString("c"))
+	rdb.Write(testMillisecondTime(2000))
+	rdb.Write(testMillisecondTime(3000))
+	rdb.Write(EncodeLength(1))
+	rdb.Write(testRawStreamID(1, 0))
+
+	rdb.Write(NewDBSelector(1).Bytes())
+	rdb.WriteByte(StringValueOpcode)
+	rdb.Write(EncodeString("keep"))
+	rdb.Write(EncodeString("value"))
+
+	rdb.WriteByte(ListOpcode)
+	rdb.Write(EncodeString("skip:list"))
+	rdb.Write(EncodeLength(2))
+	rdb.Write(EncodeString("-1"))
+	rdb.Write(EncodeString(strings.Repeat("x", 100000)))
+
+	rdb.WriteByte(EOFOpcode)
+	checksum := make([]byte, 8)
+	binary.LittleEndian.PutUint64(checksum, crc64Update(0, rdb.Bytes()))
+	rdb.Write(checksum)
+	return rdb.Bytes()
+}
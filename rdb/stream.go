@@ -0,0 +1,422 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/avito-tech/smart-redis-replication/data"
+)
+
+const (
+	// streamItemDeleted это флаг удалённой записи потока
+	streamItemDeleted = 1
+
+	// streamItemSameFields это флаг записи с теми же полями что и у
+	// главной записи узла
+	streamItemSameFields = 2
+
+	// streamIDSize это размер бинарного идентификатора записи
+	streamIDSize = 16
+)
+
+// ReadStream читает Stream, формат зависит от opcode:
+// StreamListpacksOpcode, StreamListpacks2Opcode или StreamListpacks3Opcode
+// nolint:dupl
+func (r *reader) ReadStream(
+	opcode byte,
+	expiry data.Expiry,
+) (data.StreamKey, error) {
+	keyName, err := r.ReadString()
+	if err != nil {
+		return nil, err
+	}
+	return r.readStreamValue(keyName, opcode, expiry)
+}
+
+// readStreamValue читает значение ключа keyName
+func (r *reader) readStreamValue(
+	keyName string,
+	opcode byte,
+	expiry data.Expiry,
+) (data.StreamKey, error) {
+	key := data.NewStream(keyName)
+	err := key.SetExpiry(expiry)
+	if err != nil {
+		return nil, err
+	}
+	err = r.DecodeStream(key, opcode)
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// DecodeStream декодирует Stream: дерево узлов Listpack с записями,
+// метаданные потока и группы получателей
+// nolint:gocyclo
+func (r *reader) DecodeStream(key data.StreamKey, opcode byte) error {
+	nodes, err := r.ReadLength64()
+	if err != nil {
+		return err
+	}
+	for nodes > 0 {
+		nodes--
+		nodeKey, err := r.ReadString()
+		if err != nil {
+			return err
+		}
+		if len(nodeKey) != streamIDSize {
+			return fmt.Errorf("unexpected stream node key size %d", len(nodeKey))
+		}
+		body, err := r.ReadString()
+		if err != nil {
+			return err
+		}
+		err = r.DecodeStreamListpack(key, decodeStreamID([]byte(nodeKey)), body)
+		if err != nil {
+			return err
+		}
+	}
+
+	var info data.StreamInfo
+	info.Length, err = r.ReadLength64()
+	if err != nil {
+		return err
+	}
+	info.LastID, err = r.readStreamID()
+	if err != nil {
+		return err
+	}
+	if opcode != StreamListpacksOpcode {
+		info.FirstID, err = r.readStreamID()
+		if err != nil {
+			return err
+		}
+		info.MaxDeletedID, err = r.readStreamID()
+		if err != nil {
+			return err
+		}
+		info.EntriesAdded, err = r.ReadLength64()
+		if err != nil {
+			return err
+		}
+	}
+	err = key.SetInfo(info)
+	if err != nil {
+		return err
+	}
+
+	groups, err := r.ReadLength64()
+	if err != nil {
+		return err
+	}
+	for groups > 0 {
+		groups--
+		group, err := r.readStreamGroup(opcode)
+		if err != nil {
+			return err
+		}
+		err = key.AddGroup(group)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DecodeStreamListpack декодирует записи одного узла Stream.
+// Первые элементы Listpack это главная запись узла:
+// количество записей, количество удалённых записей, количество полей,
+// поля и 0. Далее идут записи: флаги, разница ms и seq с идентификатором
+// узла, значения (если у записи поля главной записи) или количество полей
+// и пары поле-значение, количество элементов Listpack в записи
+// nolint:gocyclo
+func (r *reader) DecodeStreamListpack(
+	key data.StreamKey,
+	master data.StreamID,
+	body string,
+) error {
+	entries, err := NewListpackStringReader(body).ReadEntries()
+	if err != nil {
+		return err
+	}
+	it := &streamListpackIterator{entries: entries}
+
+	// главная запись узла
+	if _, err = it.int(); err != nil {
+		return err
+	}
+	if _, err = it.int(); err != nil {
+		return err
+	}
+	masterFieldsCount, err := it.count(1)
+	if err != nil {
+		return err
+	}
+	masterFields := make([]string, 0, masterFieldsCount)
+	for i := int64(0); i < masterFieldsCount; i++ {
+		field, err := it.string()
+		if err != nil {
+			return err
+		}
+		masterFields = append(masterFields, field)
+	}
+	if _, err = it.int(); err != nil {
+		return err
+	}
+
+	for !it.done() {
+		flags, err := it.int()
+		if err != nil {
+			return err
+		}
+		msDiff, err := it.int()
+		if err != nil {
+			return err
+		}
+		seqDiff, err := it.int()
+		if err != nil {
+			return err
+		}
+		entry := data.StreamEntry{
+			ID: data.StreamID{
+				Ms:  master.Ms + uint64(msDiff),
+				Seq: master.Seq + uint64(seqDiff),
+			},
+		}
+		if flags&streamItemSameFields != 0 {
+			entry.Fields = make([]data.StreamField, 0, len(masterFields))
+			for _, field := range masterFields {
+				value, err := it.string()
+				if err != nil {
+					return err
+				}
+				entry.Fields = append(
+					entry.Fields,
+					data.StreamField{Name: field, Value: value},
+				)
+			}
+		} else {
+			fieldsCount, err := it.count(2)
+			if err != nil {
+				return err
+			}
+			entry.Fields = make([]data.StreamField, 0, fieldsCount)
+			for i := int64(0); i < fieldsCount; i++ {
+				field, err := it.string()
+				if err != nil {
+					return err
+				}
+				value, err := it.string()
+				if err != nil {
+					return err
+				}
+				entry.Fields = append(
+					entry.Fields,
+					data.StreamField{Name: field, Value: value},
+				)
+			}
+		}
+		// количество элементов записи нужно только для чтения с конца
+		if _, err = it.int(); err != nil {
+			return err
+		}
+		if flags&streamItemDeleted != 0 {
+			continue
+		}
+		err = key.Add(entry)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readStreamGroup читает группу получателей вместе с PEL и получателями
+// nolint:gocyclo
+func (r *reader) readStreamGroup(opcode byte) (data.StreamGroup, error) {
+	group := data.StreamGroup{EntriesRead: -1}
+	var err error
+	group.Name, err = r.ReadString()
+	if err != nil {
+		return group, err
+	}
+	group.LastID, err = r.readStreamID()
+	if err != nil {
+		return group, err
+	}
+	if opcode != StreamListpacksOpcode {
+		entriesRead, err := r.ReadLength64()
+		if err != nil {
+			return group, err
+		}
+		group.EntriesRead = int64(entriesRead)
+	}
+
+	pending, err := r.ReadLength64()
+	if err != nil {
+		return group, err
+	}
+	index := make(map[data.StreamID]int, pending)
+	for pending > 0 {
+		pending--
+		var entry data.StreamPendingEntry
+		entry.ID, err = r.readRawStreamID()
+		if err != nil {
+			return group, err
+		}
+		entry.DeliveryTime, err = r.readMillisecondTime()
+		if err != nil {
+			return group, err
+		}
+		entry.DeliveryCount, err = r.ReadLength64()
+		if err != nil {
+			return group, err
+		}
+		index[entry.ID] = len(group.Pending)
+		group.Pending = append(group.Pending, entry)
+	}
+
+	consumers, err := r.ReadLength64()
+	if err != nil {
+		return group, err
+	}
+	for consumers > 0 {
+		consumers--
+		var consumer data.StreamConsumer
+		consumer.Name, err = r.ReadString()
+		if err != nil {
+			return group, err
+		}
+		consumer.SeenTime, err = r.readMillisecondTime()
+		if err != nil {
+			return group, err
+		}
+		consumer.ActiveTime = consumer.SeenTime
+		if opcode == StreamListpacks3Opcode {
+			consumer.ActiveTime, err = r.readMillisecondTime()
+			if err != nil {
+				return group, err
+			}
+		}
+		count, err := r.ReadLength64()
+		if err != nil {
+			return group, err
+		}
+		for count > 0 {
+			count--
+			id, err := r.readRawStreamID()
+			if err != nil {
+				return group, err
+			}
+			i, ok := index[id]
+			if !ok {
+				return group, fmt.Errorf(
+					"consumer %q pending entry %s not found in group %q",
+					consumer.Name,
+					id,
+					group.Name,
+				)
+			}
+			group.Pending[i].Consumer = consumer.Name
+			consumer.Pending = append(consumer.Pending, id)
+		}
+		group.Consumers = append(group.Consumers, consumer)
+	}
+	return group, nil
+}
+
+// readStreamID читает идентификатор записанный как две длины ms и seq
+func (r *reader) readStreamID() (data.StreamID, error) {
+	ms, err := r.ReadLength64()
+	if err != nil {
+		return data.StreamID{}, err
+	}
+	seq, err := r.ReadLength64()
+	if err != nil {
+		return data.StreamID{}, err
+	}
+	return data.StreamID{Ms: ms, Seq: seq}, nil
+}
+
+// readRawStreamID читает бинарный идентификатор из 16 байт
+func (r *reader) readRawStreamID() (data.StreamID, error) {
+	body, err := r.SafeRead(streamIDSize)
+	if err != nil {
+		return data.StreamID{}, err
+	}
+	return decodeStreamID(body), nil
+}
+
+// readMillisecondTime читает время в миллисекундах little endian
+func (r *reader) readMillisecondTime() (time.Time, error) {
+	body, err := r.SafeRead(8)
+	if err != nil {
+		return time.Time{}, err
+	}
+	ms := int64(binary.LittleEndian.Uint64(body))
+	return time.Unix(ms/1000, ms%1000*int64(time.Millisecond)), nil
+}
+
+// decodeStreamID декодирует бинарный идентификатор:
+// ms и seq в big endian
+func decodeStreamID(body []byte) data.StreamID {
+	return data.StreamID{
+		Ms:  binary.BigEndian.Uint64(body[:8]),
+		Seq: binary.BigEndian.Uint64(body[8:]),
+	}
+}
+
+// encodeStreamID кодирует идентификатор в бинарный вид из 16 байт
+func encodeStreamID(id data.StreamID) []byte {
+	body := make([]byte, streamIDSize)
+	binary.BigEndian.PutUint64(body[:8], id.Ms)
+	binary.BigEndian.PutUint64(body[8:], id.Seq)
+	return body
+}
+
+// streamListpackIterator последовательно читает элементы узла Stream
+type streamListpackIterator struct {
+	entries [][]byte
+	i       int
+}
+
+// done возвращает true если все элементы прочитаны
+func (it *streamListpackIterator) done() bool {
+	return it.i >= len(it.entries)
+}
+
+// string возвращает следующий элемент
+func (it *streamListpackIterator) string() (string, error) {
+	if it.done() {
+		return "", fmt.Errorf("unexpected end of stream listpack")
+	}
+	entry := it.entries[it.i]
+	it.i++
+	return string(entry), nil
+}
+
+// int возвращает следующий элемент как целое число
+func (it *streamListpackIterator) int() (int64, error) {
+	entry, err := it.string()
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(entry, 10, 64)
+}
+
+// count возвращает следующий элемент как количество значений из size
+// элементов каждое, количество не может быть отрицательным или больше
+// чем оставшихся элементов
+func (it *streamListpackIterator) count(size int) (int64, error) {
+	n, err := it.int()
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 || n > int64((len(it.entries)-it.i)/size) {
+		return 0, fmt.Errorf("invalid stream listpack count %d", n)
+	}
+	return n, nil
+}
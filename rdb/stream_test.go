@@ -0,0 +1,227 @@
+package rdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/avito-tech/smart-redis-replication/data"
+)
+
+// TestDecodeStream проверяет чтение Stream всех версий
+func TestDecodeStream(t *testing.T) {
+	tests := map[string]byte{
+		"Listpacks":  StreamListpacksOpcode,
+		"Listpacks2": StreamListpacks2Opcode,
+		"Listpacks3": StreamListpacks3Opcode,
+	}
+	for name, opcode := range tests {
+		opcode := opcode
+		t.Run(name, func(t *testing.T) {
+			testDecodeStream(t, opcode)
+		})
+	}
+}
+
+// TestDecodeStreamListpackCorruptCount проверяет что некорректное
+// количество полей возвращает ошибку, а не панику
+func TestDecodeStreamListpackCorruptCount(t *testing.T) {
+	tests := map[string][][]byte{
+		"NegativeMasterFields": {
+			[]byte{0x01}, []byte{0x00}, testListpackInt(-1), []byte{0x00},
+		},
+		"HugeMasterFields": {
+			[]byte{0x01}, []byte{0x00}, testListpackInt(math.MaxInt64),
+			[]byte{0x00},
+		},
+		"HugeFields": {
+			[]byte{0x01}, []byte{0x00}, []byte{0x00}, []byte{0x00},
+			[]byte{0x00}, []byte{0x00}, []byte{0x00},
+			testListpackInt(math.MaxInt64), testListpackString("a"),
+			testListpackString("1"), []byte{0x04},
+		},
+		"NegativeFields": {
+			[]byte{0x01}, []byte{0x00}, []byte{0x00}, []byte{0x00},
+			[]byte{0x00}, []byte{0x00}, []byte{0x00},
+			testListpackInt(-5), []byte{0x04},
+		},
+	}
+	for name, entries := range tests {
+		entries := entries
+		t.Run(name, func(t *testing.T) {
+			err := (&reader{}).DecodeStreamListpack(
+				data.NewStream("stream"),
+				data.StreamID{Ms: 1},
+				testListpack(entries...),
+			)
+			if err == nil {
+				t.Fatalf("expected error")
+			}
+		})
+	}
+}
+
+func testDecodeStream(t *testing.T, opcode byte) {
+	var rdb bytes.Buffer
+	rdb.Write(NewMagic(11).Bytes())
+	rdb.Write(NewDBSelector(0).Bytes())
+	rdb.WriteByte(opcode)
+	rdb.Write(EncodeString("stream"))
+
+	// один узел с главной записью 1-0 и полем "f"
+	rdb.Write(EncodeLength(1))
+	rdb.Write(EncodeString(string(testRawStreamID(1, 0))))
+	rdb.Write(EncodeString(testListpack(
+		// главная запись: записей, удалённых, полей, поле, 0
+		[]byte{0x02}, []byte{0x01}, []byte{0x01}, testListpackString("f"),
+		[]byte{0x00},
+		// 1-0 с полями главной записи
+		[]byte{streamItemSameFields}, []byte{0x00}, []byte{0x00},
+		testListpackString("v1"), []byte{0x04},
+		// 1-1 удалена
+		[]byte{streamItemSameFields | streamItemDeleted}, []byte{0x00},
+		[]byte{0x01}, testListpackString("v2"), []byte{0x04},
+		// 2-0 со своими полями
+		[]byte{0x00}, []byte{0x01}, []byte{0x00}, []byte{0x02},
+		testListpackString("a"), testListpackString("1"),
+		testListpackString("b"), testListpackString("2"), []byte{0x08},
+	)))
+
+	// метаданные потока
+	rdb.Write(EncodeLength(2))
+	rdb.Write(EncodeLength(2))
+	rdb.Write(EncodeLength(0))
+	if opcode != StreamListpacksOpcode {
+		rdb.Write(EncodeLength(1))
+		rdb.Write(EncodeLength(0))
+		rdb.Write(EncodeLength(1))
+		rdb.Write(EncodeLength(1))
+		rdb.Write(EncodeLength(3))
+	}
+
+	// группа "g" с одной ожидающей записью у получателя "c"
+	rdb.Write(EncodeLength(1))
+	rdb.Write(EncodeString("g"))
+	rdb.Write(EncodeLength(1))
+	rdb.Write(EncodeLength(0))
+	if opcode != StreamListpacksOpcode {
+		rdb.Write(EncodeLength(1))
+	}
+	rdb.Write(EncodeLength(1))
+	rdb.Write(testRawStreamID(1, 0))
+	rdb.Write(testMillisecondTime(1000))
+	rdb.Write(EncodeLength(1))
+	rdb.Write(EncodeLength(1))
+	rdb.Write(EncodeString("c"))
+	rdb.Write(testMillisecondTime(2000))
+	if opcode == StreamListpacks3Opcode {
+		rdb.Write(testMillisecondTime(3000))
+	}
+	rdb.Write(EncodeLength(1))
+	rdb.Write(testRawStreamID(1, 0))
+
+	rdb.WriteByte(EOFOpcode)
+	rdb.Write(make([]byte, 8))
+
+	consumer := &testKeyConsumer{}
+	err := NewDecoder(&rdb).DecodeKeys(consumer)
+	if err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	if len(consumer.keys) != 1 {
+		t.Fatalf("expected 1 key but actual %d", len(consumer.keys))
+	}
+	stream, ok := consumer.keys[0].(data.StreamKey)
+	if !ok {
+		t.Fatalf("expected StreamKey but actual %#v", consumer.keys[0])
+	}
+
+	expectedEntries := []data.StreamEntry{
+		{
+			ID:     data.StreamID{Ms: 1, Seq: 0},
+			Fields: []data.StreamField{{Name: "f", Value: "v1"}},
+		},
+		{
+			ID: data.StreamID{Ms: 2, Seq: 0},
+			Fields: []data.StreamField{
+				{Name: "a", Value: "1"},
+				{Name: "b", Value: "2"},
+			},
+		},
+	}
+	if !reflect.DeepEqual(stream.Values(), expectedEntries) {
+		t.Fatalf("expected %#v but actual %#v", expectedEntries, stream.Values())
+	}
+
+	expectedInfo := data.StreamInfo{Length: 2, LastID: data.StreamID{Ms: 2}}
+	if opcode != StreamListpacksOpcode {
+		expectedInfo.FirstID = data.StreamID{Ms: 1}
+		expectedInfo.MaxDeletedID = data.StreamID{Ms: 1, Seq: 1}
+		expectedInfo.EntriesAdded = 3
+	}
+	if stream.Info() != expectedInfo {
+		t.Fatalf("expected %#v but actual %#v", expectedInfo, stream.Info())
+	}
+
+	expectedGroup := data.StreamGroup{
+		Name:        "g",
+		LastID:      data.StreamID{Ms: 1},
+		EntriesRead: -1,
+		Pending: []data.StreamPendingEntry{{
+			ID:            data.StreamID{Ms: 1},
+			Consumer:      "c",
+			DeliveryTime:  time.Unix(1, 0),
+			DeliveryCount: 1,
+		}},
+		Consumers: []data.StreamConsumer{{
+			Name:       "c",
+			SeenTime:   time.Unix(2, 0),
+			ActiveTime: time.Unix(2, 0),
+			Pending:    []data.StreamID{{Ms: 1}},
+		}},
+	}
+	if opcode != StreamListpacksOpcode {
+		expectedGroup.EntriesRead = 1
+	}
+	if opcode == StreamListpacks3Opcode {
+		expectedGroup.Consumers[0].ActiveTime = time.Unix(3, 0)
+	}
+	groups := stream.Groups()
+	if len(groups) != 1 || !reflect.DeepEqual(groups[0], expectedGroup) {
+		t.Fatalf("expected %#v but actual %#v", expectedGroup, groups)
+	}
+}
+
+// TestReadLength64 проверяет чтение 64 битной длины
+func TestReadLength64(t *testing.T) {
+	body := []byte{len64BitPrefix, 0, 0, 0, 1, 0, 0, 0, 2}
+	length, err := NewStringReader(string(body)).ReadLength64()
+	if err != nil {
+		t.Fatalf("read length error: %v", err)
+	}
+	if length != 1<<32+2 {
+		t.Fatalf("expected %d but actual %d", uint64(1<<32+2), length)
+	}
+	_, _, err = NewStringReader(string(body)).ReadLength()
+	if err == nil {
+		t.Fatalf("expected uint32 overflow error")
+	}
+}
+
+// testRawStreamID кодирует идентификатор записи потока в 16 байт
+func testRawStreamID(ms, seq uint64) []byte {
+	body := make([]byte, streamIDSize)
+	binary.BigEndian.PutUint64(body, ms)
+	binary.BigEndian.PutUint64(body[8:], seq)
+	return body
+}
+
+// testMillisecondTime кодирует время в миллисекундах
+func testMillisecondTime(ms uint64) []byte {
+	body := make([]byte, 8)
+	binary.LittleEndian.PutUint64(body, ms)
+	return body
+}
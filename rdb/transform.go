@@ -0,0 +1,53 @@
+package rdb
+
+import (
+	"io"
+
+	"github.com/avito-tech/smart-redis-replication/data"
+)
+
+// TransformFunc преобразует ключ прочитанный из RDB и возвращает ключи
+// для записи: ключ можно изменить (ReplaceName, SetDB и прочее) и
+// вернуть, вернуть nil чтобы удалить его или несколько ключей чтобы
+// разделить его
+type TransformFunc func(key data.Key) ([]data.Key, error)
+
+// transformConsumer передаёт все данные в Encoder, а ключи через
+// TransformFunc
+type transformConsumer struct {
+	Encoder
+	fn TransformFunc
+}
+
+// NewTransformConsumer возвращает Consumer который записывает данные
+// в encoder преобразуя ключи fn, его можно передать в Decoder.Decode
+// чтобы использовать свои настройки Decoder и Encoder
+func NewTransformConsumer(encoder Encoder, fn TransformFunc) Consumer {
+	return &transformConsumer{
+		Encoder: encoder,
+		fn:      fn,
+	}
+}
+
+// Key преобразует ключ и записывает результат
+func (c *transformConsumer) Key(key data.Key) error {
+	keys, err := c.fn(key)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		err = c.Encoder.Key(key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Transform читает RDB из in, преобразует каждый ключ fn и записывает
+// новый RDB в out той же версии. Дополнительные поля, библиотеки функций
+// и данные модулей переносятся без изменений, ResizeDB и SlotInfo
+// остаются подсказками исходного файла
+func Transform(in io.Reader, out io.Writer, fn TransformFunc) error {
+	return NewDecoder(in).Decode(NewTransformConsumer(NewEncoder(out), fn))
+}
@@ -0,0 +1,128 @@
+package rdb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/avito-tech/smart-redis-replication/data"
+)
+
+// TestTransform проверяет переименование, перенос в другую базу,
+// удаление и разделение ключей при перезаписи RDB
+func TestTransform(t *testing.T) {
+	in := testTransformRDB(t)
+	rename := regexp.MustCompile(`^user:`)
+	fn := func(key data.Key) ([]data.Key, error) {
+		switch {
+		case strings.HasPrefix(key.Name(), "drop:"):
+			return nil, nil
+		case key.Name() == "move":
+			return []data.Key{key}, key.SetDB(3)
+		case key.Name() == "split":
+			var keys []data.Key
+			for field, value := range key.(data.MapKey).Values() {
+				part := data.NewString(key.Name()+":"+field, value)
+				_ = part.SetDB(key.DB())
+				keys = append(keys, part)
+			}
+			sort.Slice(keys, func(i, j int) bool { return keys[i].Name() < keys[j].Name() })
+			return keys, nil
+		}
+		return []data.Key{key}, key.ReplaceName(rename, "account:")
+	}
+
+	var out bytes.Buffer
+	if err := Transform(bytes.NewReader(in), &out, fn); err != nil {
+		t.Fatalf("transform error: %v", err)
+	}
+
+	decoder := NewDecoder(&out)
+	var tokens []string
+	for {
+		token, err := decoder.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("decode error: %v", err)
+		}
+		switch op := token.(type) {
+		case Magic:
+			tokens = append(tokens, fmt.Sprintf("magic %d", op.GetRDBVersion()))
+		case AuxField:
+			tokens = append(tokens, "aux "+op.GetKey())
+		case DBSelector:
+			tokens = append(tokens, fmt.Sprintf("db %d", op.GetDBNumber()))
+		case ResizeDB:
+			tokens = append(tokens, "resize")
+		case data.StringKey:
+			tokens = append(tokens, "key "+op.Name()+"="+op.Value())
+		default:
+			t.Fatalf("unexpected token %#v", token)
+		}
+	}
+	expected := []string{
+		"magic 11", "aux redis-ver", "db 0", "resize",
+		"key account:1=a", "db 3", "key move=b", "db 0",
+		"key split:f1=v1", "key split:f2=v2",
+		"db 1", "key other=c",
+	}
+	if !reflect.DeepEqual(tokens, expected) {
+		t.Fatalf("expected %q but actual %q", expected, tokens)
+	}
+}
+
+// TestTransformError проверяет что ошибка преобразования прерывает запись
+func TestTransformError(t *testing.T) {
+	expected := errors.New("transform failed")
+	fn := func(key data.Key) ([]data.Key, error) {
+		return nil, expected
+	}
+	err := Transform(bytes.NewReader(testTransformRDB(t)), ioutil.Discard, fn)
+	if err != expected {
+		t.Fatalf("expected %v but actual %v", expected, err)
+	}
+}
+
+// testTransformRDB возвращает RDB для преобразования
+func testTransformRDB(t *testing.T) []byte {
+	var rdb bytes.Buffer
+	encoder := NewEncoder(&rdb)
+	if err := encoder.SetMagic(NewMagic(11)); err != nil {
+		t.Fatalf("encode error: %v", err)
+	}
+	if err := encoder.SetAuxField(NewAuxField("redis-ver", "7.2.4")); err != nil {
+		t.Fatalf("encode error: %v", err)
+	}
+	if err := encoder.SetResizeDB(0, NewResizeDB(4, 0)); err != nil {
+		t.Fatalf("encode error: %v", err)
+	}
+	split := data.NewMap("split")
+	_ = split.SetData(map[string]string{"f1": "v1", "f2": "v2"})
+	other := data.NewString("other", "c")
+	_ = other.SetDB(1)
+	keys := []data.Key{
+		data.NewString("user:1", "a"),
+		data.NewString("drop:1", "x"),
+		data.NewString("move", "b"),
+		split,
+		other,
+	}
+	for _, key := range keys {
+		if err := encoder.Key(key); err != nil {
+			t.Fatalf("encode error: %v", err)
+		}
+	}
+	if err := encoder.SetEOF(NewEOF()); err != nil {
+		t.Fatalf("encode error: %v", err)
+	}
+	return rdb.Bytes()
+}
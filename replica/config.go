@@ -1,5 +1,16 @@
 package replica
 
+import (
+	"time"
+
+	"github.com/avito-tech/smart-redis-replication/rdb"
+)
+
+const (
+	// DefaultAckInterval это интервал отправки REPLCONF ACK по умолчанию
+	DefaultAckInterval = time.Second
+)
+
 // Config это минимальный набор данных для запуска репликации
 type Config struct {
 	// ReadRDB означает будут ли обрабатываться данные из RDB или их нужно пропустить
@@ -8,6 +19,24 @@ type Config struct {
 	// CacheRDB означает требуется ли кешировать RDB
 	CacheRDB bool
 
+	// ReuseCacheRDB означает что при запуске без ReplicationID позиция
+	// репликации берётся из кеша RDB, и если сервер продолжит репликацию
+	// через +CONTINUE то RDB не передаётся и не декодируется повторно:
+	// получатель продолжает с сохранённого смещения
+	ReuseCacheRDB bool
+
+	// StreamRDB означает что RDB декодируется напрямую из соединения без
+	// записи в CacheRDBFile, при включённом CacheRDB файл кеша всё равно
+	// записывается для отладки
+	StreamRDB bool
+
+	// AbsoluteExpiry означает что в командах потока репликации относительное
+	// время жизни (EXPIRE, PEXPIRE, SETEX, PSETEX, SET EX|PX) заменяется
+	// абсолютным при получении команды, см. command.AbsoluteExpiry.
+	// Иначе ConvertToExpiry отсчитывает время от момента обработки
+	// команды из Backlog а не от её получения
+	AbsoluteExpiry bool
+
 	// Debug включает запись отладки
 	Debug bool
 
@@ -19,4 +48,20 @@ type Config struct {
 
 	// DebugDumpDir это адрес каталога в который сохраняются дампы
 	DebugDumpDir string
+
+	// ReplicationID это идентификатор репликации мастера с которым
+	// синхронизировались ранее, если пустой то запрашивается полная синхронизация
+	ReplicationID string
+
+	// ReplicationOffset это смещение репликации до которого данные уже
+	// были обработаны, используется вместе с ReplicationID
+	ReplicationOffset int64
+
+	// AckInterval это интервал отправки серверу подтверждения обработанного
+	// смещения (REPLCONF ACK), если не задан то используется DefaultAckInterval
+	AckInterval time.Duration
+
+	// RDBOptions это настройки декодера RDB, например
+	// rdb.WithKeyFilter для пропуска ненужных ключей
+	RDBOptions []rdb.DecoderOption
 }
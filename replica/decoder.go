@@ -5,6 +5,7 @@ import (
 	"errors"
 	"io"
 	"os"
+	"sync/atomic"
 
 	"github.com/avito-tech/smart-redis-replication/backlog"
 	"github.com/avito-tech/smart-redis-replication/command"
@@ -17,6 +18,9 @@ type decoder struct {
 	ctx      context.Context
 	cancel   context.CancelFunc
 	err      error
+	rdbDone  chan struct{}
+	stopped  chan struct{}
+	started  int32
 	rdb      rdb.Decoder
 	file     *os.File
 	backlog  *backlog.Backlog
@@ -31,15 +35,30 @@ func NewDecoder(
 ) (
 	Decoder,
 	error,
+) {
+	return NewDecoderContext(context.Background(), backlog, config)
+}
+
+// NewDecoderContext возвращает новый Decoder который останавливается
+// вместе с ctx
+func NewDecoderContext(
+	ctx context.Context,
+	backlog *backlog.Backlog,
+	config Config,
+) (
+	Decoder,
+	error,
 ) {
 	if backlog == nil {
 		return nil, errors.New("expected backlog")
 	}
 	d := &decoder{
 		backlog: backlog,
-		config: config,
+		config:  config,
+		rdbDone: make(chan struct{}),
+		stopped: make(chan struct{}),
 	}
-	d.ctx, d.cancel = context.WithCancel(context.Background())
+	d.ctx, d.cancel = context.WithCancel(ctx)
 	return d, nil
 }
 
@@ -53,7 +72,7 @@ func (d *decoder) SetRDB(r io.Reader) error {
 	if r == nil {
 		return errors.New("expected io.Reader but actual nil")
 	}
-	d.rdb = rdb.NewDecoder(r)
+	d.rdb = rdb.NewDecoder(r, d.config.RDBOptions...)
 	return nil
 }
 
@@ -61,7 +80,7 @@ func (d *decoder) SetRDB(r io.Reader) error {
 func (d *decoder) SetFileRDB(filename string) error {
 	file, err := os.Open(filename)
 	if err != nil {
-		return nil
+		return err
 	}
 	d.file = file
 	return d.SetRDB(file)
@@ -81,6 +100,22 @@ func (d *decoder) Done() <-chan struct{} {
 	return d.ctx.Done()
 }
 
+// RDBDone возвращает канал который закрывается когда чтение RDB завершено
+// или пропущено
+func (d *decoder) RDBDone() <-chan struct{} {
+	return d.rdbDone
+}
+
+// Started возвращает true если декодирование было запущено
+func (d *decoder) Started() bool {
+	return atomic.LoadInt32(&d.started) == 1
+}
+
+// Stopped возвращает канал который закрывается когда Decode завершился
+func (d *decoder) Stopped() <-chan struct{} {
+	return d.stopped
+}
+
 // Status возвращает true если декодирование выполняется и false если нет
 func (d *decoder) Status() bool {
 	select {
@@ -110,19 +145,23 @@ func (d *decoder) Cancel(err *error) {
 
 // Do запускает декодирование RDB и Backlog
 func (d *decoder) Decode(consumer Consumer) (err error) {
+	if !atomic.CompareAndSwapInt32(&d.started, 0, 1) {
+		return errors.New("decoder already started")
+	}
+	defer close(d.stopped)
 	defer d.Cancel(&err)
 	if consumer == nil {
 		return errors.New("expected consumer but actual nil")
 	}
 	d.consumer = consumer
-	if d.rdb == nil {
-		return errors.New("empty rdb.Decoder")
-	}
 	if d.backlog == nil {
 		return errors.New("empty backlog")
 	}
-	
+
 	if d.config.ReadRDB {
+		if d.rdb == nil {
+			return errors.New("empty rdb.Decoder")
+		}
 		consumer.ReplicaStatus(status.StartReadRDB) // nolint:errcheck
 		err = d.decodeRDB(consumer)
 		consumer.ReplicaStatus(status.StopReadRDB) // nolint:errcheck
@@ -132,6 +171,7 @@ func (d *decoder) Decode(consumer Consumer) (err error) {
 	} else {
 		consumer.ReplicaStatus(status.SkipReadRDB) // nolint:errcheck
 	}
+	close(d.rdbDone)
 	consumer.ReplicaStatus(status.StartReadBacklog) // nolint:errcheck
 	err = d.decodeBacklog(consumer)
 	return err
@@ -145,16 +185,77 @@ func (d *decoder) decodeRDB(consumer Consumer) error {
 	if consumer == nil {
 		return errors.New("empty consumer")
 	}
-	// поидеи сюда тоже надо прокинуть контекст
-	return d.rdb.DecodeKeys(consumer)
+	keyConsumer := &rdbConsumer{decoder: d, consumer: consumer}
+	if chunkConsumer, ok := consumer.(rdb.ChunkConsumer); ok {
+		return d.rdb.DecodeKeys(&rdbChunkConsumer{
+			rdbConsumer: keyConsumer,
+			consumer:    chunkConsumer,
+		})
+	}
+	return d.rdb.DecodeKeys(keyConsumer)
+}
+
+// rdbConsumer передаёт получателю ключи и библиотеки функций из RDB
+type rdbConsumer struct {
+	decoder  *decoder
+	consumer Consumer
+}
+
+// Key принимает ключ с данными
+func (c *rdbConsumer) Key(key data.Key) error {
+	if !c.decoder.Status() {
+		return c.decoder.ctx.Err()
+	}
+	return c.consumer.Key(key)
+}
+
+// SetFunction передаёт библиотеку функций если получатель реализует
+// rdb.FunctionConsumer
+func (c *rdbConsumer) SetFunction(function rdb.Function) error {
+	functionConsumer, ok := c.consumer.(rdb.FunctionConsumer)
+	if !ok {
+		return nil
+	}
+	if !c.decoder.Status() {
+		return c.decoder.ctx.Err()
+	}
+	return functionConsumer.SetFunction(function)
+}
+
+// rdbChunkConsumer дополнительно передаёт большие ключи по частям
+// получателю который реализует rdb.ChunkConsumer
+type rdbChunkConsumer struct {
+	*rdbConsumer
+	consumer rdb.ChunkConsumer
+}
+
+// KeyStart принимает начало ключа
+func (c *rdbChunkConsumer) KeyStart(key data.Key) error {
+	if !c.decoder.Status() {
+		return c.decoder.ctx.Err()
+	}
+	return c.consumer.KeyStart(key)
+}
+
+// KeyChunk принимает часть значений ключа
+func (c *rdbChunkConsumer) KeyChunk(key data.Key) error {
+	if !c.decoder.Status() {
+		return c.decoder.ctx.Err()
+	}
+	return c.consumer.KeyChunk(key)
+}
+
+// KeyEnd принимает окончание ключа
+func (c *rdbChunkConsumer) KeyEnd(key data.Key) error {
+	if !c.decoder.Status() {
+		return c.decoder.ctx.Err()
+	}
+	return c.consumer.KeyEnd(key)
 }
 
 // decodeBacklog декодирует Backlog
 // nolint:gocyclo
 func (d *decoder) decodeBacklog(consumer Consumer) error {
-	if d.rdb == nil {
-		return errors.New("empty rdb.Decoder")
-	}
 	if d.backlog == nil {
 		return errors.New("empty backlog")
 	}
@@ -163,12 +264,13 @@ func (d *decoder) decodeBacklog(consumer Consumer) error {
 	}
 	var db int
 	var key data.Key
+	var cmd command.Command
 	var err error
 	for {
-		if !d.Status() {
+		cmd, err = d.backlog.GetContext(d.ctx)
+		if err != nil {
 			return d.Err()
 		}
-		cmd := d.backlog.Get()
 		switch cmd.Type() {
 		case command.Select:
 			db, err = cmd.ConvertToSelectDB()
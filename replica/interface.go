@@ -10,7 +10,8 @@ import (
 	"github.com/avito-tech/smart-redis-replication/status"
 )
 
-// Consumer это получатель информации из репликации
+// Consumer это получатель информации из репликации,
+// если он реализует rdb.FunctionConsumer то получает и библиотеки функций
 type Consumer interface {
 	// Key принимает ключи с данными
 	Key(data.Key) error
@@ -42,6 +43,12 @@ type Replica interface {
 	// Возвращает журнал отставания репликации
 	Backlog() *backlog.Backlog
 
+	// ReplicationID возвращает идентификатор репликации мастера
+	ReplicationID() string
+
+	// Offset возвращает смещение репликации до которого данные прочитаны
+	Offset() int64
+
 	// Do запускает процесс репликации
 	Do(consumer Consumer) error
 }
@@ -60,6 +67,15 @@ type Decoder interface {
 	// Done возвращает канал для ожидания завершения декодера
 	Done() <-chan struct{}
 
+	// RDBDone возвращает канал для ожидания завершения чтения RDB
+	RDBDone() <-chan struct{}
+
+	// Started возвращает true если декодирование было запущено
+	Started() bool
+
+	// Stopped возвращает канал для ожидания выхода из Decode
+	Stopped() <-chan struct{}
+
 	// Status возвращает true если декодирование выполняется и false если нет
 	Status() bool
 
@@ -0,0 +1,95 @@
+package replica
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// cacheMeta это позиция репликации на момент создания кеша RDB,
+// хранится рядом с кешем и позволяет переиспользовать его после перезапуска
+type cacheMeta struct {
+	// ReplicationID это идентификатор репликации мастера
+	ReplicationID string `json:"replication_id"`
+
+	// Offset это смещение репликации на момент создания RDB
+	Offset int64 `json:"offset"`
+
+	// Size это размер файла кеша RDB
+	Size int64 `json:"size"`
+}
+
+// cacheMetaFile возвращает адрес файла с позицией репликации кеша RDB
+func (r *replica) cacheMetaFile() string {
+	return r.config.CacheRDBFile + ".meta"
+}
+
+// saveCacheMeta сохраняет позицию репликации рядом с кешем RDB,
+// при синхронизации через SYNC позиция неизвестна и ничего не сохраняется
+func (r *replica) saveCacheMeta() error {
+	r.replication.RLock()
+	meta := cacheMeta{
+		ReplicationID: r.replication.id,
+		Offset:        r.replication.offset,
+	}
+	r.replication.RUnlock()
+	if meta.ReplicationID == "" {
+		return nil
+	}
+
+	info, err := os.Stat(r.config.CacheRDBFile)
+	if err != nil {
+		return err
+	}
+	meta.Size = info.Size()
+
+	body, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	filename := r.cacheMetaFile()
+	err = ioutil.WriteFile(filename+".tmp", body, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(filename+".tmp", filename)
+}
+
+// loadCacheMeta читает позицию репликации кеша RDB и проверяет что кеш
+// соответствует ей
+func (r *replica) loadCacheMeta() (cacheMeta, error) {
+	body, err := ioutil.ReadFile(r.cacheMetaFile())
+	if err != nil {
+		return cacheMeta{}, err
+	}
+	meta := cacheMeta{}
+	err = json.Unmarshal(body, &meta)
+	if err != nil {
+		return cacheMeta{}, fmt.Errorf("rdb cache meta error: %v", err)
+	}
+	if meta.ReplicationID == "" {
+		return cacheMeta{}, errors.New("rdb cache meta error: empty replication id")
+	}
+	info, err := os.Stat(r.config.CacheRDBFile)
+	if err != nil {
+		return cacheMeta{}, err
+	}
+	if info.Size() != meta.Size {
+		return cacheMeta{}, fmt.Errorf(
+			"rdb cache meta error: expected cache size %d but actual %d",
+			meta.Size,
+			info.Size(),
+		)
+	}
+	return meta, nil
+}
+
+// deleteCacheMeta удаляет позицию репликации кеша RDB
+func (r *replica) deleteCacheMeta() error {
+	if _, err := os.Stat(r.cacheMetaFile()); err == nil {
+		return os.Remove(r.cacheMetaFile())
+	}
+	return nil
+}
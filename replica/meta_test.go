@@ -0,0 +1,101 @@
+package replica
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+// TestCacheMeta проверяет сохранение позиции репликации рядом с кешем RDB
+// и отказ от повреждённого или не соответствующего кешу файла
+// nolint:gocyclo
+func TestCacheMeta(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replica")
+	if err != nil {
+		t.Fatalf("temp dir error: %v", err)
+	}
+	defer os.RemoveAll(dir) // nolint:errcheck
+	cacheFile := path.Join(dir, "dump.rdb")
+	r := &replica{config: Config{CacheRDBFile: cacheFile}}
+
+	t.Run("Save", func(t *testing.T) {
+		testCacheRDB(t, cacheFile, "8de1", 1000)
+		// незавершённая запись предыдущей позиции не мешает сохранению
+		err := ioutil.WriteFile(r.cacheMetaFile()+".tmp", []byte("{"), 0644)
+		if err != nil {
+			t.Fatalf("write error: %v", err)
+		}
+		r.setReplication("9ab2", 2000)
+		err = r.saveCacheMeta()
+		if err != nil {
+			t.Fatalf("save error: %v", err)
+		}
+		if _, err := os.Stat(r.cacheMetaFile() + ".tmp"); !os.IsNotExist(err) {
+			t.Fatalf("expected renamed tmp file but actual %v", err)
+		}
+		meta, err := r.loadCacheMeta()
+		if err != nil {
+			t.Fatalf("load error: %v", err)
+		}
+		if meta.ReplicationID != "9ab2" || meta.Offset != 2000 {
+			t.Fatalf("unexpected meta %#v", meta)
+		}
+	})
+	t.Run("EmptyReplicationID", func(t *testing.T) {
+		testCacheRDB(t, cacheFile, "8de1", 1000)
+		err := r.deleteCacheMeta()
+		if err != nil {
+			t.Fatalf("delete error: %v", err)
+		}
+		r.setReplication("", 0)
+		err = r.saveCacheMeta()
+		if err != nil {
+			t.Fatalf("save error: %v", err)
+		}
+		if _, err := os.Stat(r.cacheMetaFile()); !os.IsNotExist(err) {
+			t.Fatalf("expected no meta file but actual %v", err)
+		}
+	})
+	t.Run("SizeMismatch", func(t *testing.T) {
+		testCacheRDB(t, cacheFile, "8de1", 1000)
+		file, err := os.OpenFile(cacheFile, os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			t.Fatalf("open error: %v", err)
+		}
+		_, err = file.Write([]byte{0})
+		file.Close() // nolint:errcheck
+		if err != nil {
+			t.Fatalf("write error: %v", err)
+		}
+		if _, err := r.loadCacheMeta(); err == nil {
+			t.Fatalf("expected size error")
+		}
+	})
+	t.Run("MissingCache", func(t *testing.T) {
+		testCacheRDB(t, cacheFile, "8de1", 1000)
+		err := os.Remove(cacheFile)
+		if err != nil {
+			t.Fatalf("remove error: %v", err)
+		}
+		if _, err := r.loadCacheMeta(); err == nil {
+			t.Fatalf("expected missing cache error")
+		}
+	})
+	for name, body := range map[string]string{
+		"CorruptJSON": `{"replication_id":`,
+		"NoID":        `{"offset":1000,"size":0}`,
+	} {
+		body := body
+		t.Run(name, func(t *testing.T) {
+			testCacheRDB(t, cacheFile, "8de1", 1000)
+			err := ioutil.WriteFile(r.cacheMetaFile(), []byte(body), 0644)
+			if err != nil {
+				t.Fatalf("write error: %v", err)
+			}
+			if _, err := r.loadCacheMeta(); err == nil {
+				t.Fatalf("expected error for %s", body)
+			}
+		})
+	}
+}
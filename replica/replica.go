@@ -5,11 +5,17 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/avito-tech/smart-redis-replication/backlog"
 	"github.com/avito-tech/smart-redis-replication/command"
+	"github.com/avito-tech/smart-redis-replication/rdb"
 	"github.com/avito-tech/smart-redis-replication/resp"
 	"github.com/avito-tech/smart-redis-replication/status"
 )
@@ -22,12 +28,40 @@ type replica struct {
 
 	conn resp.Conn
 
+	// writeLock защищает запись в соединение,
+	// подтверждения смещения отправляются из отдельной горутины
+	writeLock sync.Mutex
+
 	config Config
 	status struct {
 		// startDecodeRDB означает что декодирование RDB уже началось
 		startDecodeRDB bool
+
+		// startAck означает что отправка подтверждений смещения уже началась
+		startAck bool
+
+		// reuseCacheRDB означает что позиция репликации взята из кеша RDB
+		reuseCacheRDB bool
+	}
+
+	// replication это идентификатор и смещение репликации мастера
+	replication struct {
+		sync.RWMutex
+		id     string
+		offset int64
+
+		// ready означает что RDB получен полностью и с этой позиции
+		// можно продолжить репликацию
+		ready bool
 	}
 
+	// decoderCtx это родительский контекст декодера
+	decoderCtx context.Context
+
+	// shared означает что декодер и backlog переходят к следующей реплике
+	// при переподключении и не останавливаются вместе с репликацией
+	shared bool
+
 	// decoder это объект в котором происходит чтение rdb и backlog
 	decoder Decoder
 
@@ -43,14 +77,58 @@ func NewReplica(
 	r io.ReadWriteCloser,
 	config Config,
 ) Replica {
+	return newReplica(r, config)
+}
+
+// NewResumeReplica возвращает новую Replica которая продолжает работу
+// предыдущей реплики prev после переподключения: использует её backlog и
+// позицию репликации, а если сервер продолжит репликацию через +CONTINUE то
+// и её декодер, поэтому получатель не перезапускается.
+// Декодер не останавливается вместе с репликацией, а только вместе с ctx
+// или при полной синхронизации
+func NewResumeReplica(
+	ctx context.Context,
+	r io.ReadWriteCloser,
+	config Config,
+	prev Replica,
+) Replica {
+	if p, ok := prev.(*replica); ok && p != nil {
+		if config.ReplicationID == "" && p.ReplicationID() != "" {
+			config.ReplicationID = p.ReplicationID()
+			config.ReplicationOffset = p.Offset()
+		}
+		replica := newReplica(r, config)
+		replica.backlog = p.backlog
+		replica.decoder = p.decoder
+		replica.decoderCtx = ctx
+		replica.shared = true
+		return replica
+	}
+	replica := newReplica(r, config)
+	replica.decoderCtx = ctx
+	replica.shared = true
+	return replica
+}
+
+// newReplica возвращает новую replica
+func newReplica(
+	r io.ReadWriteCloser,
+	config Config,
+) *replica {
 	replica := &replica{
-		conn:    resp.New(r),
-		config:  config,
-		backlog: backlog.New(config.BacklogSize),
+		conn:       resp.New(r),
+		config:     config,
+		backlog:    backlog.New(config.BacklogSize),
+		decoderCtx: context.Background(),
 	}
 	if config.Debug {
 		_ = replica.conn.EnableDebug(config.DebugDumpDir)
 	}
+	replica.replication.id = config.ReplicationID
+	replica.replication.offset = config.ReplicationOffset
+	if config.ReuseCacheRDB && config.ReplicationID == "" {
+		replica.loadReplicationFromCache()
+	}
 	replica.ctx, replica.cancel = context.WithCancel(context.Background())
 	return replica
 }
@@ -60,6 +138,58 @@ func (r *replica) Backlog() *backlog.Backlog {
 	return r.backlog
 }
 
+// loadReplicationFromCache устанавливает позицию репликации сохранённую
+// вместе с кешем RDB, если кеш отсутствует или повреждён то ничего не делает
+func (r *replica) loadReplicationFromCache() {
+	meta, err := r.loadCacheMeta()
+	if err != nil {
+		return
+	}
+	r.replication.id = meta.ReplicationID
+	r.replication.offset = meta.Offset
+	r.status.reuseCacheRDB = true
+}
+
+// ReplicationID возвращает идентификатор репликации мастера,
+// до окончания передачи RDB возвращает пустую строку
+func (r *replica) ReplicationID() string {
+	r.replication.RLock()
+	defer r.replication.RUnlock()
+	if !r.replication.ready {
+		return ""
+	}
+	return r.replication.id
+}
+
+// setReady отмечает что с текущей позиции можно продолжить репликацию
+func (r *replica) setReady() {
+	r.replication.Lock()
+	defer r.replication.Unlock()
+	r.replication.ready = true
+}
+
+// Offset возвращает смещение репликации до которого данные прочитаны
+func (r *replica) Offset() int64 {
+	r.replication.RLock()
+	defer r.replication.RUnlock()
+	return r.replication.offset
+}
+
+// addOffset увеличивает смещение репликации на n байт
+func (r *replica) addOffset(n int64) {
+	r.replication.Lock()
+	defer r.replication.Unlock()
+	r.replication.offset += n
+}
+
+// setReplication устанавливает идентификатор и смещение репликации
+func (r *replica) setReplication(id string, offset int64) {
+	r.replication.Lock()
+	defer r.replication.Unlock()
+	r.replication.id = id
+	r.replication.offset = offset
+}
+
 // SetCacheRDB устанавливает статус кеширования RDB на диск
 // status = true - кешировать
 func (r *replica) SetCacheRDB(status bool) {
@@ -78,8 +208,131 @@ func (r *replica) sendSync() error {
 	return r.sendRaw("SYNC")
 }
 
+// sendPsync отправляет сообщение PSYNC с идентификатором и смещением
+// репликации, если идентификатор неизвестен то запрашивается полная
+// синхронизация
+func (r *replica) sendPsync() error {
+	r.replication.RLock()
+	id, offset := r.replication.id, r.replication.offset
+	r.replication.RUnlock()
+
+	if id == "" {
+		return r.sendRaw("PSYNC ? -1")
+	}
+	return r.sendRaw(fmt.Sprintf("PSYNC %s %d", id, offset+1))
+}
+
+// sync запускает репликацию через PSYNC,
+// если сервер не поддерживает PSYNC то используется SYNC,
+// возвращает true если сервер продолжил репликацию без передачи RDB
+func (r *replica) sync() (continued bool, err error) {
+	err = r.sendPsync()
+	if err != nil {
+		return false, err
+	}
+	reply, err := r.conn.ReadReply()
+	if serverErr, ok := err.(resp.ServerError); ok {
+		if isTemporaryPsyncError(serverErr) {
+			return false, fmt.Errorf("psync error: %v", serverErr)
+		}
+		r.setReplication("", 0)
+		r.SendStatus(status.FullResync)
+		return false, r.sendSync()
+	}
+	if err != nil {
+		return false, err
+	}
+	return r.parsePsyncReply(reply)
+}
+
+// parsePsyncReply разбирает ответ на PSYNC:
+//
+//	+FULLRESYNC <replid> <offset> - далее будет передан RDB
+//	+CONTINUE [<replid>] - далее продолжится поток команд
+func (r *replica) parsePsyncReply(reply string) (continued bool, err error) {
+	fields := strings.Fields(reply)
+	if len(fields) == 0 {
+		return false, errors.New("empty psync reply")
+	}
+	switch strings.ToUpper(fields[0]) {
+	case "FULLRESYNC":
+		if len(fields) != 3 {
+			return false, fmt.Errorf("unexpected psync reply %q", reply)
+		}
+		offset, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return false, fmt.Errorf("psync offset error: %v", err)
+		}
+		r.setReplication(fields[1], offset)
+		r.SendStatus(status.FullResync)
+		return false, nil
+	case "CONTINUE":
+		if len(fields) > 1 {
+			r.replication.Lock()
+			r.replication.id = fields[1]
+			r.replication.Unlock()
+		}
+		r.SendStatus(status.ContinueSync)
+		return true, nil
+	}
+	return false, fmt.Errorf("unexpected psync reply %q", reply)
+}
+
+// isTemporaryPsyncError возвращает true если сервер временно не может
+// выполнить PSYNC и запрос нужно повторить позже
+func isTemporaryPsyncError(err resp.ServerError) bool {
+	message := err.Error()
+	return strings.HasPrefix(message, "NOMASTERLINK") ||
+		strings.HasPrefix(message, "LOADING")
+}
+
+// sendAck отправляет серверу подтверждение обработанного смещения
+func (r *replica) sendAck() error {
+	return r.sendRaw(fmt.Sprintf("REPLCONF ACK %d", r.Offset()))
+}
+
+// ackInterval возвращает интервал отправки подтверждений смещения
+func (r *replica) ackInterval() time.Duration {
+	if r.config.AckInterval > 0 {
+		return r.config.AckInterval
+	}
+	return DefaultAckInterval
+}
+
+// startAck запускает периодическую отправку подтверждений смещения
+func (r *replica) startAck() {
+	if r.status.startAck {
+		return
+	}
+	r.status.startAck = true
+	go r.ack()
+}
+
+// ack периодически отправляет серверу REPLCONF ACK до завершения репликации,
+// первое подтверждение отправляется сразу: после передачи RDB без записи на
+// диск сервер ждёт его чтобы начать передачу потока команд
+func (r *replica) ack() {
+	if err := r.sendAck(); err != nil {
+		return
+	}
+	ticker := time.NewTicker(r.ackInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := r.sendAck(); err != nil {
+			return
+		}
+	}
+}
+
 // sendRaw отправляет сообщение в сокет и не читает ответ
 func (r *replica) sendRaw(data string) error {
+	r.writeLock.Lock()
+	defer r.writeLock.Unlock()
 	_, err := r.conn.Write([]byte(fmt.Sprintf("%s\r\n", data)))
 	return err
 }
@@ -94,23 +347,60 @@ func (r *replica) Do(consumer Consumer) (err error) {
 	}
 	r.consumer = consumer
 
-	r.decoder, err = NewDecoder(
-		r.backlog,
-		r.config,
-	)
+	r.SendStatus(status.StartSync)
+	continued, err := r.sync()
 	if err != nil {
 		return err
 	}
+	if continued {
+		// RDB не будет передан, а данные кеша RDB получатель обработал
+		// до перезапуска, поэтому читается только поток команд
+		r.setReady()
+		r.status.startDecodeRDB = true
+		r.config.ReadRDB = false
+		if r.decoder != nil && r.decoder.Started() && r.decoder.Status() {
+			// декодер предыдущего соединения продолжает работу
+			r.startAck()
+			return r.decode()
+		}
+	}
 
-	r.SendStatus(status.StartSync)
-	err = r.sendSync()
+	err = r.newDecoder(continued)
 	if err != nil {
 		return err
 	}
+	if continued {
+		if r.status.reuseCacheRDB {
+			r.SendStatus(status.ReuseCacheRDB)
+		}
+		r.startDecoder()
+		r.startAck()
+	}
 	err = r.decode()
 	return err
 }
 
+// newDecoder создаёт новый декодер, останавливая декодер предыдущего
+// соединения, при полной синхронизации команды предыдущего соединения
+// удаляются из backlog так как они уже содержатся в новом RDB
+func (r *replica) newDecoder(continued bool) (err error) {
+	if r.decoder != nil {
+		r.decoder.Cancel(&err)
+		if r.decoder.Started() {
+			<-r.decoder.Stopped()
+		}
+	}
+	if !continued {
+		r.backlog.Reset()
+	}
+	r.decoder, err = NewDecoderContext(
+		r.decoderCtx,
+		r.backlog,
+		r.config,
+	)
+	return err
+}
+
 // Done возвращает канал для ожидания завершения репликации
 func (r *replica) Done() <-chan struct{} {
 	return r.ctx.Done()
@@ -134,7 +424,7 @@ func (r *replica) Err() error {
 // Cancel прекращает процесс репликации
 func (r *replica) Cancel(err *error) {
 	r.err = *err
-	if r.decoder != nil {
+	if r.decoder != nil && !r.shared {
 		r.decoder.Cancel(err)
 	}
 	if r.consumer != nil {
@@ -146,10 +436,12 @@ func (r *replica) Cancel(err *error) {
 
 func (r *replica) decode() (err error) {
 	var cmd command.Command
+	var offset int64
 	for {
 		if !r.decoder.Status() {
 			return r.decoder.Err()
 		}
+		offset = r.conn.Offset()
 		cmd, err = r.conn.Command()
 
 		if err != nil {
@@ -157,22 +449,47 @@ func (r *replica) decode() (err error) {
 		}
 		switch cmd.Type() {
 		case command.Empty:
+			if r.conn.Offset() == offset {
+				// Command возвращает пустую команду без ошибки в конце
+				// потока, соединение закрыто и нужно переподключение
+				return io.EOF
+			}
 			continue
 		case command.RDB:
-			r.consumer.ReplicaStatus(status.StartCacheRDB)
-
 			if r.status.startDecodeRDB {
 				return errors.New("unexpected RDB command")
 			}
 			r.status.startDecodeRDB = true
 
-			err = r.cacheRDB(cmd)
-			r.consumer.ReplicaStatus(status.StopCacheRDB)
+			if r.config.StreamRDB {
+				err = r.streamRDB(cmd)
+			} else {
+				r.consumer.ReplicaStatus(status.StartCacheRDB)
+				err = r.cacheRDB(cmd)
+				if err == nil {
+					err = r.saveCacheMeta()
+				}
+				r.consumer.ReplicaStatus(status.StopCacheRDB)
+				if err == nil {
+					r.decoder.SetFileRDB(r.config.CacheRDBFile) //nolint:errcheck
+					r.startDecoder()
+				}
+			}
 			if err == nil {
-				r.decoder.SetFileRDB(r.config.CacheRDBFile) //nolint:errcheck
-				r.startDecoder()
+				r.setReady()
+				r.startAck()
+			}
+		case command.Replconf:
+			if cmd.IsGetAck() {
+				err = r.sendAck()
 			}
 		default:
+			if r.config.AbsoluteExpiry {
+				cmd, err = cmd.AbsoluteExpiry(time.Now())
+				if err != nil {
+					return err
+				}
+			}
 			if r.consumer.CheckCommand(cmd) {
 				err = r.backlog.Add(cmd)
 			}
@@ -180,9 +497,73 @@ func (r *replica) decode() (err error) {
 		if err != nil {
 			return err
 		}
+		if cmd.Type() != command.RDB {
+			// смещение репликации учитывает только поток команд без RDB
+			r.addOffset(r.conn.Offset() - offset)
+		}
 	}
 }
 
+// rdbReader возвращает io.Reader с содержимым RDB из соединения
+func (r *replica) rdbReader(cmd command.Command) (io.Reader, error) {
+	mark, diskless := cmd.RDBEOFMark()
+	if diskless {
+		return resp.NewEOFMarkReader(r.conn, []byte(mark))
+	}
+	size, err := cmd.ConvertToRDB()
+	if err != nil {
+		return nil, err
+	}
+	return io.LimitReader(r.conn, size), nil
+}
+
+// streamRDB декодирует RDB напрямую из соединения без записи в кеш,
+// если включён CacheRDB то RDB дополнительно копируется в CacheRDBFile.
+// Поток команд продолжает читаться в backlog после того как декодер
+// дочитает RDB
+func (r *replica) streamRDB(cmd command.Command) (err error) {
+	rdbReader, err := r.rdbReader(cmd)
+	if err != nil {
+		return err
+	}
+	if r.config.CacheRDB {
+		var file *os.File
+		file, err = r.createCacheRDB()
+		if err != nil {
+			return err
+		}
+		defer file.Close() // nolint:errcheck
+		rdbReader = io.TeeReader(rdbReader, file)
+		defer func() {
+			if err == nil {
+				err = r.saveCacheMeta()
+			}
+		}()
+	}
+
+	if r.config.ReadRDB {
+		err = r.decoder.SetRDBDecoder(rdb.NewDecoder(rdbReader, r.config.RDBOptions...))
+		if err != nil {
+			return err
+		}
+		r.startDecoder()
+		select {
+		case <-r.decoder.RDBDone():
+		case <-r.decoder.Done():
+			return r.decoder.Err()
+		}
+	} else {
+		r.startDecoder()
+	}
+
+	// дочитываем остаток RDB чтобы поток команд начался с правильной позиции
+	_, err = io.Copy(ioutil.Discard, rdbReader)
+	if err != nil {
+		return fmt.Errorf("read rdb error: %v", err)
+	}
+	return nil
+}
+
 // createRDBDir создаёт директорию для хренения RDB кеша
 func (r *replica) createRDBDir() error {
 	dir := path.Dir(r.config.CacheRDBFile)
@@ -197,19 +578,15 @@ func (r *replica) createRDBDir() error {
 
 // cacheRDB сохраняет RDB в файл, предварительно удаляя старый кеш
 func (r *replica) cacheRDB(cmd command.Command) error {
-	size, err := cmd.ConvertToRDB()
-	if err != nil {
-		return err
+	mark, diskless := cmd.RDBEOFMark()
+	if diskless {
+		return r.cacheDisklessRDB([]byte(mark))
 	}
-	err = r.createRDBDir()
-	if err != nil {
-		return err
-	}
-	err = r.deleteCacheRDB()
+	size, err := cmd.ConvertToRDB()
 	if err != nil {
 		return err
 	}
-	file, err := os.Create(r.config.CacheRDBFile)
+	file, err := r.createCacheRDB()
 	if err != nil {
 		return err
 	}
@@ -226,8 +603,45 @@ func (r *replica) cacheRDB(cmd command.Command) error {
 	return err
 }
 
-// deleteCacheRDB удаляет кеш RDB
+// cacheDisklessRDB сохраняет в файл RDB переданный без указания размера,
+// данные читаются до метки окончания
+func (r *replica) cacheDisklessRDB(mark []byte) error {
+	rdbReader, err := resp.NewEOFMarkReader(r.conn, mark)
+	if err != nil {
+		return err
+	}
+	file, err := r.createCacheRDB()
+	if err != nil {
+		return err
+	}
+	defer file.Close() // nolint:errcheck
+
+	_, err = io.Copy(file, rdbReader)
+	if err != nil {
+		return fmt.Errorf("save diskless rdb cache error: %v", err)
+	}
+	return nil
+}
+
+// createCacheRDB создаёт новый файл кеша RDB, предварительно удаляя старый
+func (r *replica) createCacheRDB() (*os.File, error) {
+	err := r.createRDBDir()
+	if err != nil {
+		return nil, err
+	}
+	err = r.deleteCacheRDB()
+	if err != nil {
+		return nil, err
+	}
+	return os.Create(r.config.CacheRDBFile)
+}
+
+// deleteCacheRDB удаляет кеш RDB вместе с позицией репликации
 func (r *replica) deleteCacheRDB() error {
+	err := r.deleteCacheMeta()
+	if err != nil {
+		return err
+	}
 	if _, err := os.Stat(r.config.CacheRDBFile); err == nil {
 		return os.Remove(r.config.CacheRDBFile)
 	}
@@ -0,0 +1,628 @@
+package replica
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/avito-tech/smart-redis-replication/command"
+	"github.com/avito-tech/smart-redis-replication/data"
+	"github.com/avito-tech/smart-redis-replication/rdb"
+	"github.com/avito-tech/smart-redis-replication/status"
+)
+
+// testTimeout это максимальное время ожидания ответа в тестах
+const testTimeout = 5 * time.Second
+
+// TestSync проверяет запрос PSYNC и разбор ответа сервера
+// nolint:gocyclo
+func TestSync(t *testing.T) {
+	position := Config{ReplicationID: "8de1", ReplicationOffset: 1000}
+	tests := map[string]struct {
+		config    Config
+		reply     string
+		commands  []string
+		continued bool
+		err       bool
+		id        string
+		offset    int64
+	}{
+		"FullResync": {
+			config:   Config{},
+			reply:    "+FULLRESYNC 9ab2 2000",
+			commands: []string{"PSYNC ? -1"},
+			id:       "9ab2",
+			offset:   2000,
+		},
+		"FullResyncWithPosition": {
+			config:   position,
+			reply:    "+FULLRESYNC 9ab2 2000",
+			commands: []string{"PSYNC 8de1 1001"},
+			id:       "9ab2",
+			offset:   2000,
+		},
+		"Continue": {
+			config:    position,
+			reply:     "+CONTINUE",
+			commands:  []string{"PSYNC 8de1 1001"},
+			continued: true,
+			id:        "8de1",
+			offset:    1000,
+		},
+		"ContinueNewID": {
+			config:    position,
+			reply:     "+CONTINUE 9ab2",
+			commands:  []string{"PSYNC 8de1 1001"},
+			continued: true,
+			id:        "9ab2",
+			offset:    1000,
+		},
+		"SyncFallback": {
+			config:   position,
+			reply:    "-ERR unknown command 'PSYNC'",
+			commands: []string{"PSYNC 8de1 1001", "SYNC"},
+		},
+		"Temporary": {
+			config:   position,
+			reply:    "-NOMASTERLINK Can't SYNC while not connected with my master",
+			commands: []string{"PSYNC 8de1 1001"},
+			err:      true,
+			id:       "8de1",
+			offset:   1000,
+		},
+		"BadOffset": {
+			config:   Config{},
+			reply:    "+FULLRESYNC 9ab2 x",
+			commands: []string{"PSYNC ? -1"},
+			err:      true,
+		},
+		"Unexpected": {
+			config:   Config{},
+			reply:    "+OK",
+			commands: []string{"PSYNC ? -1"},
+			err:      true,
+		},
+	}
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			r, master := testReplica(t, test.config)
+			defer master.conn.Close() // nolint:errcheck
+			type result struct {
+				continued bool
+				err       error
+			}
+			done := make(chan result, 1)
+			go func() {
+				continued, err := r.sync()
+				done <- result{continued, err}
+			}()
+			master.expect(test.commands[0])
+			master.write(test.reply + "\r\n")
+			res := <-done
+			r.conn.Close() // nolint:errcheck
+
+			for _, expected := range test.commands[1:] {
+				master.expect(expected)
+			}
+			master.expectClosed()
+			if test.err != (res.err != nil) {
+				t.Fatalf("expected error %v but actual %v", test.err, res.err)
+			}
+			if res.continued != test.continued {
+				t.Fatalf("expected continued %v but actual %v", test.continued, res.continued)
+			}
+			if r.replication.id != test.id || r.replication.offset != test.offset {
+				t.Fatalf("expected %q %d but actual %q %d",
+					test.id,
+					test.offset,
+					r.replication.id,
+					r.replication.offset,
+				)
+			}
+		})
+	}
+}
+
+// команды потока репликации и их размер в байтах
+const (
+	testPing   = "*1\r\n$4\r\nPING\r\n"
+	testGetAck = "*3\r\n$8\r\nREPLCONF\r\n$6\r\nGETACK\r\n$1\r\n*\r\n"
+	testSet    = "*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$1\r\nv\r\n"
+)
+
+// TestOffsetAck проверяет подсчёт смещения репликации и подтверждения
+// REPLCONF ACK: сразу после начала репликации, в ответ на GETACK и
+// периодически
+func TestOffsetAck(t *testing.T) {
+	position := Config{
+		ReplicationID:     "8de1",
+		ReplicationOffset: 100,
+		BacklogSize:       100,
+	}
+	t.Run("GetAck", func(t *testing.T) {
+		config := position
+		config.AckInterval = time.Hour
+		r, master := testReplica(t, config)
+		defer master.conn.Close() // nolint:errcheck
+		consumer := newTestConsumer()
+		done := testDo(r, consumer)
+
+		master.expect("PSYNC 8de1 101")
+		master.write("+CONTINUE\r\n")
+		master.expect("REPLCONF ACK 100")
+
+		// пустая строка не входит в поток репликации
+		offset := 100 + len(testPing)
+		master.write("\n" + testPing + testGetAck)
+		master.expect(fmt.Sprintf("REPLCONF ACK %d", offset))
+
+		// GETACK подтверждает смещение без учёта самого GETACK
+		offset += len(testGetAck) + len(testSet)
+		master.write(testSet + testGetAck)
+		master.expect(fmt.Sprintf("REPLCONF ACK %d", offset))
+		consumer.expectCommand(t, "SET k v")
+
+		master.conn.Close() // nolint:errcheck
+		testDone(t, done)
+		if r.Offset() != int64(offset+len(testGetAck)) {
+			t.Fatalf("expected offset %d but actual %d", offset+len(testGetAck), r.Offset())
+		}
+	})
+	t.Run("Interval", func(t *testing.T) {
+		config := position
+		config.AckInterval = 10 * time.Millisecond
+		r, master := testReplica(t, config)
+		defer master.conn.Close() // nolint:errcheck
+		done := testDo(r, newTestConsumer())
+
+		master.expect("PSYNC 8de1 101")
+		master.write("+CONTINUE\r\n")
+		master.expect("REPLCONF ACK 100")
+		master.write(testPing)
+		expected := fmt.Sprintf("REPLCONF ACK %d", 100+len(testPing))
+		for {
+			cmd, ok := master.next()
+			if !ok {
+				t.Fatalf("expected %q", expected)
+			}
+			if cmd == expected {
+				break
+			}
+			if cmd != "REPLCONF ACK 100" {
+				t.Fatalf("unexpected command %q", cmd)
+			}
+		}
+
+		master.conn.Close() // nolint:errcheck
+		testDone(t, done)
+	})
+}
+
+// TestConnectionClosed проверяет что закрытие соединения мастером
+// завершает репликацию с io.EOF, а не ожиданием следующей команды
+func TestConnectionClosed(t *testing.T) {
+	r, master := testReplica(t, Config{
+		ReplicationID:     "8de1",
+		ReplicationOffset: 100,
+		BacklogSize:       100,
+		AckInterval:       time.Hour,
+	})
+	defer master.conn.Close() // nolint:errcheck
+	done := testDo(r, newTestConsumer())
+
+	master.expect("PSYNC 8de1 101")
+	master.write("+CONTINUE\r\n")
+	master.expect("REPLCONF ACK 100")
+
+	// пустая строка тоже пустая команда, но поток не закончен
+	master.write("\n")
+	master.conn.Close() // nolint:errcheck
+	select {
+	case err := <-done:
+		if err != io.EOF {
+			t.Fatalf("expected %v but actual %v", io.EOF, err)
+		}
+	case <-time.After(testTimeout):
+		t.Fatalf("timeout waiting for replica")
+	}
+}
+
+// TestAbsoluteExpiry проверяет замену относительного времени жизни
+// абсолютным при получении команды
+func TestAbsoluteExpiry(t *testing.T) {
+	r, master := testReplica(t, Config{
+		ReplicationID:     "8de1",
+		ReplicationOffset: 100,
+		BacklogSize:       100,
+		AckInterval:       time.Hour,
+		AbsoluteExpiry:    true,
+	})
+	defer master.conn.Close() // nolint:errcheck
+	consumer := newTestConsumer()
+	done := testDo(r, consumer)
+
+	master.expect("PSYNC 8de1 101")
+	master.write("+CONTINUE\r\n")
+	master.expect("REPLCONF ACK 100")
+
+	before := time.Now()
+	master.write("*3\r\n$6\r\nEXPIRE\r\n$1\r\nk\r\n$2\r\n10\r\n")
+	select {
+	case cmd := <-consumer.commands:
+		if cmd.Type() != command.PexpireAt {
+			t.Fatalf("expected PEXPIREAT but actual %q", cmd.Args())
+		}
+		expiry, _, err := cmd.ConvertToExpiry(time.Time{})
+		if err != nil {
+			t.Fatalf("convert to expiry error: %v", err)
+		}
+		at := expiry.Time()
+		if at.Before(before.Add(10*time.Second-time.Millisecond)) ||
+			at.After(time.Now().Add(10*time.Second)) {
+			t.Fatalf("unexpected expiry %s", at)
+		}
+	case <-time.After(testTimeout):
+		t.Fatalf("timeout waiting for command")
+	}
+
+	master.conn.Close() // nolint:errcheck
+	testDone(t, done)
+}
+
+// TestDisklessRDB проверяет кеширование RDB переданного с меткой
+// окончания, метка приходит по частям вместе с первой командой потока
+func TestDisklessRDB(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replica")
+	if err != nil {
+		t.Fatalf("temp dir error: %v", err)
+	}
+	defer os.RemoveAll(dir) // nolint:errcheck
+
+	r, master := testReplica(t, Config{
+		ReadRDB:      true,
+		CacheRDBFile: path.Join(dir, "dump.rdb"),
+		BacklogSize:  100,
+		AckInterval:  time.Hour,
+	})
+	defer master.conn.Close() // nolint:errcheck
+	consumer := newTestConsumer()
+	done := testDo(r, consumer)
+
+	master.expect("PSYNC ? -1")
+	master.write("+FULLRESYNC 8de1 1000\r\n")
+	mark := strings.Repeat("0123456789", 4)
+	body := testRDB(t, data.NewString("key", "value"))
+	master.write("$EOF:" + mark + "\r\n" + string(body) + mark[:20])
+	master.write(mark[20:] + testSet + testGetAck)
+	master.expectAck(1000 + len(testSet))
+
+	consumer.expectKey(t, "key")
+	consumer.expectCommand(t, "SET k v")
+	if r.ReplicationID() != "8de1" {
+		t.Fatalf("expected replication id %q but actual %q", "8de1", r.ReplicationID())
+	}
+	cache, err := ioutil.ReadFile(path.Join(dir, "dump.rdb"))
+	if err != nil {
+		t.Fatalf("read cache error: %v", err)
+	}
+	if !bytes.Equal(cache, body) {
+		t.Fatalf("expected cache %q but actual %q", body, cache)
+	}
+
+	master.conn.Close() // nolint:errcheck
+	testDone(t, done)
+}
+
+// TestStreamRDB проверяет что ключи RDB передаются получателю до окончания
+// передачи RDB, а команды после RDB попадают в backlog
+func TestStreamRDB(t *testing.T) {
+	r, master := testReplica(t, Config{
+		ReadRDB:     true,
+		StreamRDB:   true,
+		BacklogSize: 100,
+		AckInterval: time.Hour,
+	})
+	defer master.conn.Close() // nolint:errcheck
+	consumer := newTestConsumer()
+	done := testDo(r, consumer)
+
+	master.expect("PSYNC ? -1")
+	master.write("+FULLRESYNC 8de1 1000\r\n")
+	body := testRDB(t, data.NewString("first", "a"), data.NewString("second", "b"))
+	// граница перед кодом типа и длиной названия второго ключа
+	split := bytes.Index(body, []byte("second")) - 2
+	master.write(fmt.Sprintf("$%d\r\n", len(body)) + string(body[:split]))
+
+	consumer.expectKey(t, "first")
+	if r.ReplicationID() != "" {
+		t.Fatalf("expected empty replication id before the end of RDB")
+	}
+
+	master.write(string(body[split:]) + testSet + testGetAck)
+	consumer.expectKey(t, "second")
+	consumer.expectCommand(t, "SET k v")
+	master.expectAck(1000 + len(testSet))
+	if r.ReplicationID() != "8de1" {
+		t.Fatalf("expected replication id %q but actual %q", "8de1", r.ReplicationID())
+	}
+
+	master.conn.Close() // nolint:errcheck
+	testDone(t, done)
+}
+
+// TestContinueFromCache проверяет что после перезапуска с кешем RDB и
+// ответа +CONTINUE RDB не читается повторно, а поток команд продолжается
+// с сохранённого смещения
+func TestContinueFromCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replica")
+	if err != nil {
+		t.Fatalf("temp dir error: %v", err)
+	}
+	defer os.RemoveAll(dir) // nolint:errcheck
+	cacheFile := path.Join(dir, "dump.rdb")
+	testCacheRDB(t, cacheFile, "8de1", 1000)
+
+	r, master := testReplica(t, Config{
+		ReadRDB:       true,
+		CacheRDB:      true,
+		ReuseCacheRDB: true,
+		CacheRDBFile:  cacheFile,
+		BacklogSize:   100,
+		AckInterval:   time.Hour,
+	})
+	defer master.conn.Close() // nolint:errcheck
+	consumer := newTestConsumer()
+	done := testDo(r, consumer)
+
+	master.expect("PSYNC 8de1 1001")
+	master.write("+CONTINUE\r\n")
+	master.write(testSet + testGetAck)
+	consumer.expectCommand(t, "SET k v")
+	master.expectAck(1000 + len(testSet))
+
+	select {
+	case key := <-consumer.keys:
+		t.Fatalf("unexpected key %q from cached RDB", key.Name())
+	default:
+	}
+	if !consumer.hasStatus(status.ReuseCacheRDB) || consumer.hasStatus(status.StartReadRDB) {
+		t.Fatalf("unexpected statuses %q", consumer.statuses)
+	}
+
+	master.conn.Close() // nolint:errcheck
+	testDone(t, done)
+}
+
+// testCacheRDB записывает кеш RDB с позицией репликации
+func testCacheRDB(t *testing.T, filename, id string, offset int64) {
+	body := testRDB(t, data.NewString("cached", "v"))
+	err := ioutil.WriteFile(filename, body, 0644)
+	if err != nil {
+		t.Fatalf("write cache error: %v", err)
+	}
+	r := &replica{config: Config{CacheRDBFile: filename}}
+	r.setReplication(id, offset)
+	err = r.saveCacheMeta()
+	if err != nil {
+		t.Fatalf("save cache meta error: %v", err)
+	}
+}
+
+// testRDB возвращает RDB с ключами
+func testRDB(t *testing.T, keys ...data.Key) []byte {
+	var body bytes.Buffer
+	encoder := rdb.NewEncoder(&body)
+	for _, key := range keys {
+		if err := encoder.Key(key); err != nil {
+			t.Fatalf("encode error: %v", err)
+		}
+	}
+	if err := encoder.SetEOF(rdb.NewEOF()); err != nil {
+		t.Fatalf("encode error: %v", err)
+	}
+	return body.Bytes()
+}
+
+// testDo запускает репликацию и возвращает канал с её результатом
+func testDo(r *replica, consumer Consumer) <-chan error {
+	done := make(chan error, 1)
+	go func() {
+		done <- r.Do(consumer)
+	}()
+	return done
+}
+
+// testDone ожидает завершения репликации после закрытия соединения
+func testDone(t *testing.T, done <-chan error) {
+	t.Helper()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatalf("expected connection error")
+		}
+	case <-time.After(testTimeout):
+		t.Fatalf("timeout waiting for replica")
+	}
+}
+
+// testReplica возвращает replica соединённую с тестовым мастером
+func testReplica(t *testing.T, config Config) (*replica, *testMaster) {
+	client, server := net.Pipe()
+	return newReplica(client, config), newTestMaster(t, server)
+}
+
+// testMaster это сторона мастера в тестах: читает построчные команды
+// реплики и отправляет заранее заданные данные
+type testMaster struct {
+	t        *testing.T
+	conn     net.Conn
+	commands chan string
+}
+
+// newTestMaster возвращает testMaster и начинает читать команды реплики
+func newTestMaster(t *testing.T, conn net.Conn) *testMaster {
+	m := &testMaster{
+		t:        t,
+		conn:     conn,
+		commands: make(chan string, 1024),
+	}
+	go func() {
+		defer close(m.commands)
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			m.commands <- strings.TrimRight(line, "\r\n")
+		}
+	}()
+	return m
+}
+
+// next возвращает следующую команду реплики
+func (m *testMaster) next() (string, bool) {
+	m.t.Helper()
+	select {
+	case cmd, ok := <-m.commands:
+		return cmd, ok
+	case <-time.After(testTimeout):
+		m.t.Fatalf("timeout waiting for replica command")
+	}
+	return "", false
+}
+
+// expect проверяет следующую команду реплики
+func (m *testMaster) expect(expected string) {
+	m.t.Helper()
+	cmd, ok := m.next()
+	if !ok || cmd != expected {
+		m.t.Fatalf("expected command %q but actual %q", expected, cmd)
+	}
+}
+
+// expectClosed проверяет что реплика закрыла соединение не отправив
+// других команд
+func (m *testMaster) expectClosed() {
+	m.t.Helper()
+	if cmd, ok := m.next(); ok {
+		m.t.Fatalf("expected closed connection but actual command %q", cmd)
+	}
+}
+
+// expectAck пропускает подтверждения меньших смещений и проверяет
+// что реплика подтвердила смещение offset
+func (m *testMaster) expectAck(offset int) {
+	m.t.Helper()
+	for {
+		cmd, ok := m.next()
+		if !ok {
+			m.t.Fatalf("expected ack %d but connection closed", offset)
+		}
+		var actual int
+		_, err := fmt.Sscanf(cmd, "REPLCONF ACK %d", &actual)
+		if err != nil || actual > offset {
+			m.t.Fatalf("expected ack %d but actual %q", offset, cmd)
+		}
+		if actual == offset {
+			return
+		}
+	}
+}
+
+// write отправляет данные реплике
+func (m *testMaster) write(data string) {
+	m.t.Helper()
+	m.conn.SetWriteDeadline(time.Now().Add(testTimeout)) // nolint:errcheck
+	if _, err := m.conn.Write([]byte(data)); err != nil {
+		m.t.Fatalf("master write error: %v", err)
+	}
+}
+
+// testConsumer собирает ключи, команды и статусы репликации
+type testConsumer struct {
+	keys     chan data.Key
+	commands chan command.Command
+
+	mu       sync.Mutex
+	statuses []status.Status
+}
+
+// newTestConsumer возвращает новый testConsumer
+func newTestConsumer() *testConsumer {
+	return &testConsumer{
+		keys:     make(chan data.Key, 1024),
+		commands: make(chan command.Command, 1024),
+	}
+}
+
+func (c *testConsumer) Key(key data.Key) error {
+	c.keys <- key
+	return nil
+}
+
+func (c *testConsumer) Command(cmd command.Command) error {
+	c.commands <- cmd
+	return nil
+}
+
+func (c *testConsumer) CheckCommand(cmd command.Command) bool {
+	return cmd.Type() != command.Ping
+}
+
+func (c *testConsumer) ReplicaStatus(s status.Status) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.statuses = append(c.statuses, s)
+	return nil
+}
+
+func (c *testConsumer) Cancel(err *error) {}
+
+// expectKey проверяет название следующего ключа
+func (c *testConsumer) expectKey(t *testing.T, expected string) {
+	t.Helper()
+	select {
+	case key := <-c.keys:
+		if key.Name() != expected {
+			t.Fatalf("expected key %q but actual %q", expected, key.Name())
+		}
+	case <-time.After(testTimeout):
+		t.Fatalf("timeout waiting for key %q", expected)
+	}
+}
+
+// hasStatus возвращает true если получатель получил статус s
+func (c *testConsumer) hasStatus(s status.Status) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, actual := range c.statuses {
+		if actual == s {
+			return true
+		}
+	}
+	return false
+}
+
+// expectCommand проверяет следующую команду полученную из backlog
+func (c *testConsumer) expectCommand(t *testing.T, expected string) {
+	t.Helper()
+	select {
+	case cmd := <-c.commands:
+		if actual := strings.Join(cmd.Args(), " "); actual != expected {
+			t.Fatalf("expected command %q but actual %q", expected, actual)
+		}
+	case <-time.After(testTimeout):
+		t.Fatalf("timeout waiting for command %q", expected)
+	}
+}
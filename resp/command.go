@@ -3,11 +3,14 @@ package resp
 import (
 	"fmt"
 	"io"
+	"strconv"
+	"strings"
 
 	"github.com/avito-tech/smart-redis-replication/command"
 )
 
-// Command читает и возвращает следующую команду
+// Command читает и возвращает следующую команду,
+// в конце потока возвращается пустая команда без ошибки
 func (r *reader) Command() (command.Command, error) {
 	opcode, err := r.ReadOpcode()
 	if err != nil {
@@ -42,8 +45,25 @@ func (r *reader) ReadLFCommand() (command.Command, error) {
 	return command.Command{}, nil
 }
 
+// ReadRDBCommand читает заголовок RDB: размер ("$<size>") или
+// метку окончания при передаче без записи на диск ("$EOF:<mark>")
 func (r *reader) ReadRDBCommand() (command.Command, error) {
-	size, err := r.ReadInteger()
+	line, err := r.ReadSimpleString()
+	if err != nil {
+		return command.Command{}, err
+	}
+	if strings.HasPrefix(line, command.RDBEOFMarkPrefix) {
+		mark := strings.TrimPrefix(line, command.RDBEOFMarkPrefix)
+		if len(mark) != RDBEOFMarkLength {
+			return command.Command{}, fmt.Errorf(
+				"expected rdb eof mark length %d but actual %d",
+				RDBEOFMarkLength,
+				len(mark),
+			)
+		}
+		return command.New([]string{"rdb", line}), nil
+	}
+	size, err := strconv.ParseInt(line, 10, 64)
 	if err != nil {
 		return command.Command{}, err
 	}
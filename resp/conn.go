@@ -8,7 +8,7 @@ import (
 // conn это коннект к redis по протоколу RESP
 type conn struct {
 	close io.Closer
-	Reader
+	*reader
 	Writer
 }
 
@@ -16,7 +16,7 @@ type conn struct {
 func New(r io.ReadWriteCloser) Conn {
 	return &conn{
 		close:  r,
-		Reader: NewReader(bufio.NewReader(r)),
+		reader: newReader(bufio.NewReader(r)),
 		Writer: NewWriter(r),
 	}
 }
@@ -10,6 +10,7 @@ import (
 // Read это обёртка над bufio.Read для записи дампа
 func (r *reader) Read(buf []byte) (int, error) {
 	n, err := r.Reader.Read(buf)
+	r.offset += int64(n)
 	if r.debug && r.dump.start {
 		r.dump.buf.Write(buf)
 	}
@@ -19,6 +20,9 @@ func (r *reader) Read(buf []byte) (int, error) {
 // Read это обёртка над bufio.Read для записи дампа
 func (r *reader) ReadByte() (byte, error) {
 	b, err := r.Reader.ReadByte()
+	if err == nil {
+		r.offset++
+	}
 	if r.debug && r.dump.start {
 		r.dump.buf.WriteByte(b)
 	}
@@ -28,12 +32,25 @@ func (r *reader) ReadByte() (byte, error) {
 // Read это обёртка над bufio.Read для записи дампа
 func (r *reader) ReadString(delim byte) (string, error) {
 	s, err := r.Reader.ReadString(delim)
+	r.offset += int64(len(s))
 	if r.debug && r.dump.start {
 		r.dump.buf.Write([]byte(s))
 	}
 	return s, err
 }
 
+// Discard это обёртка над bufio.Discard учитывающая смещение
+func (r *reader) Discard(n int) (int, error) {
+	discarded, err := r.Reader.Discard(n)
+	r.offset += int64(discarded)
+	return discarded, err
+}
+
+// Offset возвращает количество байт прочитанных из потока
+func (r *reader) Offset() int64 {
+	return r.offset
+}
+
 // EnableDebug включает логирование resp команд
 func (r *reader) EnableDebug(dir string) error {
 	if !IsDir(dir) {
@@ -0,0 +1,146 @@
+package resp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// eofMarkReader читает RDB переданный без указания размера
+// (repl-diskless-sync), данные заканчиваются меткой из RDBEOFMarkLength байт,
+// сама метка в результат не попадает
+type eofMarkReader struct {
+	r    io.Reader
+	mark []byte
+
+	// tail это последние прочитанные байты которые могут оказаться меткой
+	tail []byte
+	buf  []byte
+	done bool
+}
+
+// peekReader это буферизованный источник из которого можно прочитать
+// данные не извлекая их, например bufio.Reader или Conn
+type peekReader interface {
+	Peek(n int) ([]byte, error)
+	Discard(n int) (int, error)
+	Buffered() int
+}
+
+// NewEOFMarkReader возвращает io.Reader который читает данные из r
+// до появления метки mark.
+// Если r реализует Peek и Discard (bufio.Reader, Conn) то данные после
+// метки остаются в r, даже если пришли вместе с ней. Иначе метка ищется
+// в конце каждого прочитанного блока
+func NewEOFMarkReader(r io.Reader, mark []byte) (io.Reader, error) {
+	if len(mark) != RDBEOFMarkLength {
+		return nil, fmt.Errorf(
+			"expected eof mark length %d but actual %d",
+			RDBEOFMarkLength,
+			len(mark),
+		)
+	}
+	return &eofMarkReader{
+		r:    r,
+		mark: mark,
+		tail: make([]byte, 0, len(mark)),
+	}, nil
+}
+
+// Read читает данные до метки окончания
+func (e *eofMarkReader) Read(p []byte) (int, error) {
+	if e.done {
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if cap(e.buf) < len(p)+len(e.mark) {
+		e.buf = make([]byte, len(p)+len(e.mark))
+	}
+	if peek, ok := e.r.(peekReader); ok {
+		return e.readPeek(peek, p)
+	}
+	for {
+		size := copy(e.buf, e.tail)
+		n, err := e.r.Read(e.buf[size : size+len(p)])
+		data := e.buf[:size+n]
+
+		if len(data) >= len(e.mark) &&
+			bytes.Equal(data[len(data)-len(e.mark):], e.mark) {
+			e.done = true
+			count := copy(p, data[:len(data)-len(e.mark)])
+			if count == 0 {
+				return 0, io.EOF
+			}
+			return count, nil
+		}
+
+		keep := len(data)
+		if keep > len(e.mark) {
+			keep = len(e.mark)
+		}
+		count := copy(p, data[:len(data)-keep])
+		e.tail = append(e.tail[:0], data[len(data)-keep:]...)
+
+		if err == io.EOF {
+			return count, io.ErrUnexpectedEOF
+		}
+		if count > 0 || err != nil {
+			return count, err
+		}
+	}
+}
+
+// readPeek читает доступные в буфере данные и ищет в них метку,
+// из источника извлекаются только данные до конца метки
+func (e *eofMarkReader) readPeek(r peekReader, p []byte) (int, error) {
+	for {
+		// ожидаем хотя бы один байт, затем берём всё что есть в буфере
+		_, err := r.Peek(1)
+		if err == io.EOF {
+			return 0, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return 0, err
+		}
+		n := r.Buffered()
+		if n > len(p) {
+			n = len(p)
+		}
+		window, err := r.Peek(n)
+		if err != nil {
+			return 0, err
+		}
+		size := copy(e.buf, e.tail)
+		size += copy(e.buf[size:], window)
+		data := e.buf[:size]
+
+		if i := bytes.Index(data, e.mark); i >= 0 {
+			_, err = r.Discard(i + len(e.mark) - len(e.tail))
+			if err != nil {
+				return 0, err
+			}
+			e.done = true
+			count := copy(p, data[:i])
+			if count == 0 {
+				return 0, io.EOF
+			}
+			return count, nil
+		}
+		_, err = r.Discard(len(window))
+		if err != nil {
+			return 0, err
+		}
+
+		keep := len(data)
+		if keep > len(e.mark)-1 {
+			keep = len(e.mark) - 1
+		}
+		count := copy(p, data[:len(data)-keep])
+		e.tail = append(e.tail[:0], data[len(data)-keep:]...)
+		if count > 0 {
+			return count, nil
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// testEOFMark это метка окончания RDB длиной RDBEOFMarkLength
+const testEOFMark = "ac9ab1a1f5cb0f8b3e7c53fd0c2a7e1a9f5fd8d0"
+
+func TestEOFMarkReader(t *testing.T) {
+	t.Run("Normal", func(t *testing.T) {
+		t.Run("Empty", func(t *testing.T) {
+			testEOFMarkReader(t, "", nil)
+		})
+		t.Run("Short", func(t *testing.T) {
+			testEOFMarkReader(t, "REDIS0009", nil)
+		})
+		t.Run("Long", func(t *testing.T) {
+			testEOFMarkReader(t, strings.Repeat("REDIS0009", 1000), nil)
+		})
+		t.Run("OneByte", func(t *testing.T) {
+			testEOFMarkReader(
+				t,
+				strings.Repeat("REDIS0009", 100),
+				iotest.OneByteReader,
+			)
+		})
+		t.Run("HalfReader", func(t *testing.T) {
+			testEOFMarkReader(
+				t,
+				strings.Repeat("REDIS0009", 100),
+				iotest.HalfReader,
+			)
+		})
+		t.Run("PartialMark", func(t *testing.T) {
+			testEOFMarkReader(t, testEOFMark[:39]+"x"+testEOFMark[:20], nil)
+		})
+		t.Run("FollowingCommands", func(t *testing.T) {
+			src := strings.NewReader("REDIS0009" + testEOFMark + "*1\r\n")
+			r, err := NewEOFMarkReader(
+				iotest.OneByteReader(src),
+				[]byte(testEOFMark),
+			)
+			if err != nil {
+				t.Fatalf("create reader error: %v", err)
+			}
+			result, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatalf("read error: %v", err)
+			}
+			if string(result) != "REDIS0009" {
+				t.Fatalf("expected %q but actual %q", "REDIS0009", result)
+			}
+			if src.Len() != 4 {
+				t.Fatalf("expected 4 unread bytes but actual %d", src.Len())
+			}
+		})
+		t.Run("BufferedFollowingCommands", func(t *testing.T) {
+			for name, wrap := range map[string]func(io.Reader) io.Reader{
+				"Whole":   nil,
+				"OneByte": iotest.OneByteReader,
+				"Half":    iotest.HalfReader,
+			} {
+				var src io.Reader = strings.NewReader(
+					strings.Repeat("REDIS0009", 100) + testEOFMark + "*1\r\n",
+				)
+				if wrap != nil {
+					src = wrap(src)
+				}
+				buffer := bufio.NewReader(src)
+				r, err := NewEOFMarkReader(buffer, []byte(testEOFMark))
+				if err != nil {
+					t.Fatalf("create reader error: %v", err)
+				}
+				result, err := ioutil.ReadAll(r)
+				if err != nil {
+					t.Fatalf("%s: read error: %v", name, err)
+				}
+				if string(result) != strings.Repeat("REDIS0009", 100) {
+					t.Fatalf("%s: unexpected result %d bytes", name, len(result))
+				}
+				rest, err := ioutil.ReadAll(buffer)
+				if err != nil || string(rest) != "*1\r\n" {
+					t.Fatalf("%s: expected %q but actual %q", name, "*1\r\n", rest)
+				}
+			}
+		})
+	})
+	t.Run("Error", func(t *testing.T) {
+		t.Run("NoMark", func(t *testing.T) {
+			r, err := NewEOFMarkReader(
+				strings.NewReader("REDIS0009"),
+				[]byte(testEOFMark),
+			)
+			if err != nil {
+				t.Fatalf("create reader error: %v", err)
+			}
+			_, err = ioutil.ReadAll(r)
+			if err != io.ErrUnexpectedEOF {
+				t.Fatalf("expected %v but actual %v", io.ErrUnexpectedEOF, err)
+			}
+		})
+		t.Run("MarkLength", func(t *testing.T) {
+			_, err := NewEOFMarkReader(strings.NewReader(""), []byte("short"))
+			if err == nil {
+				t.Fatalf("expected error")
+			}
+		})
+	})
+}
+
+// testEOFMarkReader проверяет чтение данных до метки окончания
+func testEOFMarkReader(
+	t *testing.T,
+	payload string,
+	wrap func(io.Reader) io.Reader,
+) {
+	var src io.Reader = strings.NewReader(payload + testEOFMark)
+	if wrap != nil {
+		src = wrap(src)
+	}
+	r, err := NewEOFMarkReader(src, []byte(testEOFMark))
+	if err != nil {
+		t.Fatalf("create reader error: %v", err)
+	}
+	var result bytes.Buffer
+	_, err = io.Copy(&result, r)
+	if err != nil {
+		t.Fatalf("read error: %v", err)
+	}
+	if result.String() != payload {
+		t.Fatalf("expected %d bytes but actual %d", len(payload), result.Len())
+	}
+}
@@ -27,6 +27,10 @@ const (
 
 	// LF это символ \n
 	LF = 0xa
+
+	// RDBEOFMarkLength это длина метки окончания RDB переданного без
+	// указания размера ("$EOF:<mark>")
+	RDBEOFMarkLength = 40
 )
 
 // Reader это интерфейс для чтения комманд из потока
@@ -38,6 +42,22 @@ type Reader interface {
 	//	ReadOpcode() (byte, error)
 	ReadString(delim byte) (string, error)
 
+	// ReadReply читает однострочный ответ сервера на команду,
+	// ответ с ошибкой возвращается как ServerError
+	ReadReply() (string, error)
+
+	// ReadArrayReply читает ответ сервера в виде массива,
+	// ответ с ошибкой возвращается как ServerError
+	ReadArrayReply() ([]string, error)
+
+	// ReadValueReply читает ответ сервера с сохранением вложенности:
+	// string, int64, nil или []interface{},
+	// ответ с ошибкой возвращается как ServerError
+	ReadValueReply() (interface{}, error)
+
+	// Offset возвращает количество байт прочитанных из потока
+	Offset() int64
+
 	//	ReadSimpleString() (string, error)
 	//	ReadError() (string, error)
 	//	ReadInteger() (int64, error)
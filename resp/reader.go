@@ -14,6 +14,9 @@ import (
 type reader struct {
 	*bufio.Reader
 
+	// offset это количество байт прочитанных из потока
+	offset int64
+
 	debug bool
 	dump  struct {
 		name  string
@@ -91,6 +94,136 @@ func (r *reader) ReadError() (string, error) {
 	return strings.TrimSpace(st), nil
 }
 
+// ServerError это ошибка которую вернул сервер в ответ на команду
+type ServerError string
+
+// Error возвращает текст ошибки сервера
+func (e ServerError) Error() string {
+	return string(e)
+}
+
+// ReadReply читает однострочный ответ сервера (SimpleString или Error),
+// пустые строки которые сервер отправляет для поддержания соединения
+// пропускаются
+func (r *reader) ReadReply() (string, error) {
+	for {
+		opcode, err := r.ReadOpcode()
+		if err != nil {
+			return "", err
+		}
+		switch opcode {
+		case CR, LF:
+			continue
+		case SimpleStringOpcode:
+			return r.ReadSimpleString()
+		case ErrorOpcode:
+			message, err := r.ReadError()
+			if err != nil {
+				return "", err
+			}
+			return "", ServerError(message)
+		}
+		return "", fmt.Errorf("unexpected reply opcode %#v", opcode)
+	}
+}
+
+// ReadArrayReply читает ответ сервера в виде массива (Array или Error),
+// пустой массив (*-1) возвращается как nil
+func (r *reader) ReadArrayReply() ([]string, error) {
+	for {
+		opcode, err := r.ReadOpcode()
+		if err != nil {
+			return nil, err
+		}
+		switch opcode {
+		case CR, LF:
+			continue
+		case ArrayOpcode:
+			return r.ReadArray()
+		case ErrorOpcode:
+			message, err := r.ReadError()
+			if err != nil {
+				return nil, err
+			}
+			return nil, ServerError(message)
+		}
+		return nil, fmt.Errorf("unexpected reply opcode %#v", opcode)
+	}
+}
+
+// ReadValueReply читает ответ сервера сохраняя вложенность массивов,
+// пустые строки и массивы (-1) возвращаются как nil
+func (r *reader) ReadValueReply() (interface{}, error) {
+	for {
+		opcode, err := r.ReadOpcode()
+		if err != nil {
+			return nil, err
+		}
+		switch opcode {
+		case CR, LF:
+			continue
+		case ErrorOpcode:
+			message, err := r.ReadError()
+			if err != nil {
+				return nil, err
+			}
+			return nil, ServerError(message)
+		}
+		return r.readValue(opcode)
+	}
+}
+
+// readValue читает значение после opcode
+func (r *reader) readValue(opcode byte) (interface{}, error) {
+	switch opcode {
+	case SimpleStringOpcode:
+		return r.ReadSimpleString()
+	case ErrorOpcode:
+		return r.ReadError()
+	case IntegerOpcode:
+		return r.ReadInteger()
+	case BulkStringOpcode:
+		length, err := r.ReadInteger()
+		if err != nil {
+			return nil, err
+		}
+		if length < 0 {
+			return nil, nil
+		}
+		data, err := r.SafeRead(uint32(length))
+		if err != nil {
+			return nil, err
+		}
+		err = r.ReadCRLF()
+		if err != nil {
+			return nil, err
+		}
+		return string(data), nil
+	case ArrayOpcode:
+		length, err := r.ReadInteger()
+		if err != nil {
+			return nil, err
+		}
+		if length < 0 {
+			return nil, nil
+		}
+		result := make([]interface{}, 0, length)
+		for ; length > 0; length-- {
+			opcode, err := r.ReadOpcode()
+			if err != nil {
+				return nil, err
+			}
+			value, err := r.readValue(opcode)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, value)
+		}
+		return result, nil
+	}
+	return nil, fmt.Errorf("unexpected opcode %#v", opcode)
+}
+
 // ReadInteger читает целое число
 func (r *reader) ReadInteger() (int64, error) {
 	st, err := r.ReadString('\n')
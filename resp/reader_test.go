@@ -83,9 +83,36 @@ func TestReaderCommand(t *testing.T) {
 			})
 		})
 	})
+	t.Run("RDB", func(t *testing.T) {
+		t.Run("Size", func(t *testing.T) {
+			testReadCommand(
+				t,
+				"$1024\r\n",
+				command.New([]string{"rdb", "1024"}),
+				true,
+			)
+		})
+		t.Run("EOFMark", func(t *testing.T) {
+			testReadCommand(
+				t,
+				"$EOF:"+testEOFMark+"\r\n",
+				command.New([]string{"rdb", "EOF:" + testEOFMark}),
+				true,
+			)
+		})
+		t.Run("ShortEOFMark", func(t *testing.T) {
+			testReadCommand(t, "$EOF:123\r\n", command.Command{}, false)
+		})
+		t.Run("IncorrectSize", func(t *testing.T) {
+			testReadCommand(t, "$abc\r\n", command.Command{}, false)
+		})
+	})
 	t.Run("Error", func(t *testing.T) {
 
 	})
+	t.Run("EOF", func(t *testing.T) {
+		testReadCommand(t, "", command.Command{}, true)
+	})
 }
 
 // testReadCommand проверяет правильное чтение команды
@@ -138,3 +165,157 @@ func testReadFileCommand(
 		}
 	}
 }
+
+func TestReaderReply(t *testing.T) {
+	t.Run("Normal", func(t *testing.T) {
+		t.Run("OK", func(t *testing.T) {
+			testReadReply(t, "+OK\r\n", "OK", true)
+		})
+		t.Run("FullResync", func(t *testing.T) {
+			testReadReply(
+				t,
+				"+FULLRESYNC 8de1787ba490483314a4d30f1c628bc5025eb761 2443808505\r\n",
+				"FULLRESYNC 8de1787ba490483314a4d30f1c628bc5025eb761 2443808505",
+				true,
+			)
+		})
+		t.Run("KeepAlive", func(t *testing.T) {
+			testReadReply(t, "\n\n\n+CONTINUE\r\n", "CONTINUE", true)
+		})
+	})
+	t.Run("Error", func(t *testing.T) {
+		t.Run("ServerError", func(t *testing.T) {
+			r := NewStringReader("-ERR unknown command 'PSYNC'\r\n")
+			_, err := r.ReadReply()
+			if _, ok := err.(ServerError); !ok {
+				t.Fatalf("expected ServerError but actual %#v", err)
+			}
+		})
+		t.Run("Array", func(t *testing.T) {
+			testReadReply(t, "*1\r\n$4\r\nPING\r\n", "", false)
+		})
+		t.Run("Empty", func(t *testing.T) {
+			testReadReply(t, "", "", false)
+		})
+	})
+}
+
+// TestReaderArrayReply проверяет чтение ответа сервера в виде массива
+func TestReaderArrayReply(t *testing.T) {
+	t.Run("Normal", func(t *testing.T) {
+		r := NewStringReader("\n*2\r\n$9\r\n127.0.0.1\r\n$4\r\n6379\r\n")
+		reply, err := r.ReadArrayReply()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(reply, []string{"127.0.0.1", "6379"}) {
+			t.Fatalf("unexpected reply %q", reply)
+		}
+	})
+	t.Run("Nil", func(t *testing.T) {
+		r := NewStringReader("*-1\r\n")
+		reply, err := r.ReadArrayReply()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if reply != nil {
+			t.Fatalf("expected nil but actual %q", reply)
+		}
+	})
+	t.Run("ServerError", func(t *testing.T) {
+		r := NewStringReader("-ERR unknown command 'SENTINEL'\r\n")
+		_, err := r.ReadArrayReply()
+		if _, ok := err.(ServerError); !ok {
+			t.Fatalf("expected ServerError but actual %#v", err)
+		}
+	})
+	t.Run("SimpleString", func(t *testing.T) {
+		r := NewStringReader("+OK\r\n")
+		_, err := r.ReadArrayReply()
+		if err == nil {
+			t.Fatalf("expected error")
+		}
+	})
+}
+
+// TestReaderValueReply проверяет чтение ответа сервера с вложенными массивами
+func TestReaderValueReply(t *testing.T) {
+	t.Run("Nested", func(t *testing.T) {
+		r := NewStringReader(
+			"*1\r\n*3\r\n:0\r\n:5460\r\n*3\r\n$9\r\n127.0.0.1\r\n:30001\r\n$-1\r\n",
+		)
+		reply, err := r.ReadValueReply()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		expected := []interface{}{
+			[]interface{}{
+				int64(0),
+				int64(5460),
+				[]interface{}{"127.0.0.1", int64(30001), nil},
+			},
+		}
+		if !reflect.DeepEqual(reply, expected) {
+			t.Fatalf("expected %#v but actual %#v", expected, reply)
+		}
+	})
+	t.Run("ServerError", func(t *testing.T) {
+		r := NewStringReader("-ERR unknown subcommand 'SHARDS'\r\n")
+		_, err := r.ReadValueReply()
+		if _, ok := err.(ServerError); !ok {
+			t.Fatalf("expected ServerError but actual %#v", err)
+		}
+	})
+	t.Run("Truncated", func(t *testing.T) {
+		r := NewStringReader("*2\r\n:1\r\n")
+		_, err := r.ReadValueReply()
+		if err == nil {
+			t.Fatalf("expected error")
+		}
+	})
+}
+
+// testReadReply проверяет правильное чтение ответа сервера
+func testReadReply(
+	t *testing.T,
+	s string,
+	expected string,
+	success bool,
+) {
+	r := NewStringReader(s)
+	reply, err := r.ReadReply()
+	if success {
+		if err != nil {
+			t.Fatalf("read reply error: %q", err)
+		}
+		if reply != expected {
+			t.Fatalf("expected %q but actual %q", expected, reply)
+		}
+	} else {
+		if err == nil {
+			t.Fatalf("expected error")
+		}
+	}
+}
+
+// TestReaderOffset проверяет подсчёт прочитанных байт
+func TestReaderOffset(t *testing.T) {
+	stream := "*1\r\n$4\r\nPING\r\n*2\r\n$6\r\nSELECT\r\n$1\r\n0\r\n"
+	r := NewStringReader(stream)
+
+	_, err := r.Command()
+	if err != nil {
+		t.Fatalf("read command error: %q", err)
+	}
+	if r.Offset() != 14 {
+		t.Fatalf("expected offset %d but actual %d", 14, r.Offset())
+	}
+
+	_, err = r.Command()
+	if err != nil {
+		t.Fatalf("read command error: %q", err)
+	}
+	if r.Offset() != int64(len(stream)) {
+		t.Fatalf("expected offset %d but actual %d", len(stream), r.Offset())
+	}
+}
@@ -0,0 +1,234 @@
+package replica
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// SwitchMasterChannel это канал sentinel в котором публикуется смена
+	// мастера
+	SwitchMasterChannel = "+switch-master"
+
+	// DefaultSentinelRetry это задержка перед повторной подпиской на
+	// события sentinel после потери соединения
+	DefaultSentinelRetry = time.Second
+)
+
+// SentinelConfig это параметры подключения к sentinel
+type SentinelConfig struct {
+	// Addresses это адреса sentinel в формате host:port,
+	// опрашиваются по очереди до первого ответа
+	Addresses []string
+
+	// MasterName это имя мастера в конфигурации sentinel
+	MasterName string
+
+	// User это имя пользователя ACL sentinel
+	User string
+
+	// Password это пароль sentinel, если не задан то AUTH не отправляется
+	Password string
+
+	// TLS это параметры TLS соединения с sentinel
+	TLS *TLSConfig
+
+	// Retry это задержка перед повторной подпиской на события,
+	// по умолчанию DefaultSentinelRetry
+	Retry time.Duration
+}
+
+// Check проверяет что в конфиге все необходимые данные
+func (c SentinelConfig) Check() error {
+	switch {
+	case len(c.Addresses) == 0:
+		return errors.New("expected sentinel addresses")
+	case c.MasterName == "":
+		return errors.New("expected master name")
+	case c.User != "" && c.Password == "":
+		return errors.New("expected password for user")
+	}
+	return nil
+}
+
+// MasterSwitch это событие смены мастера
+type MasterSwitch struct {
+	MasterName string
+	OldHost    string
+	OldPort    int
+	NewHost    string
+	NewPort    int
+}
+
+// Sentinel находит адрес мастера через redis sentinel
+// и сообщает о его смене
+type Sentinel struct {
+	config SentinelConfig
+}
+
+// NewSentinel возвращает новый Sentinel
+func NewSentinel(config SentinelConfig) (*Sentinel, error) {
+	err := config.Check()
+	if err != nil {
+		return nil, err
+	}
+	if config.Retry <= 0 {
+		config.Retry = DefaultSentinelRetry
+	}
+	return &Sentinel{config: config}, nil
+}
+
+// MasterAddr возвращает адрес текущего мастера,
+// опрашивает sentinel по очереди до первого ответа
+func (s *Sentinel) MasterAddr() (host string, port int, err error) {
+	for _, address := range s.config.Addresses {
+		host, port, err = s.masterAddr(address)
+		if err == nil {
+			return host, port, nil
+		}
+	}
+	return "", 0, fmt.Errorf("sentinel master address error: %v", err)
+}
+
+// masterAddr запрашивает адрес мастера у одного sentinel
+func (s *Sentinel) masterAddr(address string) (string, int, error) {
+	conn, err := s.connect(address)
+	if err != nil {
+		return "", 0, err
+	}
+	defer conn.Close() // nolint:errcheck
+
+	reply, err := conn.requestArray(
+		"SENTINEL",
+		"get-master-addr-by-name",
+		s.config.MasterName,
+	)
+	if err != nil {
+		return "", 0, err
+	}
+	if len(reply) != 2 {
+		return "", 0, fmt.Errorf("unknown master %q", s.config.MasterName)
+	}
+	port, err := strconv.Atoi(reply[1])
+	if err != nil {
+		return "", 0, fmt.Errorf("master port error: %v", err)
+	}
+	return reply[0], port, nil
+}
+
+// Watch подписывается на смену мастера и отправляет события в канал,
+// при потере соединения подписывается через следующий sentinel,
+// канал закрывается после завершения ctx
+func (s *Sentinel) Watch(ctx context.Context) <-chan MasterSwitch {
+	switches := make(chan MasterSwitch)
+	go func() {
+		defer close(switches)
+		for i := 0; ; i++ {
+			address := s.config.Addresses[i%len(s.config.Addresses)]
+			s.watch(ctx, address, switches) // nolint:errcheck
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(s.config.Retry):
+			}
+		}
+	}()
+	return switches
+}
+
+// watch читает события смены мастера от одного sentinel
+func (s *Sentinel) watch(
+	ctx context.Context,
+	address string,
+	switches chan<- MasterSwitch,
+) error {
+	conn, err := s.connect(address)
+	if err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+		conn.Close() // nolint:errcheck
+	}()
+
+	_, err = conn.requestArray("SUBSCRIBE", SwitchMasterChannel)
+	if err != nil {
+		return err
+	}
+	for {
+		message, err := conn.reader.ReadArrayReply()
+		if err != nil {
+			return err
+		}
+		if len(message) != 3 || message[0] != "message" ||
+			message[1] != SwitchMasterChannel {
+			continue
+		}
+		event, err := parseMasterSwitch(message[2])
+		if err != nil {
+			return err
+		}
+		if event.MasterName != s.config.MasterName {
+			continue
+		}
+		select {
+		case switches <- event:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// connect подключается к sentinel
+func (s *Sentinel) connect(address string) (*Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	portNumber, err := strconv.Atoi(port)
+	if err != nil {
+		return nil, fmt.Errorf("sentinel port error: %v", err)
+	}
+	return NewConnectConfig(Config{
+		Host:     host,
+		Port:     portNumber,
+		DB:       -1,
+		User:     s.config.User,
+		Password: s.config.Password,
+		TLS:      s.config.TLS,
+	})
+}
+
+// parseMasterSwitch разбирает сообщение
+// "<master name> <old ip> <old port> <new ip> <new port>"
+func parseMasterSwitch(message string) (MasterSwitch, error) {
+	fields := strings.Fields(message)
+	if len(fields) != 5 {
+		return MasterSwitch{}, fmt.Errorf("unexpected switch-master %q", message)
+	}
+	oldPort, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return MasterSwitch{}, fmt.Errorf("old port error: %v", err)
+	}
+	newPort, err := strconv.Atoi(fields[4])
+	if err != nil {
+		return MasterSwitch{}, fmt.Errorf("new port error: %v", err)
+	}
+	return MasterSwitch{
+		MasterName: fields[0],
+		OldHost:    fields[1],
+		OldPort:    oldPort,
+		NewHost:    fields[3],
+		NewPort:    newPort,
+	}, nil
+}
@@ -0,0 +1,337 @@
+package replica
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/avito-tech/smart-redis-replication/replica"
+	"github.com/avito-tech/smart-redis-replication/resp"
+	"github.com/avito-tech/smart-redis-replication/status"
+)
+
+// TestSentinelMasterAddr проверяет получение адреса мастера
+func TestSentinelMasterAddr(t *testing.T) {
+	t.Run("Normal", func(t *testing.T) {
+		sentinel := newFakeSentinel(t, "mymaster", "10.0.0.1:6379")
+		defer sentinel.Close()
+
+		// первый sentinel недоступен, адрес возвращает второй
+		s, err := NewSentinel(SentinelConfig{
+			Addresses:  []string{closedAddress(t), sentinel.Addr()},
+			MasterName: "mymaster",
+		})
+		if err != nil {
+			t.Fatalf("new sentinel error: %v", err)
+		}
+		host, port, err := s.MasterAddr()
+		if err != nil {
+			t.Fatalf("master addr error: %v", err)
+		}
+		if host != "10.0.0.1" || port != 6379 {
+			t.Fatalf("unexpected master address %s:%d", host, port)
+		}
+	})
+	t.Run("UnknownMaster", func(t *testing.T) {
+		sentinel := newFakeSentinel(t, "mymaster", "10.0.0.1:6379")
+		defer sentinel.Close()
+
+		s, err := NewSentinel(SentinelConfig{
+			Addresses:  []string{sentinel.Addr()},
+			MasterName: "unknown",
+		})
+		if err != nil {
+			t.Fatalf("new sentinel error: %v", err)
+		}
+		_, _, err = s.MasterAddr()
+		if err == nil {
+			t.Fatalf("expected error")
+		}
+	})
+	t.Run("EmptyConfig", func(t *testing.T) {
+		_, err := NewSentinel(SentinelConfig{MasterName: "mymaster"})
+		if err == nil {
+			t.Fatalf("expected error")
+		}
+	})
+}
+
+// TestParseMasterSwitch проверяет разбор сообщения о смене мастера
+func TestParseMasterSwitch(t *testing.T) {
+	event, err := parseMasterSwitch("mymaster 10.0.0.1 6379 10.0.0.2 6380")
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	expected := MasterSwitch{
+		MasterName: "mymaster",
+		OldHost:    "10.0.0.1",
+		OldPort:    6379,
+		NewHost:    "10.0.0.2",
+		NewPort:    6380,
+	}
+	if event != expected {
+		t.Fatalf("expected %#v but actual %#v", expected, event)
+	}
+	_, err = parseMasterSwitch("mymaster 10.0.0.1 6379")
+	if err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+// TestSupervisorSentinel проверяет что после смены мастера репликация
+// продолжается с нового мастера без перезапуска получателя
+func TestSupervisorSentinel(t *testing.T) {
+	rdbData := "REDIS0009\xfe\x00\x00\x03foo\x03bar\xff\x00\x00\x00\x00\x00\x00\x00\x00"
+	setA := "*3\r\n$3\r\nSET\r\n$1\r\na\r\n$1\r\n1\r\n"
+	setB := "*3\r\n$3\r\nSET\r\n$1\r\nb\r\n$1\r\n2\r\n"
+
+	master1 := newFakeMasterListener(t, fmt.Sprintf(
+		"+FULLRESYNC abc 100\r\n$%d\r\n%s%s",
+		len(rdbData),
+		rdbData,
+		setA,
+	))
+	defer master1.Close() // nolint:errcheck
+	master2 := newFakeMasterListener(t, "+CONTINUE\r\n"+setB)
+	defer master2.Close() // nolint:errcheck
+
+	sentinel := newFakeSentinel(t, "mymaster", master1.Addr().String())
+	defer sentinel.Close()
+
+	s, err := NewSupervisor(SupervisorConfig{
+		Replica: replica.Config{
+			ReadRDB:     true,
+			StreamRDB:   true,
+			BacklogSize: 100,
+		},
+		MinBackoff: time.Millisecond,
+		MaxBackoff: time.Millisecond,
+		Sentinel: &SentinelConfig{
+			Addresses:  []string{sentinel.Addr()},
+			MasterName: "mymaster",
+			Retry:      time.Millisecond,
+		},
+	})
+	if err != nil {
+		t.Fatalf("new supervisor error: %v", err)
+	}
+	consumer := newTestConsumer()
+	result := make(chan error, 1)
+	go func() {
+		result <- s.Run(consumer)
+	}()
+
+	waitEvent := func(expected string) {
+		select {
+		case event := <-consumer.events:
+			if event != expected {
+				t.Fatalf("expected %q but actual %q", expected, event)
+			}
+		case err := <-result:
+			t.Fatalf("unexpected stop: %v", err)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting %q", expected)
+		}
+	}
+	waitEvent("key foo")
+	waitEvent("command a")
+
+	sentinel.WaitSubscriber(t)
+	sentinel.SwitchMaster(master2.Addr().String())
+	waitEvent("command b")
+
+	s.Stop()
+	if err := <-result; err != nil {
+		t.Fatalf("run error: %v", err)
+	}
+	expectedPsync := fmt.Sprintf("PSYNC abc %d", 100+len(setA)+1)
+	if cmd := <-master2.psync; cmd != expectedPsync {
+		t.Fatalf("expected %q but actual %q", expectedPsync, cmd)
+	}
+	if consumer.count(status.SwitchMaster) != 1 {
+		t.Fatalf("expected one switch master status")
+	}
+}
+
+// closedAddress возвращает адрес на котором никто не слушает
+func closedAddress(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen error: %v", err)
+	}
+	address := listener.Addr().String()
+	listener.Close() // nolint:errcheck
+	return address
+}
+
+// fakeMasterListener принимает одно соединение реплики
+type fakeMasterListener struct {
+	net.Listener
+	psync chan string
+}
+
+// newFakeMasterListener возвращает мастер который отвечает на PSYNC reply
+// и держит соединение открытым
+func newFakeMasterListener(t *testing.T, reply string) *fakeMasterListener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen error: %v", err)
+	}
+	m := &fakeMasterListener{
+		Listener: listener,
+		psync:    make(chan string, 1),
+	}
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close() // nolint:errcheck
+		m.psync <- fakeMaster(conn, reply)
+		readAll(conn)
+	}()
+	return m
+}
+
+// fakeSentinel отвечает на SENTINEL get-master-addr-by-name
+// и публикует +switch-master подписчикам
+type fakeSentinel struct {
+	sync.Mutex
+	listener    net.Listener
+	masterName  string
+	master      string
+	subscribers []net.Conn
+}
+
+// newFakeSentinel возвращает sentinel с мастером masterName по адресу master
+func newFakeSentinel(t *testing.T, masterName, master string) *fakeSentinel {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen error: %v", err)
+	}
+	s := &fakeSentinel{
+		listener:   listener,
+		masterName: masterName,
+		master:     master,
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+// Addr возвращает адрес sentinel
+func (s *fakeSentinel) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Close останавливает sentinel
+func (s *fakeSentinel) Close() {
+	s.listener.Close() // nolint:errcheck
+	s.Lock()
+	defer s.Unlock()
+	for _, conn := range s.subscribers {
+		conn.Close() // nolint:errcheck
+	}
+}
+
+// WaitSubscriber ожидает подписку на события
+func (s *fakeSentinel) WaitSubscriber(t *testing.T) {
+	for i := 0; i < 500; i++ {
+		s.Lock()
+		n := len(s.subscribers)
+		s.Unlock()
+		if n > 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timeout waiting subscriber")
+}
+
+// SwitchMaster меняет адрес мастера и сообщает подписчикам
+func (s *fakeSentinel) SwitchMaster(master string) {
+	s.Lock()
+	defer s.Unlock()
+	oldHost, oldPort, _ := net.SplitHostPort(s.master)
+	newHost, newPort, _ := net.SplitHostPort(master)
+	s.master = master
+	message := strings.Join(
+		[]string{s.masterName, oldHost, oldPort, newHost, newPort},
+		" ",
+	)
+	for _, conn := range s.subscribers {
+		conn.Write([]byte(respArray( // nolint:errcheck
+			"message",
+			SwitchMasterChannel,
+			message,
+		)))
+	}
+}
+
+// serve отвечает на команды одного соединения
+func (s *fakeSentinel) serve(conn net.Conn) {
+	br := bufio.NewReader(conn)
+	r := resp.NewReader(br)
+	for {
+		args, err := readMasterCommand(br, r)
+		if err != nil || len(args) == 0 {
+			conn.Close() // nolint:errcheck
+			return
+		}
+		var reply string
+		switch strings.ToUpper(args[0]) {
+		case "PING":
+			reply = "+PONG\r\n"
+		case "SENTINEL":
+			s.Lock()
+			host, port, _ := net.SplitHostPort(s.master)
+			if len(args) == 3 && args[2] == s.masterName {
+				reply = respArray(host, port)
+			} else {
+				reply = "*-1\r\n"
+			}
+			s.Unlock()
+		case "SUBSCRIBE":
+			// подписчик добавляется после ответа чтобы сообщение не пришло
+			// раньше подтверждения подписки
+			s.Lock()
+			_, err = conn.Write([]byte(
+				"*3\r\n$9\r\nsubscribe\r\n$14\r\n+switch-master\r\n:1\r\n",
+			))
+			s.subscribers = append(s.subscribers, conn)
+			s.Unlock()
+			if err != nil {
+				return
+			}
+			continue
+		default:
+			reply = "-ERR unknown command\r\n"
+		}
+		s.Lock()
+		_, err = conn.Write([]byte(reply))
+		s.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// respArray возвращает массив строк в формате RESP
+func respArray(items ...string) string {
+	result := fmt.Sprintf("*%d\r\n", len(items))
+	for _, item := range items {
+		result += fmt.Sprintf("$%d\r\n%s\r\n", len(item), item)
+	}
+	return result
+}
@@ -0,0 +1,88 @@
+package replica
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/avito-tech/smart-redis-replication/command"
+)
+
+const (
+	// ClusterSlots это количество слотов redis cluster
+	ClusterSlots = 16384
+)
+
+// keylessCommands это команды без ключа в первом аргументе
+var keylessCommands = map[string]bool{
+	"ping":     true,
+	"select":   true,
+	"replconf": true,
+	"multi":    true,
+	"exec":     true,
+	"discard":  true,
+	"flushall": true,
+	"flushdb":  true,
+	"publish":  true,
+	"script":   true,
+	"function": true,
+	"swapdb":   true,
+}
+
+// KeySlot возвращает слот redis cluster для ключа,
+// если в ключе есть непустой hash tag ({...}) то слот считается по нему
+func KeySlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int(crc16(key) % ClusterSlots)
+}
+
+// CommandSlot возвращает слот ключа команды или -1 если у команды нет ключа
+func CommandSlot(cmd command.Command) int {
+	args := cmd.Args()
+	if len(args) < 2 {
+		return -1
+	}
+	name := strings.ToLower(args[0])
+	switch {
+	case keylessCommands[name]:
+		return -1
+	case name == "eval" || name == "evalsha" || name == "fcall":
+		// EVAL script numkeys key [key ...] arg [arg ...]
+		if len(args) < 4 {
+			return -1
+		}
+		numkeys, err := strconv.Atoi(args[2])
+		if err != nil || numkeys < 1 {
+			return -1
+		}
+		return KeySlot(args[3])
+	case cmd.Type() == command.Xgroup:
+		// XGROUP subcommand key ...
+		key, err := cmd.KeyName()
+		if err != nil {
+			return -1
+		}
+		return KeySlot(key)
+	}
+	return KeySlot(args[1])
+}
+
+// crc16 возвращает CRC16 (XMODEM) которую redis cluster использует
+// для распределения ключей по слотам
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for bit := 0; bit < 8; bit++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
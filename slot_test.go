@@ -0,0 +1,59 @@
+package replica
+
+import (
+	"testing"
+
+	"github.com/avito-tech/smart-redis-replication/command"
+)
+
+// TestKeySlot проверяет вычисление слота ключа
+func TestKeySlot(t *testing.T) {
+	tests := map[string]int{
+		"123456789":            12739,
+		"foo":                  12182,
+		"bar":                  5061,
+		"{user1000}.following": KeySlot("user1000"),
+		"{user1000}.followers": KeySlot("user1000"),
+		"foo{{bar}}zap":        KeySlot("{bar"),
+		"foo{bar}{zap}":        KeySlot("bar"),
+	}
+	for key, expected := range tests {
+		if slot := KeySlot(key); slot != expected {
+			t.Fatalf("key %q: expected slot %d but actual %d", key, expected, slot)
+		}
+	}
+	// пустой hash tag не используется
+	if KeySlot("foo{}{bar}") == KeySlot("bar") {
+		t.Fatalf("empty hash tag must be ignored")
+	}
+}
+
+// TestCommandSlot проверяет вычисление слота ключа команды
+func TestCommandSlot(t *testing.T) {
+	tests := []struct {
+		args     []string
+		expected int
+	}{
+		{[]string{"SET", "foo", "1"}, 12182},
+		{[]string{"zadd", "bar", "1", "a"}, 5061},
+		{[]string{"PING"}, -1},
+		{[]string{"SELECT", "0"}, -1},
+		{[]string{"MULTI"}, -1},
+		{[]string{"EVAL", "return 1", "1", "foo"}, 12182},
+		{[]string{"EVAL", "return 1", "0"}, -1},
+		{[]string{"XADD", "foo", "*", "f", "v"}, 12182},
+		{[]string{"XGROUP", "CREATE", "foo", "group", "$"}, 12182},
+		{[]string{"XGROUP", "HELP"}, -1},
+	}
+	for _, test := range tests {
+		slot := CommandSlot(command.New(test.args))
+		if slot != test.expected {
+			t.Fatalf(
+				"command %q: expected slot %d but actual %d",
+				test.args,
+				test.expected,
+				slot,
+			)
+		}
+	}
+}
@@ -10,9 +10,27 @@ const (
 	// Disconnect означает что произошло отключение от сервера
 	Disconnect Status = "disconnect"
 
+	// SwitchMaster означает что sentinel сообщил о смене мастера
+	SwitchMaster Status = "switch_master"
+
+	// TopologyChanged означает что изменился состав мастеров redis cluster
+	// или распределение слотов между ними
+	TopologyChanged Status = "topology_changed"
+
 	// StartSync означает что инициировалась репликация
 	StartSync Status = "start_sync"
 
+	// FullResync означает что сервер начал полную синхронизацию
+	FullResync Status = "full_resync"
+
+	// ContinueSync означает что сервер продолжил репликацию с сохранённого
+	// смещения без передачи RDB
+	ContinueSync Status = "continue_sync"
+
+	// ReuseCacheRDB означает что репликация продолжена с позиции кеша RDB
+	// без повторной передачи и чтения RDB
+	ReuseCacheRDB Status = "reuse_cache_rdb"
+
 	// RDB означает что началась секция с RDB
 	RDB Status = "rdb"
 
@@ -0,0 +1,379 @@
+package replica
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/avito-tech/smart-redis-replication/backlog"
+	"github.com/avito-tech/smart-redis-replication/command"
+	"github.com/avito-tech/smart-redis-replication/data"
+	"github.com/avito-tech/smart-redis-replication/rdb"
+	"github.com/avito-tech/smart-redis-replication/replica"
+	"github.com/avito-tech/smart-redis-replication/resp"
+	"github.com/avito-tech/smart-redis-replication/status"
+)
+
+const (
+	// DefaultMinBackoff это задержка перед первым переподключением
+	DefaultMinBackoff = 100 * time.Millisecond
+
+	// DefaultMaxBackoff это максимальная задержка между переподключениями
+	DefaultMaxBackoff = 30 * time.Second
+)
+
+// SupervisorConfig это настройки Supervisor
+type SupervisorConfig struct {
+	// Connect это настройки подключения к серверу
+	Connect Config
+
+	// Replica это настройки репликации
+	Replica replica.Config
+
+	// MinBackoff это задержка перед первым переподключением,
+	// по умолчанию DefaultMinBackoff
+	MinBackoff time.Duration
+
+	// MaxBackoff это максимальная задержка между переподключениями,
+	// по умолчанию DefaultMaxBackoff
+	MaxBackoff time.Duration
+
+	// MaxRetries это количество переподключений подряд без успешной
+	// синхронизации после которого Run возвращает ошибку, 0 без ограничений
+	MaxRetries int
+
+	// Sentinel это параметры sentinel, если заданы то адрес мастера
+	// берётся из sentinel вместо Connect.Host и Connect.Port, а при смене
+	// мастера репликация переподключается к новому мастеру
+	Sentinel *SentinelConfig
+}
+
+// Supervisor подключается к серверу и запускает репликацию,
+// при потере соединения переподключается с экспоненциальной задержкой
+// и продолжает репликацию не перезапуская получателя
+type Supervisor struct {
+	config   SupervisorConfig
+	sentinel *Sentinel
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	conn    *Conn
+	replica replica.Replica
+
+	// switched означает что sentinel сообщил о смене мастера
+	switched int32
+}
+
+// NewSupervisor возвращает новый Supervisor
+func NewSupervisor(config SupervisorConfig) (*Supervisor, error) {
+	s := &Supervisor{}
+	if config.Sentinel != nil {
+		sentinel, err := NewSentinel(*config.Sentinel)
+		if err != nil {
+			return nil, err
+		}
+		s.sentinel = sentinel
+	} else {
+		err := config.Connect.Check()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if config.MinBackoff <= 0 {
+		config.MinBackoff = DefaultMinBackoff
+	}
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = DefaultMaxBackoff
+	}
+	if config.MaxBackoff < config.MinBackoff {
+		config.MaxBackoff = config.MinBackoff
+	}
+	if config.MaxRetries < 0 {
+		return nil, errors.New("max retries must not be negative")
+	}
+	s.config = config
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	return s, nil
+}
+
+// Run запускает репликацию и переподключается при потере соединения,
+// возвращает ошибку получателя, ошибку авторизации или ошибку после
+// исчерпания MaxRetries, после Stop возвращает nil,
+// метод синхронный
+func (s *Supervisor) Run(consumer replica.Consumer) (err error) {
+	if consumer == nil {
+		return errors.New("expected consumer but actual nil")
+	}
+	supervised := newSupervisedConsumer(consumer)
+	defer func() {
+		s.cancel()
+		consumer.Cancel(&err)
+	}()
+
+	if s.sentinel != nil {
+		go s.watchMaster(supervised)
+	}
+
+	var prev replica.Replica
+	retries := 0
+	for {
+		prev, err = s.connect(supervised, prev)
+		if s.ctx.Err() != nil {
+			return nil
+		}
+		if consumerErr := supervised.Err(); consumerErr != nil {
+			return consumerErr
+		}
+		if isFatalError(err) {
+			return err
+		}
+		if supervised.synced() {
+			retries = 0
+		}
+		if atomic.SwapInt32(&s.switched, 0) == 1 {
+			// смена мастера это штатное переподключение без задержки
+			supervised.ReplicaStatus(status.Reconnect) // nolint:errcheck
+			continue
+		}
+		retries++
+		if s.config.MaxRetries > 0 && retries > s.config.MaxRetries {
+			return fmt.Errorf("reconnect retries exceeded: %v", err)
+		}
+
+		select {
+		case <-s.ctx.Done():
+			return nil
+		case <-time.After(s.backoff(retries)):
+		}
+		supervised.ReplicaStatus(status.Reconnect) // nolint:errcheck
+	}
+}
+
+// connect подключается к серверу и выполняет репликацию до разрыва
+// соединения, возвращает реплику для продолжения репликации
+func (s *Supervisor) connect(
+	consumer *supervisedConsumer,
+	prev replica.Replica,
+) (replica.Replica, error) {
+	consumer.ReplicaStatus(status.Connect) // nolint:errcheck
+	config := s.config.Connect
+	if s.sentinel != nil {
+		atomic.StoreInt32(&s.switched, 0)
+		host, port, err := s.sentinel.MasterAddr()
+		if err != nil {
+			return prev, err
+		}
+		config.Host, config.Port = host, port
+	}
+	conn, err := NewConnectConfig(config)
+	if err != nil {
+		return prev, err
+	}
+	defer consumer.ReplicaStatus(status.Disconnect) // nolint:errcheck
+
+	repl, err := conn.NewResumeReplica(s.ctx, s.config.Replica, prev)
+	if err != nil {
+		conn.Close() // nolint:errcheck
+		return prev, err
+	}
+	s.setReplica(conn, repl)
+	if atomic.LoadInt32(&s.switched) == 1 {
+		// мастер сменился пока шло подключение
+		conn.Close() // nolint:errcheck
+	}
+
+	// соединение закрывается при остановке или ошибке получателя,
+	// иначе репликация ожидала бы следующей команды от сервера
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-s.ctx.Done():
+		case <-consumer.Failed():
+		case <-done:
+		}
+		conn.Close() // nolint:errcheck
+	}()
+	err = repl.Do(consumer)
+	close(done)
+	return repl, err
+}
+
+// setReplica запоминает текущее соединение и реплику
+func (s *Supervisor) setReplica(conn *Conn, repl replica.Replica) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conn = conn
+	s.replica = repl
+}
+
+// watchMaster закрывает текущее соединение когда sentinel сообщает о смене
+// мастера, после чего Run подключается к новому мастеру и продолжает
+// репликацию через PSYNC
+func (s *Supervisor) watchMaster(consumer *supervisedConsumer) {
+	for range s.sentinel.Watch(s.ctx) {
+		atomic.StoreInt32(&s.switched, 1)
+		consumer.ReplicaStatus(status.SwitchMaster) // nolint:errcheck
+		s.mu.Lock()
+		if s.conn != nil {
+			s.conn.Close() // nolint:errcheck
+		}
+		s.mu.Unlock()
+	}
+}
+
+// backoff возвращает задержку перед попыткой переподключения номер retry,
+// половина задержки выбирается случайно чтобы реплики не переподключались
+// одновременно
+func (s *Supervisor) backoff(retry int) time.Duration {
+	delay := s.config.MinBackoff
+	for i := 1; i < retry && delay < s.config.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > s.config.MaxBackoff {
+		delay = s.config.MaxBackoff
+	}
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// Replica возвращает текущую реплику или nil если подключения ещё не было
+func (s *Supervisor) Replica() replica.Replica {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.replica
+}
+
+// Backlog возвращает журнал отставания репликации,
+// журнал сохраняется между переподключениями
+func (s *Supervisor) Backlog() *backlog.Backlog {
+	repl := s.Replica()
+	if repl == nil {
+		return nil
+	}
+	return repl.Backlog()
+}
+
+// Done возвращает канал для ожидания завершения Run
+func (s *Supervisor) Done() <-chan struct{} {
+	return s.ctx.Done()
+}
+
+// Stop прекращает репликацию и переподключения
+func (s *Supervisor) Stop() {
+	s.cancel()
+}
+
+// isFatalError возвращает true если переподключение не исправит ошибку:
+// сервер отклонил авторизацию. Ошибки соединения во время AUTH
+// не фатальны
+func isFatalError(err error) bool {
+	if handshakeErr, ok := err.(*HandshakeError); ok {
+		err = handshakeErr.Err
+	}
+	serverErr, ok := err.(resp.ServerError)
+	if !ok {
+		return false
+	}
+	message := serverErr.Error()
+	return isAuthError(serverErr) ||
+		strings.HasPrefix(message, "WRONGPASS")
+}
+
+// supervisedConsumer передаёт данные получателю и не даёт репликации
+// остановить его при разрыве соединения
+type supervisedConsumer struct {
+	consumer replica.Consumer
+
+	mu     sync.Mutex
+	err    error
+	failed chan struct{}
+	sync   bool
+}
+
+// newSupervisedConsumer возвращает новый supervisedConsumer
+func newSupervisedConsumer(consumer replica.Consumer) *supervisedConsumer {
+	return &supervisedConsumer{
+		consumer: consumer,
+		failed:   make(chan struct{}),
+	}
+}
+
+// Key передаёт ключ получателю и запоминает ошибку
+func (c *supervisedConsumer) Key(key data.Key) error {
+	return c.setErr(c.consumer.Key(key))
+}
+
+// Command передаёт команду получателю и запоминает ошибку
+func (c *supervisedConsumer) Command(cmd command.Command) error {
+	return c.setErr(c.consumer.Command(cmd))
+}
+
+// SetFunction передаёт библиотеку функций получателю если он реализует
+// rdb.FunctionConsumer
+func (c *supervisedConsumer) SetFunction(function rdb.Function) error {
+	functionConsumer, ok := c.consumer.(rdb.FunctionConsumer)
+	if !ok {
+		return nil
+	}
+	return c.setErr(functionConsumer.SetFunction(function))
+}
+
+// CheckCommand возвращает true если команда интересна получателю
+func (c *supervisedConsumer) CheckCommand(cmd command.Command) bool {
+	return c.consumer.CheckCommand(cmd)
+}
+
+// ReplicaStatus передаёт статус получателю и отмечает начало синхронизации
+func (c *supervisedConsumer) ReplicaStatus(s status.Status) error {
+	if s == status.FullResync || s == status.ContinueSync {
+		c.mu.Lock()
+		c.sync = true
+		c.mu.Unlock()
+	}
+	return c.consumer.ReplicaStatus(s)
+}
+
+// Cancel не останавливает получателя, его останавливает Supervisor
+func (c *supervisedConsumer) Cancel(err *error) {}
+
+// Err возвращает ошибку получателя
+func (c *supervisedConsumer) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// Failed возвращает канал который закрывается при ошибке получателя
+func (c *supervisedConsumer) Failed() <-chan struct{} {
+	return c.failed
+}
+
+// synced возвращает true если с прошлого вызова сервер начал синхронизацию
+func (c *supervisedConsumer) synced() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	synced := c.sync
+	c.sync = false
+	return synced
+}
+
+// setErr запоминает первую ошибку получателя
+func (c *supervisedConsumer) setErr(err error) error {
+	if err == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err == nil {
+		c.err = err
+		close(c.failed)
+	}
+	return err
+}
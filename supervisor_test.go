@@ -0,0 +1,421 @@
+package replica
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/avito-tech/smart-redis-replication/command"
+	"github.com/avito-tech/smart-redis-replication/data"
+	"github.com/avito-tech/smart-redis-replication/replica"
+	"github.com/avito-tech/smart-redis-replication/resp"
+	"github.com/avito-tech/smart-redis-replication/status"
+)
+
+// TestSupervisorReconnect проверяет что после разрыва соединения репликация
+// продолжается через PSYNC без перезапуска получателя
+func TestSupervisorReconnect(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen error: %v", err)
+	}
+	defer listener.Close() // nolint:errcheck
+
+	rdbData := "REDIS0009\xfe\x00\x00\x03foo\x03bar\xff\x00\x00\x00\x00\x00\x00\x00\x00"
+	setA := "*3\r\n$3\r\nSET\r\n$1\r\na\r\n$1\r\n1\r\n"
+	setB := "*3\r\n$3\r\nSET\r\n$1\r\nb\r\n$1\r\n2\r\n"
+
+	psync := make(chan string, 2)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		psync <- fakeMaster(conn, fmt.Sprintf(
+			"+FULLRESYNC abc 100\r\n$%d\r\n%s%s",
+			len(rdbData),
+			rdbData,
+			setA,
+		))
+		conn.Close() // nolint:errcheck
+
+		conn, err = listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close() // nolint:errcheck
+		psync <- fakeMaster(conn, "+CONTINUE\r\n"+setB)
+		readAll(conn)
+	}()
+
+	s, err := NewSupervisor(SupervisorConfig{
+		Connect: testConnectConfig(t, listener.Addr()),
+		Replica: replica.Config{
+			ReadRDB:     true,
+			StreamRDB:   true,
+			BacklogSize: 100,
+		},
+		MinBackoff: time.Millisecond,
+		MaxBackoff: time.Millisecond,
+		MaxRetries: 3,
+	})
+	if err != nil {
+		t.Fatalf("new supervisor error: %v", err)
+	}
+	consumer := newTestConsumer()
+	result := make(chan error, 1)
+	go func() {
+		result <- s.Run(consumer)
+	}()
+
+	expectedEvents := []string{"key foo", "command a", "command b"}
+	for _, expected := range expectedEvents {
+		select {
+		case event := <-consumer.events:
+			if event != expected {
+				t.Fatalf("expected %q but actual %q", expected, event)
+			}
+		case err := <-result:
+			t.Fatalf("unexpected stop: %v", err)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting %q", expected)
+		}
+	}
+	s.Stop()
+	if err := <-result; err != nil {
+		t.Fatalf("run error: %v", err)
+	}
+
+	if cmd := <-psync; cmd != "PSYNC ? -1" {
+		t.Fatalf("expected PSYNC ? -1 but actual %q", cmd)
+	}
+	expectedPsync := fmt.Sprintf("PSYNC abc %d", 100+len(setA)+1)
+	if cmd := <-psync; cmd != expectedPsync {
+		t.Fatalf("expected %q but actual %q", expectedPsync, cmd)
+	}
+	if consumer.canceled != 1 {
+		t.Fatalf("expected one consumer cancel but actual %d", consumer.canceled)
+	}
+	if consumer.count(status.Reconnect) != 1 {
+		t.Fatalf("expected one reconnect status")
+	}
+}
+
+// TestSupervisorFatal проверяет что ошибка авторизации не приводит
+// к переподключению
+func TestSupervisorFatal(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen error: %v", err)
+	}
+	defer listener.Close() // nolint:errcheck
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go fakeServer(
+				conn,
+				[]string{"+PONG", "-WRONGPASS invalid username-password pair"},
+				make(chan []string, 1),
+			)
+		}
+	}()
+
+	config := testConnectConfig(t, listener.Addr())
+	config.Password = "wrong"
+	s, err := NewSupervisor(SupervisorConfig{
+		Connect:    config,
+		MinBackoff: time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("new supervisor error: %v", err)
+	}
+	consumer := newTestConsumer()
+	err = s.Run(consumer)
+	if _, ok := err.(*HandshakeError); !ok {
+		t.Fatalf("expected HandshakeError but actual %#v", err)
+	}
+	if consumer.count(status.Reconnect) != 0 {
+		t.Fatalf("unexpected reconnect")
+	}
+}
+
+// TestSupervisorAuthDisconnect проверяет что разрыв соединения во время
+// AUTH приводит к переподключению, а не к остановке
+func TestSupervisorAuthDisconnect(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen error: %v", err)
+	}
+	defer listener.Close() // nolint:errcheck
+
+	rdbData := "REDIS0009\xfe\x00\x00\x03foo\x03bar\xff\x00\x00\x00\x00\x00\x00\x00\x00"
+	auth := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		br := bufio.NewReader(conn)
+		r := resp.NewReader(br)
+		for {
+			args, err := readMasterCommand(br, r)
+			if err != nil || len(args) == 0 {
+				break
+			}
+			if strings.ToUpper(args[0]) == "AUTH" {
+				auth <- args[0]
+				break
+			}
+			_, err = conn.Write([]byte("+PONG\r\n"))
+			if err != nil {
+				break
+			}
+		}
+		conn.Close() // nolint:errcheck
+
+		conn, err = listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close() // nolint:errcheck
+		fakeMaster(conn, fmt.Sprintf(
+			"+FULLRESYNC abc 100\r\n$%d\r\n%s",
+			len(rdbData),
+			rdbData,
+		))
+		readAll(conn)
+	}()
+
+	config := testConnectConfig(t, listener.Addr())
+	config.Password = "secret"
+	s, err := NewSupervisor(SupervisorConfig{
+		Connect: config,
+		Replica: replica.Config{
+			ReadRDB:     true,
+			StreamRDB:   true,
+			BacklogSize: 100,
+		},
+		MinBackoff: time.Millisecond,
+		MaxBackoff: time.Millisecond,
+		MaxRetries: 3,
+	})
+	if err != nil {
+		t.Fatalf("new supervisor error: %v", err)
+	}
+	consumer := newTestConsumer()
+	result := make(chan error, 1)
+	go func() {
+		result <- s.Run(consumer)
+	}()
+
+	select {
+	case event := <-consumer.events:
+		if event != "key foo" {
+			t.Fatalf("expected %q but actual %q", "key foo", event)
+		}
+	case err := <-result:
+		t.Fatalf("unexpected stop: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timeout waiting reconnect")
+	}
+	s.Stop()
+	if err := <-result; err != nil {
+		t.Fatalf("run error: %v", err)
+	}
+	if cmd := <-auth; cmd != "AUTH" {
+		t.Fatalf("expected AUTH but actual %q", cmd)
+	}
+	if consumer.count(status.Reconnect) != 1 {
+		t.Fatalf("expected one reconnect status")
+	}
+}
+
+// TestSupervisorMaxRetries проверяет ограничение количества переподключений
+func TestSupervisorMaxRetries(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen error: %v", err)
+	}
+	config := testConnectConfig(t, listener.Addr())
+	listener.Close() // nolint:errcheck
+
+	s, err := NewSupervisor(SupervisorConfig{
+		Connect:    config,
+		MinBackoff: time.Millisecond,
+		MaxBackoff: 2 * time.Millisecond,
+		MaxRetries: 2,
+	})
+	if err != nil {
+		t.Fatalf("new supervisor error: %v", err)
+	}
+	consumer := newTestConsumer()
+	err = s.Run(consumer)
+	if err == nil {
+		t.Fatalf("expected error")
+	}
+	if consumer.count(status.Reconnect) != 2 {
+		t.Fatalf(
+			"expected 2 reconnects but actual %d",
+			consumer.count(status.Reconnect),
+		)
+	}
+}
+
+// TestSupervisorBackoff проверяет границы задержки переподключения
+func TestSupervisorBackoff(t *testing.T) {
+	s := &Supervisor{config: SupervisorConfig{
+		MinBackoff: 100 * time.Millisecond,
+		MaxBackoff: time.Second,
+	}}
+	limits := []time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		time.Second,
+		time.Second,
+	}
+	for i, limit := range limits {
+		delay := s.backoff(i + 1)
+		if delay < limit/2 || delay > limit {
+			t.Fatalf("retry %d: expected delay in [%v, %v] but actual %v",
+				i+1, limit/2, limit, delay)
+		}
+	}
+}
+
+// testConnectConfig возвращает настройки подключения к адресу addr
+func testConnectConfig(t *testing.T, addr net.Addr) Config {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		t.Fatalf("expected tcp address but actual %v", addr)
+	}
+	return Config{
+		Host: tcpAddr.IP.String(),
+		Port: tcpAddr.Port,
+		DB:   -1,
+	}
+}
+
+// fakeMaster отвечает на подготовку соединения, на PSYNC отправляет reply
+// и возвращает полученную команду PSYNC
+func fakeMaster(conn net.Conn, reply string) string {
+	br := bufio.NewReader(conn)
+	r := resp.NewReader(br)
+	for {
+		args, err := readMasterCommand(br, r)
+		if err != nil || len(args) == 0 {
+			return ""
+		}
+		switch strings.ToUpper(args[0]) {
+		case "PING":
+			_, err = conn.Write([]byte("+PONG\r\n"))
+		case "PSYNC":
+			_, err = conn.Write([]byte(reply))
+			if err != nil {
+				return ""
+			}
+			return strings.Join(args, " ")
+		default:
+			_, err = conn.Write([]byte("+OK\r\n"))
+		}
+		if err != nil {
+			return ""
+		}
+	}
+}
+
+// readMasterCommand читает команду в формате RESP или inline команду
+func readMasterCommand(br *bufio.Reader, r resp.Reader) ([]string, error) {
+	opcode, err := br.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	if opcode[0] != resp.ArrayOpcode {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		return strings.Fields(line), nil
+	}
+	cmd, err := r.Command()
+	if err != nil {
+		return nil, err
+	}
+	return cmd.Args(), nil
+}
+
+// readAll читает соединение до его закрытия
+func readAll(conn net.Conn) {
+	buf := make([]byte, 1024)
+	for {
+		_, err := conn.Read(buf)
+		if err != nil {
+			return
+		}
+	}
+}
+
+// testConsumer записывает полученные данные
+type testConsumer struct {
+	sync.Mutex
+	events   chan string
+	statuses []status.Status
+	canceled int
+}
+
+func newTestConsumer() *testConsumer {
+	return &testConsumer{events: make(chan string, 10)}
+}
+
+func (c *testConsumer) Key(key data.Key) error {
+	c.events <- "key " + key.Name()
+	return nil
+}
+
+func (c *testConsumer) Command(cmd command.Command) error {
+	args := cmd.Args()
+	if len(args) < 2 {
+		return errors.New("unexpected command")
+	}
+	c.events <- "command " + args[1]
+	return nil
+}
+
+func (c *testConsumer) CheckCommand(cmd command.Command) bool {
+	return true
+}
+
+func (c *testConsumer) ReplicaStatus(s status.Status) error {
+	c.Lock()
+	defer c.Unlock()
+	c.statuses = append(c.statuses, s)
+	return nil
+}
+
+func (c *testConsumer) Cancel(err *error) {
+	c.Lock()
+	defer c.Unlock()
+	c.canceled++
+}
+
+func (c *testConsumer) count(s status.Status) int {
+	c.Lock()
+	defer c.Unlock()
+	n := 0
+	for _, item := range c.statuses {
+		if item == s {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,73 @@
+package replica
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// TLSConfig это параметры TLS соединения с redis сервером
+type TLSConfig struct {
+	// CAFile это адрес файла с цепочкой сертификатов (PEM) для проверки
+	// сертификата сервера, если не задан то используются системные сертификаты
+	CAFile string
+
+	// CertFile это адрес файла с клиентским сертификатом (PEM)
+	CertFile string
+
+	// KeyFile это адрес файла с ключом клиентского сертификата (PEM)
+	KeyFile string
+
+	// ServerName это имя сервера для SNI и проверки сертификата,
+	// если не задано то используется адрес сервера
+	ServerName string
+
+	// InsecureSkipVerify отключает проверку сертификата сервера,
+	// предназначено только для тестов
+	InsecureSkipVerify bool
+
+	// Config это базовая конфигурация TLS,
+	// остальные параметры дополняют её копию
+	Config *tls.Config
+}
+
+// Build возвращает *tls.Config для подключения к серверу host
+func (c TLSConfig) Build(host string) (*tls.Config, error) {
+	config := &tls.Config{}
+	if c.Config != nil {
+		config = c.Config.Clone()
+	}
+	if c.CAFile != "" {
+		pem, err := ioutil.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read tls ca file error: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("expected certificates in %q", c.CAFile)
+		}
+		config.RootCAs = pool
+	}
+	switch {
+	case c.CertFile != "" && c.KeyFile != "":
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load tls client certificate error: %v", err)
+		}
+		config.Certificates = append(config.Certificates, cert)
+	case c.CertFile != "" || c.KeyFile != "":
+		return nil, errors.New("expected both tls cert file and key file")
+	}
+	if c.ServerName != "" {
+		config.ServerName = c.ServerName
+	}
+	if config.ServerName == "" {
+		config.ServerName = host
+	}
+	if c.InsecureSkipVerify {
+		config.InsecureSkipVerify = true
+	}
+	return config, nil
+}
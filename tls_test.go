@@ -0,0 +1,195 @@
+package replica
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestTLS проверяет подключение к серверу через TLS
+func TestTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "srr-tls")
+	if err != nil {
+		t.Fatalf("create temp dir error: %v", err)
+	}
+	defer os.RemoveAll(dir) // nolint:errcheck
+
+	certFile, keyFile := path.Join(dir, "cert.pem"), path.Join(dir, "key.pem")
+	cert := testCertificate(t, certFile, keyFile)
+
+	t.Run("Normal", func(t *testing.T) {
+		t.Run("CAFile", func(t *testing.T) {
+			testTLS(t, cert, false, &TLSConfig{CAFile: certFile}, true)
+		})
+		t.Run("ServerName", func(t *testing.T) {
+			testTLS(
+				t,
+				cert,
+				false,
+				&TLSConfig{CAFile: certFile, ServerName: "localhost"},
+				true,
+			)
+		})
+		t.Run("InsecureSkipVerify", func(t *testing.T) {
+			testTLS(t, cert, false, &TLSConfig{InsecureSkipVerify: true}, true)
+		})
+		t.Run("ClientCertificate", func(t *testing.T) {
+			testTLS(
+				t,
+				cert,
+				true,
+				&TLSConfig{
+					CAFile:   certFile,
+					CertFile: certFile,
+					KeyFile:  keyFile,
+				},
+				true,
+			)
+		})
+	})
+	t.Run("Error", func(t *testing.T) {
+		t.Run("UnknownAuthority", func(t *testing.T) {
+			testTLS(t, cert, false, &TLSConfig{}, false)
+		})
+		t.Run("WrongServerName", func(t *testing.T) {
+			testTLS(
+				t,
+				cert,
+				false,
+				&TLSConfig{CAFile: certFile, ServerName: "redis.example.com"},
+				false,
+			)
+		})
+		t.Run("KeyFileWithoutCertFile", func(t *testing.T) {
+			testTLS(
+				t,
+				cert,
+				false,
+				&TLSConfig{InsecureSkipVerify: true, KeyFile: keyFile},
+				false,
+			)
+		})
+	})
+}
+
+// testTLS запускает TLS сервер и проверяет подключение к нему
+func testTLS(
+	t *testing.T,
+	cert tls.Certificate,
+	requireClientCert bool,
+	tlsConfig *TLSConfig,
+	success bool,
+) {
+	serverConfig := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if requireClientCert {
+		pool := x509.NewCertPool()
+		pool.AddCert(cert.Leaf)
+		serverConfig.ClientCAs = pool
+		serverConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", serverConfig)
+	if err != nil {
+		t.Fatalf("listen error: %v", err)
+	}
+	defer listener.Close() // nolint:errcheck
+
+	commands := make(chan []string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			commands <- nil
+			return
+		}
+		defer conn.Close() // nolint:errcheck
+		fakeServer(conn, []string{"+PONG", "+OK"}, commands)
+	}()
+
+	addr := listener.Addr().(*net.TCPAddr)
+	conn, err := NewConnectConfig(Config{
+		Host: "127.0.0.1",
+		Port: addr.Port,
+		DB:   -1,
+		TLS:  tlsConfig,
+	})
+	if !success {
+		if err == nil {
+			conn.Close() // nolint:errcheck
+			t.Fatalf("expected error")
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("connect error: %v", err)
+	}
+	err = conn.Send("SET", "key", "value")
+	if err != nil {
+		t.Fatalf("send error: %v", err)
+	}
+	conn.Close() // nolint:errcheck
+
+	expected := []string{"PING", "SET key value"}
+	result := <-commands
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("expected commands %q but actual %q", expected, result)
+	}
+}
+
+// testCertificate создаёт самоподписанный сертификат для 127.0.0.1 и
+// localhost, сохраняет его и ключ в файлы
+func testCertificate(t *testing.T, certFile, keyFile string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key error: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}, // nolint:lll
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create certificate error: %v", err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal key error: %v", err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	err = ioutil.WriteFile(certFile, certPEM, 0600)
+	if err != nil {
+		t.Fatalf("write certificate error: %v", err)
+	}
+	err = ioutil.WriteFile(keyFile, keyPEM, 0600)
+	if err != nil {
+		t.Fatalf("write key error: %v", err)
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("load certificate error: %v", err)
+	}
+	cert.Leaf, err = x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parse certificate error: %v", err)
+	}
+	return cert
+}
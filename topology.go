@@ -0,0 +1,233 @@
+package replica
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+
+	"github.com/avito-tech/smart-redis-replication/resp"
+)
+
+// SlotRange это диапазон слотов redis cluster включая границы
+type SlotRange struct {
+	Start int
+	End   int
+}
+
+// ClusterShard это мастер redis cluster и принадлежащие ему слоты
+type ClusterShard struct {
+	// ID это идентификатор мастера в кластере
+	ID string
+
+	// Host это адрес мастера
+	Host string
+
+	// Port это порт мастера
+	Port int
+
+	// Slots это слоты которые обслуживает мастер
+	Slots []SlotRange
+}
+
+// HasSlot возвращает true если слот принадлежит шарду
+func (s ClusterShard) HasSlot(slot int) bool {
+	for _, slots := range s.Slots {
+		if slot >= slots.Start && slot <= slots.End {
+			return true
+		}
+	}
+	return false
+}
+
+// equal возвращает true если шарды совпадают
+func (s ClusterShard) equal(shard ClusterShard) bool {
+	return reflect.DeepEqual(s, shard)
+}
+
+// clusterTopology запрашивает у узла список мастеров через CLUSTER SHARDS,
+// если сервер не поддерживает CLUSTER SHARDS (redis < 7) то через
+// CLUSTER SLOTS
+func (c *Conn) clusterTopology() ([]ClusterShard, error) {
+	useTLS := c.config.TLS != nil
+	reply, err := c.requestValue("CLUSTER", "SHARDS")
+	if err == nil {
+		return parseClusterShards(reply, c.config.Host, useTLS)
+	}
+	if _, ok := err.(resp.ServerError); !ok {
+		return nil, err
+	}
+	reply, err = c.requestValue("CLUSTER", "SLOTS")
+	if err != nil {
+		return nil, err
+	}
+	return parseClusterSlots(reply, c.config.Host)
+}
+
+// parseClusterShards разбирает ответ CLUSTER SHARDS, каждый шард это пары
+// ключ-значение "slots" [start end ...] и "nodes" [[id ... role ...] ...],
+// шарды без слотов пропускаются
+func parseClusterShards(
+	reply interface{},
+	seedHost string,
+	useTLS bool,
+) ([]ClusterShard, error) {
+	items, ok := reply.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("unexpected cluster shards reply %#v", reply)
+	}
+	var shards []ClusterShard
+	for _, item := range items {
+		fields, err := replyMap(item)
+		if err != nil {
+			return nil, err
+		}
+		slots, ok := fields["slots"].([]interface{})
+		if !ok || len(slots)%2 != 0 {
+			return nil, fmt.Errorf("unexpected shard slots %#v", fields["slots"])
+		}
+		if len(slots) == 0 {
+			continue
+		}
+		shard := ClusterShard{}
+		for i := 0; i < len(slots); i += 2 {
+			var slotRange SlotRange
+			slotRange.Start, err = replyInt(slots[i])
+			if err != nil {
+				return nil, err
+			}
+			slotRange.End, err = replyInt(slots[i+1])
+			if err != nil {
+				return nil, err
+			}
+			shard.Slots = append(shard.Slots, slotRange)
+		}
+		nodes, ok := fields["nodes"].([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("unexpected shard nodes %#v", fields["nodes"])
+		}
+		for _, node := range nodes {
+			nodeFields, err := replyMap(node)
+			if err != nil {
+				return nil, err
+			}
+			if nodeFields["role"] != "master" {
+				continue
+			}
+			shard.ID, _ = nodeFields["id"].(string)
+			shard.Host, _ = nodeFields["ip"].(string)
+			port := nodeFields["port"]
+			if tlsPort, ok := nodeFields["tls-port"]; ok && useTLS {
+				port = tlsPort
+			}
+			shard.Port, err = replyInt(port)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if shard.ID == "" {
+			return nil, errors.New("shard without master")
+		}
+		if shard.Host == "" || shard.Host == "?" {
+			shard.Host = seedHost
+		}
+		shards = append(shards, shard)
+	}
+	sortShards(shards)
+	return shards, nil
+}
+
+// parseClusterSlots разбирает ответ CLUSTER SLOTS, каждый элемент это
+// [start end [ip port id] [реплики ...]],
+// диапазоны одного мастера объединяются в один шард
+func parseClusterSlots(reply interface{}, seedHost string) ([]ClusterShard, error) {
+	items, ok := reply.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("unexpected cluster slots reply %#v", reply)
+	}
+	index := map[string]int{}
+	var shards []ClusterShard
+	for _, item := range items {
+		fields, ok := item.([]interface{})
+		if !ok || len(fields) < 3 {
+			return nil, fmt.Errorf("unexpected slots item %#v", item)
+		}
+		start, err := replyInt(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		end, err := replyInt(fields[1])
+		if err != nil {
+			return nil, err
+		}
+		master, ok := fields[2].([]interface{})
+		if !ok || len(master) < 2 {
+			return nil, fmt.Errorf("unexpected slots master %#v", fields[2])
+		}
+		host, _ := master[0].(string)
+		if host == "" || host == "?" {
+			host = seedHost
+		}
+		port, err := replyInt(master[1])
+		if err != nil {
+			return nil, err
+		}
+		id := net.JoinHostPort(host, strconv.Itoa(port))
+		if len(master) > 2 {
+			if nodeID, ok := master[2].(string); ok && nodeID != "" {
+				id = nodeID
+			}
+		}
+		i, ok := index[id]
+		if !ok {
+			i = len(shards)
+			index[id] = i
+			shards = append(shards, ClusterShard{ID: id, Host: host, Port: port})
+		}
+		shards[i].Slots = append(shards[i].Slots, SlotRange{Start: start, End: end})
+	}
+	sortShards(shards)
+	return shards, nil
+}
+
+// sortShards сортирует шарды и их слоты по номеру первого слота
+func sortShards(shards []ClusterShard) {
+	for _, shard := range shards {
+		sort.Slice(shard.Slots, func(i, j int) bool {
+			return shard.Slots[i].Start < shard.Slots[j].Start
+		})
+	}
+	sort.Slice(shards, func(i, j int) bool {
+		return shards[i].Slots[0].Start < shards[j].Slots[0].Start
+	})
+}
+
+// replyMap преобразует массив пар ключ-значение в map
+func replyMap(reply interface{}) (map[string]interface{}, error) {
+	items, ok := reply.([]interface{})
+	if !ok || len(items)%2 != 0 {
+		return nil, fmt.Errorf("unexpected map reply %#v", reply)
+	}
+	result := make(map[string]interface{}, len(items)/2)
+	for i := 0; i < len(items); i += 2 {
+		key, ok := items[i].(string)
+		if !ok {
+			return nil, fmt.Errorf("unexpected map key %#v", items[i])
+		}
+		result[key] = items[i+1]
+	}
+	return result, nil
+}
+
+// replyInt преобразует целое число или строку с числом в int
+func replyInt(reply interface{}) (int, error) {
+	switch value := reply.(type) {
+	case int64:
+		return int(value), nil
+	case string:
+		return strconv.Atoi(value)
+	}
+	return 0, fmt.Errorf("expected integer but actual %#v", reply)
+}
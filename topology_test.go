@@ -0,0 +1,129 @@
+package replica
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestParseClusterShards проверяет разбор ответа CLUSTER SHARDS
+func TestParseClusterShards(t *testing.T) {
+	reply := []interface{}{
+		[]interface{}{
+			"slots", []interface{}{int64(10923), int64(16383)},
+			"nodes", []interface{}{
+				[]interface{}{
+					"id", "c",
+					"port", int64(30003),
+					"ip", "10.0.0.3",
+					"role", "master",
+				},
+			},
+		},
+		[]interface{}{
+			"slots", []interface{}{
+				int64(5461), int64(10922),
+				int64(0), int64(100),
+			},
+			"nodes", []interface{}{
+				[]interface{}{
+					"id", "b-replica",
+					"port", int64(30004),
+					"ip", "10.0.0.4",
+					"role", "replica",
+				},
+				[]interface{}{
+					"id", "b",
+					"port", int64(30002),
+					"tls-port", int64(31002),
+					"ip", "",
+					"role", "master",
+				},
+			},
+		},
+		[]interface{}{
+			"slots", []interface{}{},
+			"nodes", []interface{}{
+				[]interface{}{
+					"id", "empty",
+					"port", int64(30005),
+					"ip", "10.0.0.5",
+					"role", "master",
+				},
+			},
+		},
+	}
+	shards, err := parseClusterShards(reply, "seed", true)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	expected := []ClusterShard{
+		{
+			ID:    "b",
+			Host:  "seed",
+			Port:  31002,
+			Slots: []SlotRange{{0, 100}, {5461, 10922}},
+		},
+		{
+			ID:    "c",
+			Host:  "10.0.0.3",
+			Port:  30003,
+			Slots: []SlotRange{{10923, 16383}},
+		},
+	}
+	if !reflect.DeepEqual(shards, expected) {
+		t.Fatalf("expected %#v but actual %#v", expected, shards)
+	}
+	if !shards[0].HasSlot(50) || shards[0].HasSlot(101) {
+		t.Fatalf("unexpected HasSlot result")
+	}
+
+	_, err = parseClusterShards([]interface{}{"slots"}, "seed", false)
+	if err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+// TestParseClusterSlots проверяет разбор ответа CLUSTER SLOTS
+func TestParseClusterSlots(t *testing.T) {
+	reply := []interface{}{
+		[]interface{}{
+			int64(5461), int64(10922),
+			[]interface{}{"10.0.0.2", int64(30002), "b"},
+			[]interface{}{"10.0.0.4", int64(30004), "b-replica"},
+		},
+		[]interface{}{
+			int64(0), int64(5460),
+			[]interface{}{"", int64(30001)},
+		},
+		[]interface{}{
+			int64(10923), int64(16383),
+			[]interface{}{"10.0.0.2", int64(30002), "b"},
+		},
+	}
+	shards, err := parseClusterSlots(reply, "seed")
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	expected := []ClusterShard{
+		{
+			ID:    "seed:30001",
+			Host:  "seed",
+			Port:  30001,
+			Slots: []SlotRange{{0, 5460}},
+		},
+		{
+			ID:    "b",
+			Host:  "10.0.0.2",
+			Port:  30002,
+			Slots: []SlotRange{{5461, 10922}, {10923, 16383}},
+		},
+	}
+	if !reflect.DeepEqual(shards, expected) {
+		t.Fatalf("expected %#v but actual %#v", expected, shards)
+	}
+
+	_, err = parseClusterSlots([]interface{}{[]interface{}{int64(0)}}, "seed")
+	if err == nil {
+		t.Fatalf("expected error")
+	}
+}